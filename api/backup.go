@@ -0,0 +1,214 @@
+package api
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"penumbra/app"
+	"penumbra/backup"
+	"penumbra/db"
+	"penumbra/validate"
+)
+
+// BackupPage is the form for uploading a backup, the preview of what importing one would do, and what became of it once imported.
+type BackupPage struct {
+    Format  string // Of the backup being previewed.
+    Backup  string // The backup being previewed, sent back by the form that imports it.
+    Rows    []BackupRow
+    Creates int
+    Updates int
+    Skips   int
+    Result  *db.ImportResult // Nil until a backup has been imported.
+    Errors  validate.Errors
+}
+
+// BackupRow says what importing one of the tasks in a backup does, or would do: create a task, update one, or skip it for what's wrong with it.
+type BackupRow struct {
+    Line   int
+    Title  string
+    Action string // `create`, `update`, or `skip`.
+    Errors validate.Errors
+}
+
+// ExportBackup downloads all the user's tasks as CSV, or as JSON with `?format=json`.
+func (h *RealHandler) ExportBackup(w http.ResponseWriter, r *http.Request, userId int) {
+    format, ok := backup.FormatCSV, true
+    if f := r.URL.Query().Get("format"); f != "" {
+        format, ok = backup.ParseFormat(f)
+    }
+    if !ok {
+        http.Error(w, "invalid format", http.StatusBadRequest)
+        return
+    }
+
+    tasks, err := h.store.QueryTasks(userId, db.TaskQuery{})
+    if err != nil {
+        log.Println("Error getting tasks: ", err)
+        http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+        return
+    }
+
+    if format == backup.FormatJSON {
+        w.Header().Set("Content-Type", "application/json")
+    } else {
+        w.Header().Set("Content-Type", "text/csv; charset=utf-8")
+    }
+    w.Header().Set("Content-Disposition", `attachment; filename="tasks.`+string(format)+`"`)
+    if err := backup.Encode(w, format, tasks); err != nil {
+        log.Println("Error writing backup: ", err)
+    }
+}
+
+func (h *RealHandler) RenderBackup(w http.ResponseWriter, r *http.Request, userId int) {
+    h.RenderPage(w, r, "backup", BackupPage{})
+}
+
+// SubmitBackup previews what importing the uploaded `file` would do, and shows a form that sends it back as `backup` to import it. Importing saves every task in the backup that can be saved, in one transaction, and lists those that can't.
+func (h *RealHandler) SubmitBackup(w http.ResponseWriter, r *http.Request, userId int) {
+    refuse := func(message string) {
+        w.WriteHeader(http.StatusBadRequest)
+        h.RenderPage(w, r, "backup", BackupPage{Errors: validate.Errors{"file": message}})
+    }
+
+    var data []byte
+    var format backup.Format
+    confirmed := r.PostFormValue("backup") != ""
+    if confirmed {
+        data = []byte(r.PostFormValue("backup"))
+        f, ok := backup.ParseFormat(r.PostFormValue("format"))
+        if !ok {
+            refuse("Choose a backup file to import.")
+            return
+        }
+        format = f
+    } else {
+        file, header, err := r.FormFile("file")
+        if err != nil {
+            refuse("Choose a backup file to import.")
+            return
+        }
+        defer file.Close()
+        if header.Size > maxImportBytes {
+            refuse("Choose a backup file of at most 5 MB.")
+            return
+        }
+        if data, err = io.ReadAll(file); err != nil {
+            refuse("That file couldn't be read.")
+            return
+        }
+        format = backup.DetectFormat(data)
+    }
+
+    rows, tasks, err := h.readBackup(userId, format, data)
+    if errors.Is(err, backup.ErrNotBackup) {
+        refuse(fmt.Sprintf("That isn't a CSV or JSON backup: %s.", strings.TrimPrefix(err.Error(), backup.ErrNotBackup.Error()+": ")))
+        return
+    }
+    if err != nil {
+        log.Println("Error getting user: ", err)
+        http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+        return
+    }
+    if len(rows) == 0 {
+        refuse("That backup has no tasks in it.")
+        return
+    }
+
+    page := BackupPage{Rows: rows}
+    if confirmed {
+        result, err := h.store.RestoreTasks(userId, tasks)
+        if err != nil {
+            log.Println("Error importing tasks: ", err)
+            http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+            return
+        }
+        page.Result = &result
+        page.Rows = nil
+        for _, row := range rows {
+            if row.Action == "skip" {
+                page.Rows = append(page.Rows, row)
+            }
+        }
+        page.Skips = len(page.Rows)
+        h.RenderPage(w, r, "backup", page)
+        return
+    }
+
+    updates, err := h.store.PreviewImport(userId, tasks)
+    if err != nil {
+        log.Println("Error previewing import: ", err)
+        http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+        return
+    }
+    next := 0
+    for i := range page.Rows {
+        row := &page.Rows[i]
+        switch {
+        case row.Action == "skip":
+            page.Skips++
+            continue
+        case updates[next]:
+            row.Action = "update"
+            page.Updates++
+        default:
+            row.Action = "create"
+            page.Creates++
+        }
+        next++
+    }
+    page.Format, page.Backup = string(format), string(data)
+    h.RenderPage(w, r, "backup", page)
+}
+
+// readBackup reads the tasks of a backup, and checks them and their tags as any others are checked; tags without a colour get the default one. It returns a row for every task, those that can't be saved marked to be skipped, and the tasks that can be, in order.
+func (h *RealHandler) readBackup(userId int, format backup.Format, data []byte) ([]BackupRow, []app.Task, error) {
+    loc, err := h.userLocation(userId)
+    if err != nil {
+        return nil, nil, err
+    }
+    decoded, err := backup.Decode(bytes.NewReader(data), format, loc)
+    if err != nil {
+        return nil, nil, err
+    }
+
+    rows := make([]BackupRow, len(decoded))
+    tasks := []app.Task{}
+    now := time.Now()
+    for i, d := range decoded {
+        task := d.Task
+        task.Title = strings.TrimSpace(task.Title)
+        task.Recurrence = normalizeRecurrence(task.Recurrence)
+        errs := d.Errors
+        if len(errs) == 0 {
+            errs = validate.Task(task, now)
+            for j := range task.Tags {
+                tag := &task.Tags[j]
+                tag.Colour = strings.ToLower(tag.Colour)
+                if tag.Colour == "" {
+                    tag.Colour = defaultTagColour
+                }
+                if tagErrs := validate.Tag(*tag); len(tagErrs) > 0 {
+                    message := tagErrs["name"]
+                    if message == "" {
+                        message = tagErrs["colour"]
+                    }
+                    errs.Add("tags", fmt.Sprintf("Tag %q: %s", tag.Name, message))
+                }
+            }
+        }
+
+        rows[i] = BackupRow{Line: d.Line, Title: task.Title}
+        if len(errs) > 0 {
+            rows[i].Action, rows[i].Errors = "skip", errs
+            continue
+        }
+        tasks = append(tasks, task)
+    }
+    return rows, tasks, nil
+}
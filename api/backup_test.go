@@ -0,0 +1,145 @@
+package api
+
+import (
+	"bytes"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+
+	"penumbra/app"
+	"penumbra/db"
+	"penumbra/validate"
+)
+
+// backupRequest is a multipart form post of the file as `file`.
+func backupRequest(t *testing.T, contents string) *http.Request {
+    var body bytes.Buffer
+    form := multipart.NewWriter(&body)
+    part, err := form.CreateFormFile("file", "tasks.csv")
+    if err != nil {
+        t.Fatal(err)
+    }
+    part.Write([]byte(contents))
+    form.Close()
+
+    req := httptest.NewRequest(http.MethodPost, "/import", &body)
+    req.Header.Set("Content-Type", form.FormDataContentType())
+    return req
+}
+
+func TestExportBackup(t *testing.T) {
+    mockStore := new(MockSQLiteStore)
+    handler := newTestHandler(mockStore)
+
+    id := uuid.New()
+    tasks := []app.Task{{Id: id, Title: "Pay rent", Due: time.Date(2030, 1, 7, 12, 0, 0, 0, time.UTC), Priority: app.PriorityHigh}}
+    mockStore.On("QueryTasks", 1, db.TaskQuery{}).Return(tasks, nil).Twice()
+
+    rr := httptest.NewRecorder()
+    handler.ExportBackup(rr, httptest.NewRequest(http.MethodGet, "/export", nil), 1)
+    assert.Equal(t, http.StatusOK, rr.Code)
+    assert.Equal(t, "text/csv; charset=utf-8", rr.Header().Get("Content-Type"))
+    assert.Contains(t, rr.Header().Get("Content-Disposition"), "tasks.csv")
+    assert.Contains(t, rr.Body.String(), "\n"+id.String()+",Pay rent,,,0,2030-01-07T12:00:00Z,false,")
+
+    rr = httptest.NewRecorder()
+    handler.ExportBackup(rr, httptest.NewRequest(http.MethodGet, "/export?format=json", nil), 1)
+    assert.Equal(t, http.StatusOK, rr.Code)
+    assert.Equal(t, "application/json", rr.Header().Get("Content-Type"))
+    assert.Contains(t, rr.Header().Get("Content-Disposition"), "tasks.json")
+    assert.Contains(t, rr.Body.String(), `"id": "`+id.String()+`"`)
+    assert.Contains(t, rr.Body.String(), `"priority": "high"`)
+
+    rr = httptest.NewRecorder()
+    handler.ExportBackup(rr, httptest.NewRequest(http.MethodGet, "/export?format=xml", nil), 1)
+    assert.Equal(t, http.StatusBadRequest, rr.Code)
+    mockStore.AssertExpectations(t)
+}
+
+func TestSubmitBackupPreviews(t *testing.T) {
+    mockStore := new(MockSQLiteStore)
+    handler := newTestHandler(mockStore)
+
+    id := uuid.New()
+    csv := "id,title,due,priority,tags\n" +
+        id.String() + ", Pay rent ,2030-01-07,high,home\n" +
+        ",Call mum,2030-01-08T17:00:00Z,,\n" +
+        ",Someday,,,\n" +
+        ",Later,next week,,\n" +
+        ",Tidy up,2030-01-09,," + strings.Repeat("x", validate.MaxTagNameLength+1) + "\n"
+
+    mockStore.On("GetUserById", 1).Return(app.User{Id: 1, TimeZone: "Europe/London"}, nil).Once()
+    mockStore.On("PreviewImport", 1, mock.MatchedBy(func(tasks []app.Task) bool {
+        return len(tasks) == 2 && tasks[0].Id == id && tasks[0].Title == "Pay rent" && tasks[0].AllDay && tasks[1].Title == "Call mum"
+    })).Return([]bool{true, false}, nil).Once()
+
+    rr := httptest.NewRecorder()
+    handler.SubmitBackup(rr, backupRequest(t, csv), 1)
+
+    assert.Equal(t, http.StatusOK, rr.Code)
+    body := rr.Body.String()
+    assert.Contains(t, body, "Format:csv ")
+    assert.Contains(t, body, "{Line:2 Title:Pay rent Action:update Errors:}")
+    assert.Contains(t, body, "{Line:3 Title:Call mum Action:create Errors:}")
+    assert.Contains(t, body, "{Line:4 Title:Someday Action:skip Errors:due: Choose a due date.}")
+    assert.Contains(t, body, "{Line:5 Title:Later Action:skip Errors:due: Use an RFC 3339 time")
+    assert.Contains(t, body, "{Line:6 Title:Tidy up Action:skip Errors:tags: Tag &#34;xxx")
+    assert.Contains(t, body, "Creates:1 Updates:1 Skips:3 Result:&lt;nil&gt;")
+    mockStore.AssertExpectations(t)
+    mockStore.AssertNotCalled(t, "RestoreTasks", mock.Anything, mock.Anything)
+}
+
+func TestSubmitBackupImports(t *testing.T) {
+    mockStore := new(MockSQLiteStore)
+    handler := newTestHandler(mockStore)
+    handler.templates = resultLayout
+
+    json := `[{"title": "Pay rent", "due": "2030-01-07T12:00:00Z", "priority": "high", "projectId": 4, "tags": [{"name": "Home"}, {"name": "Money", "colour": "#FF0000"}]}, {"title": "", "due": "2030-01-08T12:00:00Z"}]`
+    mockStore.On("GetUserById", 1).Return(app.User{Id: 1}, nil).Once()
+    mockStore.On("RestoreTasks", 1, mock.MatchedBy(func(tasks []app.Task) bool {
+        tags := []app.Tag{{Name: "Home", Colour: defaultTagColour}, {Name: "Money", Colour: "#ff0000"}}
+        return len(tasks) == 1 && tasks[0].Title == "Pay rent" && tasks[0].Priority == app.PriorityHigh && tasks[0].ProjectId == 4 && reflect.DeepEqual(tasks[0].Tags, tags)
+    })).Return(db.ImportResult{Created: 1}, nil).Once()
+
+    rr := httptest.NewRecorder()
+    handler.SubmitBackup(rr, formRequest("/import", url.Values{"format": {"json"}, "backup": {json}}), 1)
+
+    assert.Equal(t, http.StatusOK, rr.Code)
+    body := rr.Body.String()
+    assert.Contains(t, body, "|&amp;{Created:1 Updated:0}")
+    assert.Contains(t, body, "Rows:[{Line:2 Title: Action:skip Errors:title: ")
+    assert.NotContains(t, body, "Pay rent")
+    mockStore.AssertExpectations(t)
+    mockStore.AssertNotCalled(t, "PreviewImport", mock.Anything, mock.Anything)
+}
+
+func TestSubmitBackupRefusesBadFiles(t *testing.T) {
+    mockStore := new(MockSQLiteStore)
+    handler := newTestHandler(mockStore)
+    mockStore.On("GetUserById", 1).Return(app.User{Id: 1}, nil)
+
+    cases := map[string]*http.Request{
+        "calendar":       backupRequest(t, "BEGIN:VCALENDAR\r\nEND:VCALENDAR\r\n"),
+        "JSON object":    backupRequest(t, `{"title": "Pay rent"}`),
+        "no tasks":       backupRequest(t, "title,due\n"),
+        "no file":        formRequest("/import", nil),
+        "unknown format": formRequest("/import", url.Values{"format": {"xml"}, "backup": {"title,due\n"}}),
+    }
+    for name, req := range cases {
+        rr := httptest.NewRecorder()
+        handler.SubmitBackup(rr, req, 1)
+        assert.Equal(t, http.StatusBadRequest, rr.Code, name)
+        assert.Contains(t, rr.Body.String(), "Errors:file: ", name)
+    }
+    mockStore.AssertNotCalled(t, "PreviewImport", mock.Anything, mock.Anything)
+    mockStore.AssertNotCalled(t, "RestoreTasks", mock.Anything, mock.Anything)
+}
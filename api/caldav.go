@@ -0,0 +1,500 @@
+package api
+
+import (
+	"bytes"
+	"errors"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"penumbra/app"
+	"penumbra/caldav"
+	"penumbra/db"
+	"penumbra/ical"
+	"penumbra/recur"
+	"penumbra/validate"
+)
+
+// The resources of the CalDAV server: a principal for the user, whose calendar home holds one calendar of their tasks.
+const (
+    calDAVRoot      = "/caldav/"
+    calDAVPrincipal = "/caldav/principal/"
+    calDAVHome      = "/caldav/calendars/"
+    calDAVCalendar  = "/caldav/calendars/tasks/"
+)
+
+const calDAVContentType = "text/calendar; charset=utf-8; component=vtodo"
+
+// maxCalDAVObjectBytes is the largest to-do a CalDAV client can put.
+const maxCalDAVObjectBytes = 1 << 20
+
+// calDAVResource is a resource of the CalDAV server, and all of its properties.
+type calDAVResource struct {
+    href      string
+    props     []caldav.Property
+    etag      string           // Only set for to-dos.
+    body      []byte           // Only set for to-dos.
+    component caldav.Component // What queries are matched against; only set for to-dos.
+}
+
+// response answers a request for some of the resource's properties. Calendar data is only sent to clients that ask for it by name.
+func (res calDAVResource) response(req caldav.PropRequest) caldav.Response {
+    resp := caldav.Response{Href: res.href}
+    if req.All {
+        for _, p := range res.props {
+            if p.Name != caldav.CalendarData {
+                resp.Found = append(resp.Found, p)
+            }
+        }
+        return resp
+    }
+
+    for _, name := range req.Names {
+        found := false
+        for _, p := range res.props {
+            if p.Name == name {
+                resp.Found = append(resp.Found, p)
+                found = true
+                break
+            }
+        }
+        if !found {
+            resp.NotFound = append(resp.NotFound, name)
+        }
+    }
+    return resp
+}
+
+// HandleCalDAVProtected authenticates a CalDAV client, which sends the user's email address and one of their API tokens as HTTP Basic credentials, since calendar apps can't log in any other way. Read-only tokens can only read.
+func (h *RealHandler) HandleCalDAVProtected(w http.ResponseWriter, r *http.Request, handler func(http.ResponseWriter, *http.Request, int)) {
+    deny := func(err error) {
+        log.Println("Error authenticating CalDAV request: ", err)
+        w.Header().Set("WWW-Authenticate", `Basic realm="penumbra", charset="UTF-8"`)
+        http.Error(w, "unauthorized", http.StatusUnauthorized)
+    }
+
+    email, password, ok := r.BasicAuth()
+    if !ok || !strings.HasPrefix(password, db.APITokenPrefix) {
+        deny(errors.New("no API token"))
+        return
+    }
+    token, err := h.store.GetAPIToken(password)
+    if err != nil {
+        deny(err)
+        return
+    }
+    user, err := h.store.GetUserById(token.UserId)
+    if err != nil {
+        deny(err)
+        return
+    }
+    if !strings.EqualFold(user.Email, email) {
+        deny(errors.New("API token belongs to another user"))
+        return
+    }
+
+    if token.Scope != app.ScopeReadWrite && !isCalDAVReadMethod(r.Method) {
+        http.Error(w, "forbidden: API token is read-only", http.StatusForbidden)
+        return
+    }
+
+    handler(w, r, user.Id)
+}
+
+func isCalDAVReadMethod(method string) bool {
+    return isSafeMethod(method) || method == "PROPFIND" || method == "REPORT"
+}
+
+// ServeCalDAV serves the user's tasks to CalDAV clients, as a calendar of to-dos, each a resource named by its id, or by whatever the client that made it chose.
+func (h *RealHandler) ServeCalDAV(w http.ResponseWriter, r *http.Request, userId int) {
+    path := r.URL.Path
+    name, isObject := strings.CutPrefix(path, calDAVCalendar)
+    isObject = isObject && name != ""
+    if isObject && strings.Contains(name, "/") {
+        http.NotFound(w, r)
+        return
+    }
+    if !isObject && path != calDAVRoot && path != calDAVPrincipal && path != calDAVHome && path != calDAVCalendar {
+        http.NotFound(w, r)
+        return
+    }
+
+    allow := "OPTIONS, PROPFIND"
+    if path == calDAVCalendar {
+        allow += ", REPORT"
+    }
+    if isObject {
+        allow += ", GET, HEAD, PUT, DELETE"
+    }
+
+    switch {
+    case r.Method == http.MethodOptions:
+        w.Header().Set("DAV", "1, 3, calendar-access")
+        w.Header().Set("Allow", allow)
+    case r.Method == "PROPFIND":
+        h.calDAVPropfind(w, r, userId, path)
+    case r.Method == "REPORT" && path == calDAVCalendar:
+        h.calDAVReport(w, r, userId)
+    case (r.Method == http.MethodGet || r.Method == http.MethodHead) && isObject:
+        h.getCalDAVObject(w, r, userId, name)
+    case r.Method == http.MethodPut && isObject:
+        h.putCalDAVObject(w, r, userId, name)
+    case r.Method == http.MethodDelete && isObject:
+        h.deleteCalDAVObject(w, r, userId, name)
+    default:
+        w.Header().Set("Allow", allow)
+        http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+    }
+}
+
+// calDAVPropfind answers a PROPFIND for the resource at the path, and its members too unless the request has `Depth: 0`. Depth infinity is answered as 1, which is all that clients need to find the calendar.
+func (h *RealHandler) calDAVPropfind(w http.ResponseWriter, r *http.Request, userId int, path string) {
+    req, err := caldav.ParsePropfind(r.Body)
+    if err != nil {
+        http.Error(w, "bad request: "+err.Error(), http.StatusBadRequest)
+        return
+    }
+
+    resources, err := h.calDAVResources(userId, path, r.Header.Get("Depth") != "0")
+    if errors.Is(err, db.ErrTaskNotFound) {
+        http.NotFound(w, r)
+        return
+    }
+    if err != nil {
+        log.Println("Error getting CalDAV resources: ", err)
+        http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+        return
+    }
+
+    responses := make([]caldav.Response, len(resources))
+    for i, res := range resources {
+        responses[i] = res.response(req)
+    }
+    caldav.WriteMultistatus(w, responses)
+}
+
+// calDAVResources returns the resource at the path, followed by its members if they're asked for.
+func (h *RealHandler) calDAVResources(userId int, path string, members bool) ([]calDAVResource, error) {
+    principal := caldav.HrefProperty(caldav.CurrentUserPrincipal, calDAVPrincipal)
+    home := calDAVResource{href: calDAVHome, props: []caldav.Property{
+        caldav.ResourceTypeProperty(caldav.Collection),
+        principal,
+    }}
+
+    switch path {
+    case calDAVRoot:
+        root := calDAVResource{href: calDAVRoot, props: []caldav.Property{
+            caldav.ResourceTypeProperty(caldav.Collection),
+            caldav.TextProperty(caldav.DisplayName, "Penumbra"),
+            principal,
+        }}
+        if !members {
+            return []calDAVResource{root}, nil
+        }
+        user, err := h.calDAVPrincipalResource(userId)
+        return []calDAVResource{root, user, home}, err
+    case calDAVPrincipal:
+        user, err := h.calDAVPrincipalResource(userId)
+        return []calDAVResource{user}, err
+    case calDAVHome:
+        if !members {
+            return []calDAVResource{home}, nil
+        }
+        calendar, _, err := h.calDAVCalendarResources(userId)
+        return []calDAVResource{home, calendar}, err
+    case calDAVCalendar:
+        calendar, objects, err := h.calDAVCalendarResources(userId)
+        if !members {
+            return []calDAVResource{calendar}, err
+        }
+        return append([]calDAVResource{calendar}, objects...), err
+    }
+
+    o, err := h.store.GetCalDAVObject(userId, strings.TrimPrefix(path, calDAVCalendar))
+    if err != nil {
+        return nil, err
+    }
+    object, err := calDAVObjectResource(o)
+    return []calDAVResource{object}, err
+}
+
+func (h *RealHandler) calDAVPrincipalResource(userId int) (calDAVResource, error) {
+    user, err := h.store.GetUserById(userId)
+    if err != nil {
+        return calDAVResource{}, err
+    }
+    return calDAVResource{href: calDAVPrincipal, props: []caldav.Property{
+        caldav.ResourceTypeProperty(caldav.Principal),
+        caldav.TextProperty(caldav.DisplayName, user.Name),
+        caldav.HrefProperty(caldav.PrincipalURL, calDAVPrincipal),
+        caldav.HrefProperty(caldav.CalendarHomeSet, calDAVHome),
+        caldav.HrefProperty(caldav.CurrentUserPrincipal, calDAVPrincipal),
+    }}, nil
+}
+
+// calDAVCalendarResources returns the calendar of the user's tasks, and the to-dos in it. Its CTag and ETag are a hash of the names and ETags of the to-dos, so change whenever any of them does.
+func (h *RealHandler) calDAVCalendarResources(userId int) (calDAVResource, []calDAVResource, error) {
+    stored, err := h.store.GetCalDAVObjects(userId)
+    if err != nil {
+        return calDAVResource{}, nil, err
+    }
+
+    objects := make([]calDAVResource, len(stored))
+    var tags bytes.Buffer
+    for i, o := range stored {
+        if objects[i], err = calDAVObjectResource(o); err != nil {
+            return calDAVResource{}, nil, err
+        }
+        tags.WriteString(o.Name + " " + objects[i].etag + "\n")
+    }
+    ctag := contentETag(tags.Bytes())
+
+    calendar := calDAVResource{href: calDAVCalendar, props: []caldav.Property{
+        caldav.ResourceTypeProperty(caldav.Collection, caldav.Calendar),
+        caldav.TextProperty(caldav.DisplayName, "Tasks"),
+        caldav.ComponentSetProperty("VTODO"),
+        caldav.ReportSetProperty(caldav.CalendarQuery, caldav.CalendarMultiget),
+        caldav.TextProperty(caldav.GetCTag, ctag),
+        caldav.TextProperty(caldav.GetETag, ctag),
+        caldav.HrefProperty(caldav.CurrentUserPrincipal, calDAVPrincipal),
+    }}
+    return calendar, objects, nil
+}
+
+// calDAVObjectResource is the resource of a to-do. Its DTSTAMP is when the task was made, so that it, and the ETag, only change when the task does.
+func calDAVObjectResource(o db.CalDAVObject) (calDAVResource, error) {
+    stamp := o.Task.CreatedAt
+    if stamp.IsZero() {
+        stamp = o.Task.Due
+    }
+    todo := ical.Todo{UID: o.UID, Task: o.Task}
+
+    var body bytes.Buffer
+    if err := ical.EncodeTodos(&body, []ical.Todo{todo}, stamp); err != nil {
+        return calDAVResource{}, err
+    }
+    etag := contentETag(body.Bytes())
+
+    due, recurring := o.Task.Due, o.Task.Recurrence != ""
+    component := caldav.Component{Name: "VCALENDAR", Components: []caldav.Component{{
+        Name:       "VTODO",
+        Properties: ical.Properties(todo, stamp),
+        // A to-do that's only due overlaps a time range it's due in, per section 9.9. A repeating one is due in all of them, as far as a client can tell without expanding it.
+        Overlaps: func(tr caldav.TimeRange) bool {
+            return recurring || (tr.Start.IsZero() || tr.Start.Before(due)) && (tr.End.IsZero() || !tr.End.Before(due))
+        },
+    }}}
+
+    return calDAVResource{
+        href: calDAVCalendar + url.PathEscape(o.Name),
+        props: []caldav.Property{
+            caldav.ResourceTypeProperty(),
+            caldav.TextProperty(caldav.GetETag, etag),
+            caldav.TextProperty(caldav.GetContentType, calDAVContentType),
+            caldav.TextProperty(caldav.CalendarData, body.String()),
+        },
+        etag:      etag,
+        body:      body.Bytes(),
+        component: component,
+    }, nil
+}
+
+// calDAVReport answers a calendar-multiget with the to-dos asked for, and a calendar-query with those matching its filter.
+func (h *RealHandler) calDAVReport(w http.ResponseWriter, r *http.Request, userId int) {
+    report, err := caldav.ParseReport(r.Body)
+    if errors.Is(err, caldav.ErrUnsupportedReport) {
+        caldav.WriteError(w, http.StatusForbidden, caldav.SupportedReport)
+        return
+    }
+    if err != nil {
+        http.Error(w, "bad request: "+err.Error(), http.StatusBadRequest)
+        return
+    }
+
+    _, objects, err := h.calDAVCalendarResources(userId)
+    if err != nil {
+        log.Println("Error getting CalDAV resources: ", err)
+        http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+        return
+    }
+
+    responses := []caldav.Response{}
+    if report.Filter == nil {
+        byHref := map[string]calDAVResource{}
+        for _, o := range objects {
+            byHref[o.href] = o
+        }
+        for _, href := range report.Hrefs {
+            o, ok := byHref[calDAVHref(href)]
+            if !ok {
+                responses = append(responses, caldav.Response{Href: href, Status: http.StatusNotFound})
+                continue
+            }
+            responses = append(responses, o.response(report.Props))
+        }
+    } else {
+        for _, o := range objects {
+            if report.Filter.Match(o.component) {
+                responses = append(responses, o.response(report.Props))
+            }
+        }
+    }
+    caldav.WriteMultistatus(w, responses)
+}
+
+// calDAVHref is the path of an href, which may be a full URL, escaped the way this server escapes it.
+func calDAVHref(href string) string {
+    u, err := url.Parse(href)
+    if err != nil {
+        return href
+    }
+    name, ok := strings.CutPrefix(u.Path, calDAVCalendar)
+    if !ok {
+        return u.Path
+    }
+    return calDAVCalendar + url.PathEscape(name)
+}
+
+func (h *RealHandler) getCalDAVObject(w http.ResponseWriter, r *http.Request, userId int, name string) {
+    o, err := h.store.GetCalDAVObject(userId, name)
+    if errors.Is(err, db.ErrTaskNotFound) {
+        http.NotFound(w, r)
+        return
+    }
+    var object calDAVResource
+    if err == nil {
+        object, err = calDAVObjectResource(o)
+    }
+    if err != nil {
+        log.Println("Error getting CalDAV object: ", err)
+        http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+        return
+    }
+
+    w.Header().Set("Content-Type", calDAVContentType)
+    w.Header().Set("ETag", object.etag)
+    http.ServeContent(w, r, name, time.Time{}, bytes.NewReader(object.body))
+}
+
+// existingCalDAVObject returns the to-do with the name and its ETag, if there is one, and whether the request's conditions on it hold: `If-None-Match: *` only puts a new one, and `If-Match` only changes one with an ETag it lists.
+func (h *RealHandler) existingCalDAVObject(r *http.Request, userId int, name string) (db.CalDAVObject, bool, bool, error) {
+    o, err := h.store.GetCalDAVObject(userId, name)
+    if errors.Is(err, db.ErrTaskNotFound) {
+        return db.CalDAVObject{}, false, r.Header.Get("If-Match") == "", nil
+    }
+    if err != nil {
+        return db.CalDAVObject{}, false, false, err
+    }
+    object, err := calDAVObjectResource(o)
+    if err != nil {
+        return db.CalDAVObject{}, false, false, err
+    }
+
+    if strings.TrimSpace(r.Header.Get("If-None-Match")) == "*" {
+        return o, true, false, nil
+    }
+    if ifMatch := r.Header.Get("If-Match"); ifMatch != "" {
+        for _, etag := range strings.Split(ifMatch, ",") {
+            if etag = strings.TrimSpace(etag); etag == "*" || etag == object.etag {
+                return o, true, true, nil
+            }
+        }
+        return o, true, false, nil
+    }
+    return o, true, true, nil
+}
+
+// putCalDAVObject saves a to-do from a client, which must be one the tasks here can hold. The tasks it's saved as may not say everything it did, so it's sent back without an ETag, and clients fetch what was saved, per section 5.3.4.
+func (h *RealHandler) putCalDAVObject(w http.ResponseWriter, r *http.Request, userId int, name string) {
+    _, _, ok, err := h.existingCalDAVObject(r, userId, name)
+    if err != nil {
+        log.Println("Error getting CalDAV object: ", err)
+        http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+        return
+    }
+    if !ok {
+        http.Error(w, "precondition failed", http.StatusPreconditionFailed)
+        return
+    }
+
+    loc, err := h.userLocation(userId)
+    if err != nil {
+        log.Println("Error getting user: ", err)
+        http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+        return
+    }
+    todos, err := ical.DecodeTodos(http.MaxBytesReader(w, r.Body, maxCalDAVObjectBytes), loc)
+    var tooLarge *http.MaxBytesError
+    if errors.As(err, &tooLarge) {
+        http.Error(w, "request entity too large", http.StatusRequestEntityTooLarge)
+        return
+    }
+    if err != nil {
+        caldav.WriteError(w, http.StatusForbidden, caldav.ValidCalendarData)
+        return
+    }
+    if len(todos) == 0 {
+        caldav.WriteError(w, http.StatusForbidden, caldav.SupportedCalendarComponent)
+        return
+    }
+    if len(todos) > 1 || todos[0].UID == "" {
+        caldav.WriteError(w, http.StatusForbidden, caldav.ValidCalendarObjectResource)
+        return
+    }
+
+    // As with imports, a repeat schedule that can't be followed here is left off rather than refused.
+    task := todos[0].Task
+    task.Title = strings.TrimSpace(task.Title)
+    if task.Recurrence != "" {
+        if _, err := recur.Parse(task.Recurrence); err != nil {
+            task.Recurrence = ""
+        }
+        task.Recurrence = normalizeRecurrence(task.Recurrence)
+    }
+    if errs := validate.Task(task, time.Now()); len(errs) > 0 {
+        caldav.WriteError(w, http.StatusForbidden, caldav.ValidCalendarObjectResource)
+        return
+    }
+
+    _, created, err := h.store.PutCalDAVObject(userId, db.CalDAVObject{Name: name, UID: todos[0].UID, Task: task})
+    if errors.Is(err, db.ErrUIDConflict) {
+        caldav.WriteError(w, http.StatusForbidden, caldav.NoUIDConflict)
+        return
+    }
+    if err != nil {
+        log.Println("Error saving CalDAV object: ", err)
+        http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+        return
+    }
+
+    if created {
+        w.WriteHeader(http.StatusCreated)
+    } else {
+        w.WriteHeader(http.StatusNoContent)
+    }
+}
+
+func (h *RealHandler) deleteCalDAVObject(w http.ResponseWriter, r *http.Request, userId int, name string) {
+    o, exists, ok, err := h.existingCalDAVObject(r, userId, name)
+    if err != nil {
+        log.Println("Error getting CalDAV object: ", err)
+        http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+        return
+    }
+    if !exists {
+        http.NotFound(w, r)
+        return
+    }
+    if !ok {
+        http.Error(w, "precondition failed", http.StatusPreconditionFailed)
+        return
+    }
+
+    if err := h.store.DeleteTask(userId, o.Task.Id); err != nil {
+        taskError(w, err)
+        return
+    }
+    w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,256 @@
+package api
+
+import (
+	"database/sql"
+	"html/template"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+
+	"penumbra/app"
+	"penumbra/db"
+)
+
+// newCalDAVTestStore is a store in a temporary database with every migration applied, so that what CalDAV clients do can be checked against what the rest of the app sees.
+func newCalDAVTestStore(t *testing.T) *db.SQLiteStore {
+    path := filepath.Join(t.TempDir(), "penumbra.db")
+    conn, err := sql.Open("sqlite", path)
+    require.NoError(t, err)
+    migrations, err := filepath.Glob("../migrations/*.up.sql")
+    require.NoError(t, err)
+    sort.Strings(migrations)
+    for _, m := range migrations {
+        migration, err := os.ReadFile(m)
+        require.NoError(t, err)
+        _, err = conn.Exec(string(migration))
+        require.NoError(t, err, m)
+    }
+    require.NoError(t, conn.Close())
+
+    store, err := db.NewSQLiteStore(path)
+    require.NoError(t, err)
+    t.Cleanup(func() { store.Close() })
+    return store
+}
+
+// calDAVClient makes requests the way a calendar app would.
+type calDAVClient struct {
+    t        *testing.T
+    server   *httptest.Server
+    email    string
+    password string
+}
+
+func (c calDAVClient) do(method, path string, headers map[string]string, body string) (*http.Response, string) {
+    req, err := http.NewRequest(method, c.server.URL+path, strings.NewReader(body))
+    require.NoError(c.t, err)
+    if c.password != "" {
+        req.SetBasicAuth(c.email, c.password)
+    }
+    for name, value := range headers {
+        req.Header.Set(name, value)
+    }
+
+    client := &http.Client{CheckRedirect: func(*http.Request, []*http.Request) error { return http.ErrUseLastResponse }}
+    resp, err := client.Do(req)
+    require.NoError(c.t, err)
+    defer resp.Body.Close()
+    b, err := io.ReadAll(resp.Body)
+    require.NoError(c.t, err)
+    return resp, string(b)
+}
+
+func calendarWith(lines ...string) string {
+    return strings.Join(append(append([]string{"BEGIN:VCALENDAR", "VERSION:2.0", "PRODID:-//Example//Phone//EN"}, lines...), "END:VCALENDAR", ""), "\r\n")
+}
+
+// TestCalDAVSync walks through a sync session like those calendar apps start with, then changes made on either side.
+func TestCalDAVSync(t *testing.T) {
+    store := newCalDAVTestStore(t)
+    require.NoError(t, store.CreateUser(app.User{Name: "Sam", Email: "sam@example.com", PasswordHash: []byte("x")}))
+    user, err := store.GetUserByEmail("sam@example.com")
+    require.NoError(t, err)
+    token, err := store.CreateAPIToken(user.Id, "Phone", app.ScopeReadWrite, time.Time{})
+    require.NoError(t, err)
+
+    webTask := app.Task{Id: uuid.New(), UserId: user.Id, Title: "Renew passport", Due: time.Date(2030, 3, 1, 9, 0, 0, 0, time.UTC), CreatedAt: time.Now().UTC()}
+    require.NoError(t, store.CreateTask(webTask))
+    webHref := calDAVCalendar + webTask.Id.String() + ".ics"
+
+    server := httptest.NewServer(NewRouter(NewHandler(store, template.Must(template.New("layout").Parse(`{{.Page}}`)))))
+    defer server.Close()
+    client := calDAVClient{t, server, "Sam@Example.com", token}
+
+    // Clients without credentials are asked for them, and those only told the server's address find it.
+    resp, _ := calDAVClient{t: t, server: server}.do("PROPFIND", calDAVRoot, nil, "")
+    assert.Equal(t, http.StatusUnauthorized, resp.StatusCode)
+    assert.Contains(t, resp.Header.Get("WWW-Authenticate"), "Basic")
+    resp, _ = calDAVClient{t, server, "someone@example.com", token}.do("PROPFIND", calDAVRoot, nil, "")
+    assert.Equal(t, http.StatusUnauthorized, resp.StatusCode)
+    resp, _ = client.do(http.MethodGet, "/.well-known/caldav", nil, "")
+    assert.Equal(t, http.StatusMovedPermanently, resp.StatusCode)
+    assert.Equal(t, calDAVRoot, resp.Header.Get("Location"))
+
+    resp, _ = client.do(http.MethodOptions, calDAVCalendar, nil, "")
+    assert.Equal(t, http.StatusOK, resp.StatusCode)
+    assert.Contains(t, resp.Header.Get("DAV"), "calendar-access")
+
+    // Discovery: the principal, its calendar home, and the calendar in it.
+    resp, body := client.do("PROPFIND", calDAVRoot, map[string]string{"Depth": "0"},
+        `<propfind xmlns="DAV:"><prop><current-user-principal/></prop></propfind>`)
+    assert.Equal(t, http.StatusMultiStatus, resp.StatusCode)
+    assert.Contains(t, body, "<d:current-user-principal><d:href>/caldav/principal/</d:href></d:current-user-principal>")
+
+    _, body = client.do("PROPFIND", calDAVPrincipal, map[string]string{"Depth": "0"},
+        `<propfind xmlns="DAV:" xmlns:C="urn:ietf:params:xml:ns:caldav"><prop><displayname/><C:calendar-home-set/></prop></propfind>`)
+    assert.Contains(t, body, "<d:displayname>Sam</d:displayname><c:calendar-home-set><d:href>/caldav/calendars/</d:href></c:calendar-home-set>")
+
+    _, body = client.do("PROPFIND", calDAVHome, map[string]string{"Depth": "1"},
+        `<propfind xmlns="DAV:" xmlns:C="urn:ietf:params:xml:ns:caldav" xmlns:A="http://apple.com/ns/ical/"><prop><resourcetype/><C:supported-calendar-component-set/><A:calendar-color/></prop></propfind>`)
+    assert.Contains(t, body, "<d:href>/caldav/calendars/tasks/</d:href><d:propstat><d:prop><d:resourcetype><d:collection/><c:calendar/></d:resourcetype><c:supported-calendar-component-set><c:comp name=\"VTODO\"/></c:supported-calendar-component-set></d:prop>")
+    assert.Contains(t, body, `<x:calendar-color xmlns:x="http://apple.com/ns/ical/"/></d:prop><d:status>HTTP/1.1 404 Not Found</d:status>`)
+
+    // Tasks made on the web are in the calendar already.
+    ctag := func() string {
+        _, body := client.do("PROPFIND", calDAVCalendar, map[string]string{"Depth": "0"},
+            `<propfind xmlns="DAV:" xmlns:CS="http://calendarserver.org/ns/"><prop><CS:getctag/></prop></propfind>`)
+        _, rest, _ := strings.Cut(body, "<cs:getctag>")
+        tag, _, _ := strings.Cut(rest, "</cs:getctag>")
+        require.NotEmpty(t, tag)
+        return tag
+    }
+    before := ctag()
+    _, body = client.do("PROPFIND", calDAVCalendar, map[string]string{"Depth": "1"}, `<propfind xmlns="DAV:"><prop><getetag/></prop></propfind>`)
+    assert.Contains(t, body, "<d:href>"+webHref+"</d:href>")
+
+    // A to-do made on the phone lands in the tasks the web UI shows.
+    rent := calendarWith(
+        "BEGIN:VTODO",
+        "UID:rent@phone.example.com",
+        "SUMMARY:Pay rent",
+        "DUE;VALUE=DATE:20300107",
+        "STATUS:NEEDS-ACTION",
+        "END:VTODO",
+    )
+    resp, _ = client.do(http.MethodPut, calDAVCalendar+"rent.ics", map[string]string{"If-None-Match": "*", "Content-Type": "text/calendar"}, rent)
+    assert.Equal(t, http.StatusCreated, resp.StatusCode)
+    assert.Empty(t, resp.Header.Get("ETag"))
+    resp, _ = client.do(http.MethodPut, calDAVCalendar+"rent.ics", map[string]string{"If-None-Match": "*"}, rent)
+    assert.Equal(t, http.StatusPreconditionFailed, resp.StatusCode)
+    resp, _ = client.do(http.MethodPut, calDAVCalendar+"other.ics", nil, rent)
+    assert.Equal(t, http.StatusForbidden, resp.StatusCode)
+
+    tasks, err := store.QueryTasks(user.Id, db.TaskQuery{})
+    require.NoError(t, err)
+    require.Len(t, tasks, 2)
+    var rentTask app.Task
+    for _, task := range tasks {
+        if task.Title == "Pay rent" {
+            rentTask = task
+        }
+    }
+    assert.True(t, rentTask.AllDay)
+    assert.NotEqual(t, before, ctag())
+
+    // Syncing fetches what changed.
+    _, body = client.do("REPORT", calDAVCalendar, map[string]string{"Depth": "1"},
+        `<C:calendar-multiget xmlns:D="DAV:" xmlns:C="urn:ietf:params:xml:ns:caldav"><D:prop><D:getetag/><C:calendar-data/></D:prop>`+
+            `<D:href>`+server.URL+calDAVCalendar+`rent.ics</D:href><D:href>`+webHref+`</D:href><D:href>/caldav/calendars/tasks/gone.ics</D:href></C:calendar-multiget>`)
+    // Line breaks are escaped, so that XML parsers keep them.
+    assert.Contains(t, body, "UID:rent@phone.example.com&#xD;&#xA;")
+    assert.Contains(t, body, "DUE;VALUE=DATE:20300107&#xD;&#xA;")
+    assert.Contains(t, body, "UID:"+webTask.Id.String()+"&#xD;&#xA;")
+    assert.Contains(t, body, "<d:href>/caldav/calendars/tasks/gone.ics</d:href><d:status>HTTP/1.1 404 Not Found</d:status>")
+
+    query := func(filter string) string {
+        _, body := client.do("REPORT", calDAVCalendar, map[string]string{"Depth": "1"},
+            `<C:calendar-query xmlns:D="DAV:" xmlns:C="urn:ietf:params:xml:ns:caldav"><D:prop><D:getetag/></D:prop>`+
+                `<C:filter><C:comp-filter name="VCALENDAR"><C:comp-filter name="VTODO">`+filter+`</C:comp-filter></C:comp-filter></C:filter></C:calendar-query>`)
+        return body
+    }
+    body = query(`<C:prop-filter name="COMPLETED"><C:is-not-defined/></C:prop-filter>`)
+    assert.Contains(t, body, calDAVCalendar+"rent.ics")
+    assert.Contains(t, body, webHref)
+    body = query(`<C:time-range start="20300201T000000Z" end="20300401T000000Z"/>`)
+    assert.NotContains(t, body, calDAVCalendar+"rent.ics")
+    assert.Contains(t, body, webHref)
+
+    // Changing a to-do needs its current ETag.
+    resp, body = client.do(http.MethodGet, calDAVCalendar+"rent.ics", nil, "")
+    assert.Equal(t, http.StatusOK, resp.StatusCode)
+    assert.Equal(t, calDAVContentType, resp.Header.Get("Content-Type"))
+    assert.Contains(t, body, "SUMMARY:Pay rent\r\n")
+    etag := resp.Header.Get("ETag")
+    resp, _ = client.do(http.MethodGet, calDAVCalendar+"rent.ics", map[string]string{"If-None-Match": etag}, "")
+    assert.Equal(t, http.StatusNotModified, resp.StatusCode)
+
+    done := strings.Replace(rent, "STATUS:NEEDS-ACTION", "STATUS:COMPLETED\r\nCOMPLETED:20300106T180000Z", 1)
+    resp, _ = client.do(http.MethodPut, calDAVCalendar+"rent.ics", map[string]string{"If-Match": `"stale"`}, done)
+    assert.Equal(t, http.StatusPreconditionFailed, resp.StatusCode)
+    resp, _ = client.do(http.MethodPut, calDAVCalendar+"rent.ics", map[string]string{"If-Match": etag}, done)
+    assert.Equal(t, http.StatusNoContent, resp.StatusCode)
+
+    rentTask, err = store.GetTaskById(user.Id, rentTask.Id)
+    require.NoError(t, err)
+    assert.Equal(t, 1, rentTask.Done)
+    assert.Equal(t, time.Date(2030, 1, 6, 18, 0, 0, 0, time.UTC), rentTask.CompletedAt.UTC())
+
+    // Changes made on the web show up at the next sync.
+    webTask.Title = "Renew passport and visa"
+    require.NoError(t, store.UpdateTask(webTask))
+    _, body = client.do(http.MethodGet, webHref, nil, "")
+    assert.Contains(t, body, "SUMMARY:Renew passport and visa\r\n")
+
+    // A read-only token can sync, but not change anything.
+    readOnly, err := store.CreateAPIToken(user.Id, "Laptop", app.ScopeRead, time.Time{})
+    require.NoError(t, err)
+    resp, _ = calDAVClient{t, server, "sam@example.com", readOnly}.do("REPORT", calDAVCalendar, nil,
+        `<C:calendar-multiget xmlns:D="DAV:" xmlns:C="urn:ietf:params:xml:ns:caldav"><D:prop><D:getetag/></D:prop></C:calendar-multiget>`)
+    assert.Equal(t, http.StatusMultiStatus, resp.StatusCode)
+    resp, _ = calDAVClient{t, server, "sam@example.com", readOnly}.do(http.MethodDelete, webHref, nil, "")
+    assert.Equal(t, http.StatusForbidden, resp.StatusCode)
+
+    // Deleting on the phone deletes the task.
+    resp, _ = client.do(http.MethodDelete, calDAVCalendar+"rent.ics", nil, "")
+    assert.Equal(t, http.StatusNoContent, resp.StatusCode)
+    resp, _ = client.do(http.MethodGet, calDAVCalendar+"rent.ics", nil, "")
+    assert.Equal(t, http.StatusNotFound, resp.StatusCode)
+    _, err = store.GetTaskById(user.Id, rentTask.Id)
+    assert.ErrorIs(t, err, db.ErrTaskNotFound)
+}
+
+func TestPutCalDAVObjectRefusesWhatTasksCantHold(t *testing.T) {
+    mockStore := new(MockSQLiteStore)
+    handler := &RealHandler{store: mockStore}
+    mockStore.On("GetCalDAVObject", 1, "a.ics").Return(db.CalDAVObject{}, db.ErrTaskNotFound)
+    mockStore.On("GetUserById", 1).Return(app.User{Id: 1}, nil)
+
+    cases := map[string]struct {
+        body      string
+        condition string
+    }{
+        "not a calendar": {"hello", "valid-calendar-data"},
+        "an event":       {calendarWith("BEGIN:VEVENT", "UID:a", "DTSTART:20300107T120000Z", "END:VEVENT"), "supported-calendar-component"},
+        "two to-dos":     {calendarWith("BEGIN:VTODO", "UID:a", "SUMMARY:A", "DUE:20300107T120000Z", "END:VTODO", "BEGIN:VTODO", "UID:b", "SUMMARY:B", "DUE:20300107T120000Z", "END:VTODO"), "valid-calendar-object-resource"},
+        "no UID":         {calendarWith("BEGIN:VTODO", "SUMMARY:A", "DUE:20300107T120000Z", "END:VTODO"), "valid-calendar-object-resource"},
+        "no due date":    {calendarWith("BEGIN:VTODO", "UID:a", "SUMMARY:Someday", "END:VTODO"), "valid-calendar-object-resource"},
+    }
+    for name, c := range cases {
+        rr := httptest.NewRecorder()
+        handler.ServeCalDAV(rr, httptest.NewRequest(http.MethodPut, calDAVCalendar+"a.ics", strings.NewReader(c.body)), 1)
+        assert.Equal(t, http.StatusForbidden, rr.Code, name)
+        assert.Contains(t, rr.Body.String(), "<c:"+c.condition+"/>", name)
+    }
+    mockStore.AssertNotCalled(t, "PutCalDAVObject", mock.Anything, mock.Anything)
+}
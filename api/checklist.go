@@ -0,0 +1,204 @@
+package api
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/google/uuid"
+
+	"penumbra/app"
+	"penumbra/db"
+	"penumbra/validate"
+)
+
+// ChecklistRow is an item of the checklist on the task page, with the positions its move buttons send.
+type ChecklistRow struct {
+    app.ChecklistItem
+    Up   int
+    Down int
+    Last bool
+}
+
+// ChecklistOpen returns how many of the task's checklist items aren't ticked yet.
+func (t TaskView) ChecklistOpen() int {
+    return t.ChecklistTotal - t.ChecklistDone
+}
+
+// withChecklist loads the task's checklist into the view.
+func (h *RealHandler) withChecklist(userId int, view TaskView) (TaskView, error) {
+    items, err := h.store.GetChecklist(userId, view.Id)
+    if err != nil {
+        return view, err
+    }
+
+    view.Checklist = make([]ChecklistRow, len(items))
+    view.ChecklistTotal = len(items)
+    view.ChecklistDone = 0
+    for i, item := range items {
+        view.Checklist[i] = ChecklistRow{ChecklistItem: item, Up: i - 1, Down: i + 1, Last: i == len(items)-1}
+        if item.Done {
+            view.ChecklistDone++
+        }
+    }
+    return view, nil
+}
+
+// AddChecklistItem adds the `text` of the form to the end of the task's checklist.
+func (h *RealHandler) AddChecklistItem(w http.ResponseWriter, r *http.Request, userId int, taskId uuid.UUID) {
+    text := strings.TrimSpace(r.FormValue("text"))
+    message := validate.ChecklistItem(text)
+
+    if message == "" {
+        items, err := h.store.GetChecklist(userId, taskId)
+        if err != nil {
+            checklistError(w, err)
+            return
+        }
+        if len(items) >= validate.MaxChecklistItems {
+            message = fmt.Sprintf("A checklist can have at most %d items.", validate.MaxChecklistItems)
+        }
+    }
+
+    if message != "" {
+        task, err := h.store.GetTaskById(userId, taskId)
+        if err != nil {
+            checklistError(w, err)
+            return
+        }
+        view, err := h.withChecklist(userId, taskViewFor(task))
+        if err != nil {
+            checklistError(w, err)
+            return
+        }
+        view.Errors = validate.Errors{"checklist": message}
+
+        w.WriteHeader(http.StatusBadRequest)
+        h.RenderPage(w, r, "task", view)
+        return
+    }
+
+    if _, err := h.store.AddChecklistItem(userId, taskId, text); err != nil {
+        checklistError(w, err)
+        return
+    }
+
+    redirectToTask(w, r, taskId)
+}
+
+// ToggleChecklistItem ticks the form's `item` if `done` is 1 and unticks it otherwise. Ticking the last open item completes the task.
+func (h *RealHandler) ToggleChecklistItem(w http.ResponseWriter, r *http.Request, userId int, taskId uuid.UUID) {
+    itemId, ok := checklistItemId(w, r)
+    if !ok {
+        return
+    }
+
+    done := r.FormValue("done") == "1"
+    if err := h.store.SetChecklistItemDone(userId, taskId, itemId, done); err != nil {
+        checklistError(w, err)
+        return
+    }
+
+    if done {
+        if err := h.completeIfChecklistDone(userId, taskId); err != nil {
+            checklistError(w, err)
+            return
+        }
+    }
+
+    redirectToTask(w, r, taskId)
+}
+
+// completeIfChecklistDone marks a task as done once every item of its checklist is ticked.
+func (h *RealHandler) completeIfChecklistDone(userId int, taskId uuid.UUID) error {
+    task, err := h.store.GetTaskById(userId, taskId)
+    if err != nil {
+        return err
+    }
+    if task.Done == 1 || task.ChecklistTotal == 0 || task.ChecklistDone < task.ChecklistTotal {
+        return nil
+    }
+
+    _, err = h.store.SetTaskDone(userId, taskId, true)
+    return err
+}
+
+// MoveChecklistItem moves the form's `item` to `position`, counting from 0.
+func (h *RealHandler) MoveChecklistItem(w http.ResponseWriter, r *http.Request, userId int, taskId uuid.UUID) {
+    itemId, ok := checklistItemId(w, r)
+    if !ok {
+        return
+    }
+
+    position, err := strconv.Atoi(r.FormValue("position"))
+    if err != nil {
+        http.Error(w, "invalid position", http.StatusBadRequest)
+        return
+    }
+
+    if err := h.store.MoveChecklistItem(userId, taskId, itemId, position); err != nil {
+        checklistError(w, err)
+        return
+    }
+
+    redirectToTask(w, r, taskId)
+}
+
+// DeleteChecklistItem removes the form's `item` from the task's checklist.
+func (h *RealHandler) DeleteChecklistItem(w http.ResponseWriter, r *http.Request, userId int, taskId uuid.UUID) {
+    itemId, ok := checklistItemId(w, r)
+    if !ok {
+        return
+    }
+
+    if err := h.store.DeleteChecklistItem(userId, taskId, itemId); err != nil {
+        checklistError(w, err)
+        return
+    }
+
+    redirectToTask(w, r, taskId)
+}
+
+func checklistItemId(w http.ResponseWriter, r *http.Request) (int, bool) {
+    id, err := strconv.Atoi(r.FormValue("item"))
+    if err != nil {
+        http.Error(w, "invalid checklist item", http.StatusBadRequest)
+        return 0, false
+    }
+    return id, true
+}
+
+func redirectToTask(w http.ResponseWriter, r *http.Request, taskId uuid.UUID) {
+    http.Redirect(w, r, "/tasks/"+taskId.String(), http.StatusSeeOther)
+}
+
+// checklistError reports an error from a checklist method of the store, treating missing tasks and missing items alike.
+func checklistError(w http.ResponseWriter, err error) {
+    if errors.Is(err, db.ErrChecklistItemNotFound) {
+        err = db.ErrTaskNotFound
+    }
+    taskError(w, err)
+}
+
+// taskViewFor prepares a task for the task page.
+func taskViewFor(task app.Task) TaskView {
+    view := TaskView{
+        Id:             task.Id,
+        Title:          task.Title,
+        Status:         task.Status,
+        DuePretty:      dueDate(task),
+        DueTime:        dueTime(task),
+        Description:    task.Description,
+        Recurrence:     recurrenceFormFor(task.Recurrence),
+        ChecklistTotal: task.ChecklistTotal,
+        ChecklistDone:  task.ChecklistDone,
+        Tags:           task.Tags,
+        Priority:       task.Priority,
+    }
+    if !task.CompletedAt.IsZero() {
+        view.CompletedPretty = task.CompletedAt.In(app.Location(task.TimeZone)).Format("Mon Jan 2 2006 15:04")
+    }
+    return view
+}
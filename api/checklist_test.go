@@ -0,0 +1,174 @@
+package api
+
+import (
+	"html/template"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+
+	"penumbra/app"
+	"penumbra/db"
+	"penumbra/validate"
+)
+
+func newChecklistTestHandler(store db.Store) *RealHandler {
+    h := newTestHandler(store)
+    h.templates = template.Must(template.New("layout").Parse(`{{.Page}}|{{.Data.ChecklistDone}}/{{.Data.ChecklistTotal}}|{{.Data.Errors}}`))
+    return h
+}
+
+func TestAddChecklistItem(t *testing.T) {
+    mockStore := new(MockSQLiteStore)
+    handler := newChecklistTestHandler(mockStore)
+
+    id := uuid.New()
+    mockStore.On("GetChecklist", 2, id).Return([]app.ChecklistItem{}, nil).Once()
+    mockStore.On("AddChecklistItem", 2, id, "Buy milk").Return(app.ChecklistItem{Id: 1, TaskId: id, Text: "Buy milk"}, nil).Once()
+
+    rr := httptest.NewRecorder()
+    handler.AddChecklistItem(rr, formRequest("/tasks/checklist/add/"+id.String(), url.Values{"text": {"  Buy milk "}}), 2, id)
+
+    assert.Equal(t, http.StatusSeeOther, rr.Code)
+    assert.Equal(t, "/tasks/"+id.String(), rr.Header().Get("Location"))
+    mockStore.AssertExpectations(t)
+}
+
+func TestAddChecklistItemShowsErrors(t *testing.T) {
+    id := uuid.New()
+    full := make([]app.ChecklistItem, validate.MaxChecklistItems)
+
+    cases := map[string]struct {
+        text  string
+        items []app.ChecklistItem
+    }{
+        "blank":    {"   ", []app.ChecklistItem{{Id: 1, Done: true}}},
+        "too long": {strings.Repeat("x", validate.MaxChecklistItemLength+1), []app.ChecklistItem{{Id: 1, Done: true}}},
+        "full":     {"One more", full},
+    }
+
+    for name, tc := range cases {
+        t.Run(name, func(t *testing.T) {
+            mockStore := new(MockSQLiteStore)
+            handler := newChecklistTestHandler(mockStore)
+
+            mockStore.On("GetTaskById", 2, id).Return(app.Task{Id: id, UserId: 2, Status: "pending"}, nil).Once()
+            mockStore.On("GetChecklist", 2, id).Return(tc.items, nil)
+
+            rr := httptest.NewRecorder()
+            handler.AddChecklistItem(rr, formRequest("/tasks/checklist/add/"+id.String(), url.Values{"text": {tc.text}}), 2, id)
+
+            assert.Equal(t, http.StatusBadRequest, rr.Code)
+            assert.Contains(t, rr.Body.String(), "task|")
+            assert.Contains(t, rr.Body.String(), "checklist: ")
+            mockStore.AssertNotCalled(t, "AddChecklistItem", mock.Anything, mock.Anything, mock.Anything)
+        })
+    }
+}
+
+func TestAddChecklistItemTaskNotFound(t *testing.T) {
+    mockStore := new(MockSQLiteStore)
+    handler := newChecklistTestHandler(mockStore)
+
+    id := uuid.New()
+    mockStore.On("GetChecklist", 2, id).Return([]app.ChecklistItem(nil), db.ErrTaskNotFound).Once()
+
+    rr := httptest.NewRecorder()
+    handler.AddChecklistItem(rr, formRequest("/tasks/checklist/add/"+id.String(), url.Values{"text": {"Buy milk"}}), 2, id)
+
+    assert.Equal(t, http.StatusNotFound, rr.Code)
+    mockStore.AssertExpectations(t)
+}
+
+func TestToggleChecklistItemCompletesTask(t *testing.T) {
+    mockStore := new(MockSQLiteStore)
+    handler := newChecklistTestHandler(mockStore)
+
+    id := uuid.New()
+    mockStore.On("SetChecklistItemDone", 2, id, 7, true).Return(nil).Once()
+    mockStore.On("GetTaskById", 2, id).Return(app.Task{Id: id, UserId: 2, ChecklistTotal: 2, ChecklistDone: 2}, nil).Once()
+    mockStore.On("SetTaskDone", 2, id, true).Return(app.Task{}, nil).Once()
+
+    rr := httptest.NewRecorder()
+    handler.ToggleChecklistItem(rr, formRequest("/tasks/checklist/toggle/"+id.String(), url.Values{"item": {"7"}, "done": {"1"}}), 2, id)
+
+    assert.Equal(t, http.StatusSeeOther, rr.Code)
+    mockStore.AssertExpectations(t)
+}
+
+func TestToggleChecklistItemLeavesTaskOpen(t *testing.T) {
+    mockStore := new(MockSQLiteStore)
+    handler := newChecklistTestHandler(mockStore)
+
+    id := uuid.New()
+    mockStore.On("SetChecklistItemDone", 2, id, 7, true).Return(nil).Once()
+    mockStore.On("GetTaskById", 2, id).Return(app.Task{Id: id, UserId: 2, ChecklistTotal: 3, ChecklistDone: 2}, nil).Once()
+
+    rr := httptest.NewRecorder()
+    handler.ToggleChecklistItem(rr, formRequest("/tasks/checklist/toggle/"+id.String(), url.Values{"item": {"7"}, "done": {"1"}}), 2, id)
+
+    assert.Equal(t, http.StatusSeeOther, rr.Code)
+    mockStore.AssertNotCalled(t, "SetTaskDone", mock.Anything, mock.Anything, mock.Anything)
+    mockStore.AssertExpectations(t)
+}
+
+func TestToggleChecklistItemUntick(t *testing.T) {
+    mockStore := new(MockSQLiteStore)
+    handler := newChecklistTestHandler(mockStore)
+
+    id := uuid.New()
+    mockStore.On("SetChecklistItemDone", 2, id, 7, false).Return(nil).Once()
+
+    rr := httptest.NewRecorder()
+    handler.ToggleChecklistItem(rr, formRequest("/tasks/checklist/toggle/"+id.String(), url.Values{"item": {"7"}}), 2, id)
+
+    assert.Equal(t, http.StatusSeeOther, rr.Code)
+    mockStore.AssertNotCalled(t, "GetTaskById", mock.Anything, mock.Anything)
+    mockStore.AssertExpectations(t)
+}
+
+func TestMoveChecklistItem(t *testing.T) {
+    mockStore := new(MockSQLiteStore)
+    handler := newChecklistTestHandler(mockStore)
+
+    id := uuid.New()
+    mockStore.On("MoveChecklistItem", 2, id, 7, 0).Return(nil).Once()
+
+    rr := httptest.NewRecorder()
+    handler.MoveChecklistItem(rr, formRequest("/tasks/checklist/move/"+id.String(), url.Values{"item": {"7"}, "position": {"0"}}), 2, id)
+
+    assert.Equal(t, http.StatusSeeOther, rr.Code)
+    mockStore.AssertExpectations(t)
+}
+
+func TestMoveChecklistItemInvalid(t *testing.T) {
+    mockStore := new(MockSQLiteStore)
+    handler := newChecklistTestHandler(mockStore)
+
+    id := uuid.New()
+    for _, form := range []url.Values{{"item": {"x"}, "position": {"0"}}, {"item": {"7"}, "position": {"top"}}} {
+        rr := httptest.NewRecorder()
+        handler.MoveChecklistItem(rr, formRequest("/tasks/checklist/move/"+id.String(), form), 2, id)
+        assert.Equal(t, http.StatusBadRequest, rr.Code)
+    }
+    mockStore.AssertNotCalled(t, "MoveChecklistItem", mock.Anything, mock.Anything, mock.Anything, mock.Anything)
+}
+
+func TestDeleteChecklistItemNotFound(t *testing.T) {
+    mockStore := new(MockSQLiteStore)
+    handler := newChecklistTestHandler(mockStore)
+
+    id := uuid.New()
+    mockStore.On("DeleteChecklistItem", 2, id, 7).Return(db.ErrChecklistItemNotFound).Once()
+
+    rr := httptest.NewRecorder()
+    handler.DeleteChecklistItem(rr, formRequest("/tasks/checklist/delete/"+id.String(), url.Values{"item": {"7"}}), 2, id)
+
+    assert.Equal(t, http.StatusNotFound, rr.Code)
+    mockStore.AssertExpectations(t)
+}
@@ -0,0 +1,96 @@
+package api
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"log"
+	"net/http"
+	"strings"
+
+	"penumbra/db"
+)
+
+// CSRF protection uses the double-submit pattern. Each browser is given a random token in the `csrf_token` cookie, which lasts as long as the browser session. Pages echo the token into a hidden form field, or a meta tag for scripts, and any request that could change state must send it back in the `csrf_token` form field or the `X-CSRF-Token` header. A forged cross-site request carries the cookie but can't read it, so it can't supply the matching value. Once the browser has logged in, the token is instead derived from its session, so that it changes at every login and logout: a token handed out before login, or planted in the cookie by someone else, is refused afterwards.
+
+const (
+    csrfCookieName = "csrf_token"
+    csrfFieldName  = "csrf_token"
+    csrfHeaderName = "X-CSRF-Token"
+)
+
+type csrfContextKey struct{}
+
+// csrfToken returns the token for the current request, to be rendered into forms.
+func csrfToken(ctx context.Context) string {
+    token, _ := ctx.Value(csrfContextKey{}).(string)
+    return token
+}
+
+// sessionCSRFToken returns the token for a browser logged in with the session token, an HMAC keyed by the hash the session is stored under.
+func sessionCSRFToken(sessionToken string) string {
+    mac := hmac.New(sha256.New, db.HashToken(sessionToken))
+    mac.Write([]byte("csrf"))
+    return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+func withCSRF(next http.Handler) http.Handler {
+    return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+        token := ""
+        if cookie, err := r.Cookie(csrfCookieName); err == nil && cookie.Value != "" {
+            token = cookie.Value
+        }
+        expected := token
+        if cookie, err := r.Cookie("session_token"); err == nil && cookie.Value != "" {
+            expected = sessionCSRFToken(cookie.Value)
+        }
+
+        if !isSafeMethod(r.Method) && !csrfExempt(r) {
+            if expected == "" || !csrfTokensMatch(expected, submittedCSRFToken(r)) {
+                log.Printf("Rejecting %s %s: missing or mismatched CSRF token", r.Method, r.URL.Path)
+                http.Error(w, "Forbidden: invalid CSRF token", http.StatusForbidden)
+                return
+            }
+        }
+
+        if token == "" {
+            var err error
+            token, err = db.GenerateToken()
+            if err != nil {
+                http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+                return
+            }
+            http.SetCookie(w, &http.Cookie{
+                Name:     csrfCookieName,
+                Value:    token,
+                Path:     "/",
+                HttpOnly: true,
+                Secure:   false, // TODO: Set to true (https) in production.
+                SameSite: http.SameSiteStrictMode,
+            })
+        }
+
+        if expected == "" {
+            expected = token
+        }
+        next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), csrfContextKey{}, expected)))
+    })
+}
+
+// csrfExempt reports whether a request can't have been forged by another site. Requests that authenticate with a bearer token don't rely on cookies, and a browser won't attach that header to a cross-site request; the JSON API accepts nothing else. The CalDAV server ignores cookies too, and changes nothing on a POST, and a browser won't send another site's PUT, DELETE, PROPFIND, or REPORT without asking it first.
+func csrfExempt(r *http.Request) bool {
+    return strings.HasPrefix(r.URL.Path, "/api/v1/") || strings.HasPrefix(r.URL.Path, calDAVRoot) || bearerToken(r) != ""
+}
+
+func submittedCSRFToken(r *http.Request) string {
+    if token := r.Header.Get(csrfHeaderName); token != "" {
+        return token
+    }
+    return r.PostFormValue(csrfFieldName)
+}
+
+func csrfTokensMatch(expected, submitted string) bool {
+    return submitted != "" && subtle.ConstantTimeCompare([]byte(expected), []byte(submitted)) == 1
+}
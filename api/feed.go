@@ -0,0 +1,130 @@
+package api
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"log"
+	"net/http"
+	"strings"
+
+	"penumbra/app"
+	"penumbra/db"
+	"penumbra/ical"
+)
+
+type CalendarFeedPage struct {
+    Enabled          bool
+    CreatedPretty    string
+    LastPolledPretty string
+    URL              string // Shown once, right after the feed is created.
+}
+
+func (h *RealHandler) renderCalendarFeed(w http.ResponseWriter, r *http.Request, userId int, page CalendarFeedPage) {
+    feed, err := h.store.GetCalendarFeed(userId)
+    if err != nil && !errors.Is(err, db.ErrCalendarFeedNotFound) {
+        log.Println("Error getting calendar feed: ", err)
+        http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+        return
+    }
+
+    if err == nil {
+        page.Enabled = true
+        page.CreatedPretty = feed.CreatedAt.Format("Mon Jan 2 2006")
+        page.LastPolledPretty = "never"
+        if !feed.LastPolledAt.IsZero() {
+            page.LastPolledPretty = feed.LastPolledAt.Format("Mon Jan 2 2006")
+        }
+    }
+
+    h.RenderPage(w, r, "feed", page)
+}
+
+func (h *RealHandler) RenderCalendarFeed(w http.ResponseWriter, r *http.Request, userId int) {
+    h.renderCalendarFeed(w, r, userId, CalendarFeedPage{})
+}
+
+// SubmitCreateCalendarFeed gives the user a feed with a new address, which stops any old one working.
+func (h *RealHandler) SubmitCreateCalendarFeed(w http.ResponseWriter, r *http.Request, userId int) {
+    token, err := h.store.CreateCalendarFeed(userId)
+    if err != nil {
+        log.Println("Error creating calendar feed: ", err)
+        http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+        return
+    }
+
+    h.renderCalendarFeed(w, r, userId, CalendarFeedPage{URL: strings.TrimRight(h.baseURL, "/") + "/feeds/" + token + ".ics"})
+}
+
+func (h *RealHandler) RevokeCalendarFeed(w http.ResponseWriter, r *http.Request, userId int) {
+    err := h.store.DeleteCalendarFeed(userId)
+    if errors.Is(err, db.ErrCalendarFeedNotFound) {
+        http.Error(w, "not found", http.StatusNotFound)
+        return
+    }
+    if err != nil {
+        log.Println("Error deleting calendar feed: ", err)
+        http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+        return
+    }
+
+    http.Redirect(w, r, "/settings/feed", http.StatusSeeOther)
+}
+
+// ServeCalendarFeed serves the open tasks of the feed with the token as a calendar of to-dos, or of events with `?as=events`. The token stands in for a session, since calendar apps poll without one.
+//
+// The calendar's DTSTAMP is when the user's tasks last changed, which is also its Last-Modified time, so it only changes when they do; the ETag is a hash of the response body. Requests conditional on either are answered `304 Not Modified` while it's unchanged.
+func (h *RealHandler) ServeCalendarFeed(w http.ResponseWriter, r *http.Request, token string) {
+    encode := ical.Encode
+    switch r.URL.Query().Get("as") {
+    case "":
+    case "events":
+        encode = ical.EncodeEvents
+    default:
+        http.Error(w, "invalid as", http.StatusBadRequest)
+        return
+    }
+
+    feed, err := h.store.GetCalendarFeedByToken(token)
+    if errors.Is(err, db.ErrCalendarFeedNotFound) {
+        http.NotFound(w, r)
+        return
+    }
+    if err != nil {
+        log.Println("Error getting calendar feed: ", err)
+        http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+        return
+    }
+
+    tasks, err := h.store.QueryTasks(feed.UserId, db.TaskQuery{})
+    if err != nil {
+        log.Println("Error getting tasks: ", err)
+        http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+        return
+    }
+    open := []app.Task{}
+    for _, t := range tasks {
+        if t.Done == 0 {
+            open = append(open, t)
+        }
+    }
+
+    var body bytes.Buffer
+    if err := encode(&body, open, feed.ChangedAt); err != nil {
+        log.Println("Error writing calendar: ", err)
+        http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+        return
+    }
+
+    w.Header().Set("Content-Type", "text/calendar; charset=utf-8")
+    w.Header().Set("ETag", contentETag(body.Bytes()))
+    w.Header().Set("Cache-Control", "private, no-cache")
+    http.ServeContent(w, r, "tasks.ics", feed.ChangedAt, bytes.NewReader(body.Bytes()))
+}
+
+// contentETag is a strong ETag for a response body, which changes whenever the body does.
+func contentETag(body []byte) string {
+    sum := sha256.Sum256(body)
+    return `"` + base64.RawURLEncoding.EncodeToString(sum[:16]) + `"`
+}
@@ -0,0 +1,125 @@
+package api
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+
+	"penumbra/app"
+	"penumbra/db"
+)
+
+func TestServeCalendarFeed(t *testing.T) {
+    mockStore := new(MockSQLiteStore)
+    handler := newTestHandler(mockStore)
+
+    changedAt := time.Date(2030, 1, 2, 3, 4, 5, 0, time.UTC)
+    open, done := uuid.New(), uuid.New()
+    mockStore.On("GetCalendarFeedByToken", "feed-token").Return(app.CalendarFeed{UserId: 1, ChangedAt: changedAt}, nil)
+    mockStore.On("QueryTasks", 1, db.TaskQuery{}).Return([]app.Task{
+        {Id: open, Title: "Pay rent", Due: time.Date(2030, 1, 7, 12, 0, 0, 0, time.UTC)},
+        {Id: done, Title: "Renew passport", Done: 1, Due: time.Date(2029, 11, 2, 8, 0, 0, 0, time.UTC)},
+    }, nil)
+
+    rr := httptest.NewRecorder()
+    handler.ServeCalendarFeed(rr, httptest.NewRequest(http.MethodGet, "/feeds/feed-token.ics", nil), "feed-token")
+
+    assert.Equal(t, http.StatusOK, rr.Code)
+    assert.Equal(t, "text/calendar; charset=utf-8", rr.Header().Get("Content-Type"))
+    assert.Equal(t, "Wed, 02 Jan 2030 03:04:05 GMT", rr.Header().Get("Last-Modified"))
+    assert.Contains(t, rr.Body.String(), "UID:"+open.String()+"\r\nDTSTAMP:20300102T030405Z\r\n")
+    assert.NotContains(t, rr.Body.String(), done.String())
+    etag := rr.Header().Get("ETag")
+    assert.Regexp(t, `^"[\w-]+"$`, etag)
+
+    // Clients that already have it are told it hasn't changed.
+    for header, value := range map[string]string{"If-None-Match": etag, "If-Modified-Since": "Wed, 02 Jan 2030 03:04:05 GMT"} {
+        req := httptest.NewRequest(http.MethodGet, "/feeds/feed-token.ics", nil)
+        req.Header.Set(header, value)
+        rr = httptest.NewRecorder()
+        handler.ServeCalendarFeed(rr, req, "feed-token")
+        assert.Equal(t, http.StatusNotModified, rr.Code, header)
+        assert.Empty(t, rr.Body.String(), header)
+    }
+
+    req := httptest.NewRequest(http.MethodGet, "/feeds/feed-token.ics", nil)
+    req.Header.Set("If-None-Match", `"stale"`)
+    rr = httptest.NewRecorder()
+    handler.ServeCalendarFeed(rr, req, "feed-token")
+    assert.Equal(t, http.StatusOK, rr.Code)
+
+    rr = httptest.NewRecorder()
+    handler.ServeCalendarFeed(rr, httptest.NewRequest(http.MethodGet, "/feeds/feed-token.ics?as=events", nil), "feed-token")
+    assert.Equal(t, http.StatusOK, rr.Code)
+    assert.Contains(t, rr.Body.String(), "BEGIN:VEVENT\r\nUID:"+open.String())
+    assert.NotEqual(t, etag, rr.Header().Get("ETag"))
+
+    rr = httptest.NewRecorder()
+    handler.ServeCalendarFeed(rr, httptest.NewRequest(http.MethodGet, "/feeds/feed-token.ics?as=journal", nil), "feed-token")
+    assert.Equal(t, http.StatusBadRequest, rr.Code)
+}
+
+func TestServeCalendarFeedUnknownToken(t *testing.T) {
+    mockStore := new(MockSQLiteStore)
+    handler := newTestHandler(mockStore)
+
+    mockStore.On("GetCalendarFeedByToken", "revoked").Return(app.CalendarFeed{}, db.ErrCalendarFeedNotFound).Once()
+
+    rr := httptest.NewRecorder()
+    handler.ServeCalendarFeed(rr, httptest.NewRequest(http.MethodGet, "/feeds/revoked.ics", nil), "revoked")
+
+    assert.Equal(t, http.StatusNotFound, rr.Code)
+    mockStore.AssertExpectations(t)
+}
+
+func TestSubmitCreateCalendarFeed(t *testing.T) {
+    mockStore := new(MockSQLiteStore)
+    handler := newTestHandler(mockStore)
+    handler.baseURL = "https://penumbra.example.com/"
+
+    mockStore.On("CreateCalendarFeed", 1).Return("feed-token", nil).Once()
+    mockStore.On("GetCalendarFeed", 1).Return(app.CalendarFeed{UserId: 1, CreatedAt: time.Date(2030, 1, 2, 0, 0, 0, 0, time.UTC)}, nil).Once()
+
+    rr := httptest.NewRecorder()
+    handler.SubmitCreateCalendarFeed(rr, formRequest("/settings/feed", nil), 1)
+
+    assert.Equal(t, http.StatusOK, rr.Code)
+    assert.Contains(t, rr.Body.String(), "Enabled:true CreatedPretty:Wed Jan 2 2030 LastPolledPretty:never URL:https://penumbra.example.com/feeds/feed-token.ics")
+    mockStore.AssertExpectations(t)
+}
+
+func TestRenderCalendarFeedWithoutFeed(t *testing.T) {
+    mockStore := new(MockSQLiteStore)
+    handler := newTestHandler(mockStore)
+
+    mockStore.On("GetCalendarFeed", 1).Return(app.CalendarFeed{}, db.ErrCalendarFeedNotFound).Once()
+
+    rr := httptest.NewRecorder()
+    handler.RenderCalendarFeed(rr, httptest.NewRequest(http.MethodGet, "/settings/feed", nil), 1)
+
+    assert.Equal(t, http.StatusOK, rr.Code)
+    assert.Contains(t, rr.Body.String(), "feed|{Enabled:false")
+    mockStore.AssertExpectations(t)
+}
+
+func TestRevokeCalendarFeed(t *testing.T) {
+    mockStore := new(MockSQLiteStore)
+    handler := newTestHandler(mockStore)
+
+    mockStore.On("DeleteCalendarFeed", 1).Return(nil).Once()
+    rr := httptest.NewRecorder()
+    handler.RevokeCalendarFeed(rr, formRequest("/settings/feed/revoke", nil), 1)
+    assert.Equal(t, http.StatusSeeOther, rr.Code)
+    assert.Equal(t, "/settings/feed", rr.Header().Get("Location"))
+
+    mockStore.On("DeleteCalendarFeed", 2).Return(db.ErrCalendarFeedNotFound).Once()
+    rr = httptest.NewRecorder()
+    handler.RevokeCalendarFeed(rr, formRequest("/settings/feed/revoke", nil), 2)
+    assert.Equal(t, http.StatusNotFound, rr.Code)
+
+    mockStore.AssertExpectations(t)
+}
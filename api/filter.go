@@ -0,0 +1,88 @@
+package api
+
+import (
+	"errors"
+	"net/url"
+	"time"
+
+	"penumbra/app"
+	"penumbra/db"
+	"penumbra/validate"
+)
+
+// FilterForm is which tasks a task list was asked for, from its query string, so that its controls can show it.
+type FilterForm struct {
+    Status  string // `pending`, `overdue`, `done`, or empty for any.
+    DueFrom string // A date as the date input sends it, `2006-01-02`, or empty.
+    DueTo   string
+    Text    string // Searched for in titles and descriptions, as on the search page.
+}
+
+// taskPageSize is how many tasks the dashboard and /tasks show at a time.
+const taskPageSize = 50
+
+// dateInputLayout is how date inputs send dates.
+const dateInputLayout = "2006-01-02"
+
+// errInvalidFilter is returned by parseFilterForm when a filter can't be understood.
+var errInvalidFilter = errors.New("invalid filter")
+
+// parseFilterForm reads `status`, `due_from`, `due_to`, and `q` from the query string into the filters of a task query. Due dates are days in the user's time zone, and both ends of the range are included.
+func (h *RealHandler) parseFilterForm(userId int, query url.Values) (FilterForm, db.TaskQuery, error) {
+    form := FilterForm{
+        Status:  query.Get("status"),
+        DueFrom: query.Get("due_from"),
+        DueTo:   query.Get("due_to"),
+        Text:    query.Get("q"),
+    }
+
+    var q db.TaskQuery
+    switch form.Status {
+    case "", "pending", "overdue", "done":
+        q.Status = form.Status
+    default:
+        return form, q, errInvalidFilter
+    }
+    if len(validate.Search(form.Text)) > 0 {
+        return form, q, errInvalidFilter
+    }
+    q.Text = form.Text
+
+    if form.DueFrom == "" && form.DueTo == "" {
+        return form, q, nil
+    }
+    loc, err := h.userLocation(userId)
+    if err != nil {
+        return form, q, err
+    }
+    if form.DueFrom != "" {
+        day, err := time.ParseInLocation(dateInputLayout, form.DueFrom, loc)
+        if err != nil {
+            return form, q, errInvalidFilter
+        }
+        q.DueFrom = day
+    }
+    if form.DueTo != "" {
+        day, err := time.ParseInLocation(dateInputLayout, form.DueTo, loc)
+        if err != nil {
+            return form, q, errInvalidFilter
+        }
+        q.DueTo = app.EndOfDay(day)
+    }
+    return form, q, nil
+}
+
+// pageLink is the query string for the page the cursor points to, keeping everything else about the list as it is.
+func pageLink(query url.Values, key, cursor string) string {
+    if cursor == "" {
+        return ""
+    }
+    link := url.Values{}
+    for k, v := range query {
+        link[k] = v
+    }
+    link.Del("after")
+    link.Del("before")
+    link.Set(key, cursor)
+    return "?" + link.Encode()
+}
@@ -0,0 +1,85 @@
+package api
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"penumbra/app"
+	"penumbra/db"
+)
+
+func TestParseFilterForm(t *testing.T) {
+    mockStore := new(MockSQLiteStore)
+    handler := newTestHandler(mockStore)
+
+    query, _ := url.ParseQuery("status=overdue&q=rent")
+    form, q, err := handler.parseFilterForm(1, query)
+    assert.NoError(t, err)
+    assert.Equal(t, FilterForm{Status: "overdue", Text: "rent"}, form)
+    assert.Equal(t, db.TaskQuery{Status: "overdue", Text: "rent"}, q)
+
+    // Dates are days in the user's time zone, which is only looked up when there are any.
+    mockStore.On("GetUserById", 1).Return(app.User{Id: 1, TimeZone: "America/New_York"}, nil).Once()
+    query, _ = url.ParseQuery("due_from=2030-01-07&due_to=2030-01-08")
+    _, q, err = handler.parseFilterForm(1, query)
+    assert.NoError(t, err)
+    assert.True(t, q.DueFrom.Equal(time.Date(2030, 1, 7, 5, 0, 0, 0, time.UTC)), q.DueFrom)
+    assert.True(t, q.DueTo.Equal(time.Date(2030, 1, 9, 4, 59, 59, 999999999, time.UTC)), q.DueTo)
+    mockStore.AssertExpectations(t)
+
+    mockStore.On("GetUserById", 1).Return(app.User{Id: 1}, nil)
+    for _, bad := range []string{"status=later", "due_from=Jan+7", "due_to=2030-02-30"} {
+        query, _ := url.ParseQuery(bad)
+        _, _, err := handler.parseFilterForm(1, query)
+        assert.ErrorIs(t, err, errInvalidFilter, bad)
+    }
+}
+
+func TestPageLink(t *testing.T) {
+    query, _ := url.ParseQuery("sort=title&tag=work&before=abc")
+    assert.Equal(t, "?after=xyz&sort=title&tag=work", pageLink(query, "after", "xyz"))
+    assert.Equal(t, "", pageLink(query, "after", ""))
+    assert.Equal(t, "abc", query.Get("before"))
+}
+
+func TestHandleDashboardPages(t *testing.T) {
+    mockStore := new(MockSQLiteStore)
+    handler := newTestHandler(mockStore)
+
+    mockStore.On("PageTasks", 1,
+        db.TaskQuery{Status: "pending", Order: []db.TaskOrder{{By: db.SortDue}}},
+        db.PageQuery{Size: taskPageSize, After: "abc"},
+    ).Return(db.TaskPage{Tasks: []app.Task{{Title: "Pay rent"}}, Next: "def", Prev: "ghi"}, nil).Once()
+
+    rr := httptest.NewRecorder()
+    handler.HandleDashboard(rr, httptest.NewRequest(http.MethodGet, "/dashboard?status=pending&after=abc", nil), 1)
+
+    assert.Equal(t, http.StatusOK, rr.Code)
+    assert.Contains(t, rr.Body.String(), "Title: Pay rent")
+    assert.Contains(t, rr.Body.String(), "Filter:{Status:pending DueFrom: DueTo: Text:}")
+    assert.Contains(t, rr.Body.String(), "Next:?after=def&amp;status=pending Prev:?before=ghi&amp;status=pending")
+    mockStore.AssertExpectations(t)
+}
+
+func TestHandleAllTasksRefusesBadPages(t *testing.T) {
+    mockStore := new(MockSQLiteStore)
+    handler := newTestHandler(mockStore)
+
+    mockStore.On("PageTasks", 1, db.TaskQuery{Order: []db.TaskOrder{{By: db.SortDue}}}, db.PageQuery{Size: taskPageSize, After: "junk"}).
+        Return(db.TaskPage{}, db.ErrInvalidCursor).Once()
+
+    rr := httptest.NewRecorder()
+    handler.HandleAllTasks(rr, httptest.NewRequest(http.MethodGet, "/tasks?after=junk", nil), 1)
+    assert.Equal(t, http.StatusBadRequest, rr.Code)
+
+    rr = httptest.NewRecorder()
+    handler.HandleAllTasks(rr, httptest.NewRequest(http.MethodGet, "/tasks?status=someday", nil), 1)
+    assert.Equal(t, http.StatusBadRequest, rr.Code)
+
+    mockStore.AssertExpectations(t)
+}
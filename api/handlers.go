@@ -1,11 +1,16 @@
 package api
 
 import (
+	"crypto/rand"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"html/template"
 	"log"
+	"net"
 	"net/http"
-	"sort"
+	"strconv"
+	"strings"
 	"time"
 
 	"github.com/google/uuid"
@@ -13,11 +18,14 @@ import (
 
 	"penumbra/app"
 	"penumbra/db"
+	"penumbra/mail"
+	"penumbra/validate"
 )
 
 type PageAndOtherData struct {
-    Page    string
-    Data    any
+    Page      string
+    Data      any
+    CSRFToken string
 }
 
 type TaskView struct {
@@ -25,7 +33,45 @@ type TaskView struct {
     Title     string
     Status    string
     DuePretty string
+    DueTime   string // Empty for tasks due by the end of a day.
     Description string
+    CompletedPretty string // Empty unless the task is done.
+    Recurrence RecurrenceForm
+    Checklist []ChecklistRow // Only loaded for the task page.
+    ChecklistTotal int
+    ChecklistDone int
+    Tags []app.Tag
+    Priority app.Priority
+    TagOptions []TagOption // Only loaded for the task page.
+    ProjectOptions []ProjectOption // Only loaded for the task page.
+    Errors validate.Errors // Set when an update was refused, to show next to the fields.
+}
+
+// TasksPage lists the user's tasks, narrowed down to those with every tag in Tags if any are given.
+type TasksPage struct {
+    TaskList
+    Tags []string
+}
+
+// RegisterPage re-renders the registration form with what the user typed, apart from the password, and what was wrong with it.
+type RegisterPage struct {
+    Name   string
+    Phone  string
+    Email  string
+    Errors validate.Errors
+}
+
+// CreateTaskPage re-renders the task form with what the user typed and what was wrong with it.
+type CreateTaskPage struct {
+    Title       string
+    Description string
+    Due         string
+    DueTime     string
+    Recurrence  RecurrenceForm
+    Priority    app.Priority
+    TagOptions  []TagOption
+    ProjectOptions []ProjectOption
+    Errors      validate.Errors
 }
 
 func (t TaskView) String() string {
@@ -38,29 +84,200 @@ type Handler interface {
     SubmitLogin(http.ResponseWriter, *http.Request)
     RenderRegister(http.ResponseWriter, *http.Request)
     SubmitRegister(http.ResponseWriter, *http.Request)
-    RenderCreateTask(http.ResponseWriter, *http.Request)
+    RenderCreateTask(http.ResponseWriter, *http.Request, int) // The `int` is the user's id.
     SubmitCreateTask(http.ResponseWriter, *http.Request, int) // The `int` is the user's id.
-    GetTask(http.ResponseWriter, *http.Request, uuid.UUID)
-    MarkTaskDone(http.ResponseWriter, *http.Request, uuid.UUID)
-    HandleDashboard(http.ResponseWriter, *http.Request)
+    GetTask(http.ResponseWriter, *http.Request, int, uuid.UUID) // The `int` is the user's id.
+    MarkTaskDone(http.ResponseWriter, *http.Request, int, uuid.UUID)
+    HandleDashboard(http.ResponseWriter, *http.Request, int) // The `int` is the user's id.
     HandleHome(http.ResponseWriter, *http.Request)
     HandleLogout(http.ResponseWriter, *http.Request)
+    HandleLogoutEverywhere(http.ResponseWriter, *http.Request, int) // The `int` is the user's id.
     HandleAllTasks(http.ResponseWriter, *http.Request, int) // The `int` is the user's id.
-    DeleteTask(http.ResponseWriter, *http.Request, uuid.UUID)
-    UpdateTask(http.ResponseWriter, *http.Request, uuid.UUID)
+    SearchTasks(http.ResponseWriter, *http.Request, int) // The `int` is the user's id.
+    ExportCalendar(http.ResponseWriter, *http.Request, int) // The `int` is the user's id.
+    RenderImport(http.ResponseWriter, *http.Request, int)
+    SubmitImport(http.ResponseWriter, *http.Request, int)
+    ExportBackup(http.ResponseWriter, *http.Request, int) // The `int` is the user's id.
+    RenderBackup(http.ResponseWriter, *http.Request, int)
+    SubmitBackup(http.ResponseWriter, *http.Request, int)
+    DeleteTask(http.ResponseWriter, *http.Request, int, uuid.UUID)
+    UpdateTask(http.ResponseWriter, *http.Request, int, uuid.UUID)
+    AddChecklistItem(http.ResponseWriter, *http.Request, int, uuid.UUID)
+    ToggleChecklistItem(http.ResponseWriter, *http.Request, int, uuid.UUID)
+    MoveChecklistItem(http.ResponseWriter, *http.Request, int, uuid.UUID)
+    DeleteChecklistItem(http.ResponseWriter, *http.Request, int, uuid.UUID)
     HandleAbout(http.ResponseWriter, *http.Request)
     HandleProtected(http.ResponseWriter, *http.Request, func(http.ResponseWriter, *http.Request))
-    HandleProtectedWithTaskId(http.ResponseWriter, *http.Request, func(http.ResponseWriter, *http.Request, uuid.UUID), string)
+    HandleProtectedWithTaskId(http.ResponseWriter, *http.Request, func(http.ResponseWriter, *http.Request, int, uuid.UUID), string)
     HandleProtectedWithUserId(http.ResponseWriter, *http.Request, func(http.ResponseWriter, *http.Request, int))
+    HandleSessionProtected(http.ResponseWriter, *http.Request, func(http.ResponseWriter, *http.Request, int))
+    RenderAPITokens(http.ResponseWriter, *http.Request, int) // The `int` is the user's id.
+    SubmitCreateAPIToken(http.ResponseWriter, *http.Request, int)
+    RevokeAPIToken(http.ResponseWriter, *http.Request, int)
+    RenderCalendarFeed(http.ResponseWriter, *http.Request, int) // The `int` is the user's id.
+    SubmitCreateCalendarFeed(http.ResponseWriter, *http.Request, int)
+    RevokeCalendarFeed(http.ResponseWriter, *http.Request, int)
+    ServeCalendarFeed(http.ResponseWriter, *http.Request, string) // The `string` is the feed's token, from the URL.
+    HandleCalDAVProtected(http.ResponseWriter, *http.Request, func(http.ResponseWriter, *http.Request, int))
+    ServeCalDAV(http.ResponseWriter, *http.Request, int) // The `int` is the user's id.
+    RenderTags(http.ResponseWriter, *http.Request, int) // The `int` is the user's id.
+    SubmitCreateTag(http.ResponseWriter, *http.Request, int)
+    DeleteTag(http.ResponseWriter, *http.Request, int)
+    RenderProjects(http.ResponseWriter, *http.Request, int) // The `int` is the user's id.
+    SubmitCreateProject(http.ResponseWriter, *http.Request, int)
+    GetProject(http.ResponseWriter, *http.Request, int, string) // The `string` is the project's id, from the URL.
+    RenameProject(http.ResponseWriter, *http.Request, int, string)
+    DeleteProject(http.ResponseWriter, *http.Request, int, string)
+    MoveTask(http.ResponseWriter, *http.Request, int, uuid.UUID)
+    RenderTimeZone(http.ResponseWriter, *http.Request, int) // The `int` is the user's id.
+    SubmitTimeZone(http.ResponseWriter, *http.Request, int)
+    RenderLoginTwoFactor(http.ResponseWriter, *http.Request)
+    SubmitLoginTwoFactor(http.ResponseWriter, *http.Request)
+    RenderTwoFactor(http.ResponseWriter, *http.Request, int) // The `int` is the user's id.
+    SubmitTwoFactorSetup(http.ResponseWriter, *http.Request, int)
+    SubmitTwoFactorEnable(http.ResponseWriter, *http.Request, int)
+    SubmitTwoFactorDisable(http.ResponseWriter, *http.Request, int)
+    RenderForgotPassword(http.ResponseWriter, *http.Request)
+    SubmitForgotPassword(http.ResponseWriter, *http.Request)
+    RenderResetPassword(http.ResponseWriter, *http.Request, string) // The `string` is the reset token.
+    SubmitResetPassword(http.ResponseWriter, *http.Request, string)
+    HandleVerifyEmail(http.ResponseWriter, *http.Request)
+    SubmitResendVerification(http.ResponseWriter, *http.Request)
+
+    // JSON API, served under `/api/v1/`.
+    APILogin(http.ResponseWriter, *http.Request)
+    APIListTasks(http.ResponseWriter, *http.Request, int) // The `int` is the user's id.
+    APICreateTask(http.ResponseWriter, *http.Request, int)
+    APIGetTask(http.ResponseWriter, *http.Request, int, uuid.UUID)
+    APIPatchTask(http.ResponseWriter, *http.Request, int, uuid.UUID)
+    APIDeleteTask(http.ResponseWriter, *http.Request, int, uuid.UUID)
+    APISetTaskDone(http.ResponseWriter, *http.Request, int, uuid.UUID)
+    HandleAPIProtected(http.ResponseWriter, *http.Request, func(http.ResponseWriter, *http.Request, int))
+    HandleAPIProtectedWithTaskId(http.ResponseWriter, *http.Request, func(http.ResponseWriter, *http.Request, int, uuid.UUID), string)
 }
 
 type RealHandler struct {
     store db.Store
     templates *template.Template
+    ipLimiter Limiter    // Throttles login and registration attempts per client IP. Nil means no limit.
+    emailLimiter Limiter // Throttles login attempts per email address, and two-factor attempts per user. Nil means no limit.
+    now func() time.Time // Nil means time.Now; tests substitute a fake clock.
+    mailer mail.Mailer
+    baseURL string       // Where the app is served from, for links in emails.
+    signingKey []byte    // Signs email verification links.
+}
+
+// Option configures optional behaviour of a RealHandler.
+type Option func(*RealHandler)
+
+// WithLoginLimiters replaces the default limiters on login and registration attempts. Either may be nil to turn that limit off.
+func WithLoginLimiters(byIP, byEmail Limiter) Option {
+    return func(h *RealHandler) {
+        h.ipLimiter = byIP
+        h.emailLimiter = byEmail
+    }
+}
+
+// WithMailer sets how emails are sent. The default only logs them.
+func WithMailer(m mail.Mailer) Option {
+    return func(h *RealHandler) {
+        h.mailer = m
+    }
+}
+
+// WithBaseURL sets the URL the app is served from, such as `https://penumbra.example.com`, used to build links in emails. It's configured rather than taken from the request's Host header, which an attacker could set to send users a link to their own site.
+func WithBaseURL(baseURL string) Option {
+    return func(h *RealHandler) {
+        h.baseURL = baseURL
+    }
+}
+
+// WithSigningKey sets the key that email verification links are signed with. Without it, a random key is generated at startup, so links stop working when the server restarts.
+func WithSigningKey(key []byte) Option {
+    return func(h *RealHandler) {
+        h.signingKey = key
+    }
+}
+
+// WithClock replaces the clock used to check two-factor codes and lockouts.
+func WithClock(now func() time.Time) Option {
+    return func(h *RealHandler) {
+        h.now = now
+    }
+}
+
+func (h *RealHandler) clock() time.Time {
+    if h.now == nil {
+        return time.Now()
+    }
+    return h.now()
+}
+
+// After this many wrong passwords in a row an account is locked for `lockoutDuration`, however many IPs the guesses come from.
+const (
+    maxFailedLogins = 5
+    lockoutDuration = 15 * time.Minute
+)
+
+func NewHandler(store db.Store, templates *template.Template, opts ...Option) *RealHandler {
+    h := &RealHandler{
+        store: store,
+        templates: templates,
+        ipLimiter: NewTokenBucketLimiter(10, 6*time.Second),
+        emailLimiter: NewTokenBucketLimiter(5, time.Minute),
+        mailer: mail.LogMailer{},
+        baseURL: "http://localhost:8080",
+    }
+    for _, opt := range opts {
+        opt(h)
+    }
+    if len(h.signingKey) == 0 {
+        h.signingKey = make([]byte, 32)
+        if _, err := rand.Read(h.signingKey); err != nil {
+            panic(err)
+        }
+    }
+    return h
+}
+
+// allowAttempt takes a token from the per-IP limiter and, when an account is given, the per-account limiter. The account is usually an email address. Keys are prefixed with `action` so logins and registrations are counted separately.
+func (h *RealHandler) allowAttempt(r *http.Request, action, account string) (bool, time.Duration) {
+    if h.ipLimiter != nil {
+        if ok, wait := h.ipLimiter.Allow(action + ":ip:" + clientIP(r)); !ok {
+            return false, wait
+        }
+    }
+    if h.emailLimiter != nil && account != "" {
+        if ok, wait := h.emailLimiter.Allow(action + ":account:" + strings.ToLower(account)); !ok {
+            return false, wait
+        }
+    }
+    return true, 0
 }
 
-func NewHandler(store db.Store, templates *template.Template) *RealHandler {
-    return &RealHandler{store: store, templates: templates}
+// checkPassword compares the password against the user's hash, enforcing the account lockout. It returns a non-zero wait if the account is locked, either already or by this failure.
+func (h *RealHandler) checkPassword(user app.User, password string) (bool, time.Duration, error) {
+    now := h.clock()
+    if user.LockedUntil.After(now) {
+        return false, user.LockedUntil.Sub(now), nil
+    }
+
+    if err := bcrypt.CompareHashAndPassword(user.PasswordHash, []byte(password)); err != nil {
+        lockedUntil, err := h.store.RecordFailedLogin(user.Id, maxFailedLogins, lockoutDuration)
+        if err != nil {
+            return false, 0, err
+        }
+        if !lockedUntil.IsZero() {
+            log.Printf("Locking user %d until %s after %d failed logins", user.Id, lockedUntil.Format(time.RFC3339), maxFailedLogins)
+            return false, lockedUntil.Sub(now), nil
+        }
+        return false, 0, nil
+    }
+
+    if err := h.store.ResetFailedLogins(user.Id); err != nil {
+        return false, 0, err
+    }
+    return true, 0, nil
 }
 
 func (h *RealHandler) HandleHome(w http.ResponseWriter, r *http.Request) {
@@ -71,8 +288,8 @@ func (h *RealHandler) HandleHome(w http.ResponseWriter, r *http.Request) {
         return
     }
 
-    sessionToken, err := uuid.Parse(cookie.Value)
-    if err != nil {
+    sessionToken := cookie.Value
+    if sessionToken == "" {
         http.Error(w, "not logged in", http.StatusUnauthorized)
         return
     }
@@ -89,8 +306,9 @@ func (h *RealHandler) HandleHome(w http.ResponseWriter, r *http.Request) {
 
 func (h *RealHandler) RenderPage(w http.ResponseWriter, r *http.Request, page string, data any) {
     pageAndOtherData := PageAndOtherData{
-        Page: page,
-        Data: data,
+        Page:      page,
+        Data:      data,
+        CSRFToken: csrfToken(r.Context()),
     }
 
     err := h.templates.ExecuteTemplate(w, "layout", pageAndOtherData)
@@ -104,7 +322,7 @@ func (h *RealHandler) RenderLogin(w http.ResponseWriter, r *http.Request) {
 }
 
 func (h *RealHandler) RenderRegister(w http.ResponseWriter, r *http.Request) {
-    h.RenderPage(w, r, "register", nil)
+    h.RenderPage(w, r, "register", RegisterPage{})
 }
 
 func (h *RealHandler) SubmitLogin(w http.ResponseWriter, r *http.Request) {
@@ -115,21 +333,51 @@ func (h *RealHandler) SubmitLogin(w http.ResponseWriter, r *http.Request) {
         return
     }
 
-    user, err := h.store.GetUserByEmail(r.FormValue("email"))
+    email := r.FormValue("email")
+    if ok, wait := h.allowAttempt(r, "login", email); !ok {
+        tooManyRequests(w, wait)
+        return
+    }
+
+    user, err := h.store.GetUserByEmail(email)
     if err != nil {
         log.Println("Error getting user: ", err)
         http.Redirect(w, r, "/login", http.StatusSeeOther)
         return
     }
 
-    if err := bcrypt.CompareHashAndPassword(user.PasswordHash, []byte(r.FormValue("password"))); err != nil {
-        log.Println("Error comparing passwords: ", err)
+    ok, wait, err := h.checkPassword(user, r.FormValue("password"))
+    if err != nil {
+        log.Println("Error checking password: ", err)
+        http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+        return
+    }
+    if wait > 0 {
+        tooManyRequests(w, wait)
+        return
+    }
+    if !ok {
         http.Redirect(w, r, "/login", http.StatusSeeOther)
         return
     }
 
-    // Create session and store a hash of it in the database, in the users table. Set cookie. Fetch task titles, ids, and due dates. Redirect to `/dashboard`, which will display the list.
-    sessionToken, expiresAt, err := h.store.AddSessionToken(user.Id)
+    if !user.EmailVerified {
+        w.WriteHeader(http.StatusForbidden)
+        h.RenderPage(w, r, "verify", VerifyEmailPage{Email: user.Email, Unverified: true})
+        return
+    }
+
+    if user.TOTPEnabled {
+        h.startLoginChallenge(w, r, user.Id)
+        return
+    }
+
+    h.startSession(w, r, user.Id)
+}
+
+// startSession logs the user in: it creates a session, stores a hash of it in the sessions table, sets the cookie, and redirects to `/dashboard`.
+func (h *RealHandler) startSession(w http.ResponseWriter, r *http.Request, userId int) {
+    sessionToken, expiresAt, err := h.store.AddSessionToken(userId, r.UserAgent(), clientIP(r))
     if err != nil {
         log.Println("Error adding session: ", err)
         http.Error(w, "Internal Server Error", http.StatusInternalServerError)
@@ -138,10 +386,11 @@ func (h *RealHandler) SubmitLogin(w http.ResponseWriter, r *http.Request) {
 
     http.SetCookie(w, &http.Cookie{
         Name:     "session_token",
-        Value:    sessionToken.String(),
+        Value:    sessionToken,
         Path:     "/",
         HttpOnly: true,
         Secure:   false, // TODO: Set to true (https) in production.
+        SameSite: http.SameSiteLaxMode,
         Expires:  expiresAt,
     })
 
@@ -155,9 +404,25 @@ func (h *RealHandler) SubmitRegister(w http.ResponseWriter, r *http.Request) {
         return
     }
 
+    if ok, wait := h.allowAttempt(r, "register", ""); !ok {
+        tooManyRequests(w, wait)
+        return
+    }
+
+    user := app.User{
+        Name: strings.TrimSpace(r.FormValue("name")),
+        Email: strings.TrimSpace(r.FormValue("email")),
+        Phone: validate.NormalizePhone(r.FormValue("phone")),
+    }
+
     password := r.FormValue("password")
-    if len(password) > 72 {
-        http.Error(w, "Password too long", http.StatusBadRequest)
+    errs := validate.User(user)
+    if message := validate.Password(password); message != "" {
+        errs.Add("password", message)
+    }
+    if len(errs) > 0 {
+        w.WriteHeader(http.StatusBadRequest)
+        h.RenderPage(w, r, "register", RegisterPage{Name: user.Name, Phone: user.Phone, Email: user.Email, Errors: errs})
         return
     }
 
@@ -166,13 +431,7 @@ func (h *RealHandler) SubmitRegister(w http.ResponseWriter, r *http.Request) {
         http.Error(w, "Internal Server Error: "+err.Error(), http.StatusInternalServerError)
         return
     }
-
-    user := app.User{
-        Name: r.FormValue("name"),
-        PasswordHash: password_hash,
-        Email: r.FormValue("email"),
-        Phone: r.FormValue("phone"),
-    }
+    user.PasswordHash = password_hash
 
     if err := h.store.CreateUser(user); err != nil {
         log.Println("Error creating user: ", err)
@@ -180,136 +439,260 @@ func (h *RealHandler) SubmitRegister(w http.ResponseWriter, r *http.Request) {
         return
     }
 
-    http.Redirect(w, r, "/home", http.StatusSeeOther)
-}
-
-func (h *RealHandler) HandleDashboard(w http.ResponseWriter, r *http.Request) {
-    cookie, err := r.Cookie("session_token")
+    // The account can't be used until the address is confirmed, so fetch the new user's id for the link.
+    user, err = h.store.GetUserByEmail(user.Email)
     if err != nil {
-        log.Println("Error getting cookie: ", err)
-        http.Redirect(w, r, "/login", http.StatusSeeOther)
+        log.Println("Error getting new user: ", err)
+        http.Error(w, "Internal Server Error", http.StatusInternalServerError)
         return
     }
-
-    if cookie.Value == "" {
-        log.Println("No session token in cookie")
-        http.Redirect(w, r, "/login", http.StatusSeeOther)
-        return
+    if err := h.sendEmailVerification(user); err != nil {
+        log.Println("Error sending verification email: ", err)
     }
 
-    sessionToken, err := uuid.Parse(cookie.Value)
-    if err != nil {
-        log.Println("Error parsing session token: ", err)
-        http.Redirect(w, r, "/login", http.StatusSeeOther)
+    h.RenderPage(w, r, "verify", VerifyEmailPage{Email: user.Email, Sent: true})
+}
+
+// HandleDashboard shows a page of the user's tasks in a table, filtered, sorted, and grouped as asked by the query string.
+func (h *RealHandler) HandleDashboard(w http.ResponseWriter, r *http.Request, userId int) {
+    list, preData, ok := h.listTasks(w, r, userId)
+    if !ok {
         return
     }
 
-    user_id, err := h.store.GetUserIdFromSessionToken(sessionToken)
-    if err != nil {
-        log.Println("Error getting user id from hashed session token: ", err)
-        http.Redirect(w, r, "/login", http.StatusSeeOther)
-        return
+    list.Groups = groupTasks(list.Sort.Group, preData, dashboardViews(preData))
+    h.RenderPage(w, r, "dashboard", list)
+}
+
+// listTasks reads the page of the user's tasks that the query string asks for, filtered, sorted, and tagged as it says. If it can't, it writes the error response and returns false. The list it returns has no groups yet, since the caller decides how to show each task.
+func (h *RealHandler) listTasks(w http.ResponseWriter, r *http.Request, userId int) (TaskList, []app.Task, bool) {
+    query := r.URL.Query()
+    form, order, ok := parseSortForm(query)
+    if !ok {
+        http.Error(w, "invalid sort", http.StatusBadRequest)
+        return TaskList{}, nil, false
+    }
+    filter, q, err := h.parseFilterForm(userId, query)
+    if errors.Is(err, errInvalidFilter) {
+        http.Error(w, "invalid filter", http.StatusBadRequest)
+        return TaskList{}, nil, false
+    } else if err != nil {
+        log.Println("Error getting user: ", err)
+        http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+        return TaskList{}, nil, false
     }
+    q.Tags = form.Tags
+    q.Order = order
 
-    preData, err := h.store.GetAllTasks(user_id)
-    if err != nil {
+    page, err := h.store.PageTasks(userId, q, db.PageQuery{Size: taskPageSize, After: query.Get("after"), Before: query.Get("before")})
+    if errors.Is(err, db.ErrInvalidCursor) {
+        http.Error(w, "invalid page", http.StatusBadRequest)
+        return TaskList{}, nil, false
+    } else if err != nil {
         log.Println("Error getting tasks: ", err)
         http.Error(w, "Internal Server Error", http.StatusInternalServerError)
-        return
+        return TaskList{}, nil, false
     }
 
-    sort.Slice(preData, func(i, j int) bool {
-        return preData[i].Due.Before(preData[j].Due)
-    })
+    return TaskList{
+        Sort:   form,
+        Filter: filter,
+        Next:   pageLink(query, "after", page.Next),
+        Prev:   pageLink(query, "before", page.Prev),
+    }, page.Tasks, true
+}
+
+// dueDate and dueTime format when the task is due in its owner's time zone, as shown in lists and filled in on the form. dueTime is empty for tasks due by the end of a day.
+func dueDate(task app.Task) string {
+    return task.Due.In(app.Location(task.TimeZone)).Format("Mon Jan 2 2006")
+}
+
+func dueTime(task app.Task) string {
+    if task.AllDay {
+        return ""
+    }
+    return task.Due.In(app.Location(task.TimeZone)).Format("15:04")
+}
 
+// userLocation returns the user's time zone, which they enter due dates in.
+func (h *RealHandler) userLocation(userId int) (*time.Location, error) {
+    user, err := h.store.GetUserById(userId)
+    if err != nil {
+        return nil, err
+    }
+    return app.Location(user.TimeZone), nil
+}
+
+// dashboardViews prepares tasks, already in order, for a dashboard table.
+func dashboardViews(tasks []app.Task) []TaskView {
     var data []TaskView
-    for _, task := range preData {
+    for _, task := range tasks {
         data = append(data, TaskView{
             Id:          task.Id,
             Title:       task.Title,
             Status:      task.Status,
-            DuePretty:   task.Due.Format("Mon Jan 2 2006"),
+            DuePretty:   dueDate(task),
+            DueTime:     dueTime(task),
+            ChecklistTotal: task.ChecklistTotal,
+            ChecklistDone:  task.ChecklistDone,
+            Tags:        task.Tags,
+            Priority:    task.Priority,
         })
     }
-   
-    h.RenderPage(w, r, "dashboard", data)
+    return data
 }
 
-func (h *RealHandler) RenderCreateTask(w http.ResponseWriter, r *http.Request) {
-    cookie, err := r.Cookie("session_token")
+// RenderCreateTask shows the form for a new task, in the project given by `?project=` if there is one.
+func (h *RealHandler) RenderCreateTask(w http.ResponseWriter, r *http.Request, userId int) {
+    tags, err := h.tagOptions(userId, nil)
     if err != nil {
-        log.Println("Error getting cookie: ", err)
-        http.Redirect(w, r, "/login", http.StatusSeeOther)
+        log.Println("Error getting tags: ", err)
+        http.Error(w, "Internal Server Error", http.StatusInternalServerError)
         return
     }
-
-    if cookie.Value == "" {
-        log.Println("No session token in cookie")
-        http.Redirect(w, r, "/login", http.StatusSeeOther)
+    projectId, _ := parseProjectId(r)
+    projects, err := h.projectOptions(userId, projectId)
+    if err != nil {
+        log.Println("Error getting projects: ", err)
+        http.Error(w, "Internal Server Error", http.StatusInternalServerError)
         return
     }
 
-    sessionToken, err := uuid.Parse(cookie.Value)
-    if err != nil {
-        log.Println("Error parsing session token: ", err)
-        http.Redirect(w, r, "/login", http.StatusSeeOther)
-        return
+    h.RenderPage(w, r, "create", CreateTaskPage{Recurrence: recurrenceFormFor(""), TagOptions: tags, ProjectOptions: projects})
+}
+
+// parseTaskForm reads the task fields shared by the create and update forms and validates them. The due date is sent as a day, in the `Mon Jan 2 2006` format, with an optional `due_time` such as `14:30`, both in the user's time zone, loc; without a time, the task falls due at the end of the day. The repeat editor's fields are returned as typed, to show again if the form is refused. Tags and the project are only given by id.
+func parseTaskForm(r *http.Request, loc *time.Location) (app.Task, RecurrenceForm, validate.Errors) {
+    rule, recurrence, recurrenceError := parseRecurrenceForm(r)
+    tags, tagsOk := parseTagIds(r)
+    projectId, projectOk := parseProjectId(r)
+    priority, priorityErr := app.ParsePriority(r.FormValue("priority"))
+    task := app.Task{
+        Title:       strings.TrimSpace(r.FormValue("title")),
+        Description: r.FormValue("description"),
+        Recurrence:  rule,
+        Tags:        tags,
+        ProjectId:   projectId,
+        Priority:    priority,
     }
 
-    _, err = h.store.GetUserIdFromSessionToken(sessionToken)
-    if err != nil {
-        log.Println("Error getting user id: ", err)
-        http.Redirect(w, r, "/login", http.StatusSeeOther)
-        return
+    day, dayErr := time.ParseInLocation("Mon Jan 2 2006", r.FormValue("due"), loc)
+    var clock time.Time
+    var clockErr error
+    if value := r.FormValue("due_time"); value == "" {
+        task.AllDay = true
+    } else {
+        clock, clockErr = time.Parse("15:04", value)
+    }
+    if dayErr == nil && clockErr == nil {
+        if task.AllDay {
+            task.Due = app.EndOfDay(day).UTC()
+        } else {
+            task.Due = time.Date(day.Year(), day.Month(), day.Day(), clock.Hour(), clock.Minute(), 0, 0, loc).UTC()
+        }
+    }
+
+    errs := validate.Task(task, time.Now())
+    if dayErr != nil {
+        errs["due"] = "Choose a due date."
+    } else if clockErr != nil {
+        errs["due"] = "Enter the time as HH:MM, or leave it empty."
+    }
+    if recurrenceError != "" {
+        errs.Add("recurrence", recurrenceError)
+    }
+    if !tagsOk {
+        errs.Add("tags", "Choose tags from the list.")
+    }
+    if !projectOk {
+        errs.Add("project", "Choose a project from the list.")
+    }
+    if priorityErr != nil {
+        errs.Add("priority", "Choose a priority from the list.")
     }
 
-    h.RenderPage(w, r, "create", nil)
+    return task, recurrence, errs
 }
 
 func (h *RealHandler) SubmitCreateTask(w http.ResponseWriter, r *http.Request, userId int) {
     r.ParseForm()
-    title := r.FormValue("title")
-	description := r.FormValue("description")
-	dueStr := r.FormValue("due")
-
-    dueDate, err := time.Parse("Mon Jan 2 2006", dueStr)
-	if err != nil {
-		http.Error(w, "Invalid date format", http.StatusBadRequest)
-		return
-	}
-
-    dueDate = time.Date(dueDate.Year(), dueDate.Month(), dueDate.Day(), 23, 59, 59, 999999999, dueDate.Location())
+    loc, err := h.userLocation(userId)
+    if err != nil {
+        log.Println("Error getting user: ", err)
+        http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+        return
+    }
+    task, recurrence, errs := parseTaskForm(r, loc)
+    if len(errs) > 0 {
+        tags, err := h.tagOptions(userId, task.Tags)
+        if err != nil {
+            log.Println("Error getting tags: ", err)
+            http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+            return
+        }
+        projects, err := h.projectOptions(userId, task.ProjectId)
+        if err != nil {
+            log.Println("Error getting projects: ", err)
+            http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+            return
+        }
+
+        w.WriteHeader(http.StatusBadRequest)
+        h.RenderPage(w, r, "create", CreateTaskPage{
+            Title:       task.Title,
+            Description: task.Description,
+            Due:         r.FormValue("due"),
+            DueTime:     r.FormValue("due_time"),
+            Recurrence:  recurrence,
+            Priority:    task.Priority,
+            TagOptions:  tags,
+            ProjectOptions: projects,
+            Errors:      errs,
+        })
+        return
+    }
 
-    task := app.Task{
-        Id:          uuid.New(),
-        UserId:      userId,
-		Title:       title,
-		Description: description,
-		Due:         dueDate,
-	}
+    task.Id = uuid.New()
+    task.UserId = userId
 
     err = h.store.CreateTask(task)
+    if errors.Is(err, db.ErrTagNotFound) || errors.Is(err, db.ErrProjectNotFound) {
+        taskError(w, err)
+        return
+    }
     if err != nil {
         http.Error(w, "failed to create task", http.StatusInternalServerError)
         return
     }
 
+    if task.ProjectId != 0 {
+        http.Redirect(w, r, "/projects/"+strconv.Itoa(task.ProjectId), http.StatusSeeOther)
+        return
+    }
     http.Redirect(w, r, "/dashboard", http.StatusSeeOther)
 }
 
-func (h *RealHandler) GetTask(w http.ResponseWriter, r *http.Request, id uuid.UUID) {
-    task, err := h.store.GetTaskById(id)
+func (h *RealHandler) GetTask(w http.ResponseWriter, r *http.Request, userId int, id uuid.UUID) {
+    task, err := h.store.GetTaskById(userId, id)
     if err != nil {
-        http.Error(w, "not found", http.StatusNotFound)
+        taskError(w, err)
         return
     }
 
-    prettyTask := TaskView{
-        Id:          task.Id,
-        Title:       task.Title,
-        Status:      task.Status,
-        DuePretty:   task.Due.Format("Mon Jan 2 2006"),
-        Description: task.Description,
+    prettyTask, err := h.withChecklist(userId, taskViewFor(task))
+    if err != nil {
+        taskError(w, err)
+        return
+    }
+    prettyTask.TagOptions, err = h.tagOptions(userId, task.Tags)
+    if err != nil {
+        taskError(w, err)
+        return
+    }
+    prettyTask.ProjectOptions, err = h.projectOptions(userId, task.ProjectId)
+    if err != nil {
+        taskError(w, err)
+        return
     }
 
     h.RenderPage(w, r, "task", prettyTask)
@@ -320,26 +703,44 @@ func (h *RealHandler) HandleAbout(w http.ResponseWriter, r *http.Request) {
 }
 
 func (h *RealHandler) HandleLogout(w http.ResponseWriter, r *http.Request) {
+    if cookie, err := r.Cookie("session_token"); err == nil && cookie.Value != "" {
+        if err := h.store.DeleteSession(cookie.Value); err != nil {
+            log.Println("Error deleting session: ", err)
+        }
+    }
+
+    clearSessionCookie(w)
+    http.Redirect(w, r, "/login", http.StatusSeeOther)
+}
+
+// HandleLogoutEverywhere revokes all of the user's sessions, including those on other devices, e.g. after a cookie has been stolen.
+func (h *RealHandler) HandleLogoutEverywhere(w http.ResponseWriter, r *http.Request, userId int) {
+    if err := h.store.DeleteAllSessions(userId); err != nil {
+        log.Println("Error deleting sessions: ", err)
+        http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+        return
+    }
+
+    clearSessionCookie(w)
+    http.Redirect(w, r, "/login", http.StatusSeeOther)
+}
+
+func clearSessionCookie(w http.ResponseWriter) {
     http.SetCookie(w, &http.Cookie{
         Name:    "session_token",
         Value:   "",
+        Path:    "/",
         Expires: time.Unix(0, 0),
     })
-    http.Redirect(w, r, "/login", http.StatusSeeOther)
 }
 
+// HandleAllTasks lists a page of the user's tasks with their details, filtered, sorted, and grouped as asked by the query string.
 func (h *RealHandler) HandleAllTasks(w http.ResponseWriter, r *http.Request, userId int) {
-    preData, err := h.store.GetAllTasks(userId)
-    if err != nil {
-        log.Println("Error getting tasks: ", err)
-        http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+    list, preData, ok := h.listTasks(w, r, userId)
+    if !ok {
         return
     }
 
-    sort.Slice(preData, func(i, j int) bool {
-        return preData[i].Due.Before(preData[j].Due)
-    })
-
     var data []TaskView
     for _, task := range preData {
         data = append(data, TaskView{
@@ -347,106 +748,228 @@ func (h *RealHandler) HandleAllTasks(w http.ResponseWriter, r *http.Request, use
             Title:       task.Title,
             Status:      task.Status,
             Description: task.Description,
-            DuePretty:   task.Due.Format("Mon Jan 2 2006"),
+            DuePretty:   dueDate(task),
+            DueTime:     dueTime(task),
+            Recurrence:  recurrenceFormFor(task.Recurrence),
+            ChecklistTotal: task.ChecklistTotal,
+            ChecklistDone:  task.ChecklistDone,
+            Tags:        task.Tags,
+            Priority:    task.Priority,
         })
     }
 
-    h.RenderPage(w, r, "tasks", data)
+    list.Groups = groupTasks(list.Sort.Group, preData, data)
+    h.RenderPage(w, r, "tasks", TasksPage{
+        TaskList: list,
+        Tags:     list.Sort.Tags,
+    })
 }
 
-func (h *RealHandler) DeleteTask(w http.ResponseWriter, r *http.Request, id uuid.UUID) {
-    err := h.store.DeleteTask(id)
+func (h *RealHandler) DeleteTask(w http.ResponseWriter, r *http.Request, userId int, id uuid.UUID) {
+    err := h.store.DeleteTask(userId, id)
     if err != nil {
-        http.Error(w, "not found", http.StatusNotFound)
+        taskError(w, err)
         return
     }
 
     http.Redirect(w, r, "/dashboard", http.StatusSeeOther)
 }
 
-func (h *RealHandler) MarkTaskDone(w http.ResponseWriter, r *http.Request, id uuid.UUID) {
-    err := h.store.SetTaskDone(id)
+// MarkTaskDone handles the dashboard checkboxes, which send `{"checked": bool}`, and responds with the task's new status, plus the id of the next occurrence if a recurring task was done.
+func (h *RealHandler) MarkTaskDone(w http.ResponseWriter, r *http.Request, userId int, id uuid.UUID) {
+    var requestBody struct {
+        Checked bool `json:"checked"`
+    }
+
+    if err := json.NewDecoder(r.Body).Decode(&requestBody); err != nil {
+        http.Error(w, "Invalid request body", http.StatusBadRequest)
+        return
+    }
+
+    next, err := h.store.SetTaskDone(userId, id, requestBody.Checked)
     if err != nil {
-        http.Error(w, "not found", http.StatusNotFound)
+        taskError(w, err)
         return
     }
 
-    http.Redirect(w, r, "/dashboard", http.StatusSeeOther)
-}
+    task, err := h.store.GetTaskById(userId, id)
+    if err != nil {
+        taskError(w, err)
+        return
+    }
 
-func (h *RealHandler) UpdateTask(w http.ResponseWriter, r *http.Request, id uuid.UUID) {
-    title := r.FormValue("title")
-    status := r.FormValue("status")
-    description := r.FormValue("description")
-    due := r.FormValue("due")
+    response := map[string]string{"status": task.Status}
+    if next.Id != uuid.Nil {
+        response["next"] = next.Id.String()
+    }
+    writeJSON(w, http.StatusOK, response)
+}
 
-    dueDate, err := time.Parse("Mon Jan 2 2006", due)
+func (h *RealHandler) UpdateTask(w http.ResponseWriter, r *http.Request, userId int, id uuid.UUID) {
+    loc, err := h.userLocation(userId)
     if err != nil {
-        http.Error(w, "Invalid date format", http.StatusBadRequest)
+        log.Println("Error getting user: ", err)
+        http.Error(w, "Internal Server Error", http.StatusInternalServerError)
         return
     }
+    updatedTask, recurrence, errs := parseTaskForm(r, loc)
+    switch r.FormValue("status") {
+    case "done":
+        updatedTask.Done = 1
+    case "pending", "overdue":
+        updatedTask.Done = 0
+    default:
+        errs.Add("status", "Choose whether the task is done.")
+    }
+    if len(errs) > 0 {
+        existing, err := h.store.GetTaskById(userId, id)
+        if err != nil {
+            taskError(w, err)
+            return
+        }
+
+        view, err := h.withChecklist(userId, TaskView{
+            Id:          id,
+            Title:       updatedTask.Title,
+            Status:      existing.Status,
+            DuePretty:   r.FormValue("due"),
+            DueTime:     r.FormValue("due_time"),
+            Description: updatedTask.Description,
+            Recurrence:  recurrence,
+            Tags:        existing.Tags,
+            Priority:    updatedTask.Priority,
+            Errors:      errs,
+        })
+        if err != nil {
+            taskError(w, err)
+            return
+        }
+        view.TagOptions, err = h.tagOptions(userId, updatedTask.Tags)
+        if err != nil {
+            taskError(w, err)
+            return
+        }
+        view.ProjectOptions, err = h.projectOptions(userId, updatedTask.ProjectId)
+        if err != nil {
+            taskError(w, err)
+            return
+        }
 
-    dueDate = time.Date(dueDate.Year(), dueDate.Month(), dueDate.Day(), 23, 59, 59, 999999999, dueDate.Location())
-
-    updatedTask := app.Task{
-        Id:          id,
-        Title:       title,
-        Status:      status,
-        Description: description,
-        Due:         dueDate,
+        w.WriteHeader(http.StatusBadRequest)
+        h.RenderPage(w, r, "task", view)
+        return
     }
 
+    updatedTask.Id = id
+    updatedTask.UserId = userId
+
     err = h.store.UpdateTask(updatedTask)
     if err != nil {
-        http.Error(w, "Error updating task", http.StatusInternalServerError)
+        taskError(w, err)
         return
     }
 
     http.Redirect(w, r, "/dashboard", http.StatusSeeOther)
 }
 
-func (h *RealHandler) HandleProtected(w http.ResponseWriter, r *http.Request, handler func(http.ResponseWriter, *http.Request)) {
-    cookie, err := r.Cookie("session_token")
+// clientIP returns the address the request came from, without the port. It deliberately ignores `X-Forwarded-For`, which the client controls.
+func clientIP(r *http.Request) string {
+    host, _, err := net.SplitHostPort(r.RemoteAddr)
     if err != nil {
-        log.Println("Error getting cookie: ", err)
-        http.Redirect(w, r, "/login", http.StatusSeeOther)
-        return
+        return r.RemoteAddr
     }
+    return host
+}
 
-    sessionToken, err := uuid.Parse(cookie.Value)
-    if err != nil {
-        http.Error(w, "not logged in", http.StatusUnauthorized)
+// taskError reports an error from a task method of the store. Tasks that don't exist and tasks that belong to another user are both reported as not found.
+func taskError(w http.ResponseWriter, err error) {
+    if errors.Is(err, db.ErrTaskNotFound) {
+        http.Error(w, "not found", http.StatusNotFound)
         return
     }
-
-    _, err = h.store.GetUserIdFromSessionToken(sessionToken)
-    if err != nil {
-        log.Println("Error getting user id: ", err)
-        http.Redirect(w, r, "/login", http.StatusSeeOther)
+    if errors.Is(err, db.ErrTagNotFound) {
+        http.Error(w, "unknown tag", http.StatusBadRequest)
         return
     }
-
-    handler(w, r)
+    if errors.Is(err, db.ErrProjectNotFound) {
+        http.Error(w, "unknown project", http.StatusBadRequest)
+        return
+    }
+    log.Println("Error accessing task: ", err)
+    http.Error(w, "Internal Server Error", http.StatusInternalServerError)
 }
 
-func (h *RealHandler) HandleProtectedWithTaskId(w http.ResponseWriter, r *http.Request, handler func(http.ResponseWriter, *http.Request, uuid.UUID), idString string) {
+var (
+    errNoCredentials = errors.New("no session cookie or bearer token")
+    errReadOnlyToken = errors.New("API token is read-only")
+    errNeedsSession  = errors.New("account routes need a session cookie")
+)
+
+// authenticate returns the id of the user making the request. Credentials are taken from an `Authorization: Bearer` header if there is one, and from the session cookie otherwise.
+func (h *RealHandler) authenticate(r *http.Request) (int, error) {
+    if token := bearerToken(r); token != "" {
+        return h.authenticateBearer(r, token)
+    }
+
     cookie, err := r.Cookie("session_token")
+    if err != nil || cookie.Value == "" {
+        return 0, errNoCredentials
+    }
+
+    return h.store.GetUserIdFromSessionToken(cookie.Value)
+}
+
+// authenticateBearer accepts either a personal API token or a session token, such as the one returned by `/api/v1/login`. Read-only API tokens are refused for anything but safe methods.
+func (h *RealHandler) authenticateBearer(r *http.Request, token string) (int, error) {
+    if !strings.HasPrefix(token, db.APITokenPrefix) {
+        return h.store.GetUserIdFromSessionToken(token)
+    }
+
+    apiToken, err := h.store.GetAPIToken(token)
     if err != nil {
-        log.Println("Error getting cookie: ", err)
+        return 0, err
+    }
+
+    if apiToken.Scope != app.ScopeReadWrite && !isSafeMethod(r.Method) {
+        return 0, errReadOnlyToken
+    }
+
+    return apiToken.UserId, nil
+}
+
+func isSafeMethod(method string) bool {
+    return method == http.MethodGet || method == http.MethodHead || method == http.MethodOptions
+}
+
+// denyHTML responds to a request to an HTML route that couldn't be authenticated. Browsers are sent to the login page; scripts that sent a bearer token get a status code they can act on.
+func denyHTML(w http.ResponseWriter, r *http.Request, err error) {
+    log.Println("Error authenticating request: ", err)
+
+    switch {
+    case errors.Is(err, errReadOnlyToken):
+        http.Error(w, "forbidden: API token is read-only", http.StatusForbidden)
+    case errors.Is(err, errNeedsSession):
+        http.Error(w, "forbidden: log in to manage your account", http.StatusForbidden)
+    case bearerToken(r) != "":
+        http.Error(w, "invalid or expired token", http.StatusUnauthorized)
+    default:
         http.Redirect(w, r, "/login", http.StatusSeeOther)
-        return
     }
+}
 
-    sessionToken, err := uuid.Parse(cookie.Value)
-    if err != nil {
-        http.Error(w, "not logged in", http.StatusUnauthorized)
+func (h *RealHandler) HandleProtected(w http.ResponseWriter, r *http.Request, handler func(http.ResponseWriter, *http.Request)) {
+    if _, err := h.authenticate(r); err != nil {
+        denyHTML(w, r, err)
         return
     }
 
-    _, err = h.store.GetUserIdFromSessionToken(sessionToken)
+    handler(w, r)
+}
+
+func (h *RealHandler) HandleProtectedWithTaskId(w http.ResponseWriter, r *http.Request, handler func(http.ResponseWriter, *http.Request, int, uuid.UUID), idString string) {
+    userId, err := h.authenticate(r)
     if err != nil {
-        log.Println("Error getting user id: ", err)
-        http.Redirect(w, r, "/login", http.StatusSeeOther)
+        denyHTML(w, r, err)
         return
     }
 
@@ -456,29 +979,25 @@ func (h *RealHandler) HandleProtectedWithTaskId(w http.ResponseWriter, r *http.R
         return
     }
 
-    handler(w, r, taskId)
+    handler(w, r, userId, taskId)
 }
 
 func (h *RealHandler) HandleProtectedWithUserId(w http.ResponseWriter, r *http.Request, handler func(http.ResponseWriter, *http.Request, int)) {
-    cookie, err := r.Cookie("session_token")
+    userId, err := h.authenticate(r)
     if err != nil {
-        log.Println("Error getting cookie: ", err)
-        http.Redirect(w, r, "/login", http.StatusSeeOther)
+        denyHTML(w, r, err)
         return
     }
 
-    sessionToken, err := uuid.Parse(cookie.Value)
-    if err != nil {
-        http.Error(w, "not logged in", http.StatusUnauthorized)
-        return
-    }
+    handler(w, r, userId)
+}
 
-    userId, err := h.store.GetUserIdFromSessionToken(sessionToken)
-    if err != nil {
-        log.Println("Error getting user id: ", err)
-        http.Redirect(w, r, "/login", http.StatusSeeOther)
+// HandleSessionProtected is HandleProtectedWithUserId for the routes that manage the account, such as its tokens and sessions. Only the session cookie of a logged-in browser is accepted, so that a leaked or limited bearer token can't be used to take the account over.
+func (h *RealHandler) HandleSessionProtected(w http.ResponseWriter, r *http.Request, handler func(http.ResponseWriter, *http.Request, int)) {
+    if bearerToken(r) != "" {
+        denyHTML(w, r, errNeedsSession)
         return
     }
 
-    handler(w, r, userId)
-}
\ No newline at end of file
+    h.HandleProtectedWithUserId(w, r, handler)
+}
@@ -15,6 +15,8 @@ import (
 	"golang.org/x/crypto/bcrypt"
 
 	"penumbra/app"
+	"penumbra/db"
+	"penumbra/validate"
 )
 
 type MockSQLiteStore struct {
@@ -31,21 +33,246 @@ func (m *MockSQLiteStore) GetUserByEmail(email string) (app.User, error) {
     return args.Get(0).(app.User), args.Error(1)
 }
 
-func (m *MockSQLiteStore) AddSessionToken(user_id int) (uuid.UUID, time.Time, error) {
+func (m *MockSQLiteStore) RecordFailedLogin(user_id int, maxAttempts int, lockFor time.Duration) (time.Time, error) {
+    args := m.Called(user_id, maxAttempts, lockFor)
+    return args.Get(0).(time.Time), args.Error(1)
+}
+
+func (m *MockSQLiteStore) ResetFailedLogins(user_id int) error {
+    args := m.Called(user_id)
+    return args.Error(0)
+}
+
+func (m *MockSQLiteStore) GetUserById(user_id int) (app.User, error) {
+    args := m.Called(user_id)
+    return args.Get(0).(app.User), args.Error(1)
+}
+
+func (m *MockSQLiteStore) SetPendingTOTPSecret(user_id int, secret string) error {
+    args := m.Called(user_id, secret)
+    return args.Error(0)
+}
+
+func (m *MockSQLiteStore) EnableTOTP(user_id int, recoveryCodes []string) error {
+    args := m.Called(user_id, recoveryCodes)
+    return args.Error(0)
+}
+
+func (m *MockSQLiteStore) DisableTOTP(user_id int) error {
+    args := m.Called(user_id)
+    return args.Error(0)
+}
+
+func (m *MockSQLiteStore) RecordTOTPStep(user_id int, step int64) (bool, error) {
+    args := m.Called(user_id, step)
+    return args.Bool(0), args.Error(1)
+}
+
+func (m *MockSQLiteStore) UseRecoveryCode(user_id int, code string) (bool, error) {
+    args := m.Called(user_id, code)
+    return args.Bool(0), args.Error(1)
+}
+
+func (m *MockSQLiteStore) CountRecoveryCodes(user_id int) (int, error) {
+    args := m.Called(user_id)
+    return args.Int(0), args.Error(1)
+}
+
+func (m *MockSQLiteStore) CreateLoginChallenge(user_id int) (string, time.Time, error) {
+    args := m.Called(user_id)
+    return args.String(0), args.Get(1).(time.Time), args.Error(2)
+}
+
+func (m *MockSQLiteStore) GetLoginChallenge(token string) (int, error) {
+    args := m.Called(token)
+    return args.Int(0), args.Error(1)
+}
+
+func (m *MockSQLiteStore) DeleteLoginChallenge(token string) error {
+    args := m.Called(token)
+    return args.Error(0)
+}
+
+func (m *MockSQLiteStore) CreatePasswordReset(user_id int) (string, time.Time, error) {
     args := m.Called(user_id)
-    return args.Get(0).(uuid.UUID), args.Get(1).(time.Time), args.Error(2)
+    return args.String(0), args.Get(1).(time.Time), args.Error(2)
+}
+
+func (m *MockSQLiteStore) GetPasswordReset(token string) (int, error) {
+    args := m.Called(token)
+    return args.Int(0), args.Error(1)
+}
+
+func (m *MockSQLiteStore) ResetPassword(token string, passwordHash []byte) (int, error) {
+    args := m.Called(token, passwordHash)
+    return args.Int(0), args.Error(1)
+}
+
+func (m *MockSQLiteStore) MarkEmailVerified(user_id int, email string) error {
+    args := m.Called(user_id, email)
+    return args.Error(0)
+}
+
+func (m *MockSQLiteStore) AddSessionToken(user_id int, userAgent string, ip string) (string, time.Time, error) {
+    args := m.Called(user_id, userAgent, ip)
+    return args.String(0), args.Get(1).(time.Time), args.Error(2)
+}
+
+func (m *MockSQLiteStore) DeleteSession(sessionToken string) error {
+    args := m.Called(sessionToken)
+    return args.Error(0)
 }
 
-func (m *MockSQLiteStore) GetUserIdFromSessionToken(sessionToken uuid.UUID) (int, error) {
+func (m *MockSQLiteStore) GetUserIdFromSessionToken(sessionToken string) (int, error) {
     args := m.Called(sessionToken)
     return args.Int(0), args.Error(1)
 }
 
+func (m *MockSQLiteStore) DeleteAllSessions(user_id int) error {
+    args := m.Called(user_id)
+    return args.Error(0)
+}
+
+func (m *MockSQLiteStore) CreateAPIToken(user_id int, name string, scope string, expiresAt time.Time) (string, error) {
+    args := m.Called(user_id, name, scope, expiresAt)
+    return args.String(0), args.Error(1)
+}
+
+func (m *MockSQLiteStore) GetAPITokens(user_id int) ([]app.APIToken, error) {
+    args := m.Called(user_id)
+    return args.Get(0).([]app.APIToken), args.Error(1)
+}
+
+func (m *MockSQLiteStore) GetAPIToken(token string) (app.APIToken, error) {
+    args := m.Called(token)
+    return args.Get(0).(app.APIToken), args.Error(1)
+}
+
+func (m *MockSQLiteStore) DeleteAPIToken(user_id int, id int) error {
+    args := m.Called(user_id, id)
+    return args.Error(0)
+}
+
+func (m *MockSQLiteStore) GetCalDAVObjects(user_id int) ([]db.CalDAVObject, error) {
+    args := m.Called(user_id)
+    return args.Get(0).([]db.CalDAVObject), args.Error(1)
+}
+
+func (m *MockSQLiteStore) GetCalDAVObject(user_id int, name string) (db.CalDAVObject, error) {
+    args := m.Called(user_id, name)
+    return args.Get(0).(db.CalDAVObject), args.Error(1)
+}
+
+func (m *MockSQLiteStore) PutCalDAVObject(user_id int, o db.CalDAVObject) (db.CalDAVObject, bool, error) {
+    args := m.Called(user_id, o)
+    return args.Get(0).(db.CalDAVObject), args.Bool(1), args.Error(2)
+}
+
+func (m *MockSQLiteStore) CreateCalendarFeed(user_id int) (string, error) {
+    args := m.Called(user_id)
+    return args.String(0), args.Error(1)
+}
+
+func (m *MockSQLiteStore) GetCalendarFeed(user_id int) (app.CalendarFeed, error) {
+    args := m.Called(user_id)
+    return args.Get(0).(app.CalendarFeed), args.Error(1)
+}
+
+func (m *MockSQLiteStore) GetCalendarFeedByToken(token string) (app.CalendarFeed, error) {
+    args := m.Called(token)
+    return args.Get(0).(app.CalendarFeed), args.Error(1)
+}
+
+func (m *MockSQLiteStore) DeleteCalendarFeed(user_id int) error {
+    args := m.Called(user_id)
+    return args.Error(0)
+}
+
 func (m *MockSQLiteStore) GetAllTasks(user_id int) ([]app.Task, error) {
     args := m.Called(user_id)
     return args.Get(0).([]app.Task), args.Error(1)
 }
 
+func (m *MockSQLiteStore) QueryTasks(user_id int, q db.TaskQuery) ([]app.Task, error) {
+    args := m.Called(user_id, q)
+    return args.Get(0).([]app.Task), args.Error(1)
+}
+
+func (m *MockSQLiteStore) PageTasks(user_id int, q db.TaskQuery, page db.PageQuery) (db.TaskPage, error) {
+    args := m.Called(user_id, q, page)
+    return args.Get(0).(db.TaskPage), args.Error(1)
+}
+
+func (m *MockSQLiteStore) ImportTasks(user_id int, tasks []app.Task) (db.ImportResult, error) {
+    args := m.Called(user_id, tasks)
+    return args.Get(0).(db.ImportResult), args.Error(1)
+}
+
+func (m *MockSQLiteStore) RestoreTasks(user_id int, tasks []app.Task) (db.ImportResult, error) {
+    args := m.Called(user_id, tasks)
+    return args.Get(0).(db.ImportResult), args.Error(1)
+}
+
+func (m *MockSQLiteStore) PreviewImport(user_id int, tasks []app.Task) ([]bool, error) {
+    args := m.Called(user_id, tasks)
+    return args.Get(0).([]bool), args.Error(1)
+}
+
+func (m *MockSQLiteStore) SearchTasks(user_id int, query string) ([]db.TaskMatch, error) {
+    args := m.Called(user_id, query)
+    return args.Get(0).([]db.TaskMatch), args.Error(1)
+}
+
+func (m *MockSQLiteStore) GetTags(user_id int) ([]app.Tag, error) {
+    args := m.Called(user_id)
+    return args.Get(0).([]app.Tag), args.Error(1)
+}
+
+func (m *MockSQLiteStore) CreateTag(tag app.Tag) (app.Tag, error) {
+    args := m.Called(tag)
+    return args.Get(0).(app.Tag), args.Error(1)
+}
+
+func (m *MockSQLiteStore) DeleteTag(user_id int, tag_id int) error {
+    args := m.Called(user_id, tag_id)
+    return args.Error(0)
+}
+
+func (m *MockSQLiteStore) SetTimeZone(user_id int, timeZone string) error {
+    args := m.Called(user_id, timeZone)
+    return args.Error(0)
+}
+
+func (m *MockSQLiteStore) GetProjects(user_id int) ([]app.Project, error) {
+    args := m.Called(user_id)
+    return args.Get(0).([]app.Project), args.Error(1)
+}
+
+func (m *MockSQLiteStore) GetProject(user_id int, project_id int) (app.Project, error) {
+    args := m.Called(user_id, project_id)
+    return args.Get(0).(app.Project), args.Error(1)
+}
+
+func (m *MockSQLiteStore) CreateProject(project app.Project) (app.Project, error) {
+    args := m.Called(project)
+    return args.Get(0).(app.Project), args.Error(1)
+}
+
+func (m *MockSQLiteStore) RenameProject(user_id int, project_id int, name string) error {
+    args := m.Called(user_id, project_id, name)
+    return args.Error(0)
+}
+
+func (m *MockSQLiteStore) DeleteProject(user_id int, project_id int) error {
+    args := m.Called(user_id, project_id)
+    return args.Error(0)
+}
+
+func (m *MockSQLiteStore) MoveTask(user_id int, task_id uuid.UUID, project_id int) error {
+    args := m.Called(user_id, task_id, project_id)
+    return args.Error(0)
+}
+
 func (m *MockSQLiteStore) RenderCreateTask() (int, error) {
     args := m.Called()
     return args.Int(0), args.Error(1)
@@ -56,8 +283,8 @@ func (m *MockSQLiteStore) SubmitCreateTask(task app.Task) (int, error) {
     return args.Int(0), args.Error(1)
 }
 
-func (m *MockSQLiteStore) GetTaskById(id uuid.UUID) (app.Task, error) {
-    args := m.Called(id)
+func (m *MockSQLiteStore) GetTaskById(user_id int, id uuid.UUID) (app.Task, error) {
+    args := m.Called(user_id, id)
     return args.Get(0).(app.Task), args.Error(1)
 }
 
@@ -71,8 +298,8 @@ func (m *MockSQLiteStore) CreateTask(task app.Task) error {
     return args.Error(0)
 }
 
-func (m *MockSQLiteStore) DeleteTask(id uuid.UUID) error {
-    args := m.Called(id)
+func (m *MockSQLiteStore) DeleteTask(user_id int, id uuid.UUID) error {
+    args := m.Called(user_id, id)
     return args.Error(0)
 }
 
@@ -81,9 +308,34 @@ func (m *MockSQLiteStore) UpdateTask(task app.Task) error {
     return args.Error(0)
 }
 
-func (m *MockSQLiteStore) SetTaskDone(id uuid.UUID) error {
-    args := m.Called()
-    return args.Error(1)
+func (m *MockSQLiteStore) SetTaskDone(user_id int, id uuid.UUID, done bool) (app.Task, error) {
+    args := m.Called(user_id, id, done)
+    return args.Get(0).(app.Task), args.Error(1)
+}
+
+func (m *MockSQLiteStore) GetChecklist(user_id int, task_id uuid.UUID) ([]app.ChecklistItem, error) {
+    args := m.Called(user_id, task_id)
+    return args.Get(0).([]app.ChecklistItem), args.Error(1)
+}
+
+func (m *MockSQLiteStore) AddChecklistItem(user_id int, task_id uuid.UUID, text string) (app.ChecklistItem, error) {
+    args := m.Called(user_id, task_id, text)
+    return args.Get(0).(app.ChecklistItem), args.Error(1)
+}
+
+func (m *MockSQLiteStore) SetChecklistItemDone(user_id int, task_id uuid.UUID, item_id int, done bool) error {
+    args := m.Called(user_id, task_id, item_id, done)
+    return args.Error(0)
+}
+
+func (m *MockSQLiteStore) MoveChecklistItem(user_id int, task_id uuid.UUID, item_id int, position int) error {
+    args := m.Called(user_id, task_id, item_id, position)
+    return args.Error(0)
+}
+
+func (m *MockSQLiteStore) DeleteChecklistItem(user_id int, task_id uuid.UUID, item_id int) error {
+    args := m.Called(user_id, task_id, item_id)
+    return args.Error(0)
 }
 
 func TestRenderPage(t *testing.T) {
@@ -135,7 +387,7 @@ func TestHandleHomeValidCookie(t *testing.T) {
 	cookie := &http.Cookie{Name: "session_token", Value: uuid.New().String()}
 	req.AddCookie(cookie)
 
-    mockStore.On("GetUserIdFromSessionToken", mock.AnythingOfType("uuid.UUID")).Return(1, nil).Once()
+    mockStore.On("GetUserIdFromSessionToken", mock.AnythingOfType("string")).Return(1, nil).Once()
 
 	rr := httptest.NewRecorder()
 
@@ -170,15 +422,17 @@ func TestSubmitLogin(t *testing.T) {
 	}
 	
 	mockUser := app.User{
-		Id:           1,
-		Email:        "test@example.com",
-		PasswordHash: passwordHash,
+		Id:            1,
+		Email:         "test@example.com",
+		PasswordHash:  passwordHash,
+		EmailVerified: true,
 	}
 	
 	mockStore.On("GetUserByEmail", "test@example.com").Return(mockUser, nil).Once()
+	mockStore.On("ResetFailedLogins", mockUser.Id).Return(nil).Once()
 	
-	mockSessionToken := uuid.New()
-	mockStore.On("AddSessionToken", mockUser.Id).Return(mockSessionToken, time.Now().Add(time.Hour), nil).Once()
+	mockSessionToken := "mock-session-token"
+	mockStore.On("AddSessionToken", mockUser.Id, "test-agent", "192.0.2.1").Return(mockSessionToken, time.Now().Add(time.Hour), nil).Once()
 	
 	form := url.Values{}
 	form.Add("email", "test@example.com")
@@ -186,6 +440,7 @@ func TestSubmitLogin(t *testing.T) {
 	
 	req := httptest.NewRequest("POST", "/login", strings.NewReader(form.Encode()))
 	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("User-Agent", "test-agent")
 	
 	rr := httptest.NewRecorder()
 	handler.SubmitLogin(rr, req)
@@ -206,8 +461,365 @@ func TestSubmitLogin(t *testing.T) {
 		cookie := cookies[0]
 		t.Logf("Cookie: Name=%s, Value=%s, Path=%s", cookie.Name, cookie.Value, cookie.Path)
 		assert.Equal(t, "session_token", cookie.Name)
-		assert.Equal(t, mockSessionToken.String(), cookie.Value)
+		assert.Equal(t, mockSessionToken, cookie.Value)
 	}
 	
 	mockStore.AssertExpectations(t)
-}
\ No newline at end of file
+}
+func TestGetTaskOtherUsersTask(t *testing.T) {
+    mockStore := new(MockSQLiteStore)
+    handler := &RealHandler{store: mockStore}
+
+    id := uuid.New()
+    mockStore.On("GetTaskById", 2, id).Return(app.Task{}, db.ErrTaskNotFound).Once()
+
+    req := httptest.NewRequest(http.MethodGet, "/tasks/"+id.String(), nil)
+    rr := httptest.NewRecorder()
+
+    handler.GetTask(rr, req, 2, id)
+
+    assert.Equal(t, http.StatusNotFound, rr.Code)
+    mockStore.AssertExpectations(t)
+}
+
+func TestDeleteTaskOtherUsersTask(t *testing.T) {
+    mockStore := new(MockSQLiteStore)
+    handler := &RealHandler{store: mockStore}
+
+    id := uuid.New()
+    mockStore.On("DeleteTask", 2, id).Return(db.ErrTaskNotFound).Once()
+
+    req := httptest.NewRequest(http.MethodPost, "/tasks/delete/"+id.String(), nil)
+    rr := httptest.NewRecorder()
+
+    handler.DeleteTask(rr, req, 2, id)
+
+    assert.Equal(t, http.StatusNotFound, rr.Code)
+    mockStore.AssertExpectations(t)
+}
+
+func TestMarkTaskDoneOtherUsersTask(t *testing.T) {
+    mockStore := new(MockSQLiteStore)
+    handler := &RealHandler{store: mockStore}
+
+    id := uuid.New()
+    mockStore.On("SetTaskDone", 2, id, true).Return(app.Task{}, db.ErrTaskNotFound).Once()
+
+    req := httptest.NewRequest(http.MethodPost, "/tasks/done/"+id.String(), strings.NewReader(`{"checked": true}`))
+    rr := httptest.NewRecorder()
+
+    handler.MarkTaskDone(rr, req, 2, id)
+
+    assert.Equal(t, http.StatusNotFound, rr.Code)
+    mockStore.AssertExpectations(t)
+}
+
+func TestMarkTaskDoneUnchecked(t *testing.T) {
+    mockStore := new(MockSQLiteStore)
+    handler := &RealHandler{store: mockStore}
+
+    id := uuid.New()
+    mockStore.On("SetTaskDone", 2, id, false).Return(app.Task{}, nil).Once()
+    mockStore.On("GetTaskById", 2, id).Return(app.Task{Id: id, UserId: 2, Status: "overdue"}, nil).Once()
+
+    req := httptest.NewRequest(http.MethodPost, "/tasks/done/"+id.String(), strings.NewReader(`{"checked": false}`))
+    rr := httptest.NewRecorder()
+
+    handler.MarkTaskDone(rr, req, 2, id)
+
+    assert.Equal(t, http.StatusOK, rr.Code)
+    assert.JSONEq(t, `{"status": "overdue"}`, rr.Body.String())
+    mockStore.AssertExpectations(t)
+}
+
+func TestMarkTaskDoneInvalidBody(t *testing.T) {
+    mockStore := new(MockSQLiteStore)
+    handler := &RealHandler{store: mockStore}
+
+    req := httptest.NewRequest(http.MethodPost, "/tasks/done/"+uuid.NewString(), strings.NewReader(`{"checked": `))
+    rr := httptest.NewRecorder()
+
+    handler.MarkTaskDone(rr, req, 2, uuid.New())
+
+    assert.Equal(t, http.StatusBadRequest, rr.Code)
+    mockStore.AssertNotCalled(t, "SetTaskDone", mock.Anything, mock.Anything, mock.Anything)
+}
+
+func TestUpdateTaskPersistsStatus(t *testing.T) {
+    for status, done := range map[string]int{"done": 1, "pending": 0, "overdue": 0} {
+        t.Run(status, func(t *testing.T) {
+            mockStore := new(MockSQLiteStore)
+            handler := &RealHandler{store: mockStore}
+            mockStore.On("GetUserById", 2).Return(app.User{Id: 2}, nil).Once()
+
+            id := uuid.New()
+            mockStore.On("UpdateTask", mock.MatchedBy(func(task app.Task) bool {
+                return task.Id == id && task.Done == done
+            })).Return(nil).Once()
+
+            form := url.Values{"title": {"Report"}, "description": {"Quarterly"}, "due": {"Mon Jan 2 2006"}, "status": {status}}
+            rr := httptest.NewRecorder()
+
+            handler.UpdateTask(rr, formRequest("/tasks/update/"+id.String(), form), 2, id)
+
+            assert.Equal(t, http.StatusSeeOther, rr.Code)
+            mockStore.AssertExpectations(t)
+        })
+    }
+}
+
+func TestUpdateTaskScopedToUser(t *testing.T) {
+    mockStore := new(MockSQLiteStore)
+    handler := &RealHandler{store: mockStore}
+    mockStore.On("GetUserById", 2).Return(app.User{Id: 2}, nil).Once()
+
+    id := uuid.New()
+    mockStore.On("UpdateTask", mock.MatchedBy(func(task app.Task) bool {
+        return task.Id == id && task.UserId == 2
+    })).Return(db.ErrTaskNotFound).Once()
+
+    form := url.Values{}
+    form.Add("title", "Not mine")
+    form.Add("description", "Still not mine")
+    form.Add("due", "Mon Jan 2 2006")
+    form.Add("status", "pending")
+
+    req := httptest.NewRequest(http.MethodPost, "/tasks/update/"+id.String(), strings.NewReader(form.Encode()))
+    req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+    rr := httptest.NewRecorder()
+
+    handler.UpdateTask(rr, req, 2, id)
+
+    assert.Equal(t, http.StatusNotFound, rr.Code)
+    mockStore.AssertExpectations(t)
+}
+
+func TestHandleProtectedWithTaskIdPassesUserId(t *testing.T) {
+    mockStore := new(MockSQLiteStore)
+    handler := &RealHandler{store: mockStore}
+
+    sessionToken := "session-token"
+    taskId := uuid.New()
+    mockStore.On("GetUserIdFromSessionToken", sessionToken).Return(7, nil).Once()
+
+    req := httptest.NewRequest(http.MethodGet, "/tasks/"+taskId.String(), nil)
+    req.AddCookie(&http.Cookie{Name: "session_token", Value: sessionToken})
+    rr := httptest.NewRecorder()
+
+    var gotUserId int
+    var gotTaskId uuid.UUID
+    handler.HandleProtectedWithTaskId(rr, req, func(w http.ResponseWriter, r *http.Request, userId int, id uuid.UUID) {
+        gotUserId = userId
+        gotTaskId = id
+    }, taskId.String())
+
+    assert.Equal(t, 7, gotUserId)
+    assert.Equal(t, taskId, gotTaskId)
+    mockStore.AssertExpectations(t)
+}
+
+func TestHandleLogoutRevokesSession(t *testing.T) {
+    mockStore := new(MockSQLiteStore)
+    handler := &RealHandler{store: mockStore}
+
+    mockStore.On("DeleteSession", "session-token").Return(nil).Once()
+
+    req := httptest.NewRequest(http.MethodPost, "/logout", nil)
+    req.AddCookie(&http.Cookie{Name: "session_token", Value: "session-token"})
+    rr := httptest.NewRecorder()
+
+    handler.HandleLogout(rr, req)
+
+    assert.Equal(t, http.StatusSeeOther, rr.Code)
+    assert.Equal(t, "/login", rr.Header().Get("Location"))
+
+    cookies := rr.Result().Cookies()
+    if assert.Len(t, cookies, 1) {
+        assert.Equal(t, "session_token", cookies[0].Name)
+        assert.Equal(t, "", cookies[0].Value)
+        assert.Equal(t, "/", cookies[0].Path)
+    }
+
+    mockStore.AssertExpectations(t)
+}
+
+func TestHandleLogoutWithoutCookie(t *testing.T) {
+    mockStore := new(MockSQLiteStore)
+    handler := &RealHandler{store: mockStore}
+
+    req := httptest.NewRequest(http.MethodPost, "/logout", nil)
+    rr := httptest.NewRecorder()
+
+    handler.HandleLogout(rr, req)
+
+    assert.Equal(t, http.StatusSeeOther, rr.Code)
+    mockStore.AssertNotCalled(t, "DeleteSession", mock.Anything)
+}
+
+func TestHandleLogoutEverywhere(t *testing.T) {
+    mockStore := new(MockSQLiteStore)
+    handler := &RealHandler{store: mockStore}
+
+    mockStore.On("DeleteAllSessions", 3).Return(nil).Once()
+
+    req := httptest.NewRequest(http.MethodPost, "/logout/all", nil)
+    rr := httptest.NewRecorder()
+
+    handler.HandleLogoutEverywhere(rr, req, 3)
+
+    assert.Equal(t, http.StatusSeeOther, rr.Code)
+    assert.Equal(t, "/login", rr.Header().Get("Location"))
+    mockStore.AssertExpectations(t)
+}
+
+func TestRenderPageIncludesCSRFToken(t *testing.T) {
+    tmpl := template.Must(template.New("layout").Parse(`<input name="csrf_token" value="{{.CSRFToken}}">`))
+    handler := &RealHandler{templates: tmpl}
+
+    page := withCSRF(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+        handler.RenderPage(w, r, "login", nil)
+    }))
+
+    req := httptest.NewRequest(http.MethodGet, "/login", nil)
+    req.AddCookie(&http.Cookie{Name: "csrf_token", Value: "existing-token"})
+    rr := httptest.NewRecorder()
+
+    page.ServeHTTP(rr, req)
+
+    assert.Contains(t, rr.Body.String(), `value="existing-token"`)
+    assert.Empty(t, rr.Result().Cookies(), "expected existing CSRF cookie to be kept")
+}
+
+func TestCSRFTokenChangesAtLogin(t *testing.T) {
+    passwordHash, err := bcrypt.GenerateFromPassword([]byte("password123"), bcrypt.MinCost)
+    if err != nil {
+        t.Fatal(err)
+    }
+    mockStore := new(MockSQLiteStore)
+    handler := newTestHandler(mockStore)
+    handler.templates = template.Must(template.New("layout").Parse(`{{.CSRFToken}}`))
+    router := NewRouter(handler)
+
+    mockStore.On("GetUserByEmail", "sam@example.com").Return(app.User{Id: 1, Email: "sam@example.com", PasswordHash: passwordHash, EmailVerified: true}, nil).Once()
+    mockStore.On("ResetFailedLogins", 1).Return(nil).Once()
+    mockStore.On("AddSessionToken", 1, "", "192.0.2.1").Return("session-token", time.Now().Add(time.Hour), nil).Once()
+    mockStore.On("GetUserIdFromSessionToken", "session-token").Return(1, nil)
+    mockStore.On("DeleteSession", "session-token").Return(nil).Once()
+
+    // post sends a form with the browser's cookies and the given CSRF token.
+    post := func(url string, form url.Values, token string, cookies ...*http.Cookie) *httptest.ResponseRecorder {
+        form.Set("csrf_token", token)
+        req := httptest.NewRequest(http.MethodPost, url, strings.NewReader(form.Encode()))
+        req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+        for _, c := range cookies {
+            req.AddCookie(c)
+        }
+        rr := httptest.NewRecorder()
+        router.ServeHTTP(rr, req)
+        return rr
+    }
+
+    rr := httptest.NewRecorder()
+    router.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/login", nil))
+    before := rr.Body.String()
+    csrfCookie := rr.Result().Cookies()[0]
+    assert.Equal(t, before, csrfCookie.Value)
+
+    rr = post("/login", url.Values{"email": {"sam@example.com"}, "password": {"password123"}}, before, csrfCookie)
+    assert.Equal(t, http.StatusSeeOther, rr.Code)
+    sessionCookie := rr.Result().Cookies()[0]
+    assert.Equal(t, "session-token", sessionCookie.Value)
+
+    rr = post("/logout", url.Values{}, before, csrfCookie, sessionCookie)
+    assert.Equal(t, http.StatusForbidden, rr.Code, "expected the token from before login to be refused")
+    mockStore.AssertNotCalled(t, "DeleteSession", mock.Anything)
+
+    req := httptest.NewRequest(http.MethodGet, "/about", nil)
+    req.AddCookie(csrfCookie)
+    req.AddCookie(sessionCookie)
+    rr = httptest.NewRecorder()
+    router.ServeHTTP(rr, req)
+    after := rr.Body.String()
+    assert.NotEqual(t, before, after)
+
+    rr = post("/logout", url.Values{}, after, csrfCookie, sessionCookie)
+    assert.Equal(t, http.StatusSeeOther, rr.Code)
+    mockStore.AssertExpectations(t)
+}
+
+// newTestHandler returns a handler whose pages render as their name and data, for tests to match against. Tests that need a base URL or another layout set them on the handler.
+func newTestHandler(store db.Store) *RealHandler {
+    return &RealHandler{
+        store:     store,
+        templates: template.Must(template.New("layout").Parse(`{{.Page}}|{{printf "%+v" .Data}}`)),
+    }
+}
+
+func TestSubmitRegisterShowsErrors(t *testing.T) {
+    mockStore := new(MockSQLiteStore)
+    handler := newTestHandler(mockStore)
+
+    form := url.Values{"name": {" Dana "}, "phone": {"555-0123"}, "email": {"dana@"}, "password": {"hunter2hunter2"}}
+    rr := httptest.NewRecorder()
+
+    handler.SubmitRegister(rr, formRequest("/register", form))
+
+    assert.Equal(t, http.StatusBadRequest, rr.Code)
+    body := rr.Body.String()
+    assert.Contains(t, body, "register|")
+    assert.Contains(t, body, "Name:Dana")
+    assert.Contains(t, body, "Email:dana@")
+    assert.Contains(t, body, "email: ")
+    assert.Contains(t, body, "phone: ")
+    assert.NotContains(t, body, "hunter2hunter2")
+    mockStore.AssertNotCalled(t, "CreateUser", mock.Anything)
+}
+
+func TestSubmitCreateTaskShowsErrors(t *testing.T) {
+    mockStore := new(MockSQLiteStore)
+    handler := newTestHandler(mockStore)
+    mockStore.On("GetUserById", 1).Return(app.User{Id: 1}, nil).Once()
+
+    mockStore.On("GetTags", 1).Return([]app.Tag{}, nil).Once()
+    mockStore.On("GetProjects", 1).Return([]app.Project{}, nil).Once()
+
+    form := url.Values{"title": {"   "}, "description": {"Quarterly"}, "due": {"someday"}}
+    rr := httptest.NewRecorder()
+
+    handler.SubmitCreateTask(rr, formRequest("/tasks/create", form), 1)
+
+    assert.Equal(t, http.StatusBadRequest, rr.Code)
+    body := rr.Body.String()
+    assert.Contains(t, body, "create|")
+    assert.Contains(t, body, "Description:Quarterly")
+    assert.Contains(t, body, "Due:someday")
+    assert.Contains(t, body, "title: ")
+    assert.Contains(t, body, "due: ")
+    mockStore.AssertNotCalled(t, "CreateTask", mock.Anything)
+}
+
+func TestUpdateTaskShowsErrors(t *testing.T) {
+    mockStore := new(MockSQLiteStore)
+    handler := newTestHandler(mockStore)
+    mockStore.On("GetUserById", 2).Return(app.User{Id: 2}, nil).Once()
+    handler.templates = template.Must(template.New("layout").Parse(`{{.Page}}|{{.Data.Status}}|{{.Data.DuePretty}}|{{.Data.Errors}}`))
+
+    id := uuid.New()
+    mockStore.On("GetTaskById", 2, id).Return(app.Task{Id: id, UserId: 2, Status: "pending"}, nil).Once()
+    mockStore.On("GetChecklist", 2, id).Return([]app.ChecklistItem{}, nil).Once()
+    mockStore.On("GetTags", 2).Return([]app.Tag{}, nil).Once()
+    mockStore.On("GetProjects", 2).Return([]app.Project{}, nil).Once()
+
+    form := url.Values{"title": {strings.Repeat("x", validate.MaxTitleLength+1)}, "description": {"Quarterly"}, "due": {"Mon Jan 2 2006"}, "status": {"pending"}}
+    rr := httptest.NewRecorder()
+
+    handler.UpdateTask(rr, formRequest("/tasks/update/"+id.String(), form), 2, id)
+
+    assert.Equal(t, http.StatusBadRequest, rr.Code)
+    body := rr.Body.String()
+    assert.Contains(t, body, "task|")
+    assert.Contains(t, body, "|pending|Mon Jan 2 2006|")
+    assert.Contains(t, body, "title: ")
+    mockStore.AssertNotCalled(t, "UpdateTask", mock.Anything)
+    mockStore.AssertExpectations(t)
+}
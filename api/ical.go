@@ -0,0 +1,113 @@
+package api
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"penumbra/db"
+	"penumbra/ical"
+	"penumbra/recur"
+	"penumbra/validate"
+)
+
+// maxImportBytes is the largest calendar or backup file that can be imported.
+const maxImportBytes = 5 << 20
+
+// ImportPage is the form for uploading a calendar, and what became of the last one uploaded.
+type ImportPage struct {
+    Result *db.ImportResult // Nil until a calendar has been imported.
+    Notes  []ImportNote
+    Errors validate.Errors
+}
+
+// ImportNote says what was wrong with one of the to-dos in an imported calendar, and what was done about it.
+type ImportNote struct {
+    Title string
+    Note  string
+}
+
+// ExportCalendar downloads all the user's tasks as an iCalendar file of to-dos.
+func (h *RealHandler) ExportCalendar(w http.ResponseWriter, r *http.Request, userId int) {
+    tasks, err := h.store.QueryTasks(userId, db.TaskQuery{})
+    if err != nil {
+        log.Println("Error getting tasks: ", err)
+        http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+        return
+    }
+
+    w.Header().Set("Content-Type", "text/calendar; charset=utf-8")
+    w.Header().Set("Content-Disposition", `attachment; filename="tasks.ics"`)
+    if err := ical.Encode(w, tasks, time.Now()); err != nil {
+        log.Println("Error writing calendar: ", err)
+    }
+}
+
+func (h *RealHandler) RenderImport(w http.ResponseWriter, r *http.Request, userId int) {
+    h.RenderPage(w, r, "import", ImportPage{})
+}
+
+// SubmitImport imports the to-dos of the uploaded `calendar` file, updating the tasks they were exported from and creating the rest. To-dos that can't be tasks are skipped, and listed with the reason.
+func (h *RealHandler) SubmitImport(w http.ResponseWriter, r *http.Request, userId int) {
+    refuse := func(message string) {
+        w.WriteHeader(http.StatusBadRequest)
+        h.RenderPage(w, r, "import", ImportPage{Errors: validate.Errors{"calendar": message}})
+    }
+
+    file, header, err := r.FormFile("calendar")
+    if err != nil {
+        refuse("Choose a calendar file to import.")
+        return
+    }
+    defer file.Close()
+    if header.Size > maxImportBytes {
+        refuse("Choose a calendar file of at most 5 MB.")
+        return
+    }
+
+    loc, err := h.userLocation(userId)
+    if err != nil {
+        log.Println("Error getting user: ", err)
+        http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+        return
+    }
+    tasks, err := ical.Decode(file, loc)
+    if errors.Is(err, ical.ErrNotCalendar) {
+        refuse("That isn't an iCalendar (.ics) file.")
+        return
+    } else if err != nil {
+        refuse(fmt.Sprintf("That calendar couldn't be read: %v.", err))
+        return
+    }
+
+    var page ImportPage
+    now := time.Now()
+    valid := tasks[:0]
+    for _, task := range tasks {
+        task.Title = strings.TrimSpace(task.Title)
+        if task.Recurrence != "" {
+            if _, err := recur.Parse(task.Recurrence); err != nil {
+                page.Notes = append(page.Notes, ImportNote{task.Title, "Imported without its repeat schedule, which can't be followed here (" + strings.TrimPrefix(err.Error(), "recur: ") + ")."})
+                task.Recurrence = ""
+            }
+            task.Recurrence = normalizeRecurrence(task.Recurrence)
+        }
+        if errs := validate.Task(task, now); len(errs) > 0 {
+            page.Notes = append(page.Notes, ImportNote{task.Title, "Skipped: " + errs.Error()})
+            continue
+        }
+        valid = append(valid, task)
+    }
+
+    result, err := h.store.ImportTasks(userId, valid)
+    if err != nil {
+        log.Println("Error importing tasks: ", err)
+        http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+        return
+    }
+    page.Result = &result
+    h.RenderPage(w, r, "import", page)
+}
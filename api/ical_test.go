@@ -0,0 +1,118 @@
+package api
+
+import (
+	"bytes"
+	"html/template"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+
+	"penumbra/app"
+	"penumbra/db"
+	"penumbra/ical"
+)
+
+// resultLayout also renders the result of an import, which pages only have a pointer to.
+var resultLayout = template.Must(template.New("layout").Parse(`{{.Page}}|{{printf "%+v" .Data}}{{with .Data.Result}}|{{printf "%+v" .}}{{end}}`))
+
+// uploadRequest is a multipart form post of the file as `calendar`.
+func uploadRequest(t *testing.T, contents string) *http.Request {
+    var body bytes.Buffer
+    form := multipart.NewWriter(&body)
+    part, err := form.CreateFormFile("calendar", "tasks.ics")
+    if err != nil {
+        t.Fatal(err)
+    }
+    part.Write([]byte(contents))
+    form.Close()
+
+    req := httptest.NewRequest(http.MethodPost, "/tasks/import", &body)
+    req.Header.Set("Content-Type", form.FormDataContentType())
+    return req
+}
+
+func TestExportCalendar(t *testing.T) {
+    mockStore := new(MockSQLiteStore)
+    handler := newTestHandler(mockStore)
+
+    id := uuid.New()
+    mockStore.On("QueryTasks", 1, db.TaskQuery{}).Return([]app.Task{{Id: id, Title: "Pay rent", Due: time.Date(2030, 1, 7, 12, 0, 0, 0, time.UTC)}}, nil).Once()
+
+    rr := httptest.NewRecorder()
+    handler.ExportCalendar(rr, httptest.NewRequest(http.MethodGet, "/tasks/export.ics", nil), 1)
+
+    assert.Equal(t, http.StatusOK, rr.Code)
+    assert.Equal(t, "text/calendar; charset=utf-8", rr.Header().Get("Content-Type"))
+    assert.Contains(t, rr.Header().Get("Content-Disposition"), "tasks.ics")
+    assert.Contains(t, rr.Body.String(), "UID:"+id.String()+"\r\n")
+    assert.Contains(t, rr.Body.String(), "DUE:20300107T120000Z\r\n")
+    mockStore.AssertExpectations(t)
+}
+
+func TestSubmitImport(t *testing.T) {
+    mockStore := new(MockSQLiteStore)
+    handler := newTestHandler(mockStore)
+    handler.templates = resultLayout
+
+    calendar := strings.Join([]string{
+        "BEGIN:VCALENDAR",
+        "BEGIN:VTODO",
+        "UID:a@example.com",
+        "SUMMARY:  Pay rent ",
+        "DUE;VALUE=DATE:20300107",
+        "END:VTODO",
+        "BEGIN:VTODO",
+        "UID:b@example.com",
+        "SUMMARY:Call mum",
+        "DUE:20300108T170000Z",
+        "RRULE:FREQ=MONTHLY;BYDAY=1SU",
+        "END:VTODO",
+        "BEGIN:VTODO",
+        "UID:c@example.com",
+        "SUMMARY:Someday",
+        "END:VTODO",
+        "END:VCALENDAR",
+    }, "\r\n")
+
+    mockStore.On("GetUserById", 1).Return(app.User{Id: 1, TimeZone: "Europe/London"}, nil).Once()
+    mockStore.On("ImportTasks", 1, mock.MatchedBy(func(tasks []app.Task) bool {
+        return len(tasks) == 2 &&
+            tasks[0].Id == ical.TaskId("a@example.com") && tasks[0].Title == "Pay rent" && tasks[0].AllDay &&
+            tasks[1].Title == "Call mum" && tasks[1].Recurrence == ""
+    })).Return(db.ImportResult{Created: 1, Updated: 1}, nil).Once()
+
+    rr := httptest.NewRecorder()
+    handler.SubmitImport(rr, uploadRequest(t, calendar), 1)
+
+    assert.Equal(t, http.StatusOK, rr.Code)
+    assert.Contains(t, rr.Body.String(), "|&amp;{Created:1 Updated:1}")
+    assert.Contains(t, rr.Body.String(), "{Title:Call mum Note:Imported without its repeat schedule")
+    assert.Contains(t, rr.Body.String(), "{Title:Someday Note:Skipped: due: Choose a due date.}")
+    mockStore.AssertExpectations(t)
+}
+
+func TestSubmitImportRefusesBadFiles(t *testing.T) {
+    mockStore := new(MockSQLiteStore)
+    handler := newTestHandler(mockStore)
+    mockStore.On("GetUserById", 1).Return(app.User{Id: 1}, nil)
+
+    cases := map[string]*http.Request{
+        "not a calendar": uploadRequest(t, "title,due\nPay rent,2030-01-07\n"),
+        "broken":         uploadRequest(t, "BEGIN:VCALENDAR\r\nBEGIN:VTODO\r\nDUE:soon\r\nEND:VTODO\r\nEND:VCALENDAR\r\n"),
+        "no file":        formRequest("/tasks/import", nil),
+    }
+    for name, req := range cases {
+        rr := httptest.NewRecorder()
+        handler.SubmitImport(rr, req, 1)
+        assert.Equal(t, http.StatusBadRequest, rr.Code, name)
+        assert.Contains(t, rr.Body.String(), "Errors:calendar: ", name)
+    }
+    mockStore.AssertNotCalled(t, "ImportTasks", mock.Anything, mock.Anything)
+}
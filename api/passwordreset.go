@@ -0,0 +1,144 @@
+package api
+
+import (
+	"errors"
+	"log"
+	"net/http"
+	"strings"
+
+	"golang.org/x/crypto/bcrypt"
+
+	"penumbra/db"
+	"penumbra/mail"
+	"penumbra/validate"
+)
+
+// Password reset works by emailing the user a link carrying a single-use token. The same confirmation is shown whether or not the email belongs to an account, so the form can't be used to find out who is registered.
+
+type ForgotPasswordPage struct {
+    Sent bool
+}
+
+type ResetPasswordPage struct {
+    Token   string
+    Invalid bool // The token doesn't exist, has expired, or has been used.
+    Done    bool
+    Error   string
+}
+
+func (h *RealHandler) RenderForgotPassword(w http.ResponseWriter, r *http.Request) {
+    h.RenderPage(w, r, "forgot", ForgotPasswordPage{})
+}
+
+func (h *RealHandler) SubmitForgotPassword(w http.ResponseWriter, r *http.Request) {
+    if err := r.ParseForm(); err != nil {
+        http.Error(w, "Bad Request", http.StatusBadRequest)
+        return
+    }
+
+    email := strings.TrimSpace(r.FormValue("email"))
+    if ok, wait := h.allowAttempt(r, "reset", email); !ok {
+        tooManyRequests(w, wait)
+        return
+    }
+
+    if email != "" {
+        h.sendPasswordReset(email)
+    }
+
+    h.RenderPage(w, r, "forgot", ForgotPasswordPage{Sent: true})
+}
+
+// sendPasswordReset emails a reset link if the email belongs to an account. Failures are only logged, since reporting them would reveal that the account exists.
+func (h *RealHandler) sendPasswordReset(email string) {
+    user, err := h.store.GetUserByEmail(email)
+    if err != nil {
+        log.Println("Password reset requested for unknown email: ", err)
+        return
+    }
+
+    token, _, err := h.store.CreatePasswordReset(user.Id)
+    if err != nil {
+        log.Println("Error creating password reset: ", err)
+        return
+    }
+
+    link := strings.TrimRight(h.baseURL, "/") + "/password/reset/" + token
+    err = h.mailer.Send(mail.Message{
+        To:      user.Email,
+        Subject: "Reset your Penumbra password",
+        Body: "Someone asked to reset the password for your Penumbra account. If it was you, open this link within an hour to choose a new one:\n\n" +
+            link + "\n\n" +
+            "If it wasn't you, you can ignore this email; your password hasn't changed.\n",
+    })
+    if err != nil {
+        log.Println("Error sending password reset email: ", err)
+    }
+}
+
+// The reset token is in the URL, so keep it out of the Referer header sent when the page loads scripts and styles from CDNs.
+func noReferrer(w http.ResponseWriter) {
+    w.Header().Set("Referrer-Policy", "no-referrer")
+}
+
+func (h *RealHandler) RenderResetPassword(w http.ResponseWriter, r *http.Request, token string) {
+    noReferrer(w)
+
+    _, err := h.store.GetPasswordReset(token)
+    if errors.Is(err, db.ErrPasswordResetNotFound) {
+        w.WriteHeader(http.StatusNotFound)
+        h.RenderPage(w, r, "reset", ResetPasswordPage{Invalid: true})
+        return
+    }
+    if err != nil {
+        log.Println("Error getting password reset: ", err)
+        http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+        return
+    }
+
+    h.RenderPage(w, r, "reset", ResetPasswordPage{Token: token})
+}
+
+func (h *RealHandler) SubmitResetPassword(w http.ResponseWriter, r *http.Request, token string) {
+    noReferrer(w)
+
+    if err := r.ParseForm(); err != nil {
+        http.Error(w, "Bad Request", http.StatusBadRequest)
+        return
+    }
+
+    password := r.FormValue("password")
+    if message := validate.Password(password); message != "" {
+        w.WriteHeader(http.StatusBadRequest)
+        h.RenderPage(w, r, "reset", ResetPasswordPage{Token: token, Error: message})
+        return
+    }
+    if password != r.FormValue("confirm_password") {
+        w.WriteHeader(http.StatusBadRequest)
+        h.RenderPage(w, r, "reset", ResetPasswordPage{Token: token, Error: "The passwords don't match."})
+        return
+    }
+
+    passwordHash, err := bcrypt.GenerateFromPassword([]byte(password), 10)
+    if err != nil {
+        log.Println("Error hashing password: ", err)
+        http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+        return
+    }
+
+    _, err = h.store.ResetPassword(token, passwordHash)
+    if errors.Is(err, db.ErrPasswordResetNotFound) {
+        w.WriteHeader(http.StatusNotFound)
+        h.RenderPage(w, r, "reset", ResetPasswordPage{Invalid: true})
+        return
+    }
+    if err != nil {
+        log.Println("Error resetting password: ", err)
+        http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+        return
+    }
+
+    // Every session was revoked with the old password, this browser's included.
+    clearSessionCookie(w)
+    h.RenderPage(w, r, "reset", ResetPasswordPage{Done: true})
+}
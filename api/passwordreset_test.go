@@ -0,0 +1,161 @@
+package api
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"golang.org/x/crypto/bcrypt"
+
+	"penumbra/app"
+	"penumbra/db"
+	"penumbra/mail"
+)
+
+func newPasswordResetTestHandler(store db.Store, mailer mail.Mailer) *RealHandler {
+    h := newTestHandler(store)
+    h.mailer, h.baseURL = mailer, "https://penumbra.example.com/"
+    return h
+}
+
+func formRequest(target string, form url.Values) *http.Request {
+    req := httptest.NewRequest(http.MethodPost, target, strings.NewReader(form.Encode()))
+    req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+    return req
+}
+
+func TestSubmitForgotPasswordSendsLink(t *testing.T) {
+    mockStore := new(MockSQLiteStore)
+    mailer := &mail.MemoryMailer{}
+    handler := newPasswordResetTestHandler(mockStore, mailer)
+
+    mockStore.On("GetUserByEmail", "bob@example.com").Return(app.User{Id: 3, Email: "bob@example.com"}, nil).Once()
+    mockStore.On("CreatePasswordReset", 3).Return("reset-token", time.Now().Add(time.Hour), nil).Once()
+
+    req := formRequest("/password/forgot", url.Values{"email": {" bob@example.com "}})
+    req.Host = "evil.example.com"
+    rr := httptest.NewRecorder()
+
+    handler.SubmitForgotPassword(rr, req)
+
+    assert.Equal(t, http.StatusOK, rr.Code)
+    assert.Contains(t, rr.Body.String(), "Sent:true")
+
+    sent := mailer.Messages()
+    if assert.Len(t, sent, 1) {
+        assert.Equal(t, "bob@example.com", sent[0].To)
+        assert.Contains(t, sent[0].Body, "https://penumbra.example.com/password/reset/reset-token\n")
+        assert.NotContains(t, sent[0].Body, "evil.example.com")
+    }
+    mockStore.AssertExpectations(t)
+}
+
+func TestSubmitForgotPasswordUnknownEmail(t *testing.T) {
+    mockStore := new(MockSQLiteStore)
+    mailer := &mail.MemoryMailer{}
+    handler := newPasswordResetTestHandler(mockStore, mailer)
+
+    mockStore.On("GetUserByEmail", "nobody@example.com").Return(app.User{}, assert.AnError).Once()
+
+    rr := httptest.NewRecorder()
+    handler.SubmitForgotPassword(rr, formRequest("/password/forgot", url.Values{"email": {"nobody@example.com"}}))
+
+    assert.Equal(t, http.StatusOK, rr.Code)
+    assert.Contains(t, rr.Body.String(), "Sent:true", "the response shouldn't reveal whether the account exists")
+    assert.Empty(t, mailer.Messages())
+    mockStore.AssertNotCalled(t, "CreatePasswordReset", mock.Anything)
+}
+
+func TestSubmitForgotPasswordRateLimited(t *testing.T) {
+    mockStore := new(MockSQLiteStore)
+    handler := newPasswordResetTestHandler(mockStore, &mail.MemoryMailer{})
+    handler.emailLimiter, _ = newTestLimiter(1, time.Minute)
+
+    mockStore.On("GetUserByEmail", "nobody@example.com").Return(app.User{}, assert.AnError).Once()
+
+    for _, want := range []int{http.StatusOK, http.StatusTooManyRequests} {
+        rr := httptest.NewRecorder()
+        handler.SubmitForgotPassword(rr, formRequest("/password/forgot", url.Values{"email": {"nobody@example.com"}}))
+        assert.Equal(t, want, rr.Code)
+    }
+}
+
+func TestRenderResetPassword(t *testing.T) {
+    mockStore := new(MockSQLiteStore)
+    handler := newPasswordResetTestHandler(mockStore, nil)
+
+    mockStore.On("GetPasswordReset", "good-token").Return(3, nil).Once()
+    mockStore.On("GetPasswordReset", "bad-token").Return(0, db.ErrPasswordResetNotFound).Once()
+
+    rr := httptest.NewRecorder()
+    handler.RenderResetPassword(rr, httptest.NewRequest(http.MethodGet, "/password/reset/good-token", nil), "good-token")
+    assert.Equal(t, http.StatusOK, rr.Code)
+    assert.Contains(t, rr.Body.String(), "Token:good-token")
+    assert.Equal(t, "no-referrer", rr.Header().Get("Referrer-Policy"))
+
+    rr = httptest.NewRecorder()
+    handler.RenderResetPassword(rr, httptest.NewRequest(http.MethodGet, "/password/reset/bad-token", nil), "bad-token")
+    assert.Equal(t, http.StatusNotFound, rr.Code)
+    assert.Contains(t, rr.Body.String(), "Invalid:true")
+}
+
+func TestSubmitResetPassword(t *testing.T) {
+    mockStore := new(MockSQLiteStore)
+    handler := newPasswordResetTestHandler(mockStore, nil)
+
+    mockStore.On("ResetPassword", "good-token", mock.MatchedBy(func(hash []byte) bool {
+        return bcrypt.CompareHashAndPassword(hash, []byte("new password")) == nil
+    })).Return(3, nil).Once()
+
+    rr := httptest.NewRecorder()
+    form := url.Values{"password": {"new password"}, "confirm_password": {"new password"}}
+    handler.SubmitResetPassword(rr, formRequest("/password/reset/good-token", form), "good-token")
+
+    assert.Equal(t, http.StatusOK, rr.Code)
+    assert.Contains(t, rr.Body.String(), "Done:true")
+    if cookie := findCookie(rr, "session_token"); assert.NotNil(t, cookie) {
+        assert.Empty(t, cookie.Value)
+    }
+    mockStore.AssertExpectations(t)
+}
+
+func TestSubmitResetPasswordInvalid(t *testing.T) {
+    cases := map[string]url.Values{
+        "empty":    {"password": {""}, "confirm_password": {""}},
+        "too long": {"password": {strings.Repeat("a", 73)}, "confirm_password": {strings.Repeat("a", 73)}},
+        "mismatch": {"password": {"new password"}, "confirm_password": {"other password"}},
+    }
+
+    for name, form := range cases {
+        t.Run(name, func(t *testing.T) {
+            mockStore := new(MockSQLiteStore)
+            handler := newPasswordResetTestHandler(mockStore, nil)
+
+            rr := httptest.NewRecorder()
+            handler.SubmitResetPassword(rr, formRequest("/password/reset/good-token", form), "good-token")
+
+            assert.Equal(t, http.StatusBadRequest, rr.Code)
+            assert.Contains(t, rr.Body.String(), "Token:good-token")
+            mockStore.AssertNotCalled(t, "ResetPassword", mock.Anything, mock.Anything)
+        })
+    }
+}
+
+func TestSubmitResetPasswordUsedToken(t *testing.T) {
+    mockStore := new(MockSQLiteStore)
+    handler := newPasswordResetTestHandler(mockStore, nil)
+
+    mockStore.On("ResetPassword", "used-token", mock.Anything).Return(0, db.ErrPasswordResetNotFound).Once()
+
+    rr := httptest.NewRecorder()
+    form := url.Values{"password": {"new password"}, "confirm_password": {"new password"}}
+    handler.SubmitResetPassword(rr, formRequest("/password/reset/used-token", form), "used-token")
+
+    assert.Equal(t, http.StatusNotFound, rr.Code)
+    assert.Contains(t, rr.Body.String(), "Invalid:true")
+}
@@ -0,0 +1,222 @@
+package api
+
+import (
+	"errors"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/google/uuid"
+
+	"penumbra/app"
+	"penumbra/db"
+	"penumbra/validate"
+)
+
+// ProjectsPage lists the user's projects, with a form to create one that's shown again as typed if it was refused.
+type ProjectsPage struct {
+    Projects []app.Project
+    Name     string
+    Errors   validate.Errors
+}
+
+// ProjectPage is the dashboard of a single project, with a form to rename it.
+type ProjectPage struct {
+    Project  app.Project
+    Tasks    []TaskView
+    Projects []ProjectOption // Where its tasks can be moved to.
+    Name     string          // The new name as typed, if renaming was refused.
+    Errors   validate.Errors
+}
+
+// ProjectOption is an entry of the project picker on the create and task pages.
+type ProjectOption struct {
+    app.Project
+    Selected bool
+}
+
+func (h *RealHandler) renderProjects(w http.ResponseWriter, r *http.Request, userId int, page ProjectsPage) {
+    projects, err := h.store.GetProjects(userId)
+    if err != nil {
+        log.Println("Error getting projects: ", err)
+        http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+        return
+    }
+
+    page.Projects = projects
+    h.RenderPage(w, r, "projects", page)
+}
+
+func (h *RealHandler) RenderProjects(w http.ResponseWriter, r *http.Request, userId int) {
+    h.renderProjects(w, r, userId, ProjectsPage{})
+}
+
+func (h *RealHandler) SubmitCreateProject(w http.ResponseWriter, r *http.Request, userId int) {
+    project := app.Project{UserId: userId, Name: strings.TrimSpace(r.FormValue("name"))}
+
+    errs := validate.Project(project)
+    if len(errs) == 0 {
+        created, err := h.store.CreateProject(project)
+        if errors.Is(err, db.ErrProjectExists) {
+            errs.Add("name", "You already have a project with this name.")
+        } else if err != nil {
+            log.Println("Error creating project: ", err)
+            http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+            return
+        } else {
+            project = created
+        }
+    }
+    if len(errs) > 0 {
+        w.WriteHeader(http.StatusBadRequest)
+        h.renderProjects(w, r, userId, ProjectsPage{Name: project.Name, Errors: errs})
+        return
+    }
+
+    http.Redirect(w, r, "/projects/"+strconv.Itoa(project.Id), http.StatusSeeOther)
+}
+
+func (h *RealHandler) renderProject(w http.ResponseWriter, r *http.Request, userId int, page ProjectPage) {
+    tasks, err := h.store.QueryTasks(userId, db.TaskQuery{ProjectId: page.Project.Id, Order: []db.TaskOrder{{By: db.SortDue}}})
+    if err != nil {
+        log.Println("Error getting tasks: ", err)
+        http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+        return
+    }
+    page.Projects, err = h.projectOptions(userId, page.Project.Id)
+    if err != nil {
+        log.Println("Error getting projects: ", err)
+        http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+        return
+    }
+
+    page.Tasks = dashboardViews(tasks)
+    h.RenderPage(w, r, "project", page)
+}
+
+// GetProject shows the dashboard of the project with the id given in the URL.
+func (h *RealHandler) GetProject(w http.ResponseWriter, r *http.Request, userId int, id string) {
+    project, ok := h.findProject(w, userId, id)
+    if !ok {
+        return
+    }
+
+    h.renderProject(w, r, userId, ProjectPage{Project: project})
+}
+
+// RenameProject renames the project with the id given in the URL to the form's `name`.
+func (h *RealHandler) RenameProject(w http.ResponseWriter, r *http.Request, userId int, id string) {
+    project, ok := h.findProject(w, userId, id)
+    if !ok {
+        return
+    }
+
+    name := strings.TrimSpace(r.FormValue("name"))
+    errs := validate.Project(app.Project{Name: name})
+    if len(errs) == 0 {
+        err := h.store.RenameProject(userId, project.Id, name)
+        if errors.Is(err, db.ErrProjectExists) {
+            errs.Add("name", "You already have a project with this name.")
+        } else if err != nil {
+            projectError(w, err)
+            return
+        }
+    }
+    if len(errs) > 0 {
+        w.WriteHeader(http.StatusBadRequest)
+        h.renderProject(w, r, userId, ProjectPage{Project: project, Name: name, Errors: errs})
+        return
+    }
+
+    http.Redirect(w, r, "/projects/"+strconv.Itoa(project.Id), http.StatusSeeOther)
+}
+
+// DeleteProject deletes the project with the id given in the URL, keeping its tasks in no project.
+func (h *RealHandler) DeleteProject(w http.ResponseWriter, r *http.Request, userId int, id string) {
+    projectId, err := strconv.Atoi(id)
+    if err != nil {
+        http.Error(w, "not found", http.StatusNotFound)
+        return
+    }
+
+    if err := h.store.DeleteProject(userId, projectId); err != nil {
+        projectError(w, err)
+        return
+    }
+
+    http.Redirect(w, r, "/projects", http.StatusSeeOther)
+}
+
+// MoveTask puts the task in the form's `project`, or in no project if it's empty, and shows where the task went.
+func (h *RealHandler) MoveTask(w http.ResponseWriter, r *http.Request, userId int, taskId uuid.UUID) {
+    projectId, ok := parseProjectId(r)
+    if !ok {
+        http.Error(w, "invalid project", http.StatusBadRequest)
+        return
+    }
+
+    if err := h.store.MoveTask(userId, taskId, projectId); err != nil {
+        taskError(w, err)
+        return
+    }
+
+    if projectId == 0 {
+        http.Redirect(w, r, "/dashboard", http.StatusSeeOther)
+        return
+    }
+    http.Redirect(w, r, "/projects/"+strconv.Itoa(projectId), http.StatusSeeOther)
+}
+
+// findProject looks up a project by the id given in the URL, reporting it as not found if the id isn't a number.
+func (h *RealHandler) findProject(w http.ResponseWriter, userId int, id string) (app.Project, bool) {
+    projectId, err := strconv.Atoi(id)
+    if err != nil {
+        http.Error(w, "not found", http.StatusNotFound)
+        return app.Project{}, false
+    }
+
+    project, err := h.store.GetProject(userId, projectId)
+    if err != nil {
+        projectError(w, err)
+        return app.Project{}, false
+    }
+    return project, true
+}
+
+// projectError reports an error from a project method of the store. Projects that don't exist and projects that belong to another user are both reported as not found.
+func projectError(w http.ResponseWriter, err error) {
+    if errors.Is(err, db.ErrProjectNotFound) {
+        http.Error(w, "not found", http.StatusNotFound)
+        return
+    }
+    log.Println("Error accessing project: ", err)
+    http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+}
+
+// projectOptions lists the user's projects for the project picker, selecting the one with the given id.
+func (h *RealHandler) projectOptions(userId int, selected int) ([]ProjectOption, error) {
+    projects, err := h.store.GetProjects(userId)
+    if err != nil {
+        return nil, err
+    }
+
+    options := make([]ProjectOption, len(projects))
+    for i, p := range projects {
+        options[i] = ProjectOption{Project: p, Selected: p.Id == selected}
+    }
+    return options, nil
+}
+
+// parseProjectId reads the project picker, where the empty value means no project. The store checks that the project is the user's own.
+func parseProjectId(r *http.Request) (int, bool) {
+    value := r.FormValue("project")
+    if value == "" {
+        return 0, true
+    }
+    id, err := strconv.Atoi(value)
+    if err != nil || id < 1 {
+        return 0, false
+    }
+    return id, true
+}
@@ -0,0 +1,158 @@
+package api
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+
+	"penumbra/app"
+	"penumbra/db"
+)
+
+func TestSubmitCreateProject(t *testing.T) {
+    mockStore := new(MockSQLiteStore)
+    handler := newTestHandler(mockStore)
+
+    mockStore.On("CreateProject", app.Project{UserId: 1, Name: "Garden"}).Return(app.Project{Id: 4, UserId: 1, Name: "Garden"}, nil).Once()
+
+    rr := httptest.NewRecorder()
+    handler.SubmitCreateProject(rr, formRequest("/projects", url.Values{"name": {"  Garden "}}), 1)
+
+    assert.Equal(t, http.StatusSeeOther, rr.Code)
+    assert.Equal(t, "/projects/4", rr.Header().Get("Location"))
+    mockStore.AssertExpectations(t)
+}
+
+func TestSubmitCreateProjectShowsErrors(t *testing.T) {
+    cases := map[string]struct {
+        name     string
+        existing bool
+    }{
+        "blank":     {"", false},
+        "duplicate": {"garden", true},
+    }
+
+    for name, tc := range cases {
+        t.Run(name, func(t *testing.T) {
+            mockStore := new(MockSQLiteStore)
+            handler := newTestHandler(mockStore)
+
+            if tc.existing {
+                mockStore.On("CreateProject", mock.Anything).Return(app.Project{}, db.ErrProjectExists).Once()
+            }
+            mockStore.On("GetProjects", 1).Return([]app.Project{{Id: 4, Name: "Garden"}}, nil).Once()
+
+            rr := httptest.NewRecorder()
+            handler.SubmitCreateProject(rr, formRequest("/projects", url.Values{"name": {tc.name}}), 1)
+
+            assert.Equal(t, http.StatusBadRequest, rr.Code)
+            assert.Contains(t, rr.Body.String(), "projects|")
+            assert.Contains(t, rr.Body.String(), "name:")
+            mockStore.AssertExpectations(t)
+        })
+    }
+}
+
+func TestGetProject(t *testing.T) {
+    mockStore := new(MockSQLiteStore)
+    handler := newTestHandler(mockStore)
+
+    mockStore.On("GetProject", 1, 4).Return(app.Project{Id: 4, UserId: 1, Name: "Garden"}, nil).Once()
+    mockStore.On("QueryTasks", 1, db.TaskQuery{ProjectId: 4, Order: []db.TaskOrder{{By: db.SortDue}}}).Return([]app.Task{{Title: "Weed", ProjectId: 4}}, nil).Once()
+    mockStore.On("GetProjects", 1).Return([]app.Project{{Id: 4, Name: "Garden"}, {Id: 5, Name: "House"}}, nil).Once()
+
+    rr := httptest.NewRecorder()
+    handler.GetProject(rr, httptest.NewRequest(http.MethodGet, "/projects/4", nil), 1, "4")
+
+    assert.Equal(t, http.StatusOK, rr.Code)
+    assert.Contains(t, rr.Body.String(), "project|")
+    assert.Contains(t, rr.Body.String(), "Title: Weed")
+    assert.Contains(t, rr.Body.String(), "Name:Garden} Selected:true")
+    mockStore.AssertExpectations(t)
+}
+
+func TestGetProjectNotFound(t *testing.T) {
+    mockStore := new(MockSQLiteStore)
+    handler := newTestHandler(mockStore)
+
+    mockStore.On("GetProject", 1, 9).Return(app.Project{}, db.ErrProjectNotFound).Once()
+
+    for _, id := range []string{"9", "x"} {
+        rr := httptest.NewRecorder()
+        handler.GetProject(rr, httptest.NewRequest(http.MethodGet, "/projects/"+id, nil), 1, id)
+        assert.Equal(t, http.StatusNotFound, rr.Code)
+    }
+    mockStore.AssertExpectations(t)
+}
+
+func TestRenameProjectShowsErrors(t *testing.T) {
+    mockStore := new(MockSQLiteStore)
+    handler := newTestHandler(mockStore)
+
+    mockStore.On("GetProject", 1, 4).Return(app.Project{Id: 4, UserId: 1, Name: "Garden"}, nil).Once()
+    mockStore.On("RenameProject", 1, 4, "House").Return(db.ErrProjectExists).Once()
+    mockStore.On("QueryTasks", 1, db.TaskQuery{ProjectId: 4, Order: []db.TaskOrder{{By: db.SortDue}}}).Return([]app.Task{}, nil).Once()
+    mockStore.On("GetProjects", 1).Return([]app.Project{}, nil).Once()
+
+    rr := httptest.NewRecorder()
+    handler.RenameProject(rr, formRequest("/projects/rename/4", url.Values{"name": {"House"}}), 1, "4")
+
+    assert.Equal(t, http.StatusBadRequest, rr.Code)
+    assert.Contains(t, rr.Body.String(), "Name:House")
+    assert.Contains(t, rr.Body.String(), "name:")
+    mockStore.AssertExpectations(t)
+}
+
+func TestDeleteProject(t *testing.T) {
+    mockStore := new(MockSQLiteStore)
+    handler := newTestHandler(mockStore)
+
+    mockStore.On("DeleteProject", 1, 4).Return(nil).Once()
+    mockStore.On("DeleteProject", 1, 5).Return(db.ErrProjectNotFound).Once()
+
+    rr := httptest.NewRecorder()
+    handler.DeleteProject(rr, formRequest("/projects/delete/4", url.Values{}), 1, "4")
+    assert.Equal(t, http.StatusSeeOther, rr.Code)
+    assert.Equal(t, "/projects", rr.Header().Get("Location"))
+
+    rr = httptest.NewRecorder()
+    handler.DeleteProject(rr, formRequest("/projects/delete/5", url.Values{}), 1, "5")
+    assert.Equal(t, http.StatusNotFound, rr.Code)
+
+    mockStore.AssertExpectations(t)
+}
+
+func TestMoveTask(t *testing.T) {
+    mockStore := new(MockSQLiteStore)
+    handler := newTestHandler(mockStore)
+
+    id := uuid.New()
+    mockStore.On("MoveTask", 1, id, 4).Return(nil).Once()
+    mockStore.On("MoveTask", 1, id, 0).Return(nil).Once()
+    mockStore.On("MoveTask", 1, id, 9).Return(db.ErrProjectNotFound).Once()
+
+    rr := httptest.NewRecorder()
+    handler.MoveTask(rr, formRequest("/tasks/move/"+id.String(), url.Values{"project": {"4"}}), 1, id)
+    assert.Equal(t, http.StatusSeeOther, rr.Code)
+    assert.Equal(t, "/projects/4", rr.Header().Get("Location"))
+
+    rr = httptest.NewRecorder()
+    handler.MoveTask(rr, formRequest("/tasks/move/"+id.String(), url.Values{"project": {""}}), 1, id)
+    assert.Equal(t, http.StatusSeeOther, rr.Code)
+    assert.Equal(t, "/dashboard", rr.Header().Get("Location"))
+
+    rr = httptest.NewRecorder()
+    handler.MoveTask(rr, formRequest("/tasks/move/"+id.String(), url.Values{"project": {"9"}}), 1, id)
+    assert.Equal(t, http.StatusBadRequest, rr.Code)
+
+    rr = httptest.NewRecorder()
+    handler.MoveTask(rr, formRequest("/tasks/move/"+id.String(), url.Values{"project": {"garden"}}), 1, id)
+    assert.Equal(t, http.StatusBadRequest, rr.Code)
+
+    mockStore.AssertExpectations(t)
+}
@@ -0,0 +1,98 @@
+package api
+
+import (
+	"math"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// Limiter throttles attempts keyed by, for example, an IP address or an email address. Allow reports whether another attempt may go ahead now and, if not, how long to wait before trying again. TokenBucketLimiter keeps its state in memory; an implementation backed by a shared store could be swapped in when running more than one instance.
+type Limiter interface {
+    Allow(key string) (bool, time.Duration)
+}
+
+// TokenBucketLimiter gives each key a bucket holding up to `burst` tokens, refilled at one token per `interval`. Each attempt takes a token, and attempts are refused while the bucket is empty.
+type TokenBucketLimiter struct {
+    mu       sync.Mutex
+    burst    float64
+    interval time.Duration
+    buckets  map[string]*tokenBucket
+    now      func() time.Time
+    calls    int
+}
+
+type tokenBucket struct {
+    tokens float64
+    last   time.Time
+}
+
+// How many calls to Allow go by between sweeps for buckets that have refilled completely and so can be forgotten.
+const tokenBucketSweepEvery = 1000
+
+func NewTokenBucketLimiter(burst int, interval time.Duration) *TokenBucketLimiter {
+    return &TokenBucketLimiter{
+        burst:    float64(burst),
+        interval: interval,
+        buckets:  make(map[string]*tokenBucket),
+        now:      time.Now,
+    }
+}
+
+func (l *TokenBucketLimiter) Allow(key string) (bool, time.Duration) {
+    l.mu.Lock()
+    defer l.mu.Unlock()
+
+    now := l.now()
+
+    l.calls++
+    if l.calls%tokenBucketSweepEvery == 0 {
+        l.sweep(now)
+    }
+
+    b, ok := l.buckets[key]
+    if !ok {
+        b = &tokenBucket{tokens: l.burst, last: now}
+        l.buckets[key] = b
+    }
+
+    b.tokens = math.Min(l.burst, b.tokens+float64(now.Sub(b.last))/float64(l.interval))
+    b.last = now
+
+    if b.tokens < 1 {
+        wait := time.Duration((1 - b.tokens) * float64(l.interval))
+        return false, wait
+    }
+
+    b.tokens--
+    return true, 0
+}
+
+func (l *TokenBucketLimiter) sweep(now time.Time) {
+    full := time.Duration(l.burst * float64(l.interval))
+    for key, b := range l.buckets {
+        if now.Sub(b.last) >= full {
+            delete(l.buckets, key)
+        }
+    }
+}
+
+// retryAfterSeconds rounds a wait up to whole seconds, as the `Retry-After` header requires.
+func retryAfterSeconds(wait time.Duration) string {
+    seconds := int(math.Ceil(wait.Seconds()))
+    if seconds < 1 {
+        seconds = 1
+    }
+    return strconv.Itoa(seconds)
+}
+
+func tooManyRequests(w http.ResponseWriter, wait time.Duration) {
+    w.Header().Set("Retry-After", retryAfterSeconds(wait))
+    http.Error(w, "Too many attempts. Please try again later.", http.StatusTooManyRequests)
+}
+
+func tooManyRequestsJSON(w http.ResponseWriter, wait time.Duration) {
+    w.Header().Set("Retry-After", retryAfterSeconds(wait))
+    writeJSONError(w, http.StatusTooManyRequests, "too many attempts")
+}
@@ -0,0 +1,214 @@
+package api
+
+import (
+	"html/template"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"golang.org/x/crypto/bcrypt"
+
+	"penumbra/app"
+	"penumbra/mail"
+)
+
+func newTestLimiter(burst int, interval time.Duration) (*TokenBucketLimiter, *time.Time) {
+    now := time.Date(2030, 1, 2, 15, 4, 5, 0, time.UTC)
+    l := NewTokenBucketLimiter(burst, interval)
+    l.now = func() time.Time { return now }
+    return l, &now
+}
+
+func TestTokenBucketLimiterBurst(t *testing.T) {
+    l, _ := newTestLimiter(3, time.Minute)
+
+    for i := 0; i < 3; i++ {
+        ok, _ := l.Allow("key")
+        assert.True(t, ok, "attempt %d should be allowed", i+1)
+    }
+
+    ok, wait := l.Allow("key")
+    assert.False(t, ok)
+    assert.Equal(t, time.Minute, wait)
+
+    ok, _ = l.Allow("other")
+    assert.True(t, ok, "keys should have separate buckets")
+}
+
+func TestTokenBucketLimiterRefill(t *testing.T) {
+    l, now := newTestLimiter(2, 10*time.Second)
+
+    l.Allow("key")
+    l.Allow("key")
+
+    *now = now.Add(4 * time.Second)
+    ok, wait := l.Allow("key")
+    assert.False(t, ok)
+    assert.Equal(t, 6*time.Second, wait)
+
+    *now = now.Add(6 * time.Second)
+    ok, _ = l.Allow("key")
+    assert.True(t, ok)
+
+    ok, _ = l.Allow("key")
+    assert.False(t, ok, "only one token should have refilled")
+}
+
+func TestTokenBucketLimiterSweep(t *testing.T) {
+    l, now := newTestLimiter(1, time.Second)
+
+    l.Allow("stale")
+    *now = now.Add(time.Hour)
+    for i := 1; i < tokenBucketSweepEvery; i++ {
+        l.Allow("fresh")
+    }
+
+    _, ok := l.buckets["stale"]
+    assert.False(t, ok, "refilled buckets should be swept")
+}
+
+func TestRetryAfterSeconds(t *testing.T) {
+    assert.Equal(t, "1", retryAfterSeconds(0))
+    assert.Equal(t, "1", retryAfterSeconds(200*time.Millisecond))
+    assert.Equal(t, "7", retryAfterSeconds(6100*time.Millisecond))
+}
+
+func loginRequest(email, password string) *http.Request {
+    form := url.Values{}
+    form.Add("email", email)
+    form.Add("password", password)
+
+    req := httptest.NewRequest(http.MethodPost, "/login", strings.NewReader(form.Encode()))
+    req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+    return req
+}
+
+func TestSubmitLoginRateLimitedByIP(t *testing.T) {
+    mockStore := new(MockSQLiteStore)
+    byIP, _ := newTestLimiter(1, time.Minute)
+    handler := NewHandler(mockStore, nil, WithLoginLimiters(byIP, nil))
+
+    mockStore.On("GetUserByEmail", "a@example.com").Return(app.User{}, assert.AnError).Once()
+
+    rr := httptest.NewRecorder()
+    handler.SubmitLogin(rr, loginRequest("a@example.com", "guess"))
+    assert.Equal(t, http.StatusSeeOther, rr.Code)
+
+    rr = httptest.NewRecorder()
+    handler.SubmitLogin(rr, loginRequest("b@example.com", "guess"))
+    assert.Equal(t, http.StatusTooManyRequests, rr.Code)
+    assert.Equal(t, "60", rr.Header().Get("Retry-After"))
+    mockStore.AssertNotCalled(t, "GetUserByEmail", "b@example.com")
+}
+
+func TestSubmitLoginRateLimitedByEmail(t *testing.T) {
+    mockStore := new(MockSQLiteStore)
+    byEmail, _ := newTestLimiter(1, time.Minute)
+    handler := NewHandler(mockStore, nil, WithLoginLimiters(nil, byEmail))
+
+    mockStore.On("GetUserByEmail", mock.Anything).Return(app.User{}, assert.AnError)
+
+    rr := httptest.NewRecorder()
+    handler.SubmitLogin(rr, loginRequest("a@example.com", "guess"))
+    assert.Equal(t, http.StatusSeeOther, rr.Code)
+
+    rr = httptest.NewRecorder()
+    handler.SubmitLogin(rr, loginRequest("A@example.com", "guess"))
+    assert.Equal(t, http.StatusTooManyRequests, rr.Code, "email keys should ignore case")
+
+    rr = httptest.NewRecorder()
+    handler.SubmitLogin(rr, loginRequest("b@example.com", "guess"))
+    assert.Equal(t, http.StatusSeeOther, rr.Code)
+}
+
+func TestSubmitLoginLocksAccount(t *testing.T) {
+    passwordHash, err := bcrypt.GenerateFromPassword([]byte("password123"), bcrypt.MinCost)
+    if err != nil {
+        t.Fatalf("Failed to generate password hash: %v", err)
+    }
+
+    mockStore := new(MockSQLiteStore)
+    handler := &RealHandler{store: mockStore}
+
+    lockedUntil := time.Now().Add(lockoutDuration)
+    mockStore.On("GetUserByEmail", "test@example.com").Return(app.User{Id: 1, PasswordHash: passwordHash}, nil).Once()
+    mockStore.On("RecordFailedLogin", 1, maxFailedLogins, lockoutDuration).Return(lockedUntil, nil).Once()
+
+    rr := httptest.NewRecorder()
+    handler.SubmitLogin(rr, loginRequest("test@example.com", "wrong"))
+
+    assert.Equal(t, http.StatusTooManyRequests, rr.Code)
+    assert.NotEmpty(t, rr.Header().Get("Retry-After"))
+    mockStore.AssertExpectations(t)
+}
+
+func TestSubmitLoginWhileLocked(t *testing.T) {
+    passwordHash, err := bcrypt.GenerateFromPassword([]byte("password123"), bcrypt.MinCost)
+    if err != nil {
+        t.Fatalf("Failed to generate password hash: %v", err)
+    }
+
+    mockStore := new(MockSQLiteStore)
+    handler := &RealHandler{store: mockStore}
+
+    user := app.User{Id: 1, PasswordHash: passwordHash, LockedUntil: time.Now().Add(10 * time.Minute)}
+    mockStore.On("GetUserByEmail", "test@example.com").Return(user, nil).Once()
+
+    rr := httptest.NewRecorder()
+    handler.SubmitLogin(rr, loginRequest("test@example.com", "password123"))
+
+    assert.Equal(t, http.StatusTooManyRequests, rr.Code)
+    assert.Equal(t, "600", rr.Header().Get("Retry-After"))
+    assert.Empty(t, rr.Result().Cookies(), "a locked account must not get a session, even with the right password")
+    mockStore.AssertNotCalled(t, "AddSessionToken", mock.Anything, mock.Anything, mock.Anything)
+    mockStore.AssertNotCalled(t, "RecordFailedLogin", mock.Anything, mock.Anything, mock.Anything)
+}
+
+func TestSubmitRegisterRateLimited(t *testing.T) {
+    mockStore := new(MockSQLiteStore)
+    byIP, _ := newTestLimiter(1, time.Minute)
+    templates := template.Must(template.New("layout").Parse(`{{.Page}}`))
+    handler := NewHandler(mockStore, templates, WithLoginLimiters(byIP, nil), WithMailer(&mail.MemoryMailer{}))
+
+    mockStore.On("CreateUser", mock.Anything).Return(nil).Once()
+    mockStore.On("GetUserByEmail", "dana@example.com").Return(app.User{Id: 4, Email: "dana@example.com"}, nil).Once()
+
+    form := url.Values{"name": {"Dana"}, "phone": {"+15555550123"}, "email": {"dana@example.com"}, "password": {"password123"}}
+    for _, want := range []int{http.StatusOK, http.StatusTooManyRequests} {
+        req := httptest.NewRequest(http.MethodPost, "/register", strings.NewReader(form.Encode()))
+        req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+        rr := httptest.NewRecorder()
+
+        handler.SubmitRegister(rr, req)
+
+        assert.Equal(t, want, rr.Code)
+    }
+    mockStore.AssertExpectations(t)
+}
+
+func TestAPILoginRateLimited(t *testing.T) {
+    mockStore := new(MockSQLiteStore)
+    byEmail, _ := newTestLimiter(1, time.Minute)
+    handler := NewHandler(mockStore, nil, WithLoginLimiters(nil, byEmail))
+
+    mockStore.On("GetUserByEmail", "test@example.com").Return(app.User{}, assert.AnError).Once()
+
+    body := `{"email": "test@example.com", "password": "guess"}`
+    for _, want := range []int{http.StatusUnauthorized, http.StatusTooManyRequests} {
+        req := httptest.NewRequest(http.MethodPost, "/api/v1/login", strings.NewReader(body))
+        rr := httptest.NewRecorder()
+
+        handler.APILogin(rr, req)
+
+        assert.Equal(t, want, rr.Code)
+        if want == http.StatusTooManyRequests {
+            assert.Equal(t, "application/json", rr.Header().Get("Content-Type"))
+            assert.Equal(t, "60", rr.Header().Get("Retry-After"))
+        }
+    }
+}
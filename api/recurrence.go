@@ -0,0 +1,156 @@
+package api
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"penumbra/recur"
+)
+
+// RecurrenceForm holds the fields of the repeat editor on the create and task pages, either as the user typed them or as loaded from a task's rule.
+type RecurrenceForm struct {
+    Freq       string // Empty for a one-off task.
+    Interval   string
+    Days       []RecurrenceDay
+    ByMonthDay string
+    Ends       string // "never", "until", or "count".
+    Until      string // In the `2006-01-02` format of a date input.
+    Count      string
+    Summary    string // Describes the stored rule, if there is one.
+}
+
+// RecurrenceDay is one of the weekday checkboxes of the repeat editor.
+type RecurrenceDay struct {
+    Code    string
+    Name    string
+    Checked bool
+}
+
+// Weekdays in the order the repeat editor shows them, starting on Monday like RRULE weeks.
+var editorWeekdays = []time.Weekday{time.Monday, time.Tuesday, time.Wednesday, time.Thursday, time.Friday, time.Saturday, time.Sunday}
+
+func recurrenceDays(checked func(time.Weekday) bool) []RecurrenceDay {
+    days := make([]RecurrenceDay, len(editorWeekdays))
+    for i, day := range editorWeekdays {
+        days[i] = RecurrenceDay{Code: recur.DayCode(day), Name: day.String()[:3], Checked: checked(day)}
+    }
+    return days
+}
+
+// recurrenceFormFor fills the repeat editor from a task's stored rule.
+func recurrenceFormFor(rule string) RecurrenceForm {
+    r, err := recur.Parse(rule)
+    if rule == "" || err != nil {
+        return RecurrenceForm{Interval: "1", Ends: "never", Days: recurrenceDays(func(time.Weekday) bool { return false })}
+    }
+
+    form := RecurrenceForm{
+        Freq:     string(r.Freq),
+        Interval: strconv.Itoa(max(r.Interval, 1)),
+        Ends:     "never",
+        Summary:  r.Describe(),
+        Days: recurrenceDays(func(day time.Weekday) bool {
+            for _, d := range r.ByDay {
+                if d == day {
+                    return true
+                }
+            }
+            return false
+        }),
+    }
+    if r.ByMonthDay > 0 {
+        form.ByMonthDay = strconv.Itoa(r.ByMonthDay)
+    }
+    switch {
+    case !r.Until.IsZero():
+        form.Ends = "until"
+        form.Until = r.Until.Format("2006-01-02")
+    case r.Count > 0:
+        form.Ends = "count"
+        form.Count = strconv.Itoa(r.Count)
+    }
+    return form
+}
+
+// parseRecurrenceForm reads the repeat editor. It returns the rule in RRULE syntax, or "" for a one-off task; the fields as typed, to show again if the form is refused; and a message saying what's wrong, if anything is.
+func parseRecurrenceForm(r *http.Request) (string, RecurrenceForm, string) {
+    form := RecurrenceForm{
+        Freq:       r.FormValue("repeat"),
+        Interval:   strings.TrimSpace(r.FormValue("interval")),
+        ByMonthDay: strings.TrimSpace(r.FormValue("bymonthday")),
+        Ends:       r.FormValue("ends"),
+        Until:      r.FormValue("until"),
+        Count:      strings.TrimSpace(r.FormValue("count")),
+    }
+    byDay := map[string]bool{}
+    for _, code := range r.Form["byday"] {
+        byDay[code] = true
+    }
+    form.Days = recurrenceDays(func(day time.Weekday) bool { return byDay[recur.DayCode(day)] })
+    if form.Ends == "" {
+        form.Ends = "never"
+    }
+
+    if form.Freq == "" {
+        return "", form, ""
+    }
+
+    rule := recur.Rule{Freq: recur.Frequency(form.Freq), Interval: 1}
+    if form.Interval != "" {
+        n, err := strconv.Atoi(form.Interval)
+        if err != nil || n < 1 || n > recur.MaxInterval {
+            return "", form, "Repeat every 1 to 999 periods."
+        }
+        rule.Interval = n
+    }
+
+    switch rule.Freq {
+    case recur.Weekly:
+        for _, day := range editorWeekdays {
+            if byDay[recur.DayCode(day)] {
+                rule.ByDay = append(rule.ByDay, day)
+            }
+        }
+    case recur.Monthly:
+        if form.ByMonthDay != "" {
+            n, err := strconv.Atoi(form.ByMonthDay)
+            if err != nil || n < 1 || n > 31 {
+                return "", form, "Choose a day of the month from 1 to 31."
+            }
+            rule.ByMonthDay = n
+        }
+    }
+
+    switch form.Ends {
+    case "never":
+    case "until":
+        until, err := time.Parse("2006-01-02", form.Until)
+        if err != nil {
+            return "", form, "Choose the date the task stops repeating."
+        }
+        rule.Until = until.Add(24*time.Hour - time.Nanosecond)
+    case "count":
+        n, err := strconv.Atoi(form.Count)
+        if err != nil || n < 1 || n > recur.MaxCount {
+            return "", form, "Repeat 1 to 999 times."
+        }
+        rule.Count = n
+    default:
+        return "", form, "Choose when the task stops repeating."
+    }
+
+    if err := rule.Validate(); err != nil {
+        return "", form, "Choose how often the task repeats."
+    }
+    return rule.String(), form, ""
+}
+
+// normalizeRecurrence rewrites a rule from the API in the canonical form it's stored in. Invalid rules are left alone for validate.Task to report.
+func normalizeRecurrence(rule string) string {
+    if r, err := recur.Parse(rule); err == nil {
+        return r.String()
+    }
+    return strings.TrimSpace(rule)
+}
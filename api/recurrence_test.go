@@ -0,0 +1,120 @@
+package api
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+
+	"penumbra/app"
+)
+
+func recurrenceRequest(form url.Values) *http.Request {
+    req := formRequest("/tasks/create", form)
+    req.ParseForm()
+    return req
+}
+
+func TestParseRecurrenceForm(t *testing.T) {
+    cases := map[string]struct {
+        form url.Values
+        want string
+    }{
+        "one-off":       {url.Values{"repeat": {""}, "interval": {"3"}}, ""},
+        "daily":         {url.Values{"repeat": {"DAILY"}, "interval": {"1"}, "ends": {"never"}}, "FREQ=DAILY"},
+        "weekly":        {url.Values{"repeat": {"WEEKLY"}, "interval": {"2"}, "byday": {"TH", "MO"}, "ends": {"count"}, "count": {"10"}}, "FREQ=WEEKLY;INTERVAL=2;BYDAY=MO,TH;COUNT=10"},
+        "monthly":       {url.Values{"repeat": {"MONTHLY"}, "bymonthday": {"15"}, "byday": {"MO"}, "ends": {"until"}, "until": {"2030-12-31"}}, "FREQ=MONTHLY;BYMONTHDAY=15;UNTIL=20301231T235959Z"},
+        "blank interval": {url.Values{"repeat": {"YEARLY"}, "interval": {""}}, "FREQ=YEARLY"},
+    }
+
+    for name, tc := range cases {
+        t.Run(name, func(t *testing.T) {
+            rule, _, message := parseRecurrenceForm(recurrenceRequest(tc.form))
+            assert.Empty(t, message)
+            assert.Equal(t, tc.want, rule)
+        })
+    }
+}
+
+func TestParseRecurrenceFormInvalid(t *testing.T) {
+    invalid := map[string]url.Values{
+        "frequency":    {"repeat": {"HOURLY"}},
+        "interval":     {"repeat": {"DAILY"}, "interval": {"0"}},
+        "day of month": {"repeat": {"MONTHLY"}, "bymonthday": {"32"}},
+        "until":        {"repeat": {"DAILY"}, "ends": {"until"}, "until": {"soon"}},
+        "count":        {"repeat": {"DAILY"}, "ends": {"count"}, "count": {"1000"}},
+        "ends":         {"repeat": {"DAILY"}, "ends": {"sometime"}},
+    }
+
+    for name, form := range invalid {
+        t.Run(name, func(t *testing.T) {
+            rule, typed, message := parseRecurrenceForm(recurrenceRequest(form))
+            assert.Empty(t, rule)
+            assert.NotEmpty(t, message)
+            assert.Equal(t, form.Get("repeat"), typed.Freq, "the form should be shown again as typed")
+        })
+    }
+}
+
+func TestRecurrenceFormFor(t *testing.T) {
+    form := recurrenceFormFor("FREQ=WEEKLY;INTERVAL=2;BYDAY=MO,TH;COUNT=10")
+    assert.Equal(t, "WEEKLY", form.Freq)
+    assert.Equal(t, "2", form.Interval)
+    assert.Equal(t, "count", form.Ends)
+    assert.Equal(t, "10", form.Count)
+    assert.Equal(t, "Every 2 weeks on Mon, Thu, 10 times left", form.Summary)
+
+    var checked []string
+    for _, day := range form.Days {
+        if day.Checked {
+            checked = append(checked, day.Code)
+        }
+    }
+    assert.Equal(t, []string{"MO", "TH"}, checked)
+
+    none := recurrenceFormFor("")
+    assert.Equal(t, "", none.Freq)
+    assert.Equal(t, "never", none.Ends)
+    assert.Len(t, none.Days, 7)
+}
+
+func TestSubmitCreateTaskWithRecurrence(t *testing.T) {
+    mockStore := new(MockSQLiteStore)
+    handler := &RealHandler{store: mockStore}
+    mockStore.On("GetUserById", 1).Return(app.User{Id: 1}, nil).Once()
+
+    mockStore.On("CreateTask", mock.MatchedBy(func(task app.Task) bool {
+        return task.UserId == 1 && task.Recurrence == "FREQ=WEEKLY;BYDAY=MO"
+    })).Return(nil).Once()
+
+    form := url.Values{"title": {"Bins"}, "due": {"Mon Jan 7 2030"}, "repeat": {"WEEKLY"}, "interval": {"1"}, "byday": {"MO"}, "ends": {"never"}}
+    rr := httptest.NewRecorder()
+
+    handler.SubmitCreateTask(rr, formRequest("/tasks/create", form), 1)
+
+    assert.Equal(t, http.StatusSeeOther, rr.Code)
+    mockStore.AssertExpectations(t)
+}
+
+func TestMarkTaskDoneReportsNextOccurrence(t *testing.T) {
+    mockStore := new(MockSQLiteStore)
+    handler := &RealHandler{store: mockStore}
+
+    id, nextId := uuid.New(), uuid.New()
+    mockStore.On("SetTaskDone", 2, id, true).Return(app.Task{Id: nextId}, nil).Once()
+    mockStore.On("GetTaskById", 2, id).Return(app.Task{Id: id, UserId: 2, Status: "done"}, nil).Once()
+
+    req := httptest.NewRequest(http.MethodPost, "/tasks/done/"+id.String(), strings.NewReader(`{"checked": true}`))
+    rr := httptest.NewRecorder()
+
+    handler.MarkTaskDone(rr, req, 2, id)
+
+    assert.Equal(t, http.StatusOK, rr.Code)
+    assert.JSONEq(t, `{"status": "done", "next": "`+nextId.String()+`"}`, rr.Body.String())
+    mockStore.AssertExpectations(t)
+}
@@ -3,7 +3,6 @@ package api
 import (
 	"crypto/rand"
 	"encoding/base64"
-	"encoding/json"
 	"net/http"
 	"strings"
 )
@@ -31,6 +30,11 @@ func GenerateNonce() string {
 	return base64.StdEncoding.EncodeToString(nonce)
 }
 
+func methodNotAllowedJSON(w http.ResponseWriter, allowed ...string) {
+    w.Header().Set("Allow", strings.Join(allowed, ", "))
+    writeJSONError(w, http.StatusMethodNotAllowed, "method not allowed")
+}
+
 func NewRouter(h Handler) http.Handler {
     mux := http.NewServeMux()
 
@@ -68,7 +72,7 @@ func NewRouter(h Handler) http.Handler {
 
     mux.HandleFunc(("/dashboard"), func(w http.ResponseWriter, r *http.Request) {
         if r.Method == http.MethodGet {
-            h.HandleProtected(w, r, h.HandleDashboard)
+            h.HandleProtectedWithUserId(w, r, h.HandleDashboard)
         } else {
             http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
         }
@@ -84,7 +88,7 @@ func NewRouter(h Handler) http.Handler {
 
     mux.HandleFunc("/tasks/create", func(w http.ResponseWriter, r *http.Request) {
         if r.Method == http.MethodGet {
-            h.HandleProtected(w, r, h.RenderCreateTask)
+            h.HandleProtectedWithUserId(w, r, h.RenderCreateTask)
         } else if r.Method == http.MethodPost {
             h.HandleProtectedWithUserId(w, r, h.SubmitCreateTask)
         } else {
@@ -93,13 +97,21 @@ func NewRouter(h Handler) http.Handler {
     })
 
     mux.HandleFunc("/logout", func(w http.ResponseWriter, r *http.Request) {
-        if r.Method == http.MethodGet {
+        if r.Method == http.MethodPost {
             h.HandleLogout(w, r)
         } else {
             http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
         }
     })
 
+    mux.HandleFunc("/logout/all", func(w http.ResponseWriter, r *http.Request) {
+        if r.Method == http.MethodPost {
+            h.HandleSessionProtected(w, r, h.HandleLogoutEverywhere)
+        } else {
+            http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+        }
+    })
+
     mux.HandleFunc("/tasks", func(w http.ResponseWriter, r *http.Request) {
         if r.Method == http.MethodGet {
             h.HandleProtectedWithUserId(w, r, h.HandleAllTasks)
@@ -108,6 +120,52 @@ func NewRouter(h Handler) http.Handler {
         }
     })
 
+    mux.HandleFunc("/tasks/export.ics", func(w http.ResponseWriter, r *http.Request) {
+        if r.Method == http.MethodGet {
+            h.HandleProtectedWithUserId(w, r, h.ExportCalendar)
+        } else {
+            http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+        }
+    })
+
+    mux.HandleFunc("/tasks/import", func(w http.ResponseWriter, r *http.Request) {
+        switch r.Method {
+        case http.MethodGet:
+            h.HandleProtectedWithUserId(w, r, h.RenderImport)
+        case http.MethodPost:
+            h.HandleProtectedWithUserId(w, r, h.SubmitImport)
+        default:
+            http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+        }
+    })
+
+    mux.HandleFunc("/export", func(w http.ResponseWriter, r *http.Request) {
+        if r.Method == http.MethodGet {
+            h.HandleProtectedWithUserId(w, r, h.ExportBackup)
+        } else {
+            http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+        }
+    })
+
+    mux.HandleFunc("/import", func(w http.ResponseWriter, r *http.Request) {
+        switch r.Method {
+        case http.MethodGet:
+            h.HandleProtectedWithUserId(w, r, h.RenderBackup)
+        case http.MethodPost:
+            h.HandleProtectedWithUserId(w, r, h.SubmitBackup)
+        default:
+            http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+        }
+    })
+
+    mux.HandleFunc("/tasks/search", func(w http.ResponseWriter, r *http.Request) {
+        if r.Method == http.MethodGet {
+            h.HandleProtectedWithUserId(w, r, h.SearchTasks)
+        } else {
+            http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+        }
+    })
+
     mux.HandleFunc("/tasks/", func(w http.ResponseWriter, r *http.Request) {
         id := strings.TrimPrefix(r.URL.Path, "/tasks/")
         if r.Method == http.MethodGet {
@@ -121,19 +179,6 @@ func NewRouter(h Handler) http.Handler {
         id := strings.TrimPrefix(r.URL.Path, "/tasks/done/")
     
         if r.Method == http.MethodPost {
-            var requestBody struct {
-                Checked bool `json:"checked"`
-            }
-    
-            if err := json.NewDecoder(r.Body).Decode(&requestBody); err != nil {
-                http.Error(w, "Invalid request body", http.StatusBadRequest)
-                return
-            }
-
-            if !requestBody.Checked {
-                return
-            }
-    
             h.HandleProtectedWithTaskId(w, r, h.MarkTaskDone, id)
             return
         }
@@ -142,6 +187,42 @@ func NewRouter(h Handler) http.Handler {
     })
     
     
+    mux.HandleFunc("/tasks/checklist/add/", func(w http.ResponseWriter, r *http.Request) {
+        id := strings.TrimPrefix(r.URL.Path, "/tasks/checklist/add/")
+        if r.Method == http.MethodPost {
+            h.HandleProtectedWithTaskId(w, r, h.AddChecklistItem, id)
+        } else {
+            http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+        }
+    })
+
+    mux.HandleFunc("/tasks/checklist/toggle/", func(w http.ResponseWriter, r *http.Request) {
+        id := strings.TrimPrefix(r.URL.Path, "/tasks/checklist/toggle/")
+        if r.Method == http.MethodPost {
+            h.HandleProtectedWithTaskId(w, r, h.ToggleChecklistItem, id)
+        } else {
+            http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+        }
+    })
+
+    mux.HandleFunc("/tasks/checklist/move/", func(w http.ResponseWriter, r *http.Request) {
+        id := strings.TrimPrefix(r.URL.Path, "/tasks/checklist/move/")
+        if r.Method == http.MethodPost {
+            h.HandleProtectedWithTaskId(w, r, h.MoveChecklistItem, id)
+        } else {
+            http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+        }
+    })
+
+    mux.HandleFunc("/tasks/checklist/delete/", func(w http.ResponseWriter, r *http.Request) {
+        id := strings.TrimPrefix(r.URL.Path, "/tasks/checklist/delete/")
+        if r.Method == http.MethodPost {
+            h.HandleProtectedWithTaskId(w, r, h.DeleteChecklistItem, id)
+        } else {
+            http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+        }
+    })
+
     mux.HandleFunc("/tasks/update/", func(w http.ResponseWriter, r *http.Request) {
         id := strings.TrimPrefix(r.URL.Path, "/tasks/update/")
         if r.Method == http.MethodPost {
@@ -160,5 +241,284 @@ func NewRouter(h Handler) http.Handler {
         }
     })
 
-    return withCSP(mux)
+    mux.HandleFunc("/settings/tokens", func(w http.ResponseWriter, r *http.Request) {
+        switch r.Method {
+        case http.MethodGet:
+            h.HandleSessionProtected(w, r, h.RenderAPITokens)
+        case http.MethodPost:
+            h.HandleSessionProtected(w, r, h.SubmitCreateAPIToken)
+        default:
+            http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+        }
+    })
+
+    mux.HandleFunc("/settings/tokens/revoke", func(w http.ResponseWriter, r *http.Request) {
+        if r.Method == http.MethodPost {
+            h.HandleSessionProtected(w, r, h.RevokeAPIToken)
+        } else {
+            http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+        }
+    })
+
+    mux.HandleFunc("/settings/feed", func(w http.ResponseWriter, r *http.Request) {
+        switch r.Method {
+        case http.MethodGet:
+            h.HandleSessionProtected(w, r, h.RenderCalendarFeed)
+        case http.MethodPost:
+            h.HandleSessionProtected(w, r, h.SubmitCreateCalendarFeed)
+        default:
+            http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+        }
+    })
+
+    mux.HandleFunc("/settings/feed/revoke", func(w http.ResponseWriter, r *http.Request) {
+        if r.Method == http.MethodPost {
+            h.HandleSessionProtected(w, r, h.RevokeCalendarFeed)
+        } else {
+            http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+        }
+    })
+
+    mux.HandleFunc("/feeds/", func(w http.ResponseWriter, r *http.Request) {
+        token, ok := strings.CutSuffix(strings.TrimPrefix(r.URL.Path, "/feeds/"), ".ics")
+        if !ok || token == "" || strings.Contains(token, "/") {
+            http.NotFound(w, r)
+            return
+        }
+
+        if r.Method == http.MethodGet || r.Method == http.MethodHead {
+            h.ServeCalendarFeed(w, r, token)
+        } else {
+            http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+        }
+    })
+
+    mux.HandleFunc("/caldav/", func(w http.ResponseWriter, r *http.Request) {
+        h.HandleCalDAVProtected(w, r, h.ServeCalDAV)
+    })
+
+    // Clients that are only given the server's address look for the CalDAV server here, per RFC 6764.
+    mux.HandleFunc("/.well-known/caldav", func(w http.ResponseWriter, r *http.Request) {
+        http.Redirect(w, r, "/caldav/", http.StatusMovedPermanently)
+    })
+
+    mux.HandleFunc("/projects", func(w http.ResponseWriter, r *http.Request) {
+        switch r.Method {
+        case http.MethodGet:
+            h.HandleProtectedWithUserId(w, r, h.RenderProjects)
+        case http.MethodPost:
+            h.HandleProtectedWithUserId(w, r, h.SubmitCreateProject)
+        default:
+            http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+        }
+    })
+
+    mux.HandleFunc("/projects/", func(w http.ResponseWriter, r *http.Request) {
+        id := strings.TrimPrefix(r.URL.Path, "/projects/")
+        if r.Method == http.MethodGet {
+            h.HandleProtectedWithUserId(w, r, func(w http.ResponseWriter, r *http.Request, userId int) {
+                h.GetProject(w, r, userId, id)
+            })
+        } else {
+            http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+        }
+    })
+
+    mux.HandleFunc("/projects/rename/", func(w http.ResponseWriter, r *http.Request) {
+        id := strings.TrimPrefix(r.URL.Path, "/projects/rename/")
+        if r.Method == http.MethodPost {
+            h.HandleProtectedWithUserId(w, r, func(w http.ResponseWriter, r *http.Request, userId int) {
+                h.RenameProject(w, r, userId, id)
+            })
+        } else {
+            http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+        }
+    })
+
+    mux.HandleFunc("/projects/delete/", func(w http.ResponseWriter, r *http.Request) {
+        id := strings.TrimPrefix(r.URL.Path, "/projects/delete/")
+        if r.Method == http.MethodPost {
+            h.HandleProtectedWithUserId(w, r, func(w http.ResponseWriter, r *http.Request, userId int) {
+                h.DeleteProject(w, r, userId, id)
+            })
+        } else {
+            http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+        }
+    })
+
+    mux.HandleFunc("/tasks/move/", func(w http.ResponseWriter, r *http.Request) {
+        id := strings.TrimPrefix(r.URL.Path, "/tasks/move/")
+        if r.Method == http.MethodPost {
+            h.HandleProtectedWithTaskId(w, r, h.MoveTask, id)
+        } else {
+            http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+        }
+    })
+
+    mux.HandleFunc("/settings/timezone", func(w http.ResponseWriter, r *http.Request) {
+        switch r.Method {
+        case http.MethodGet:
+            h.HandleSessionProtected(w, r, h.RenderTimeZone)
+        case http.MethodPost:
+            h.HandleSessionProtected(w, r, h.SubmitTimeZone)
+        default:
+            http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+        }
+    })
+
+    mux.HandleFunc("/settings/tags", func(w http.ResponseWriter, r *http.Request) {
+        switch r.Method {
+        case http.MethodGet:
+            h.HandleSessionProtected(w, r, h.RenderTags)
+        case http.MethodPost:
+            h.HandleSessionProtected(w, r, h.SubmitCreateTag)
+        default:
+            http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+        }
+    })
+
+    mux.HandleFunc("/settings/tags/delete", func(w http.ResponseWriter, r *http.Request) {
+        if r.Method == http.MethodPost {
+            h.HandleSessionProtected(w, r, h.DeleteTag)
+        } else {
+            http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+        }
+    })
+
+    mux.HandleFunc("/login/2fa", func(w http.ResponseWriter, r *http.Request) {
+        switch r.Method {
+        case http.MethodGet:
+            h.RenderLoginTwoFactor(w, r)
+        case http.MethodPost:
+            h.SubmitLoginTwoFactor(w, r)
+        default:
+            http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+        }
+    })
+
+    mux.HandleFunc("/password/forgot", func(w http.ResponseWriter, r *http.Request) {
+        switch r.Method {
+        case http.MethodGet:
+            h.RenderForgotPassword(w, r)
+        case http.MethodPost:
+            h.SubmitForgotPassword(w, r)
+        default:
+            http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+        }
+    })
+
+    mux.HandleFunc("/password/reset/", func(w http.ResponseWriter, r *http.Request) {
+        token := strings.TrimPrefix(r.URL.Path, "/password/reset/")
+        if token == "" || strings.Contains(token, "/") {
+            http.NotFound(w, r)
+            return
+        }
+
+        switch r.Method {
+        case http.MethodGet:
+            h.RenderResetPassword(w, r, token)
+        case http.MethodPost:
+            h.SubmitResetPassword(w, r, token)
+        default:
+            http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+        }
+    })
+
+    mux.HandleFunc("/verify", func(w http.ResponseWriter, r *http.Request) {
+        if r.Method == http.MethodGet {
+            h.HandleVerifyEmail(w, r)
+        } else {
+            http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+        }
+    })
+
+    mux.HandleFunc("/verify/resend", func(w http.ResponseWriter, r *http.Request) {
+        if r.Method == http.MethodPost {
+            h.SubmitResendVerification(w, r)
+        } else {
+            http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+        }
+    })
+
+    mux.HandleFunc("/settings/2fa", func(w http.ResponseWriter, r *http.Request) {
+        if r.Method == http.MethodGet {
+            h.HandleSessionProtected(w, r, h.RenderTwoFactor)
+        } else {
+            http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+        }
+    })
+
+    mux.HandleFunc("/settings/2fa/setup", func(w http.ResponseWriter, r *http.Request) {
+        if r.Method == http.MethodPost {
+            h.HandleSessionProtected(w, r, h.SubmitTwoFactorSetup)
+        } else {
+            http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+        }
+    })
+
+    mux.HandleFunc("/settings/2fa/enable", func(w http.ResponseWriter, r *http.Request) {
+        if r.Method == http.MethodPost {
+            h.HandleSessionProtected(w, r, h.SubmitTwoFactorEnable)
+        } else {
+            http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+        }
+    })
+
+    mux.HandleFunc("/settings/2fa/disable", func(w http.ResponseWriter, r *http.Request) {
+        if r.Method == http.MethodPost {
+            h.HandleSessionProtected(w, r, h.SubmitTwoFactorDisable)
+        } else {
+            http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+        }
+    })
+
+    mux.HandleFunc("/api/v1/", func(w http.ResponseWriter, r *http.Request) {
+        writeJSONError(w, http.StatusNotFound, "not found")
+    })
+
+    mux.HandleFunc("/api/v1/login", func(w http.ResponseWriter, r *http.Request) {
+        if r.Method == http.MethodPost {
+            h.APILogin(w, r)
+        } else {
+            methodNotAllowedJSON(w, http.MethodPost)
+        }
+    })
+
+    mux.HandleFunc("/api/v1/tasks", func(w http.ResponseWriter, r *http.Request) {
+        switch r.Method {
+        case http.MethodGet:
+            h.HandleAPIProtected(w, r, h.APIListTasks)
+        case http.MethodPost:
+            h.HandleAPIProtected(w, r, h.APICreateTask)
+        default:
+            methodNotAllowedJSON(w, http.MethodGet, http.MethodPost)
+        }
+    })
+
+    mux.HandleFunc("/api/v1/tasks/", func(w http.ResponseWriter, r *http.Request) {
+        rest := strings.TrimPrefix(r.URL.Path, "/api/v1/tasks/")
+
+        if id, found := strings.CutSuffix(rest, "/done"); found {
+            switch r.Method {
+            case http.MethodPost, http.MethodDelete:
+                h.HandleAPIProtectedWithTaskId(w, r, h.APISetTaskDone, id)
+            default:
+                methodNotAllowedJSON(w, http.MethodPost, http.MethodDelete)
+            }
+            return
+        }
+
+        switch r.Method {
+        case http.MethodGet:
+            h.HandleAPIProtectedWithTaskId(w, r, h.APIGetTask, rest)
+        case http.MethodPatch:
+            h.HandleAPIProtectedWithTaskId(w, r, h.APIPatchTask, rest)
+        case http.MethodDelete:
+            h.HandleAPIProtectedWithTaskId(w, r, h.APIDeleteTask, rest)
+        default:
+            methodNotAllowedJSON(w, http.MethodGet, http.MethodPatch, http.MethodDelete)
+        }
+    })
+
+    return withCSP(withCSRF(mux))
 }
@@ -5,6 +5,7 @@ import (
 	"encoding/json"
 	"net/http"
 	"net/http/httptest"
+	"strings"
 	"testing"
 
 	"github.com/google/uuid"
@@ -43,9 +44,9 @@ func (m *MockHandler) HandleProtected(w http.ResponseWriter, r *http.Request, ha
 	handlerFunc(w, r)
 }
 
-func (m *MockHandler) HandleProtectedWithTaskId(w http.ResponseWriter, r *http.Request, handlerFunc func(http.ResponseWriter, *http.Request, uuid.UUID), id string) {
+func (m *MockHandler) HandleProtectedWithTaskId(w http.ResponseWriter, r *http.Request, handlerFunc func(http.ResponseWriter, *http.Request, int, uuid.UUID), id string) {
 	m.Called(w, r, handlerFunc, id)
-	handlerFunc(w, r, uuid.MustParse(id))
+	handlerFunc(w, r, 1, uuid.MustParse(id))
 }
 
 func (m *MockHandler) HandleProtectedWithUserId(w http.ResponseWriter, r *http.Request, handlerFunc func(http.ResponseWriter, *http.Request, int)) {
@@ -53,16 +54,21 @@ func (m *MockHandler) HandleProtectedWithUserId(w http.ResponseWriter, r *http.R
 	handlerFunc(w, r, 1)
 }
 
-func (m *MockHandler) HandleDashboard(w http.ResponseWriter, r *http.Request) {
-	m.Called(w, r)
+func (m *MockHandler) HandleSessionProtected(w http.ResponseWriter, r *http.Request, handlerFunc func(http.ResponseWriter, *http.Request, int)) {
+	m.Called(w, r, handlerFunc)
+	handlerFunc(w, r, 1)
+}
+
+func (m *MockHandler) HandleDashboard(w http.ResponseWriter, r *http.Request, userId int) {
+	m.Called(w, r, userId)
 }
 
 func (m *MockHandler) HandleAbout(w http.ResponseWriter, r *http.Request) {
 	m.Called(w, r)
 }
 
-func (m *MockHandler) RenderCreateTask(w http.ResponseWriter, r *http.Request) {
-	m.Called(w, r)
+func (m *MockHandler) RenderCreateTask(w http.ResponseWriter, r *http.Request, userId int) {
+	m.Called(w, r, userId)
 }
 
 func (m *MockHandler) SubmitCreateTask(w http.ResponseWriter, r *http.Request, userId int) {
@@ -73,24 +79,239 @@ func (m *MockHandler) HandleLogout(w http.ResponseWriter, r *http.Request) {
 	m.Called(w, r)
 }
 
+func (m *MockHandler) HandleLogoutEverywhere(w http.ResponseWriter, r *http.Request, userId int) {
+	m.Called(w, r, userId)
+}
+
 func (m *MockHandler) HandleAllTasks(w http.ResponseWriter, r *http.Request, userId int) {
 	m.Called(w, r, userId)
 }
 
-func (m *MockHandler) GetTask(w http.ResponseWriter, r *http.Request, id uuid.UUID) {
-	m.Called(w, r, id)
+func (m *MockHandler) GetTask(w http.ResponseWriter, r *http.Request, userId int, id uuid.UUID) {
+	m.Called(w, r, userId, id)
+}
+
+func (m *MockHandler) MarkTaskDone(w http.ResponseWriter, r *http.Request, userId int, id uuid.UUID) {
+	m.Called(w, r, userId, id)
+}
+
+func (m *MockHandler) AddChecklistItem(w http.ResponseWriter, r *http.Request, userId int, id uuid.UUID) {
+	m.Called(w, r, userId, id)
+}
+
+func (m *MockHandler) ToggleChecklistItem(w http.ResponseWriter, r *http.Request, userId int, id uuid.UUID) {
+	m.Called(w, r, userId, id)
+}
+
+func (m *MockHandler) MoveChecklistItem(w http.ResponseWriter, r *http.Request, userId int, id uuid.UUID) {
+	m.Called(w, r, userId, id)
+}
+
+func (m *MockHandler) DeleteChecklistItem(w http.ResponseWriter, r *http.Request, userId int, id uuid.UUID) {
+	m.Called(w, r, userId, id)
+}
+
+func (m *MockHandler) UpdateTask(w http.ResponseWriter, r *http.Request, userId int, id uuid.UUID) {
+	m.Called(w, r, userId, id)
+}
+
+func (m *MockHandler) DeleteTask(w http.ResponseWriter, r *http.Request, userId int, id uuid.UUID) {
+	m.Called(w, r, userId, id)
+}
+
+func (m *MockHandler) RenderAPITokens(w http.ResponseWriter, r *http.Request, userId int) {
+	m.Called(w, r, userId)
+}
+
+func (m *MockHandler) SubmitCreateAPIToken(w http.ResponseWriter, r *http.Request, userId int) {
+	m.Called(w, r, userId)
+}
+
+func (m *MockHandler) RevokeAPIToken(w http.ResponseWriter, r *http.Request, userId int) {
+	m.Called(w, r, userId)
+}
+
+func (m *MockHandler) RenderCalendarFeed(w http.ResponseWriter, r *http.Request, userId int) {
+	m.Called(w, r, userId)
+}
+
+func (m *MockHandler) SubmitCreateCalendarFeed(w http.ResponseWriter, r *http.Request, userId int) {
+	m.Called(w, r, userId)
+}
+
+func (m *MockHandler) RevokeCalendarFeed(w http.ResponseWriter, r *http.Request, userId int) {
+	m.Called(w, r, userId)
+}
+
+func (m *MockHandler) ServeCalendarFeed(w http.ResponseWriter, r *http.Request, token string) {
+	m.Called(w, r, token)
+}
+
+func (m *MockHandler) HandleCalDAVProtected(w http.ResponseWriter, r *http.Request, handlerFunc func(http.ResponseWriter, *http.Request, int)) {
+	m.Called(w, r, handlerFunc)
+	handlerFunc(w, r, 1)
+}
+
+func (m *MockHandler) ServeCalDAV(w http.ResponseWriter, r *http.Request, userId int) {
+	m.Called(w, r, userId)
+}
+
+func (m *MockHandler) RenderTags(w http.ResponseWriter, r *http.Request, userId int) {
+	m.Called(w, r, userId)
+}
+
+func (m *MockHandler) SubmitCreateTag(w http.ResponseWriter, r *http.Request, userId int) {
+	m.Called(w, r, userId)
+}
+
+func (m *MockHandler) DeleteTag(w http.ResponseWriter, r *http.Request, userId int) {
+	m.Called(w, r, userId)
+}
+
+func (m *MockHandler) RenderProjects(w http.ResponseWriter, r *http.Request, userId int) {
+	m.Called(w, r, userId)
+}
+
+func (m *MockHandler) SubmitCreateProject(w http.ResponseWriter, r *http.Request, userId int) {
+	m.Called(w, r, userId)
+}
+
+func (m *MockHandler) GetProject(w http.ResponseWriter, r *http.Request, userId int, id string) {
+	m.Called(w, r, userId, id)
+}
+
+func (m *MockHandler) RenameProject(w http.ResponseWriter, r *http.Request, userId int, id string) {
+	m.Called(w, r, userId, id)
+}
+
+func (m *MockHandler) DeleteProject(w http.ResponseWriter, r *http.Request, userId int, id string) {
+	m.Called(w, r, userId, id)
+}
+
+func (m *MockHandler) MoveTask(w http.ResponseWriter, r *http.Request, userId int, id uuid.UUID) {
+	m.Called(w, r, userId, id)
+}
+
+func (m *MockHandler) ExportCalendar(w http.ResponseWriter, r *http.Request, userId int) {
+	m.Called(w, r, userId)
+}
+
+func (m *MockHandler) RenderImport(w http.ResponseWriter, r *http.Request, userId int) {
+	m.Called(w, r, userId)
+}
+
+func (m *MockHandler) SubmitImport(w http.ResponseWriter, r *http.Request, userId int) {
+	m.Called(w, r, userId)
+}
+
+func (m *MockHandler) ExportBackup(w http.ResponseWriter, r *http.Request, userId int) {
+	m.Called(w, r, userId)
+}
+
+func (m *MockHandler) RenderBackup(w http.ResponseWriter, r *http.Request, userId int) {
+	m.Called(w, r, userId)
+}
+
+func (m *MockHandler) SubmitBackup(w http.ResponseWriter, r *http.Request, userId int) {
+	m.Called(w, r, userId)
+}
+
+func (m *MockHandler) SearchTasks(w http.ResponseWriter, r *http.Request, userId int) {
+	m.Called(w, r, userId)
+}
+
+func (m *MockHandler) RenderTimeZone(w http.ResponseWriter, r *http.Request, userId int) {
+	m.Called(w, r, userId)
+}
+
+func (m *MockHandler) SubmitTimeZone(w http.ResponseWriter, r *http.Request, userId int) {
+	m.Called(w, r, userId)
+}
+
+func (m *MockHandler) RenderLoginTwoFactor(w http.ResponseWriter, r *http.Request) {
+	m.Called(w, r)
+}
+
+func (m *MockHandler) SubmitLoginTwoFactor(w http.ResponseWriter, r *http.Request) {
+	m.Called(w, r)
+}
+
+func (m *MockHandler) RenderTwoFactor(w http.ResponseWriter, r *http.Request, userId int) {
+	m.Called(w, r, userId)
+}
+
+func (m *MockHandler) SubmitTwoFactorSetup(w http.ResponseWriter, r *http.Request, userId int) {
+	m.Called(w, r, userId)
+}
+
+func (m *MockHandler) SubmitTwoFactorEnable(w http.ResponseWriter, r *http.Request, userId int) {
+	m.Called(w, r, userId)
+}
+
+func (m *MockHandler) SubmitTwoFactorDisable(w http.ResponseWriter, r *http.Request, userId int) {
+	m.Called(w, r, userId)
+}
+
+func (m *MockHandler) RenderForgotPassword(w http.ResponseWriter, r *http.Request) {
+	m.Called(w, r)
+}
+
+func (m *MockHandler) SubmitForgotPassword(w http.ResponseWriter, r *http.Request) {
+	m.Called(w, r)
+}
+
+func (m *MockHandler) RenderResetPassword(w http.ResponseWriter, r *http.Request, token string) {
+	m.Called(w, r, token)
+}
+
+func (m *MockHandler) SubmitResetPassword(w http.ResponseWriter, r *http.Request, token string) {
+	m.Called(w, r, token)
+}
+
+func (m *MockHandler) HandleVerifyEmail(w http.ResponseWriter, r *http.Request) {
+	m.Called(w, r)
+}
+
+func (m *MockHandler) SubmitResendVerification(w http.ResponseWriter, r *http.Request) {
+	m.Called(w, r)
+}
+
+func (m *MockHandler) APILogin(w http.ResponseWriter, r *http.Request) {
+	m.Called(w, r)
+}
+
+func (m *MockHandler) APIListTasks(w http.ResponseWriter, r *http.Request, userId int) {
+	m.Called(w, r, userId)
+}
+
+func (m *MockHandler) APICreateTask(w http.ResponseWriter, r *http.Request, userId int) {
+	m.Called(w, r, userId)
+}
+
+func (m *MockHandler) APIGetTask(w http.ResponseWriter, r *http.Request, userId int, id uuid.UUID) {
+	m.Called(w, r, userId, id)
+}
+
+func (m *MockHandler) APIPatchTask(w http.ResponseWriter, r *http.Request, userId int, id uuid.UUID) {
+	m.Called(w, r, userId, id)
+}
+
+func (m *MockHandler) APIDeleteTask(w http.ResponseWriter, r *http.Request, userId int, id uuid.UUID) {
+	m.Called(w, r, userId, id)
 }
 
-func (m *MockHandler) MarkTaskDone(w http.ResponseWriter, r *http.Request, id uuid.UUID) {
-	m.Called(w, r, id)
+func (m *MockHandler) APISetTaskDone(w http.ResponseWriter, r *http.Request, userId int, id uuid.UUID) {
+	m.Called(w, r, userId, id)
 }
 
-func (m *MockHandler) UpdateTask(w http.ResponseWriter, r *http.Request, id uuid.UUID) {
-	m.Called(w, r, id)
+func (m *MockHandler) HandleAPIProtected(w http.ResponseWriter, r *http.Request, handlerFunc func(http.ResponseWriter, *http.Request, int)) {
+	m.Called(w, r, handlerFunc)
+	handlerFunc(w, r, 1)
 }
 
-func (m *MockHandler) DeleteTask(w http.ResponseWriter, r *http.Request, id uuid.UUID) {
-	m.Called(w, r, id)
+func (m *MockHandler) HandleAPIProtectedWithTaskId(w http.ResponseWriter, r *http.Request, handlerFunc func(http.ResponseWriter, *http.Request, int, uuid.UUID), id string) {
+	m.Called(w, r, handlerFunc, id)
+	handlerFunc(w, r, 1, uuid.MustParse(id))
 }
 
 func TestDashboardRoute(t *testing.T) {
@@ -101,13 +322,13 @@ func TestDashboardRoute(t *testing.T) {
 		fn := args.Get(2).(func(http.ResponseWriter, *http.Request))
 		fn(args.Get(0).(http.ResponseWriter), args.Get(1).(*http.Request))
 	})
-	mockHandler.On("HandleDashboard", mock.Anything, mock.Anything).Maybe()
+	mockHandler.On("HandleDashboard", mock.Anything, mock.Anything, 1).Maybe()
 
 	mockHandler.On("HandleProtectedWithTaskId", mock.Anything, mock.Anything, mock.Anything, mock.Anything).Maybe().
 	Run(func(args mock.Arguments) {
-		fn := args.Get(2).(func(http.ResponseWriter, *http.Request, uuid.UUID))
+		fn := args.Get(2).(func(http.ResponseWriter, *http.Request, int, uuid.UUID))
 		id := uuid.MustParse(args.Get(3).(string))
-		fn(args.Get(0).(http.ResponseWriter), args.Get(1).(*http.Request), id)
+		fn(args.Get(0).(http.ResponseWriter), args.Get(1).(*http.Request), 1, id)
 	})
 
 	mockHandler.On("HandleProtectedWithUserId", mock.Anything, mock.Anything, mock.Anything).Maybe().
@@ -177,50 +398,857 @@ func TestNewRouter_Routes(t *testing.T) {
 					"HandleProtectedWithTaskId",
 					mock.Anything,
 					mock.Anything,
-					mock.AnythingOfType("func(http.ResponseWriter, *http.Request, uuid.UUID)"),
+					mock.AnythingOfType("func(http.ResponseWriter, *http.Request, int, uuid.UUID)"),
 					id,
 				).Once()
 			
-				mockHandler.On("MarkTaskDone", mock.Anything, mock.Anything, uuid.MustParse(id)).Once()
+				mockHandler.On("MarkTaskDone", mock.Anything, mock.Anything, 1, uuid.MustParse(id)).Once()
 			},
 			expectCode: http.StatusOK,
 		},			
 		{
-			name:   "Logout GET",
-			method: http.MethodGet,
+			name:   "Checklist Add POST",
+			method: http.MethodPost,
+			url:    "/tasks/checklist/add/123e4567-e89b-12d3-a456-426614174000",
+			expectFunc: func() {
+				id := "123e4567-e89b-12d3-a456-426614174000"
+				mockHandler.On(
+					"HandleProtectedWithTaskId",
+					mock.Anything,
+					mock.Anything,
+					mock.AnythingOfType("func(http.ResponseWriter, *http.Request, int, uuid.UUID)"),
+					id,
+				).Once()
+				mockHandler.On("AddChecklistItem", mock.Anything, mock.Anything, 1, uuid.MustParse(id)).Once()
+			},
+			expectCode: http.StatusOK,
+		},
+		{
+			name:       "Checklist Add GET",
+			method:     http.MethodGet,
+			url:        "/tasks/checklist/add/123e4567-e89b-12d3-a456-426614174000",
+			expectFunc: func() {},
+			expectCode: http.StatusMethodNotAllowed,
+		},
+		{
+			name:   "Checklist Toggle POST",
+			method: http.MethodPost,
+			url:    "/tasks/checklist/toggle/123e4567-e89b-12d3-a456-426614174000",
+			expectFunc: func() {
+				id := "123e4567-e89b-12d3-a456-426614174000"
+				mockHandler.On(
+					"HandleProtectedWithTaskId",
+					mock.Anything,
+					mock.Anything,
+					mock.AnythingOfType("func(http.ResponseWriter, *http.Request, int, uuid.UUID)"),
+					id,
+				).Once()
+				mockHandler.On("ToggleChecklistItem", mock.Anything, mock.Anything, 1, uuid.MustParse(id)).Once()
+			},
+			expectCode: http.StatusOK,
+		},
+		{
+			name:       "Checklist Toggle GET",
+			method:     http.MethodGet,
+			url:        "/tasks/checklist/toggle/123e4567-e89b-12d3-a456-426614174000",
+			expectFunc: func() {},
+			expectCode: http.StatusMethodNotAllowed,
+		},
+		{
+			name:   "Checklist Move POST",
+			method: http.MethodPost,
+			url:    "/tasks/checklist/move/123e4567-e89b-12d3-a456-426614174000",
+			expectFunc: func() {
+				id := "123e4567-e89b-12d3-a456-426614174000"
+				mockHandler.On(
+					"HandleProtectedWithTaskId",
+					mock.Anything,
+					mock.Anything,
+					mock.AnythingOfType("func(http.ResponseWriter, *http.Request, int, uuid.UUID)"),
+					id,
+				).Once()
+				mockHandler.On("MoveChecklistItem", mock.Anything, mock.Anything, 1, uuid.MustParse(id)).Once()
+			},
+			expectCode: http.StatusOK,
+		},
+		{
+			name:       "Checklist Move GET",
+			method:     http.MethodGet,
+			url:        "/tasks/checklist/move/123e4567-e89b-12d3-a456-426614174000",
+			expectFunc: func() {},
+			expectCode: http.StatusMethodNotAllowed,
+		},
+		{
+			name:   "Checklist Delete POST",
+			method: http.MethodPost,
+			url:    "/tasks/checklist/delete/123e4567-e89b-12d3-a456-426614174000",
+			expectFunc: func() {
+				id := "123e4567-e89b-12d3-a456-426614174000"
+				mockHandler.On(
+					"HandleProtectedWithTaskId",
+					mock.Anything,
+					mock.Anything,
+					mock.AnythingOfType("func(http.ResponseWriter, *http.Request, int, uuid.UUID)"),
+					id,
+				).Once()
+				mockHandler.On("DeleteChecklistItem", mock.Anything, mock.Anything, 1, uuid.MustParse(id)).Once()
+			},
+			expectCode: http.StatusOK,
+		},
+		{
+			name:       "Checklist Delete GET",
+			method:     http.MethodGet,
+			url:        "/tasks/checklist/delete/123e4567-e89b-12d3-a456-426614174000",
+			expectFunc: func() {},
+			expectCode: http.StatusMethodNotAllowed,
+		},
+		{
+			name:   "Logout POST",
+			method: http.MethodPost,
 			url:    "/logout",
 			expectFunc: func() {
 				mockHandler.On("HandleLogout", mock.Anything, mock.Anything).Once()
 			},
 			expectCode: http.StatusOK,
 		},
-		
-	}
-
-	for _, tc := range cases {
-		t.Run(tc.name, func(t *testing.T) {
-			rec := httptest.NewRecorder()
-			var req *http.Request
-			if tc.body != nil {
-				req = httptest.NewRequest(tc.method, tc.url, bytes.NewReader(tc.body))
-			} else {
-				req = httptest.NewRequest(tc.method, tc.url, nil)
-			}
-
-			tc.expectFunc()
-
-			router.ServeHTTP(rec, req)
-
-			assert.Equal(t, tc.expectCode, rec.Code)
-			mockHandler.AssertExpectations(t)
-		})
-	}
-}
-
-func mustJSON(v any) []byte {
-	b, err := json.Marshal(v)
-	if err != nil {
-		panic(err)
-	}
-	return b
+		{
+			name:       "Logout GET",
+			method:     http.MethodGet,
+			url:        "/logout",
+			expectFunc: func() {},
+			expectCode: http.StatusMethodNotAllowed,
+		},
+		{
+			name:   "API Tokens GET",
+			method: http.MethodGet,
+			url:    "/settings/tokens",
+			expectFunc: func() {
+				mockHandler.On("HandleSessionProtected", mock.Anything, mock.Anything, mock.Anything).Once()
+				mockHandler.On("RenderAPITokens", mock.Anything, mock.Anything, 1).Once()
+			},
+			expectCode: http.StatusOK,
+		},
+		{
+			name:   "API Tokens POST",
+			method: http.MethodPost,
+			url:    "/settings/tokens",
+			expectFunc: func() {
+				mockHandler.On("HandleSessionProtected", mock.Anything, mock.Anything, mock.Anything).Once()
+				mockHandler.On("SubmitCreateAPIToken", mock.Anything, mock.Anything, 1).Once()
+			},
+			expectCode: http.StatusOK,
+		},
+		{
+			name:   "API Token Revoke POST",
+			method: http.MethodPost,
+			url:    "/settings/tokens/revoke",
+			expectFunc: func() {
+				mockHandler.On("HandleSessionProtected", mock.Anything, mock.Anything, mock.Anything).Once()
+				mockHandler.On("RevokeAPIToken", mock.Anything, mock.Anything, 1).Once()
+			},
+			expectCode: http.StatusOK,
+		},
+		{
+			name:   "Calendar Feed GET",
+			method: http.MethodGet,
+			url:    "/settings/feed",
+			expectFunc: func() {
+				mockHandler.On("HandleSessionProtected", mock.Anything, mock.Anything, mock.Anything).Once()
+				mockHandler.On("RenderCalendarFeed", mock.Anything, mock.Anything, 1).Once()
+			},
+			expectCode: http.StatusOK,
+		},
+		{
+			name:   "Calendar Feed POST",
+			method: http.MethodPost,
+			url:    "/settings/feed",
+			expectFunc: func() {
+				mockHandler.On("HandleSessionProtected", mock.Anything, mock.Anything, mock.Anything).Once()
+				mockHandler.On("SubmitCreateCalendarFeed", mock.Anything, mock.Anything, 1).Once()
+			},
+			expectCode: http.StatusOK,
+		},
+		{
+			name:   "Calendar Feed Revoke POST",
+			method: http.MethodPost,
+			url:    "/settings/feed/revoke",
+			expectFunc: func() {
+				mockHandler.On("HandleSessionProtected", mock.Anything, mock.Anything, mock.Anything).Once()
+				mockHandler.On("RevokeCalendarFeed", mock.Anything, mock.Anything, 1).Once()
+			},
+			expectCode: http.StatusOK,
+		},
+		{
+			name:   "Feed GET",
+			method: http.MethodGet,
+			url:    "/feeds/feed-token.ics",
+			expectFunc: func() {
+				mockHandler.On("ServeCalendarFeed", mock.Anything, mock.Anything, "feed-token").Once()
+			},
+			expectCode: http.StatusOK,
+		},
+		{
+			name:   "Feed HEAD",
+			method: http.MethodHead,
+			url:    "/feeds/feed-token.ics",
+			expectFunc: func() {
+				mockHandler.On("ServeCalendarFeed", mock.Anything, mock.Anything, "feed-token").Once()
+			},
+			expectCode: http.StatusOK,
+		},
+		{
+			name:       "Feed Without Extension",
+			method:     http.MethodGet,
+			url:        "/feeds/feed-token",
+			expectFunc: func() {},
+			expectCode: http.StatusNotFound,
+		},
+		{
+			name:   "CalDAV PROPFIND",
+			method: "PROPFIND",
+			url:    "/caldav/calendars/tasks/",
+			expectFunc: func() {
+				mockHandler.On("HandleCalDAVProtected", mock.Anything, mock.Anything, mock.Anything).Once()
+				mockHandler.On("ServeCalDAV", mock.Anything, mock.Anything, 1).Once()
+			},
+			expectCode: http.StatusOK,
+		},
+		{
+			name:   "CalDAV PUT",
+			method: http.MethodPut,
+			url:    "/caldav/calendars/tasks/rent.ics",
+			expectFunc: func() {
+				mockHandler.On("HandleCalDAVProtected", mock.Anything, mock.Anything, mock.Anything).Once()
+				mockHandler.On("ServeCalDAV", mock.Anything, mock.Anything, 1).Once()
+			},
+			expectCode: http.StatusOK,
+		},
+		{
+			name:       "CalDAV Well-Known",
+			method:     http.MethodGet,
+			url:        "/.well-known/caldav",
+			expectFunc: func() {},
+			expectCode: http.StatusMovedPermanently,
+		},
+		{
+			name:   "Create Task GET",
+			method: http.MethodGet,
+			url:    "/tasks/create",
+			expectFunc: func() {
+				mockHandler.On("HandleProtectedWithUserId", mock.Anything, mock.Anything, mock.Anything).Once()
+				mockHandler.On("RenderCreateTask", mock.Anything, mock.Anything, 1).Once()
+			},
+			expectCode: http.StatusOK,
+		},
+		{
+			name:   "Tags GET",
+			method: http.MethodGet,
+			url:    "/settings/tags",
+			expectFunc: func() {
+				mockHandler.On("HandleSessionProtected", mock.Anything, mock.Anything, mock.Anything).Once()
+				mockHandler.On("RenderTags", mock.Anything, mock.Anything, 1).Once()
+			},
+			expectCode: http.StatusOK,
+		},
+		{
+			name:   "Tags POST",
+			method: http.MethodPost,
+			url:    "/settings/tags",
+			expectFunc: func() {
+				mockHandler.On("HandleSessionProtected", mock.Anything, mock.Anything, mock.Anything).Once()
+				mockHandler.On("SubmitCreateTag", mock.Anything, mock.Anything, 1).Once()
+			},
+			expectCode: http.StatusOK,
+		},
+		{
+			name:   "Tag Delete POST",
+			method: http.MethodPost,
+			url:    "/settings/tags/delete",
+			expectFunc: func() {
+				mockHandler.On("HandleSessionProtected", mock.Anything, mock.Anything, mock.Anything).Once()
+				mockHandler.On("DeleteTag", mock.Anything, mock.Anything, 1).Once()
+			},
+			expectCode: http.StatusOK,
+		},
+		{
+			name:   "Projects GET",
+			method: http.MethodGet,
+			url:    "/projects",
+			expectFunc: func() {
+				mockHandler.On("HandleProtectedWithUserId", mock.Anything, mock.Anything, mock.Anything).Once()
+				mockHandler.On("RenderProjects", mock.Anything, mock.Anything, 1).Once()
+			},
+			expectCode: http.StatusOK,
+		},
+		{
+			name:   "Projects POST",
+			method: http.MethodPost,
+			url:    "/projects",
+			expectFunc: func() {
+				mockHandler.On("HandleProtectedWithUserId", mock.Anything, mock.Anything, mock.Anything).Once()
+				mockHandler.On("SubmitCreateProject", mock.Anything, mock.Anything, 1).Once()
+			},
+			expectCode: http.StatusOK,
+		},
+		{
+			name:   "Project GET",
+			method: http.MethodGet,
+			url:    "/projects/7",
+			expectFunc: func() {
+				mockHandler.On("HandleProtectedWithUserId", mock.Anything, mock.Anything, mock.Anything).Once()
+				mockHandler.On("GetProject", mock.Anything, mock.Anything, 1, "7").Once()
+			},
+			expectCode: http.StatusOK,
+		},
+		{
+			name:   "Project Rename POST",
+			method: http.MethodPost,
+			url:    "/projects/rename/7",
+			expectFunc: func() {
+				mockHandler.On("HandleProtectedWithUserId", mock.Anything, mock.Anything, mock.Anything).Once()
+				mockHandler.On("RenameProject", mock.Anything, mock.Anything, 1, "7").Once()
+			},
+			expectCode: http.StatusOK,
+		},
+		{
+			name:   "Project Delete POST",
+			method: http.MethodPost,
+			url:    "/projects/delete/7",
+			expectFunc: func() {
+				mockHandler.On("HandleProtectedWithUserId", mock.Anything, mock.Anything, mock.Anything).Once()
+				mockHandler.On("DeleteProject", mock.Anything, mock.Anything, 1, "7").Once()
+			},
+			expectCode: http.StatusOK,
+		},
+		{
+			name:       "Project Delete GET",
+			method:     http.MethodGet,
+			url:        "/projects/delete/7",
+			expectFunc: func() {},
+			expectCode: http.StatusMethodNotAllowed,
+		},
+		{
+			name:   "Task Move POST",
+			method: http.MethodPost,
+			url:    "/tasks/move/123e4567-e89b-12d3-a456-426614174000",
+			expectFunc: func() {
+				id := "123e4567-e89b-12d3-a456-426614174000"
+				mockHandler.On(
+					"HandleProtectedWithTaskId",
+					mock.Anything,
+					mock.Anything,
+					mock.AnythingOfType("func(http.ResponseWriter, *http.Request, int, uuid.UUID)"),
+					id,
+				).Once()
+				mockHandler.On("MoveTask", mock.Anything, mock.Anything, 1, uuid.MustParse(id)).Once()
+			},
+			expectCode: http.StatusOK,
+		},
+		{
+			name:   "Export Calendar GET",
+			method: http.MethodGet,
+			url:    "/tasks/export.ics",
+			expectFunc: func() {
+				mockHandler.On("HandleProtectedWithUserId", mock.Anything, mock.Anything, mock.Anything).Once()
+				mockHandler.On("ExportCalendar", mock.Anything, mock.Anything, 1).Once()
+			},
+			expectCode: http.StatusOK,
+		},
+		{
+			name:   "Import GET",
+			method: http.MethodGet,
+			url:    "/tasks/import",
+			expectFunc: func() {
+				mockHandler.On("HandleProtectedWithUserId", mock.Anything, mock.Anything, mock.Anything).Once()
+				mockHandler.On("RenderImport", mock.Anything, mock.Anything, 1).Once()
+			},
+			expectCode: http.StatusOK,
+		},
+		{
+			name:   "Import POST",
+			method: http.MethodPost,
+			url:    "/tasks/import",
+			expectFunc: func() {
+				mockHandler.On("HandleProtectedWithUserId", mock.Anything, mock.Anything, mock.Anything).Once()
+				mockHandler.On("SubmitImport", mock.Anything, mock.Anything, 1).Once()
+			},
+			expectCode: http.StatusOK,
+		},
+		{
+			name:   "Export Backup GET",
+			method: http.MethodGet,
+			url:    "/export?format=json",
+			expectFunc: func() {
+				mockHandler.On("HandleProtectedWithUserId", mock.Anything, mock.Anything, mock.Anything).Once()
+				mockHandler.On("ExportBackup", mock.Anything, mock.Anything, 1).Once()
+			},
+			expectCode: http.StatusOK,
+		},
+		{
+			name:       "Export Backup POST",
+			method:     http.MethodPost,
+			url:        "/export",
+			expectFunc: func() {},
+			expectCode: http.StatusMethodNotAllowed,
+		},
+		{
+			name:   "Backup Import GET",
+			method: http.MethodGet,
+			url:    "/import",
+			expectFunc: func() {
+				mockHandler.On("HandleProtectedWithUserId", mock.Anything, mock.Anything, mock.Anything).Once()
+				mockHandler.On("RenderBackup", mock.Anything, mock.Anything, 1).Once()
+			},
+			expectCode: http.StatusOK,
+		},
+		{
+			name:   "Backup Import POST",
+			method: http.MethodPost,
+			url:    "/import",
+			expectFunc: func() {
+				mockHandler.On("HandleProtectedWithUserId", mock.Anything, mock.Anything, mock.Anything).Once()
+				mockHandler.On("SubmitBackup", mock.Anything, mock.Anything, 1).Once()
+			},
+			expectCode: http.StatusOK,
+		},
+		{
+			name:   "Search Tasks GET",
+			method: http.MethodGet,
+			url:    "/tasks/search?q=report",
+			expectFunc: func() {
+				mockHandler.On("HandleProtectedWithUserId", mock.Anything, mock.Anything, mock.Anything).Once()
+				mockHandler.On("SearchTasks", mock.Anything, mock.Anything, 1).Once()
+			},
+			expectCode: http.StatusOK,
+		},
+		{
+			name:       "Search Tasks POST",
+			method:     http.MethodPost,
+			url:        "/tasks/search",
+			expectFunc: func() {},
+			expectCode: http.StatusMethodNotAllowed,
+		},
+		{
+			name:   "Time Zone GET",
+			method: http.MethodGet,
+			url:    "/settings/timezone",
+			expectFunc: func() {
+				mockHandler.On("HandleSessionProtected", mock.Anything, mock.Anything, mock.Anything).Once()
+				mockHandler.On("RenderTimeZone", mock.Anything, mock.Anything, 1).Once()
+			},
+			expectCode: http.StatusOK,
+		},
+		{
+			name:   "Time Zone POST",
+			method: http.MethodPost,
+			url:    "/settings/timezone",
+			expectFunc: func() {
+				mockHandler.On("HandleSessionProtected", mock.Anything, mock.Anything, mock.Anything).Once()
+				mockHandler.On("SubmitTimeZone", mock.Anything, mock.Anything, 1).Once()
+			},
+			expectCode: http.StatusOK,
+		},
+		{
+			name:       "Tag Delete GET",
+			method:     http.MethodGet,
+			url:        "/settings/tags/delete",
+			expectFunc: func() {},
+			expectCode: http.StatusMethodNotAllowed,
+		},
+		{
+			name:   "Login Two-Factor GET",
+			method: http.MethodGet,
+			url:    "/login/2fa",
+			expectFunc: func() {
+				mockHandler.On("RenderLoginTwoFactor", mock.Anything, mock.Anything).Once()
+			},
+			expectCode: http.StatusOK,
+		},
+		{
+			name:   "Login Two-Factor POST",
+			method: http.MethodPost,
+			url:    "/login/2fa",
+			expectFunc: func() {
+				mockHandler.On("SubmitLoginTwoFactor", mock.Anything, mock.Anything).Once()
+			},
+			expectCode: http.StatusOK,
+		},
+		{
+			name:   "Two-Factor Settings GET",
+			method: http.MethodGet,
+			url:    "/settings/2fa",
+			expectFunc: func() {
+				mockHandler.On("HandleSessionProtected", mock.Anything, mock.Anything, mock.Anything).Once()
+				mockHandler.On("RenderTwoFactor", mock.Anything, mock.Anything, 1).Once()
+			},
+			expectCode: http.StatusOK,
+		},
+		{
+			name:   "Two-Factor Setup POST",
+			method: http.MethodPost,
+			url:    "/settings/2fa/setup",
+			expectFunc: func() {
+				mockHandler.On("HandleSessionProtected", mock.Anything, mock.Anything, mock.Anything).Once()
+				mockHandler.On("SubmitTwoFactorSetup", mock.Anything, mock.Anything, 1).Once()
+			},
+			expectCode: http.StatusOK,
+		},
+		{
+			name:   "Two-Factor Enable POST",
+			method: http.MethodPost,
+			url:    "/settings/2fa/enable",
+			expectFunc: func() {
+				mockHandler.On("HandleSessionProtected", mock.Anything, mock.Anything, mock.Anything).Once()
+				mockHandler.On("SubmitTwoFactorEnable", mock.Anything, mock.Anything, 1).Once()
+			},
+			expectCode: http.StatusOK,
+		},
+		{
+			name:   "Two-Factor Disable POST",
+			method: http.MethodPost,
+			url:    "/settings/2fa/disable",
+			expectFunc: func() {
+				mockHandler.On("HandleSessionProtected", mock.Anything, mock.Anything, mock.Anything).Once()
+				mockHandler.On("SubmitTwoFactorDisable", mock.Anything, mock.Anything, 1).Once()
+			},
+			expectCode: http.StatusOK,
+		},
+		{
+			name:   "Forgot Password GET",
+			method: http.MethodGet,
+			url:    "/password/forgot",
+			expectFunc: func() {
+				mockHandler.On("RenderForgotPassword", mock.Anything, mock.Anything).Once()
+			},
+			expectCode: http.StatusOK,
+		},
+		{
+			name:   "Forgot Password POST",
+			method: http.MethodPost,
+			url:    "/password/forgot",
+			expectFunc: func() {
+				mockHandler.On("SubmitForgotPassword", mock.Anything, mock.Anything).Once()
+			},
+			expectCode: http.StatusOK,
+		},
+		{
+			name:   "Reset Password GET",
+			method: http.MethodGet,
+			url:    "/password/reset/reset-token",
+			expectFunc: func() {
+				mockHandler.On("RenderResetPassword", mock.Anything, mock.Anything, "reset-token").Once()
+			},
+			expectCode: http.StatusOK,
+		},
+		{
+			name:   "Reset Password POST",
+			method: http.MethodPost,
+			url:    "/password/reset/reset-token",
+			expectFunc: func() {
+				mockHandler.On("SubmitResetPassword", mock.Anything, mock.Anything, "reset-token").Once()
+			},
+			expectCode: http.StatusOK,
+		},
+		{
+			name:       "Reset Password Missing Token",
+			method:     http.MethodGet,
+			url:        "/password/reset/",
+			expectFunc: func() {},
+			expectCode: http.StatusNotFound,
+		},
+		{
+			name:   "Verify Email GET",
+			method: http.MethodGet,
+			url:    "/verify?token=abc",
+			expectFunc: func() {
+				mockHandler.On("HandleVerifyEmail", mock.Anything, mock.Anything).Once()
+			},
+			expectCode: http.StatusOK,
+		},
+		{
+			name:   "Resend Verification POST",
+			method: http.MethodPost,
+			url:    "/verify/resend",
+			expectFunc: func() {
+				mockHandler.On("SubmitResendVerification", mock.Anything, mock.Anything).Once()
+			},
+			expectCode: http.StatusOK,
+		},
+		{
+			name:   "Logout Everywhere POST",
+			method: http.MethodPost,
+			url:    "/logout/all",
+			expectFunc: func() {
+				mockHandler.On("HandleSessionProtected", mock.Anything, mock.Anything, mock.Anything).Once()
+				mockHandler.On("HandleLogoutEverywhere", mock.Anything, mock.Anything, 1).Once()
+			},
+			expectCode: http.StatusOK,
+		},
+		{
+			name:       "Logout Everywhere GET",
+			method:     http.MethodGet,
+			url:        "/logout/all",
+			expectFunc: func() {},
+			expectCode: http.StatusMethodNotAllowed,
+		},
+		
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			var req *http.Request
+			if tc.body != nil {
+				req = httptest.NewRequest(tc.method, tc.url, bytes.NewReader(tc.body))
+			} else {
+				req = httptest.NewRequest(tc.method, tc.url, nil)
+			}
+			addCSRFToken(req)
+
+			tc.expectFunc()
+
+			router.ServeHTTP(rec, req)
+
+			assert.Equal(t, tc.expectCode, rec.Code)
+			mockHandler.AssertExpectations(t)
+		})
+	}
+}
+
+const testCSRFToken = "test-csrf-token"
+
+// addCSRFToken gives a request the CSRF cookie and matching header that a page rendered by the app would supply.
+func addCSRFToken(req *http.Request) {
+	req.AddCookie(&http.Cookie{Name: "csrf_token", Value: testCSRFToken})
+	req.Header.Set("X-CSRF-Token", testCSRFToken)
+}
+
+func mustJSON(v any) []byte {
+	b, err := json.Marshal(v)
+	if err != nil {
+		panic(err)
+	}
+	return b
+}
+
+func TestAPIRoutes(t *testing.T) {
+	id := "123e4567-e89b-12d3-a456-426614174000"
+
+	cases := []struct {
+		name       string
+		method     string
+		url        string
+		handler    string
+		protected  string
+		expectCode int
+	}{
+		{"Login POST", http.MethodPost, "/api/v1/login", "APILogin", "", http.StatusOK},
+		{"List GET", http.MethodGet, "/api/v1/tasks", "APIListTasks", "HandleAPIProtected", http.StatusOK},
+		{"Create POST", http.MethodPost, "/api/v1/tasks", "APICreateTask", "HandleAPIProtected", http.StatusOK},
+		{"Get GET", http.MethodGet, "/api/v1/tasks/" + id, "APIGetTask", "HandleAPIProtectedWithTaskId", http.StatusOK},
+		{"Patch PATCH", http.MethodPatch, "/api/v1/tasks/" + id, "APIPatchTask", "HandleAPIProtectedWithTaskId", http.StatusOK},
+		{"Delete DELETE", http.MethodDelete, "/api/v1/tasks/" + id, "APIDeleteTask", "HandleAPIProtectedWithTaskId", http.StatusOK},
+		{"Done POST", http.MethodPost, "/api/v1/tasks/" + id + "/done", "APISetTaskDone", "HandleAPIProtectedWithTaskId", http.StatusOK},
+		{"Undone DELETE", http.MethodDelete, "/api/v1/tasks/" + id + "/done", "APISetTaskDone", "HandleAPIProtectedWithTaskId", http.StatusOK},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			mockHandler := new(MockHandler)
+			router := api.NewRouter(mockHandler)
+
+			switch tc.protected {
+			case "HandleAPIProtected":
+				mockHandler.On(tc.protected, mock.Anything, mock.Anything, mock.Anything).Once()
+				mockHandler.On(tc.handler, mock.Anything, mock.Anything, 1).Once()
+			case "HandleAPIProtectedWithTaskId":
+				mockHandler.On(tc.protected, mock.Anything, mock.Anything, mock.Anything, id).Once()
+				mockHandler.On(tc.handler, mock.Anything, mock.Anything, 1, uuid.MustParse(id)).Once()
+			default:
+				mockHandler.On(tc.handler, mock.Anything, mock.Anything).Once()
+			}
+
+			req := httptest.NewRequest(tc.method, tc.url, nil)
+			rec := httptest.NewRecorder()
+
+			router.ServeHTTP(rec, req)
+
+			assert.Equal(t, tc.expectCode, rec.Code)
+			mockHandler.AssertExpectations(t)
+		})
+	}
+}
+
+func TestAPIRoutesReturnJSONErrors(t *testing.T) {
+	mockHandler := new(MockHandler)
+	router := api.NewRouter(mockHandler)
+
+	cases := []struct {
+		method     string
+		url        string
+		expectCode int
+	}{
+		{http.MethodPut, "/api/v1/tasks", http.StatusMethodNotAllowed},
+		{http.MethodGet, "/api/v1/login", http.StatusMethodNotAllowed},
+		{http.MethodGet, "/api/v1/nothing-here", http.StatusNotFound},
+	}
+
+	for _, tc := range cases {
+		req := httptest.NewRequest(tc.method, tc.url, nil)
+		rec := httptest.NewRecorder()
+
+		router.ServeHTTP(rec, req)
+
+		assert.Equal(t, tc.expectCode, rec.Code, tc.url)
+		assert.Equal(t, "application/json", rec.Header().Get("Content-Type"), tc.url)
+
+		var body map[string]string
+		assert.NoError(t, json.NewDecoder(rec.Body).Decode(&body))
+		assert.NotEmpty(t, body["error"])
+	}
+
+	mockHandler.AssertExpectations(t)
+}
+
+func TestCSRFRejectsForgedPosts(t *testing.T) {
+	id := "123e4567-e89b-12d3-a456-426614174000"
+	urls := []string{
+		"/login",
+		"/register",
+		"/logout",
+		"/logout/all",
+		"/tasks/create",
+		"/tasks/update/" + id,
+		"/tasks/delete/" + id,
+		"/tasks/done/" + id,
+		"/tasks/checklist/add/" + id,
+		"/tasks/checklist/toggle/" + id,
+		"/tasks/checklist/move/" + id,
+		"/tasks/checklist/delete/" + id,
+		"/settings/tokens",
+		"/settings/tokens/revoke",
+		"/settings/feed",
+		"/settings/feed/revoke",
+		"/settings/tags",
+		"/settings/tags/delete",
+		"/settings/timezone",
+		"/tasks/import",
+		"/import",
+		"/projects",
+		"/projects/rename/7",
+		"/projects/delete/7",
+		"/tasks/move/" + id,
+	}
+
+	cases := map[string]func(req *http.Request){
+		"no cookie or token": func(req *http.Request) {},
+		"token without cookie": func(req *http.Request) {
+			req.Header.Set("X-CSRF-Token", testCSRFToken)
+		},
+		"cookie without token": func(req *http.Request) {
+			req.AddCookie(&http.Cookie{Name: "csrf_token", Value: testCSRFToken})
+		},
+		"mismatched token": func(req *http.Request) {
+			req.AddCookie(&http.Cookie{Name: "csrf_token", Value: testCSRFToken})
+			req.Header.Set("X-CSRF-Token", "forged")
+		},
+		"token from before login": func(req *http.Request) {
+			addCSRFToken(req)
+			req.AddCookie(&http.Cookie{Name: "session_token", Value: "session-token"})
+		},
+	}
+
+	for name, prepare := range cases {
+		for _, url := range urls {
+			t.Run(name+" "+url, func(t *testing.T) {
+				mockHandler := new(MockHandler)
+				router := api.NewRouter(mockHandler)
+
+				req := httptest.NewRequest(http.MethodPost, url, bytes.NewReader(mustJSON(map[string]bool{"checked": true})))
+				prepare(req)
+				rec := httptest.NewRecorder()
+
+				router.ServeHTTP(rec, req)
+
+				assert.Equal(t, http.StatusForbidden, rec.Code)
+				mockHandler.AssertNotCalled(t, "SubmitLogin", mock.Anything, mock.Anything)
+				mockHandler.AssertNotCalled(t, "HandleProtectedWithUserId", mock.Anything, mock.Anything, mock.Anything)
+				mockHandler.AssertNotCalled(t, "HandleProtectedWithTaskId", mock.Anything, mock.Anything, mock.Anything, mock.Anything)
+			})
+		}
+	}
+}
+
+func TestCSRFAcceptsFormField(t *testing.T) {
+	mockHandler := new(MockHandler)
+	router := api.NewRouter(mockHandler)
+
+	mockHandler.On("SubmitLogin", mock.Anything, mock.Anything).Once()
+
+	req := httptest.NewRequest(http.MethodPost, "/login", strings.NewReader("email=a%40b.c&csrf_token="+testCSRFToken))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.AddCookie(&http.Cookie{Name: "csrf_token", Value: testCSRFToken})
+	rec := httptest.NewRecorder()
+
+	router.ServeHTTP(rec, req)
+
+	assert.Equal(t, http.StatusOK, rec.Code)
+	mockHandler.AssertExpectations(t)
+}
+
+func TestCSRFExemptsBearerRequests(t *testing.T) {
+	mockHandler := new(MockHandler)
+	router := api.NewRouter(mockHandler)
+
+	mockHandler.On("HandleProtectedWithUserId", mock.Anything, mock.Anything, mock.Anything).Once()
+	mockHandler.On("SubmitCreateTask", mock.Anything, mock.Anything, 1).Once()
+	mockHandler.On("APILogin", mock.Anything, mock.Anything).Once()
+
+	req := httptest.NewRequest(http.MethodPost, "/tasks/create", nil)
+	req.Header.Set("Authorization", "Bearer pnb_token")
+	rec := httptest.NewRecorder()
+	router.ServeHTTP(rec, req)
+	assert.Equal(t, http.StatusOK, rec.Code)
+
+	req = httptest.NewRequest(http.MethodPost, "/api/v1/login", nil)
+	rec = httptest.NewRecorder()
+	router.ServeHTTP(rec, req)
+	assert.Equal(t, http.StatusOK, rec.Code)
+
+	// CalDAV clients send Basic credentials, and no CSRF token.
+	mockHandler.On("HandleCalDAVProtected", mock.Anything, mock.Anything, mock.Anything).Once()
+	mockHandler.On("ServeCalDAV", mock.Anything, mock.Anything, 1).Once()
+	req = httptest.NewRequest(http.MethodDelete, "/caldav/calendars/tasks/rent.ics", nil)
+	req.SetBasicAuth("sam@example.com", "pnb_token")
+	rec = httptest.NewRecorder()
+	router.ServeHTTP(rec, req)
+	assert.Equal(t, http.StatusOK, rec.Code)
+
+	mockHandler.AssertExpectations(t)
+}
+
+func TestCSRFCookieIssuedOnGet(t *testing.T) {
+	mockHandler := new(MockHandler)
+	router := api.NewRouter(mockHandler)
+
+	mockHandler.On("RenderLogin", mock.Anything, mock.Anything).Once()
+
+	req := httptest.NewRequest(http.MethodGet, "/login", nil)
+	rec := httptest.NewRecorder()
+
+	router.ServeHTTP(rec, req)
+
+	var csrfCookie *http.Cookie
+	for _, c := range rec.Result().Cookies() {
+		if c.Name == "csrf_token" {
+			csrfCookie = c
+		}
+	}
+
+	if assert.NotNil(t, csrfCookie) {
+		assert.NotEmpty(t, csrfCookie.Value)
+		assert.True(t, csrfCookie.HttpOnly)
+		assert.Equal(t, http.SameSiteStrictMode, csrfCookie.SameSite)
+	}
+	mockHandler.AssertExpectations(t)
 }
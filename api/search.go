@@ -0,0 +1,81 @@
+package api
+
+import (
+	"log"
+	"net/http"
+	"strings"
+
+	"penumbra/db"
+	"penumbra/validate"
+)
+
+// SearchPage is the search box, shown again with what was typed, and the tasks it found.
+type SearchPage struct {
+    Query   string
+    Results []SearchResult
+    Errors  validate.Errors
+}
+
+// SearchResult is a task found by a search, with its title and an excerpt of its description split so that the matching words can be highlighted.
+type SearchResult struct {
+    Task    TaskView
+    Title   []Fragment
+    Snippet []Fragment
+}
+
+// Fragment is a run of text that either matched the search or didn't.
+type Fragment struct {
+    Text  string
+    Match bool
+}
+
+// SearchTasks lists the user's tasks matching `q`, best match first.
+func (h *RealHandler) SearchTasks(w http.ResponseWriter, r *http.Request, userId int) {
+    query := strings.TrimSpace(r.URL.Query().Get("q"))
+    if errs := validate.Search(query); len(errs) > 0 {
+        w.WriteHeader(http.StatusBadRequest)
+        h.RenderPage(w, r, "search", SearchPage{Query: query, Errors: errs})
+        return
+    }
+
+    page := SearchPage{Query: query}
+    if query != "" {
+        matches, err := h.store.SearchTasks(userId, query)
+        if err != nil {
+            log.Println("Error searching tasks: ", err)
+            http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+            return
+        }
+        for _, match := range matches {
+            page.Results = append(page.Results, SearchResult{
+                Task:    taskViewFor(match.Task),
+                Title:   splitMatches(match.Title),
+                Snippet: splitMatches(match.Snippet),
+            })
+        }
+    }
+
+    h.RenderPage(w, r, "search", page)
+}
+
+// splitMatches splits text from the store at its db.MatchStart and db.MatchEnd markers, dropping the markers.
+func splitMatches(text string) []Fragment {
+    var fragments []Fragment
+    add := func(s string, match bool) {
+        if s != "" {
+            fragments = append(fragments, Fragment{Text: s, Match: match})
+        }
+    }
+
+    for text != "" {
+        before, rest, found := strings.Cut(text, db.MatchStart)
+        add(before, false)
+        if !found {
+            break
+        }
+        var match string
+        match, text, _ = strings.Cut(rest, db.MatchEnd)
+        add(match, true)
+    }
+    return fragments
+}
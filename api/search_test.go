@@ -0,0 +1,70 @@
+package api
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+
+	"penumbra/app"
+	"penumbra/db"
+	"penumbra/validate"
+)
+
+func TestSplitMatches(t *testing.T) {
+    m := func(s string) string { return db.MatchStart + s + db.MatchEnd }
+
+    assert.Nil(t, splitMatches(""))
+    assert.Equal(t, []Fragment{{Text: "Buy milk"}}, splitMatches("Buy milk"))
+    assert.Equal(t, []Fragment{
+        {Text: "Write "},
+        {Text: "quarterly", Match: true},
+        {Text: " "},
+        {Text: "report", Match: true},
+    }, splitMatches("Write "+m("quarterly")+" "+m("report")))
+    assert.Equal(t, []Fragment{{Text: "Report", Match: true}, {Text: " <b>"}}, splitMatches(m("Report")+" <b>"))
+}
+
+func TestSearchTasks(t *testing.T) {
+    mockStore := new(MockSQLiteStore)
+    handler := newTestHandler(mockStore)
+
+    match := db.TaskMatch{
+        Task:    app.Task{Title: "Write report", Due: time.Date(2030, 1, 7, 12, 0, 0, 0, time.UTC)},
+        Title:   "Write " + db.MatchStart + "report" + db.MatchEnd,
+        Snippet: "by Friday",
+    }
+    mockStore.On("SearchTasks", 1, `"quarterly report" rep*`).Return([]db.TaskMatch{match}, nil).Once()
+
+    rr := httptest.NewRecorder()
+    handler.SearchTasks(rr, httptest.NewRequest(http.MethodGet, "/tasks/search?q="+url.QueryEscape(` "quarterly report" rep* `), nil), 1)
+
+    assert.Equal(t, http.StatusOK, rr.Code)
+    assert.Contains(t, rr.Body.String(), "search|")
+    assert.Contains(t, rr.Body.String(), "Title:[{Text:Write  Match:false} {Text:report Match:true}]")
+    assert.Contains(t, rr.Body.String(), "Snippet:[{Text:by Friday Match:false}]")
+    mockStore.AssertExpectations(t)
+}
+
+func TestSearchTasksWithoutQuery(t *testing.T) {
+    mockStore := new(MockSQLiteStore)
+    handler := newTestHandler(mockStore)
+
+    rr := httptest.NewRecorder()
+    handler.SearchTasks(rr, httptest.NewRequest(http.MethodGet, "/tasks/search?q=+", nil), 1)
+    assert.Equal(t, http.StatusOK, rr.Code)
+    assert.Contains(t, rr.Body.String(), "Results:[]")
+
+    rr = httptest.NewRecorder()
+    long := strings.Repeat("a", validate.MaxSearchLength+1)
+    handler.SearchTasks(rr, httptest.NewRequest(http.MethodGet, "/tasks/search?q="+long, nil), 1)
+    assert.Equal(t, http.StatusBadRequest, rr.Code)
+    assert.Contains(t, rr.Body.String(), "q: Use at most")
+
+    mockStore.AssertNotCalled(t, "SearchTasks", mock.Anything, mock.Anything)
+}
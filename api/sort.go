@@ -0,0 +1,133 @@
+package api
+
+import (
+	"net/url"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"penumbra/app"
+	"penumbra/db"
+)
+
+// SortForm is how a task list was asked to be sorted and grouped, from its query string, so that its controls can show it.
+type SortForm struct {
+    Sort  string   // What the list is sorted by, `due` unless given.
+    Order string   // `asc`, `desc`, or empty for the sort's natural order.
+    Group string   // What the list is grouped by, or empty for no groups.
+    Tags  []string // The tags the list is filtered by, kept when the sort changes.
+}
+
+// TaskGroup is a run of tasks shown under a heading. A list that isn't grouped has a single group with no label.
+type TaskGroup struct {
+    Label string
+    Tasks []TaskView
+}
+
+// TaskList is a page of a filtered, sorted, and possibly grouped list of tasks, with the filters and sort that produced it.
+type TaskList struct {
+    Groups []TaskGroup
+    Sort   SortForm
+    Filter FilterForm
+    Next   string // The query string for the next page, or empty on the last.
+    Prev   string // The query string for the previous page, or empty on the first.
+}
+
+// naturalDesc lists the sorts that go from high to low unless asked otherwise, so that the most urgent and the newest tasks come first.
+var naturalDesc = map[db.TaskSort]bool{
+    db.SortPriority: true,
+    db.SortCreated:  true,
+}
+
+// parseSortForm reads `sort`, `order`, and `group` from the query string and turns them into the order for the store, grouping key first. It reports false if any of them is unknown.
+func parseSortForm(query url.Values) (SortForm, []db.TaskOrder, bool) {
+    form := SortForm{
+        Sort:  query.Get("sort"),
+        Order: query.Get("order"),
+        Group: query.Get("group"),
+        Tags:  query["tag"],
+    }
+    if form.Sort == "" {
+        form.Sort = string(db.SortDue)
+    }
+
+    var order []db.TaskOrder
+    if form.Group != "" {
+        group := db.TaskSort(form.Group)
+        if !db.ValidTaskSort(group) {
+            return form, nil, false
+        }
+        order = append(order, db.TaskOrder{By: group, Desc: naturalDesc[group]})
+    }
+
+    sort := db.TaskSort(form.Sort)
+    if !db.ValidTaskSort(sort) {
+        return form, nil, false
+    }
+    desc := naturalDesc[sort]
+    switch form.Order {
+    case "":
+    case "asc":
+        desc = false
+    case "desc":
+        desc = true
+    default:
+        return form, nil, false
+    }
+    order = append(order, db.TaskOrder{By: sort, Desc: desc})
+
+    return form, order, true
+}
+
+// groupTasks splits the views of tasks, which are already in order, into runs that share the label for `by`. views[i] must be the view of tasks[i].
+func groupTasks(by string, tasks []app.Task, views []TaskView) []TaskGroup {
+    if by == "" {
+        return []TaskGroup{{Tasks: views}}
+    }
+
+    var groups []TaskGroup
+    for i, task := range tasks {
+        label := groupLabel(db.TaskSort(by), task)
+        if len(groups) == 0 || groups[len(groups)-1].Label != label {
+            groups = append(groups, TaskGroup{Label: label})
+        }
+        last := &groups[len(groups)-1]
+        last.Tasks = append(last.Tasks, views[i])
+    }
+    return groups
+}
+
+// groupLabel is the heading of the group the task falls in when grouping by `by`.
+func groupLabel(by db.TaskSort, task app.Task) string {
+    switch by {
+    case db.SortDue:
+        return dueDate(task)
+    case db.SortPriority:
+        if task.Priority == app.PriorityNone {
+            return "No priority"
+        }
+        return capitalize(task.Priority.String())
+    case db.SortStatus:
+        return capitalize(task.Status)
+    case db.SortTitle:
+        first, _ := utf8.DecodeRuneInString(strings.TrimSpace(task.Title))
+        if !unicode.IsLetter(first) {
+            return "#"
+        }
+        return string(unicode.ToUpper(first))
+    case db.SortCreated:
+        if task.CreatedAt.IsZero() {
+            return "Created earlier"
+        }
+        return "Created " + task.CreatedAt.In(app.Location(task.TimeZone)).Format("Mon Jan 2 2006")
+    }
+    return ""
+}
+
+func capitalize(s string) string {
+    if s == "" {
+        return s
+    }
+    first, size := utf8.DecodeRuneInString(s)
+    return string(unicode.ToUpper(first)) + s[size:]
+}
@@ -0,0 +1,77 @@
+package api
+
+import (
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"penumbra/app"
+	"penumbra/db"
+)
+
+func TestParseSortForm(t *testing.T) {
+    cases := map[string]struct {
+        query string
+        order []db.TaskOrder
+    }{
+        "default":         {"", []db.TaskOrder{{By: db.SortDue}}},
+        "priority":        {"sort=priority", []db.TaskOrder{{By: db.SortPriority, Desc: true}}},
+        "priority asc":    {"sort=priority&order=asc", []db.TaskOrder{{By: db.SortPriority}}},
+        "title desc":      {"sort=title&order=desc", []db.TaskOrder{{By: db.SortTitle, Desc: true}}},
+        "grouped":         {"sort=title&group=status", []db.TaskOrder{{By: db.SortStatus}, {By: db.SortTitle}}},
+        "grouped created": {"group=created", []db.TaskOrder{{By: db.SortCreated, Desc: true}, {By: db.SortDue}}},
+    }
+
+    for name, tc := range cases {
+        t.Run(name, func(t *testing.T) {
+            query, _ := url.ParseQuery(tc.query)
+            _, order, ok := parseSortForm(query)
+            assert.True(t, ok)
+            assert.Equal(t, tc.order, order)
+        })
+    }
+
+    for _, query := range []string{"sort=colour", "order=up", "group=tag", "sort=rowid"} {
+        q, _ := url.ParseQuery(query)
+        _, _, ok := parseSortForm(q)
+        assert.False(t, ok, query)
+    }
+}
+
+func TestGroupTasks(t *testing.T) {
+    due := time.Date(2030, 1, 7, 0, 0, 0, 0, time.UTC)
+    tasks := []app.Task{
+        {Title: "apples", Priority: app.PriorityUrgent, Due: due},
+        {Title: "Avocados", Priority: app.PriorityUrgent, Due: due},
+        {Title: "bread", Due: due.AddDate(0, 0, 1)},
+        {Title: "42 eggs", Due: due.AddDate(0, 0, 1)},
+    }
+    views := dashboardViews(tasks)
+
+    groups := groupTasks("", tasks, views)
+    if assert.Len(t, groups, 1) {
+        assert.Equal(t, "", groups[0].Label)
+        assert.Len(t, groups[0].Tasks, 4)
+    }
+
+    groups = groupTasks("priority", tasks, views)
+    if assert.Len(t, groups, 2) {
+        assert.Equal(t, "Urgent", groups[0].Label)
+        assert.Len(t, groups[0].Tasks, 2)
+        assert.Equal(t, "No priority", groups[1].Label)
+    }
+
+    groups = groupTasks("title", tasks, views)
+    var labels []string
+    for _, group := range groups {
+        labels = append(labels, group.Label)
+    }
+    assert.Equal(t, []string{"A", "B", "#"}, labels)
+
+    groups = groupTasks("due", tasks, views)
+    if assert.Len(t, groups, 2) {
+        assert.Equal(t, "Mon Jan 7 2030", groups[0].Label)
+    }
+}
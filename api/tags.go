@@ -0,0 +1,130 @@
+package api
+
+import (
+	"errors"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"penumbra/app"
+	"penumbra/db"
+	"penumbra/validate"
+)
+
+// TagsPage lists the user's tags, with a form to create one that's shown again as typed if it was refused.
+type TagsPage struct {
+    Tags   []app.Tag
+    Name   string
+    Colour string
+    Errors validate.Errors
+}
+
+// TagOption is a checkbox of the tag picker on the create and task pages.
+type TagOption struct {
+    app.Tag
+    Checked bool
+}
+
+// defaultTagColour is preselected on the form for a new tag.
+const defaultTagColour = "#1e90ff"
+
+func (h *RealHandler) renderTags(w http.ResponseWriter, r *http.Request, userId int, page TagsPage) {
+    tags, err := h.store.GetTags(userId)
+    if err != nil {
+        log.Println("Error getting tags: ", err)
+        http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+        return
+    }
+
+    page.Tags = tags
+    if page.Colour == "" {
+        page.Colour = defaultTagColour
+    }
+    h.RenderPage(w, r, "tags", page)
+}
+
+func (h *RealHandler) RenderTags(w http.ResponseWriter, r *http.Request, userId int) {
+    h.renderTags(w, r, userId, TagsPage{})
+}
+
+func (h *RealHandler) SubmitCreateTag(w http.ResponseWriter, r *http.Request, userId int) {
+    tag := app.Tag{
+        UserId: userId,
+        Name:   strings.TrimSpace(r.FormValue("name")),
+        Colour: strings.ToLower(r.FormValue("colour")),
+    }
+
+    errs := validate.Tag(tag)
+    if len(errs) == 0 {
+        _, err := h.store.CreateTag(tag)
+        if errors.Is(err, db.ErrTagExists) {
+            errs.Add("name", "You already have a tag with this name.")
+        } else if err != nil {
+            log.Println("Error creating tag: ", err)
+            http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+            return
+        }
+    }
+    if len(errs) > 0 {
+        w.WriteHeader(http.StatusBadRequest)
+        h.renderTags(w, r, userId, TagsPage{Name: tag.Name, Colour: tag.Colour, Errors: errs})
+        return
+    }
+
+    http.Redirect(w, r, "/settings/tags", http.StatusSeeOther)
+}
+
+// DeleteTag deletes the form's tag `id`, taking it off every task that has it.
+func (h *RealHandler) DeleteTag(w http.ResponseWriter, r *http.Request, userId int) {
+    id, err := strconv.Atoi(r.FormValue("id"))
+    if err != nil {
+        http.Error(w, "invalid id", http.StatusBadRequest)
+        return
+    }
+
+    err = h.store.DeleteTag(userId, id)
+    if errors.Is(err, db.ErrTagNotFound) {
+        http.Error(w, "not found", http.StatusNotFound)
+        return
+    }
+    if err != nil {
+        log.Println("Error deleting tag: ", err)
+        http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+        return
+    }
+
+    http.Redirect(w, r, "/settings/tags", http.StatusSeeOther)
+}
+
+// tagOptions lists the user's tags for the tag picker, ticking those the task has.
+func (h *RealHandler) tagOptions(userId int, selected []app.Tag) ([]TagOption, error) {
+    tags, err := h.store.GetTags(userId)
+    if err != nil {
+        return nil, err
+    }
+
+    checked := map[int]bool{}
+    for _, tag := range selected {
+        checked[tag.Id] = true
+    }
+
+    options := make([]TagOption, len(tags))
+    for i, tag := range tags {
+        options[i] = TagOption{Tag: tag, Checked: checked[tag.Id]}
+    }
+    return options, nil
+}
+
+// parseTagIds reads the ids of the tags ticked in the tag picker. The store checks that they're the user's own.
+func parseTagIds(r *http.Request) ([]app.Tag, bool) {
+    var tags []app.Tag
+    for _, value := range r.Form["tags"] {
+        id, err := strconv.Atoi(value)
+        if err != nil {
+            return nil, false
+        }
+        tags = append(tags, app.Tag{Id: id})
+    }
+    return tags, true
+}
@@ -0,0 +1,144 @@
+package api
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+
+	"penumbra/app"
+	"penumbra/db"
+)
+
+func TestSubmitCreateTag(t *testing.T) {
+    mockStore := new(MockSQLiteStore)
+    handler := newTestHandler(mockStore)
+
+    mockStore.On("CreateTag", app.Tag{UserId: 1, Name: "work", Colour: "#1e90ff"}).Return(app.Tag{Id: 3}, nil).Once()
+
+    rr := httptest.NewRecorder()
+    handler.SubmitCreateTag(rr, formRequest("/settings/tags", url.Values{"name": {" work "}, "colour": {"#1E90FF"}}), 1)
+
+    assert.Equal(t, http.StatusSeeOther, rr.Code)
+    assert.Equal(t, "/settings/tags", rr.Header().Get("Location"))
+    mockStore.AssertExpectations(t)
+}
+
+func TestSubmitCreateTagShowsErrors(t *testing.T) {
+    cases := map[string]struct {
+        form     url.Values
+        existing bool
+    }{
+        "invalid":   {url.Values{"name": {""}, "colour": {"red"}}, false},
+        "duplicate": {url.Values{"name": {"Work"}, "colour": {"#000000"}}, true},
+    }
+
+    for name, tc := range cases {
+        t.Run(name, func(t *testing.T) {
+            mockStore := new(MockSQLiteStore)
+            handler := newTestHandler(mockStore)
+
+            if tc.existing {
+                mockStore.On("CreateTag", mock.Anything).Return(app.Tag{}, db.ErrTagExists).Once()
+            }
+            mockStore.On("GetTags", 1).Return([]app.Tag{{Id: 1, Name: "work", Colour: "#000000"}}, nil).Once()
+
+            rr := httptest.NewRecorder()
+            handler.SubmitCreateTag(rr, formRequest("/settings/tags", tc.form), 1)
+
+            assert.Equal(t, http.StatusBadRequest, rr.Code)
+            assert.Contains(t, rr.Body.String(), "tags|")
+            assert.Contains(t, rr.Body.String(), "Name:"+tc.form.Get("name"))
+            assert.Contains(t, rr.Body.String(), "name:")
+            mockStore.AssertExpectations(t)
+        })
+    }
+}
+
+func TestDeleteTag(t *testing.T) {
+    mockStore := new(MockSQLiteStore)
+    handler := newTestHandler(mockStore)
+
+    mockStore.On("DeleteTag", 1, 3).Return(nil).Once()
+    mockStore.On("DeleteTag", 1, 4).Return(db.ErrTagNotFound).Once()
+
+    rr := httptest.NewRecorder()
+    handler.DeleteTag(rr, formRequest("/settings/tags/delete", url.Values{"id": {"3"}}), 1)
+    assert.Equal(t, http.StatusSeeOther, rr.Code)
+
+    rr = httptest.NewRecorder()
+    handler.DeleteTag(rr, formRequest("/settings/tags/delete", url.Values{"id": {"4"}}), 1)
+    assert.Equal(t, http.StatusNotFound, rr.Code)
+
+    rr = httptest.NewRecorder()
+    handler.DeleteTag(rr, formRequest("/settings/tags/delete", url.Values{"id": {"x"}}), 1)
+    assert.Equal(t, http.StatusBadRequest, rr.Code)
+
+    mockStore.AssertExpectations(t)
+}
+
+func TestHandleAllTasksFiltersByTag(t *testing.T) {
+    mockStore := new(MockSQLiteStore)
+    handler := newTestHandler(mockStore)
+
+    mockStore.On("PageTasks", 1, db.TaskQuery{Tags: []string{"work", "urgent"}, Order: []db.TaskOrder{{By: db.SortDue}}}, db.PageQuery{Size: taskPageSize}).Return(db.TaskPage{Tasks: []app.Task{}}, nil).Once()
+
+    rr := httptest.NewRecorder()
+    handler.HandleAllTasks(rr, httptest.NewRequest(http.MethodGet, "/tasks?tag=work&tag=urgent", nil), 1)
+
+    assert.Equal(t, http.StatusOK, rr.Code)
+    assert.Contains(t, rr.Body.String(), "Tags:[work urgent]")
+    mockStore.AssertExpectations(t)
+}
+
+func TestSubmitCreateTaskWithTags(t *testing.T) {
+    mockStore := new(MockSQLiteStore)
+    handler := newTestHandler(mockStore)
+    mockStore.On("GetUserById", 1).Return(app.User{Id: 1}, nil).Once()
+
+    mockStore.On("CreateTask", mock.MatchedBy(func(task app.Task) bool {
+        return len(task.Tags) == 2 && task.Tags[0].Id == 3 && task.Tags[1].Id == 5
+    })).Return(nil).Once()
+
+    form := url.Values{"title": {"Report"}, "due": {"Mon Jan 7 2030"}, "tags": {"3", "5"}}
+    rr := httptest.NewRecorder()
+    handler.SubmitCreateTask(rr, formRequest("/tasks/create", form), 1)
+
+    assert.Equal(t, http.StatusSeeOther, rr.Code)
+    mockStore.AssertExpectations(t)
+}
+
+func TestSubmitCreateTaskWithUnknownTag(t *testing.T) {
+    mockStore := new(MockSQLiteStore)
+    handler := newTestHandler(mockStore)
+    mockStore.On("GetUserById", 1).Return(app.User{Id: 1}, nil).Once()
+
+    mockStore.On("CreateTask", mock.Anything).Return(db.ErrTagNotFound).Once()
+
+    form := url.Values{"title": {"Report"}, "due": {"Mon Jan 7 2030"}, "tags": {"99"}}
+    rr := httptest.NewRecorder()
+    handler.SubmitCreateTask(rr, formRequest("/tasks/create", form), 1)
+
+    assert.Equal(t, http.StatusBadRequest, rr.Code)
+    mockStore.AssertExpectations(t)
+}
+
+func TestSubmitCreateTaskWithInvalidTag(t *testing.T) {
+    mockStore := new(MockSQLiteStore)
+    handler := newTestHandler(mockStore)
+    mockStore.On("GetUserById", 1).Return(app.User{Id: 1}, nil).Once()
+
+    mockStore.On("GetTags", 1).Return([]app.Tag{{Id: 3, Name: "work"}}, nil).Once()
+    mockStore.On("GetProjects", 1).Return([]app.Project{}, nil).Once()
+
+    form := url.Values{"title": {"Report"}, "due": {"Mon Jan 7 2030"}, "tags": {"work"}}
+    rr := httptest.NewRecorder()
+    handler.SubmitCreateTask(rr, formRequest("/tasks/create", form), 1)
+
+    assert.Equal(t, http.StatusBadRequest, rr.Code)
+    assert.Contains(t, rr.Body.String(), "tags:")
+    mockStore.AssertNotCalled(t, "CreateTask", mock.Anything)
+}
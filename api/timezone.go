@@ -0,0 +1,50 @@
+package api
+
+import (
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"penumbra/app"
+	"penumbra/validate"
+)
+
+// TimeZonePage shows the user's time zone, with a form to change it that's shown again as typed if it was refused.
+type TimeZonePage struct {
+    TimeZone string
+    Now      string // The time now in the zone, so the user can check it's right.
+    Errors   validate.Errors
+}
+
+func (h *RealHandler) RenderTimeZone(w http.ResponseWriter, r *http.Request, userId int) {
+    user, err := h.store.GetUserById(userId)
+    if err != nil {
+        log.Println("Error getting user: ", err)
+        http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+        return
+    }
+
+    h.RenderPage(w, r, "timezone", TimeZonePage{
+        TimeZone: user.TimeZone,
+        Now:      time.Now().In(app.Location(user.TimeZone)).Format("Mon Jan 2 2006 15:04"),
+    })
+}
+
+// SubmitTimeZone sets the user's time zone to the form's `timeZone`. Tasks due on a day stay due on that day in the new zone.
+func (h *RealHandler) SubmitTimeZone(w http.ResponseWriter, r *http.Request, userId int) {
+    timeZone := strings.TrimSpace(r.FormValue("timeZone"))
+    if errs := validate.TimeZone(timeZone); len(errs) > 0 {
+        w.WriteHeader(http.StatusBadRequest)
+        h.RenderPage(w, r, "timezone", TimeZonePage{TimeZone: timeZone, Errors: errs})
+        return
+    }
+
+    if err := h.store.SetTimeZone(userId, timeZone); err != nil {
+        log.Println("Error setting time zone: ", err)
+        http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+        return
+    }
+
+    http.Redirect(w, r, "/settings/timezone", http.StatusSeeOther)
+}
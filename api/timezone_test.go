@@ -0,0 +1,115 @@
+package api
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+
+	"penumbra/app"
+)
+
+func TestSubmitCreateTaskInTimeZone(t *testing.T) {
+    newYork, _ := time.LoadLocation("America/New_York")
+    cases := map[string]struct {
+        time   string
+        allDay bool
+        due    time.Time
+    }{
+        "at a time": {"09:30", false, time.Date(2030, 1, 7, 14, 30, 0, 0, time.UTC)},
+        "all day":   {"", true, app.EndOfDay(time.Date(2030, 1, 7, 0, 0, 0, 0, newYork)).UTC()},
+    }
+
+    for name, tc := range cases {
+        t.Run(name, func(t *testing.T) {
+            mockStore := new(MockSQLiteStore)
+            handler := newTestHandler(mockStore)
+
+            mockStore.On("GetUserById", 1).Return(app.User{Id: 1, TimeZone: "America/New_York"}, nil).Once()
+            mockStore.On("CreateTask", mock.MatchedBy(func(task app.Task) bool {
+                return task.AllDay == tc.allDay && task.Due.Equal(tc.due) && task.Due.Location() == time.UTC
+            })).Return(nil).Once()
+
+            form := url.Values{"title": {"Report"}, "due": {"Mon Jan 7 2030"}, "due_time": {tc.time}}
+            rr := httptest.NewRecorder()
+            handler.SubmitCreateTask(rr, formRequest("/tasks/create", form), 1)
+
+            assert.Equal(t, http.StatusSeeOther, rr.Code)
+            mockStore.AssertExpectations(t)
+        })
+    }
+}
+
+func TestSubmitCreateTaskInvalidTime(t *testing.T) {
+    mockStore := new(MockSQLiteStore)
+    handler := newTestHandler(mockStore)
+
+    mockStore.On("GetUserById", 1).Return(app.User{Id: 1}, nil).Once()
+    mockStore.On("GetTags", 1).Return([]app.Tag{}, nil).Once()
+    mockStore.On("GetProjects", 1).Return([]app.Project{}, nil).Once()
+
+    form := url.Values{"title": {"Report"}, "due": {"Mon Jan 7 2030"}, "due_time": {"half past nine"}}
+    rr := httptest.NewRecorder()
+    handler.SubmitCreateTask(rr, formRequest("/tasks/create", form), 1)
+
+    assert.Equal(t, http.StatusBadRequest, rr.Code)
+    assert.Contains(t, rr.Body.String(), "DueTime:half past nine")
+    assert.Contains(t, rr.Body.String(), "due: Enter the time")
+    mockStore.AssertNotCalled(t, "CreateTask", mock.Anything)
+}
+
+func TestTaskViewInTimeZone(t *testing.T) {
+    // 3am UTC on the 8th is still the evening of the 7th in New York.
+    task := app.Task{Due: time.Date(2030, 1, 8, 3, 0, 0, 0, time.UTC), TimeZone: "America/New_York"}
+    view := taskViewFor(task)
+    assert.Equal(t, "Mon Jan 7 2030", view.DuePretty)
+    assert.Equal(t, "22:00", view.DueTime)
+
+    task.AllDay = true
+    assert.Equal(t, "", taskViewFor(task).DueTime)
+}
+
+func TestSubmitTimeZone(t *testing.T) {
+    mockStore := new(MockSQLiteStore)
+    handler := newTestHandler(mockStore)
+
+    mockStore.On("SetTimeZone", 1, "Europe/London").Return(nil).Once()
+
+    rr := httptest.NewRecorder()
+    handler.SubmitTimeZone(rr, formRequest("/settings/timezone", url.Values{"timeZone": {" Europe/London "}}), 1)
+    assert.Equal(t, http.StatusSeeOther, rr.Code)
+    assert.Equal(t, "/settings/timezone", rr.Header().Get("Location"))
+
+    rr = httptest.NewRecorder()
+    handler.SubmitTimeZone(rr, formRequest("/settings/timezone", url.Values{"timeZone": {"Europe/Atlantis"}}), 1)
+    assert.Equal(t, http.StatusBadRequest, rr.Code)
+    assert.Contains(t, rr.Body.String(), "timezone|")
+    assert.Contains(t, rr.Body.String(), "TimeZone:Europe/Atlantis")
+
+    mockStore.AssertExpectations(t)
+}
+
+func TestAPICreateTaskAllDay(t *testing.T) {
+    mockStore := new(MockSQLiteStore)
+    handler := &RealHandler{store: mockStore}
+
+    tokyo, _ := time.LoadLocation("Asia/Tokyo")
+    mockStore.On("GetUserById", 1).Return(app.User{Id: 1, TimeZone: "Asia/Tokyo"}, nil).Once()
+    mockStore.On("CreateTask", mock.MatchedBy(func(task app.Task) bool {
+        return task.AllDay && task.Due.Equal(app.EndOfDay(time.Date(2030, 1, 3, 0, 0, 0, 0, tokyo)))
+    })).Return(nil).Once()
+
+    // 20:00 UTC on the 2nd is already the 3rd in Tokyo.
+    body := `{"title": "Write report", "due": "2030-01-02T20:00:00Z", "allDay": true}`
+    rr := httptest.NewRecorder()
+    handler.APICreateTask(rr, httptest.NewRequest(http.MethodPost, "/api/v1/tasks", strings.NewReader(body)), 1)
+
+    assert.Equal(t, http.StatusCreated, rr.Code)
+    assert.Contains(t, rr.Body.String(), `"due":"2030-01-03T14:59:59.999999999Z"`)
+    mockStore.AssertExpectations(t)
+}
@@ -0,0 +1,138 @@
+package api
+
+import (
+	"errors"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"penumbra/app"
+	"penumbra/db"
+)
+
+type APITokenView struct {
+    Id             int
+    Name           string
+    Scope          string
+    CreatedPretty  string
+    LastUsedPretty string
+    ExpiresPretty  string
+    Expired        bool
+}
+
+type APITokensPage struct {
+    Tokens   []APITokenView
+    NewToken string // Shown once, right after the token is created.
+    Error    string
+}
+
+// apiTokenLifetimes are the choices offered for how long a new token lasts, keyed by the form value. The empty key means the token never expires.
+var apiTokenLifetimes = map[string]time.Duration{
+    "":    0,
+    "7":   7 * 24 * time.Hour,
+    "30":  30 * 24 * time.Hour,
+    "90":  90 * 24 * time.Hour,
+    "365": 365 * 24 * time.Hour,
+}
+
+const maxAPITokenNameLength = 100
+
+func (h *RealHandler) renderAPITokens(w http.ResponseWriter, r *http.Request, userId int, page APITokensPage) {
+    tokens, err := h.store.GetAPITokens(userId)
+    if err != nil {
+        log.Println("Error getting API tokens: ", err)
+        http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+        return
+    }
+
+    now := time.Now()
+    for _, t := range tokens {
+        view := APITokenView{
+            Id:             t.Id,
+            Name:           t.Name,
+            Scope:          t.Scope,
+            CreatedPretty:  t.CreatedAt.Format("Mon Jan 2 2006"),
+            LastUsedPretty: "never",
+            ExpiresPretty:  "never",
+        }
+        if !t.LastUsedAt.IsZero() {
+            view.LastUsedPretty = t.LastUsedAt.Format("Mon Jan 2 2006")
+        }
+        if !t.ExpiresAt.IsZero() {
+            view.ExpiresPretty = t.ExpiresAt.Format("Mon Jan 2 2006")
+            view.Expired = t.ExpiresAt.Before(now)
+        }
+        page.Tokens = append(page.Tokens, view)
+    }
+
+    h.RenderPage(w, r, "tokens", page)
+}
+
+func (h *RealHandler) RenderAPITokens(w http.ResponseWriter, r *http.Request, userId int) {
+    h.renderAPITokens(w, r, userId, APITokensPage{})
+}
+
+func (h *RealHandler) SubmitCreateAPIToken(w http.ResponseWriter, r *http.Request, userId int) {
+    if err := r.ParseForm(); err != nil {
+        http.Error(w, "Bad Request", http.StatusBadRequest)
+        return
+    }
+
+    name := strings.TrimSpace(r.FormValue("name"))
+    if name == "" || len(name) > maxAPITokenNameLength {
+        w.WriteHeader(http.StatusBadRequest)
+        h.renderAPITokens(w, r, userId, APITokensPage{Error: "Give the token a name of at most 100 characters."})
+        return
+    }
+
+    scope := r.FormValue("scope")
+    if scope != app.ScopeRead && scope != app.ScopeReadWrite {
+        w.WriteHeader(http.StatusBadRequest)
+        h.renderAPITokens(w, r, userId, APITokensPage{Error: "Choose a scope for the token."})
+        return
+    }
+
+    lifetime, ok := apiTokenLifetimes[r.FormValue("expires_in_days")]
+    if !ok {
+        w.WriteHeader(http.StatusBadRequest)
+        h.renderAPITokens(w, r, userId, APITokensPage{Error: "Choose when the token expires."})
+        return
+    }
+
+    var expiresAt time.Time
+    if lifetime != 0 {
+        expiresAt = time.Now().Add(lifetime)
+    }
+
+    token, err := h.store.CreateAPIToken(userId, name, scope, expiresAt)
+    if err != nil {
+        log.Println("Error creating API token: ", err)
+        http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+        return
+    }
+
+    h.renderAPITokens(w, r, userId, APITokensPage{NewToken: token})
+}
+
+func (h *RealHandler) RevokeAPIToken(w http.ResponseWriter, r *http.Request, userId int) {
+    id, err := strconv.Atoi(r.FormValue("id"))
+    if err != nil {
+        http.Error(w, "invalid id", http.StatusBadRequest)
+        return
+    }
+
+    err = h.store.DeleteAPIToken(userId, id)
+    if errors.Is(err, db.ErrAPITokenNotFound) {
+        http.Error(w, "not found", http.StatusNotFound)
+        return
+    }
+    if err != nil {
+        log.Println("Error deleting API token: ", err)
+        http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+        return
+    }
+
+    http.Redirect(w, r, "/settings/tokens", http.StatusSeeOther)
+}
@@ -0,0 +1,274 @@
+package api
+
+import (
+	"html/template"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+
+	"penumbra/app"
+	"penumbra/db"
+)
+
+func newTokensTestTemplates() *template.Template {
+    return template.Must(template.New("layout").Parse(
+        `{{.Page}}|{{with .Data}}{{.NewToken}}|{{.Error}}|{{range .Tokens}}{{.Name}},{{end}}{{end}}`))
+}
+
+func TestAuthenticateWithAPIToken(t *testing.T) {
+    mockStore := new(MockSQLiteStore)
+    handler := &RealHandler{store: mockStore}
+
+    token := db.APITokenPrefix + "secret"
+    mockStore.On("GetAPIToken", token).Return(app.APIToken{Id: 1, UserId: 5, Scope: app.ScopeReadWrite}, nil).Once()
+
+    req := httptest.NewRequest(http.MethodPost, "/tasks/create", nil)
+    req.Header.Set("Authorization", "Bearer "+token)
+    rr := httptest.NewRecorder()
+
+    var gotUserId int
+    handler.HandleProtectedWithUserId(rr, req, func(w http.ResponseWriter, r *http.Request, userId int) {
+        gotUserId = userId
+    })
+
+    assert.Equal(t, 5, gotUserId)
+    mockStore.AssertExpectations(t)
+    mockStore.AssertNotCalled(t, "GetUserIdFromSessionToken", mock.Anything)
+}
+
+func TestAuthenticateWithReadOnlyAPIToken(t *testing.T) {
+    token := db.APITokenPrefix + "secret"
+
+    for method, allowed := range map[string]bool{http.MethodGet: true, http.MethodPost: false} {
+        t.Run(method, func(t *testing.T) {
+            mockStore := new(MockSQLiteStore)
+            handler := &RealHandler{store: mockStore}
+
+            mockStore.On("GetAPIToken", token).Return(app.APIToken{Id: 1, UserId: 5, Scope: app.ScopeRead}, nil).Once()
+
+            req := httptest.NewRequest(method, "/tasks", nil)
+            req.Header.Set("Authorization", "Bearer "+token)
+            rr := httptest.NewRecorder()
+
+            called := false
+            handler.HandleProtectedWithUserId(rr, req, func(w http.ResponseWriter, r *http.Request, userId int) {
+                called = true
+            })
+
+            assert.Equal(t, allowed, called)
+            if !allowed {
+                assert.Equal(t, http.StatusForbidden, rr.Code)
+            }
+        })
+    }
+}
+
+func TestAuthenticateWithUnknownAPIToken(t *testing.T) {
+    mockStore := new(MockSQLiteStore)
+    handler := &RealHandler{store: mockStore}
+
+    token := db.APITokenPrefix + "revoked"
+    mockStore.On("GetAPIToken", token).Return(app.APIToken{}, db.ErrAPITokenNotFound).Once()
+
+    req := httptest.NewRequest(http.MethodGet, "/dashboard", nil)
+    req.Header.Set("Authorization", "Bearer "+token)
+    rr := httptest.NewRecorder()
+
+    handler.HandleProtectedWithUserId(rr, req, func(w http.ResponseWriter, r *http.Request, userId int) {
+        t.Fatal("handler should not be called")
+    })
+
+    assert.Equal(t, http.StatusUnauthorized, rr.Code)
+    assert.Empty(t, rr.Header().Get("Location"))
+}
+
+func TestAPIReadOnlyTokenCannotWrite(t *testing.T) {
+    mockStore := new(MockSQLiteStore)
+    handler := &RealHandler{store: mockStore}
+
+    token := db.APITokenPrefix + "secret"
+    mockStore.On("GetAPIToken", token).Return(app.APIToken{Id: 1, UserId: 5, Scope: app.ScopeRead}, nil).Once()
+
+    req := httptest.NewRequest(http.MethodPost, "/api/v1/tasks", nil)
+    req.Header.Set("Authorization", "Bearer "+token)
+    rr := httptest.NewRecorder()
+
+    handler.HandleAPIProtected(rr, req, func(w http.ResponseWriter, r *http.Request, userId int) {
+        t.Fatal("handler should not be called")
+    })
+
+    assert.Equal(t, http.StatusForbidden, rr.Code)
+    assert.Equal(t, "application/json", rr.Header().Get("Content-Type"))
+}
+
+func TestSubmitCreateAPIToken(t *testing.T) {
+    mockStore := new(MockSQLiteStore)
+    handler := &RealHandler{store: mockStore, templates: newTokensTestTemplates()}
+
+    mockStore.On("CreateAPIToken", 1, "Backup script", app.ScopeRead, mock.MatchedBy(func(expiresAt time.Time) bool {
+        return expiresAt.After(time.Now().Add(29*24*time.Hour)) && expiresAt.Before(time.Now().Add(31*24*time.Hour))
+    })).Return(db.APITokenPrefix+"new", nil).Once()
+    mockStore.On("GetAPITokens", 1).Return([]app.APIToken{{Id: 1, Name: "Backup script", Scope: app.ScopeRead, CreatedAt: time.Now()}}, nil).Once()
+
+    form := url.Values{}
+    form.Add("name", "Backup script")
+    form.Add("scope", app.ScopeRead)
+    form.Add("expires_in_days", "30")
+
+    req := httptest.NewRequest(http.MethodPost, "/settings/tokens", strings.NewReader(form.Encode()))
+    req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+    rr := httptest.NewRecorder()
+
+    handler.SubmitCreateAPIToken(rr, req, 1)
+
+    assert.Equal(t, http.StatusOK, rr.Code)
+    assert.Equal(t, "tokens|"+db.APITokenPrefix+"new||Backup script,", rr.Body.String())
+    mockStore.AssertExpectations(t)
+}
+
+func TestSubmitCreateAPITokenNeverExpires(t *testing.T) {
+    mockStore := new(MockSQLiteStore)
+    handler := &RealHandler{store: mockStore, templates: newTokensTestTemplates()}
+
+    mockStore.On("CreateAPIToken", 1, "CI", app.ScopeReadWrite, time.Time{}).Return(db.APITokenPrefix+"new", nil).Once()
+    mockStore.On("GetAPITokens", 1).Return([]app.APIToken{}, nil).Once()
+
+    form := url.Values{}
+    form.Add("name", "CI")
+    form.Add("scope", app.ScopeReadWrite)
+    form.Add("expires_in_days", "")
+
+    req := httptest.NewRequest(http.MethodPost, "/settings/tokens", strings.NewReader(form.Encode()))
+    req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+    rr := httptest.NewRecorder()
+
+    handler.SubmitCreateAPIToken(rr, req, 1)
+
+    assert.Equal(t, http.StatusOK, rr.Code)
+    mockStore.AssertExpectations(t)
+}
+
+func TestSubmitCreateAPITokenInvalid(t *testing.T) {
+    cases := map[string]url.Values{
+        "missing name":  {"name": {""}, "scope": {app.ScopeRead}, "expires_in_days": {"30"}},
+        "bad scope":     {"name": {"CI"}, "scope": {"admin"}, "expires_in_days": {"30"}},
+        "bad lifetime":  {"name": {"CI"}, "scope": {app.ScopeRead}, "expires_in_days": {"12"}},
+        "name too long": {"name": {strings.Repeat("a", 101)}, "scope": {app.ScopeRead}, "expires_in_days": {"30"}},
+    }
+
+    for name, form := range cases {
+        t.Run(name, func(t *testing.T) {
+            mockStore := new(MockSQLiteStore)
+            handler := &RealHandler{store: mockStore, templates: newTokensTestTemplates()}
+
+            mockStore.On("GetAPITokens", 1).Return([]app.APIToken{}, nil).Once()
+
+            req := httptest.NewRequest(http.MethodPost, "/settings/tokens", strings.NewReader(form.Encode()))
+            req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+            rr := httptest.NewRecorder()
+
+            handler.SubmitCreateAPIToken(rr, req, 1)
+
+            assert.Equal(t, http.StatusBadRequest, rr.Code)
+            mockStore.AssertNotCalled(t, "CreateAPIToken", mock.Anything, mock.Anything, mock.Anything, mock.Anything)
+        })
+    }
+}
+
+func TestRevokeAPIToken(t *testing.T) {
+    mockStore := new(MockSQLiteStore)
+    handler := &RealHandler{store: mockStore}
+
+    mockStore.On("DeleteAPIToken", 1, 9).Return(nil).Once()
+
+    req := httptest.NewRequest(http.MethodPost, "/settings/tokens/revoke", strings.NewReader("id=9"))
+    req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+    rr := httptest.NewRecorder()
+
+    handler.RevokeAPIToken(rr, req, 1)
+
+    assert.Equal(t, http.StatusSeeOther, rr.Code)
+    assert.Equal(t, "/settings/tokens", rr.Header().Get("Location"))
+    mockStore.AssertExpectations(t)
+}
+
+func TestRevokeOtherUsersAPIToken(t *testing.T) {
+    mockStore := new(MockSQLiteStore)
+    handler := &RealHandler{store: mockStore}
+
+    mockStore.On("DeleteAPIToken", 2, 9).Return(db.ErrAPITokenNotFound).Once()
+
+    req := httptest.NewRequest(http.MethodPost, "/settings/tokens/revoke", strings.NewReader("id=9"))
+    req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+    rr := httptest.NewRecorder()
+
+    handler.RevokeAPIToken(rr, req, 2)
+
+    assert.Equal(t, http.StatusNotFound, rr.Code)
+    mockStore.AssertExpectations(t)
+}
+
+func TestAccountRoutesRefuseBearerTokens(t *testing.T) {
+    // The store expects nothing: the request is refused before any token is looked up.
+    router := NewRouter(&RealHandler{store: new(MockSQLiteStore)})
+
+    routes := []struct{ method, url string }{
+        {http.MethodPost, "/logout/all"},
+        {http.MethodGet, "/settings/tokens"},
+        {http.MethodPost, "/settings/tokens"},
+        {http.MethodPost, "/settings/tokens/revoke"},
+        {http.MethodGet, "/settings/feed"},
+        {http.MethodPost, "/settings/feed"},
+        {http.MethodPost, "/settings/feed/revoke"},
+        {http.MethodGet, "/settings/timezone"},
+        {http.MethodPost, "/settings/timezone"},
+        {http.MethodGet, "/settings/tags"},
+        {http.MethodPost, "/settings/tags"},
+        {http.MethodPost, "/settings/tags/delete"},
+        {http.MethodGet, "/settings/2fa"},
+        {http.MethodPost, "/settings/2fa/setup"},
+        {http.MethodPost, "/settings/2fa/enable"},
+        {http.MethodPost, "/settings/2fa/disable"},
+    }
+    tokens := map[string]string{
+        "API token": db.APITokenPrefix + "secret",
+        "session":   "session-token",
+    }
+    for name, token := range tokens {
+        for _, route := range routes {
+            req := httptest.NewRequest(route.method, route.url, nil)
+            req.Header.Set("Authorization", "Bearer "+token)
+            rr := httptest.NewRecorder()
+
+            router.ServeHTTP(rr, req)
+
+            assert.Equal(t, http.StatusForbidden, rr.Code, name+" "+route.method+" "+route.url)
+            assert.Contains(t, rr.Body.String(), "log in to manage your account", name+" "+route.method+" "+route.url)
+        }
+    }
+}
+
+func TestHandleSessionProtectedAcceptsSessionCookie(t *testing.T) {
+    mockStore := new(MockSQLiteStore)
+    handler := &RealHandler{store: mockStore}
+
+    mockStore.On("GetUserIdFromSessionToken", "session-token").Return(4, nil).Once()
+
+    req := httptest.NewRequest(http.MethodGet, "/settings/tokens", nil)
+    req.AddCookie(&http.Cookie{Name: "session_token", Value: "session-token"})
+    rr := httptest.NewRecorder()
+
+    var gotUserId int
+    handler.HandleSessionProtected(rr, req, func(w http.ResponseWriter, r *http.Request, userId int) {
+        gotUserId = userId
+    })
+
+    assert.Equal(t, 4, gotUserId)
+    mockStore.AssertExpectations(t)
+}
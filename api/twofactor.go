@@ -0,0 +1,314 @@
+package api
+
+import (
+	"errors"
+	"html/template"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"penumbra/app"
+	"penumbra/db"
+	"penumbra/totp"
+)
+
+// Two-factor authentication uses TOTP codes from an authenticator app. When it's on, the right password alone doesn't log the user in: SubmitLogin creates a short-lived login challenge, identified by the `login_challenge` cookie, and the session is only started once SubmitLoginTwoFactor has checked a code against it. A recovery code can stand in for a TOTP code, once each.
+
+const (
+    loginChallengeCookieName = "login_challenge"
+    totpIssuer               = "Penumbra"
+    recoveryCodeCount        = 10
+)
+
+type LoginTwoFactorPage struct {
+    Error string
+}
+
+type TwoFactorPage struct {
+    Enabled           bool
+    Secret            string       // Shown while enrolling, for typing into the authenticator by hand.
+    URI               template.URL // The `otpauth://` URI for the same secret.
+    RecoveryCodes     []string     // Shown once, right after two-factor authentication is turned on.
+    RecoveryCodesLeft int
+    Error             string
+}
+
+func (h *RealHandler) startLoginChallenge(w http.ResponseWriter, r *http.Request, userId int) {
+    token, expiresAt, err := h.store.CreateLoginChallenge(userId)
+    if err != nil {
+        log.Println("Error creating login challenge: ", err)
+        http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+        return
+    }
+
+    http.SetCookie(w, &http.Cookie{
+        Name:     loginChallengeCookieName,
+        Value:    token,
+        Path:     "/login",
+        HttpOnly: true,
+        Secure:   false, // TODO: Set to true (https) in production.
+        SameSite: http.SameSiteLaxMode,
+        Expires:  expiresAt,
+    })
+
+    http.Redirect(w, r, "/login/2fa", http.StatusSeeOther)
+}
+
+func clearLoginChallengeCookie(w http.ResponseWriter) {
+    http.SetCookie(w, &http.Cookie{
+        Name:    loginChallengeCookieName,
+        Value:   "",
+        Path:    "/login",
+        Expires: time.Unix(0, 0),
+    })
+}
+
+// isTOTPCode reports whether the input looks like a TOTP code rather than a recovery code.
+func isTOTPCode(code string) bool {
+    code = strings.ReplaceAll(code, " ", "")
+    if len(code) != totp.Digits {
+        return false
+    }
+    for _, c := range code {
+        if c < '0' || c > '9' {
+            return false
+        }
+    }
+    return true
+}
+
+// checkSecondFactor accepts either a TOTP code that hasn't been used before or an unused recovery code, which is used up.
+func (h *RealHandler) checkSecondFactor(user app.User, code string) (bool, error) {
+    if !user.TOTPEnabled || user.TOTPSecret == "" {
+        return false, nil
+    }
+
+    if isTOTPCode(code) {
+        step, ok := totp.Validate(user.TOTPSecret, code, h.clock())
+        if !ok {
+            return false, nil
+        }
+        return h.store.RecordTOTPStep(user.Id, step)
+    }
+
+    normalized := totp.NormalizeRecoveryCode(code)
+    if normalized == "" {
+        return false, nil
+    }
+    return h.store.UseRecoveryCode(user.Id, normalized)
+}
+
+func (h *RealHandler) RenderLoginTwoFactor(w http.ResponseWriter, r *http.Request) {
+    if _, err := r.Cookie(loginChallengeCookieName); err != nil {
+        http.Redirect(w, r, "/login", http.StatusSeeOther)
+        return
+    }
+
+    h.RenderPage(w, r, "login2fa", LoginTwoFactorPage{})
+}
+
+func (h *RealHandler) SubmitLoginTwoFactor(w http.ResponseWriter, r *http.Request) {
+    cookie, err := r.Cookie(loginChallengeCookieName)
+    if err != nil {
+        http.Redirect(w, r, "/login", http.StatusSeeOther)
+        return
+    }
+
+    userId, err := h.store.GetLoginChallenge(cookie.Value)
+    if errors.Is(err, db.ErrLoginChallengeNotFound) {
+        clearLoginChallengeCookie(w)
+        http.Redirect(w, r, "/login", http.StatusSeeOther)
+        return
+    }
+    if err != nil {
+        log.Println("Error getting login challenge: ", err)
+        http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+        return
+    }
+
+    if ok, wait := h.allowAttempt(r, "2fa", strconv.Itoa(userId)); !ok {
+        tooManyRequests(w, wait)
+        return
+    }
+
+    user, err := h.store.GetUserById(userId)
+    if err != nil {
+        log.Println("Error getting user: ", err)
+        http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+        return
+    }
+
+    ok, err := h.checkSecondFactor(user, r.FormValue("code"))
+    if err != nil {
+        log.Println("Error checking two-factor code: ", err)
+        http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+        return
+    }
+    if !ok {
+        w.WriteHeader(http.StatusUnauthorized)
+        h.RenderPage(w, r, "login2fa", LoginTwoFactorPage{Error: "That code isn't valid. Try again."})
+        return
+    }
+
+    if err := h.store.DeleteLoginChallenge(cookie.Value); err != nil {
+        log.Println("Error deleting login challenge: ", err)
+        http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+        return
+    }
+    clearLoginChallengeCookie(w)
+
+    h.startSession(w, r, userId)
+}
+
+func (h *RealHandler) renderTwoFactor(w http.ResponseWriter, r *http.Request, user app.User, page TwoFactorPage) {
+    page.Enabled = user.TOTPEnabled
+    if user.TOTPEnabled {
+        count, err := h.store.CountRecoveryCodes(user.Id)
+        if err != nil {
+            log.Println("Error counting recovery codes: ", err)
+            http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+            return
+        }
+        page.RecoveryCodesLeft = count
+    } else if user.TOTPSecret != "" {
+        page.Secret = user.TOTPSecret
+        page.URI = template.URL(totp.URI(totpIssuer, user.Email, user.TOTPSecret))
+    }
+
+    h.RenderPage(w, r, "twofactor", page)
+}
+
+// userOr500 fetches the user, writing a 500 response if that fails.
+func (h *RealHandler) userOr500(w http.ResponseWriter, userId int) (app.User, bool) {
+    user, err := h.store.GetUserById(userId)
+    if err != nil {
+        log.Println("Error getting user: ", err)
+        http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+        return app.User{}, false
+    }
+    return user, true
+}
+
+func (h *RealHandler) RenderTwoFactor(w http.ResponseWriter, r *http.Request, userId int) {
+    user, ok := h.userOr500(w, userId)
+    if !ok {
+        return
+    }
+
+    h.renderTwoFactor(w, r, user, TwoFactorPage{})
+}
+
+// SubmitTwoFactorSetup starts enrolment by generating a new secret. Two-factor authentication isn't turned on until the user confirms a code from it.
+func (h *RealHandler) SubmitTwoFactorSetup(w http.ResponseWriter, r *http.Request, userId int) {
+    user, ok := h.userOr500(w, userId)
+    if !ok {
+        return
+    }
+    if user.TOTPEnabled {
+        http.Redirect(w, r, "/settings/2fa", http.StatusSeeOther)
+        return
+    }
+
+    secret, err := totp.GenerateSecret()
+    if err != nil {
+        log.Println("Error generating TOTP secret: ", err)
+        http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+        return
+    }
+
+    if err := h.store.SetPendingTOTPSecret(userId, secret); err != nil {
+        log.Println("Error storing TOTP secret: ", err)
+        http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+        return
+    }
+
+    http.Redirect(w, r, "/settings/2fa", http.StatusSeeOther)
+}
+
+func (h *RealHandler) SubmitTwoFactorEnable(w http.ResponseWriter, r *http.Request, userId int) {
+    user, ok := h.userOr500(w, userId)
+    if !ok {
+        return
+    }
+    if user.TOTPEnabled || user.TOTPSecret == "" {
+        http.Redirect(w, r, "/settings/2fa", http.StatusSeeOther)
+        return
+    }
+
+    if ok, wait := h.allowAttempt(r, "2fa", strconv.Itoa(userId)); !ok {
+        tooManyRequests(w, wait)
+        return
+    }
+
+    step, ok := totp.Validate(user.TOTPSecret, r.FormValue("code"), h.clock())
+    if !ok {
+        w.WriteHeader(http.StatusBadRequest)
+        h.renderTwoFactor(w, r, user, TwoFactorPage{Error: "That code doesn't match. Check that your device's clock is right and try again."})
+        return
+    }
+
+    if _, err := h.store.RecordTOTPStep(userId, step); err != nil {
+        log.Println("Error recording TOTP step: ", err)
+        http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+        return
+    }
+
+    codes, err := totp.GenerateRecoveryCodes(recoveryCodeCount)
+    if err != nil {
+        log.Println("Error generating recovery codes: ", err)
+        http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+        return
+    }
+    normalized := make([]string, len(codes))
+    for i, code := range codes {
+        normalized[i] = totp.NormalizeRecoveryCode(code)
+    }
+
+    if err := h.store.EnableTOTP(userId, normalized); err != nil {
+        log.Println("Error enabling TOTP: ", err)
+        http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+        return
+    }
+
+    user.TOTPEnabled = true
+    h.renderTwoFactor(w, r, user, TwoFactorPage{RecoveryCodes: codes})
+}
+
+// SubmitTwoFactorDisable turns two-factor authentication off. It asks for a current code, or a recovery code, so that someone who has only borrowed a logged-in browser can't do it.
+func (h *RealHandler) SubmitTwoFactorDisable(w http.ResponseWriter, r *http.Request, userId int) {
+    user, ok := h.userOr500(w, userId)
+    if !ok {
+        return
+    }
+    if !user.TOTPEnabled {
+        http.Redirect(w, r, "/settings/2fa", http.StatusSeeOther)
+        return
+    }
+
+    if ok, wait := h.allowAttempt(r, "2fa", strconv.Itoa(userId)); !ok {
+        tooManyRequests(w, wait)
+        return
+    }
+
+    ok, err := h.checkSecondFactor(user, r.FormValue("code"))
+    if err != nil {
+        log.Println("Error checking two-factor code: ", err)
+        http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+        return
+    }
+    if !ok {
+        w.WriteHeader(http.StatusBadRequest)
+        h.renderTwoFactor(w, r, user, TwoFactorPage{Error: "That code isn't valid. Try again."})
+        return
+    }
+
+    if err := h.store.DisableTOTP(userId); err != nil {
+        log.Println("Error disabling TOTP: ", err)
+        http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+        return
+    }
+
+    http.Redirect(w, r, "/settings/2fa", http.StatusSeeOther)
+}
@@ -0,0 +1,316 @@
+package api
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"golang.org/x/crypto/bcrypt"
+
+	"penumbra/app"
+	"penumbra/db"
+	"penumbra/totp"
+)
+
+const testTOTPSecret = "JBSWY3DPEHPK3PXP"
+
+var testTOTPNow = time.Date(2030, 1, 2, 15, 4, 5, 0, time.UTC)
+
+func newTwoFactorTestHandler(store db.Store) *RealHandler {
+    h := newTestHandler(store)
+    h.now = func() time.Time { return testTOTPNow }
+    return h
+}
+
+func twoFactorRequest(target string, code string) *http.Request {
+    form := url.Values{"code": {code}}
+    req := httptest.NewRequest(http.MethodPost, target, strings.NewReader(form.Encode()))
+    req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+    req.AddCookie(&http.Cookie{Name: loginChallengeCookieName, Value: "challenge-token"})
+    return req
+}
+
+func findCookie(rr *httptest.ResponseRecorder, name string) *http.Cookie {
+    for _, c := range rr.Result().Cookies() {
+        if c.Name == name {
+            return c
+        }
+    }
+    return nil
+}
+
+func TestSubmitLoginWithTOTPStartsChallenge(t *testing.T) {
+    passwordHash, err := bcrypt.GenerateFromPassword([]byte("password123"), bcrypt.MinCost)
+    if err != nil {
+        t.Fatalf("Failed to generate password hash: %v", err)
+    }
+
+    mockStore := new(MockSQLiteStore)
+    handler := newTwoFactorTestHandler(mockStore)
+
+    user := app.User{Id: 1, PasswordHash: passwordHash, EmailVerified: true, TOTPSecret: testTOTPSecret, TOTPEnabled: true}
+    mockStore.On("GetUserByEmail", "test@example.com").Return(user, nil).Once()
+    mockStore.On("ResetFailedLogins", 1).Return(nil).Once()
+    mockStore.On("CreateLoginChallenge", 1).Return("challenge-token", time.Now().Add(5*time.Minute), nil).Once()
+
+    rr := httptest.NewRecorder()
+    handler.SubmitLogin(rr, loginRequest("test@example.com", "password123"))
+
+    assert.Equal(t, http.StatusSeeOther, rr.Code)
+    assert.Equal(t, "/login/2fa", rr.Header().Get("Location"))
+    if cookie := findCookie(rr, loginChallengeCookieName); assert.NotNil(t, cookie) {
+        assert.Equal(t, "challenge-token", cookie.Value)
+        assert.True(t, cookie.HttpOnly)
+    }
+    assert.Nil(t, findCookie(rr, "session_token"), "no session until the second factor is checked")
+    mockStore.AssertNotCalled(t, "AddSessionToken", mock.Anything, mock.Anything, mock.Anything)
+    mockStore.AssertExpectations(t)
+}
+
+func TestSubmitLoginTwoFactor(t *testing.T) {
+    mockStore := new(MockSQLiteStore)
+    handler := newTwoFactorTestHandler(mockStore)
+
+    code, err := totp.Code(testTOTPSecret, testTOTPNow)
+    assert.NoError(t, err)
+
+    mockStore.On("GetLoginChallenge", "challenge-token").Return(1, nil).Once()
+    mockStore.On("GetUserById", 1).Return(app.User{Id: 1, TOTPSecret: testTOTPSecret, TOTPEnabled: true}, nil).Once()
+    mockStore.On("RecordTOTPStep", 1, totp.Step(testTOTPNow)).Return(true, nil).Once()
+    mockStore.On("DeleteLoginChallenge", "challenge-token").Return(nil).Once()
+    mockStore.On("AddSessionToken", 1, mock.Anything, mock.Anything).Return("session-token", time.Now().Add(time.Hour), nil).Once()
+
+    rr := httptest.NewRecorder()
+    handler.SubmitLoginTwoFactor(rr, twoFactorRequest("/login/2fa", code))
+
+    assert.Equal(t, http.StatusSeeOther, rr.Code)
+    assert.Equal(t, "/dashboard", rr.Header().Get("Location"))
+    if cookie := findCookie(rr, "session_token"); assert.NotNil(t, cookie) {
+        assert.Equal(t, "session-token", cookie.Value)
+    }
+    if cookie := findCookie(rr, loginChallengeCookieName); assert.NotNil(t, cookie) {
+        assert.Empty(t, cookie.Value, "the challenge cookie should be cleared")
+    }
+    mockStore.AssertExpectations(t)
+}
+
+func TestSubmitLoginTwoFactorRefused(t *testing.T) {
+    code, err := totp.Code(testTOTPSecret, testTOTPNow)
+    assert.NoError(t, err)
+    staleCode, err := totp.Code(testTOTPSecret, testTOTPNow.Add(-5*time.Minute))
+    assert.NoError(t, err)
+
+    cases := map[string]struct {
+        code   string
+        expect func(*MockSQLiteStore)
+    }{
+        "wrong code": {"000000", func(*MockSQLiteStore) {}},
+        "stale code": {staleCode, func(*MockSQLiteStore) {}},
+        "replayed code": {code, func(m *MockSQLiteStore) {
+            m.On("RecordTOTPStep", 1, totp.Step(testTOTPNow)).Return(false, nil).Once()
+        }},
+        "used recovery code": {"abcde-fghjk", func(m *MockSQLiteStore) {
+            m.On("UseRecoveryCode", 1, "abcdefghjk").Return(false, nil).Once()
+        }},
+    }
+
+    for name, tc := range cases {
+        t.Run(name, func(t *testing.T) {
+            mockStore := new(MockSQLiteStore)
+            handler := newTwoFactorTestHandler(mockStore)
+
+            mockStore.On("GetLoginChallenge", "challenge-token").Return(1, nil).Once()
+            mockStore.On("GetUserById", 1).Return(app.User{Id: 1, TOTPSecret: testTOTPSecret, TOTPEnabled: true}, nil).Once()
+            tc.expect(mockStore)
+
+            rr := httptest.NewRecorder()
+            handler.SubmitLoginTwoFactor(rr, twoFactorRequest("/login/2fa", tc.code))
+
+            assert.Equal(t, http.StatusUnauthorized, rr.Code)
+            assert.Contains(t, rr.Body.String(), "login2fa|")
+            assert.Nil(t, findCookie(rr, "session_token"))
+            mockStore.AssertNotCalled(t, "AddSessionToken", mock.Anything, mock.Anything, mock.Anything)
+            mockStore.AssertExpectations(t)
+        })
+    }
+}
+
+func TestSubmitLoginTwoFactorRecoveryCode(t *testing.T) {
+    mockStore := new(MockSQLiteStore)
+    handler := newTwoFactorTestHandler(mockStore)
+
+    mockStore.On("GetLoginChallenge", "challenge-token").Return(1, nil).Once()
+    mockStore.On("GetUserById", 1).Return(app.User{Id: 1, TOTPSecret: testTOTPSecret, TOTPEnabled: true}, nil).Once()
+    mockStore.On("UseRecoveryCode", 1, "abcdefghjk").Return(true, nil).Once()
+    mockStore.On("DeleteLoginChallenge", "challenge-token").Return(nil).Once()
+    mockStore.On("AddSessionToken", 1, mock.Anything, mock.Anything).Return("session-token", time.Now().Add(time.Hour), nil).Once()
+
+    rr := httptest.NewRecorder()
+    handler.SubmitLoginTwoFactor(rr, twoFactorRequest("/login/2fa", "ABCDE-FGHJK"))
+
+    assert.Equal(t, http.StatusSeeOther, rr.Code)
+    assert.Equal(t, "/dashboard", rr.Header().Get("Location"))
+    mockStore.AssertExpectations(t)
+}
+
+func TestSubmitLoginTwoFactorExpiredChallenge(t *testing.T) {
+    mockStore := new(MockSQLiteStore)
+    handler := newTwoFactorTestHandler(mockStore)
+
+    mockStore.On("GetLoginChallenge", "challenge-token").Return(0, db.ErrLoginChallengeNotFound).Once()
+
+    rr := httptest.NewRecorder()
+    handler.SubmitLoginTwoFactor(rr, twoFactorRequest("/login/2fa", "123456"))
+
+    assert.Equal(t, http.StatusSeeOther, rr.Code)
+    assert.Equal(t, "/login", rr.Header().Get("Location"))
+    mockStore.AssertNotCalled(t, "GetUserById", mock.Anything)
+}
+
+func TestSubmitLoginTwoFactorRateLimited(t *testing.T) {
+    mockStore := new(MockSQLiteStore)
+    handler := newTwoFactorTestHandler(mockStore)
+    handler.emailLimiter, _ = newTestLimiter(1, time.Minute)
+
+    mockStore.On("GetLoginChallenge", "challenge-token").Return(1, nil)
+    mockStore.On("GetUserById", 1).Return(app.User{Id: 1, TOTPSecret: testTOTPSecret, TOTPEnabled: true}, nil).Once()
+
+    for _, want := range []int{http.StatusUnauthorized, http.StatusTooManyRequests} {
+        rr := httptest.NewRecorder()
+        handler.SubmitLoginTwoFactor(rr, twoFactorRequest("/login/2fa", "000000"))
+        assert.Equal(t, want, rr.Code)
+    }
+}
+
+func TestSubmitTwoFactorSetup(t *testing.T) {
+    mockStore := new(MockSQLiteStore)
+    handler := newTwoFactorTestHandler(mockStore)
+
+    mockStore.On("GetUserById", 1).Return(app.User{Id: 1}, nil).Once()
+    mockStore.On("SetPendingTOTPSecret", 1, mock.MatchedBy(func(secret string) bool {
+        _, err := totp.Code(secret, testTOTPNow)
+        return err == nil && len(secret) == 32
+    })).Return(nil).Once()
+
+    rr := httptest.NewRecorder()
+    handler.SubmitTwoFactorSetup(rr, httptest.NewRequest(http.MethodPost, "/settings/2fa/setup", nil), 1)
+
+    assert.Equal(t, http.StatusSeeOther, rr.Code)
+    assert.Equal(t, "/settings/2fa", rr.Header().Get("Location"))
+    mockStore.AssertExpectations(t)
+}
+
+func TestRenderTwoFactorPending(t *testing.T) {
+    mockStore := new(MockSQLiteStore)
+    handler := newTwoFactorTestHandler(mockStore)
+
+    mockStore.On("GetUserById", 1).Return(app.User{Id: 1, Email: "bob@example.com", TOTPSecret: testTOTPSecret}, nil).Once()
+
+    rr := httptest.NewRecorder()
+    handler.RenderTwoFactor(rr, httptest.NewRequest(http.MethodGet, "/settings/2fa", nil), 1)
+
+    assert.Equal(t, http.StatusOK, rr.Code)
+    assert.Contains(t, rr.Body.String(), "Secret:"+testTOTPSecret)
+    assert.Contains(t, rr.Body.String(), "otpauth://totp/Penumbra:bob@example.com?")
+}
+
+func TestSubmitTwoFactorEnable(t *testing.T) {
+    mockStore := new(MockSQLiteStore)
+    handler := newTwoFactorTestHandler(mockStore)
+
+    code, err := totp.Code(testTOTPSecret, testTOTPNow)
+    assert.NoError(t, err)
+
+    mockStore.On("GetUserById", 1).Return(app.User{Id: 1, TOTPSecret: testTOTPSecret}, nil).Once()
+    mockStore.On("RecordTOTPStep", 1, totp.Step(testTOTPNow)).Return(true, nil).Once()
+    mockStore.On("EnableTOTP", 1, mock.MatchedBy(func(codes []string) bool {
+        return len(codes) == recoveryCodeCount && !strings.Contains(codes[0], "-")
+    })).Return(nil).Once()
+    mockStore.On("CountRecoveryCodes", 1).Return(recoveryCodeCount, nil).Once()
+
+    rr := httptest.NewRecorder()
+    handler.SubmitTwoFactorEnable(rr, twoFactorRequest("/settings/2fa/enable", code), 1)
+
+    assert.Equal(t, http.StatusOK, rr.Code)
+    assert.Contains(t, rr.Body.String(), "Enabled:true")
+    assert.Regexp(t, `RecoveryCodes:\[[a-z2-9]{5}-[a-z2-9]{5} `, rr.Body.String())
+    mockStore.AssertExpectations(t)
+}
+
+func TestSubmitTwoFactorEnableWrongCode(t *testing.T) {
+    mockStore := new(MockSQLiteStore)
+    handler := newTwoFactorTestHandler(mockStore)
+
+    mockStore.On("GetUserById", 1).Return(app.User{Id: 1, TOTPSecret: testTOTPSecret}, nil).Once()
+
+    rr := httptest.NewRecorder()
+    handler.SubmitTwoFactorEnable(rr, twoFactorRequest("/settings/2fa/enable", "000000"), 1)
+
+    assert.Equal(t, http.StatusBadRequest, rr.Code)
+    mockStore.AssertNotCalled(t, "EnableTOTP", mock.Anything, mock.Anything)
+}
+
+func TestSubmitTwoFactorDisable(t *testing.T) {
+    mockStore := new(MockSQLiteStore)
+    handler := newTwoFactorTestHandler(mockStore)
+
+    code, err := totp.Code(testTOTPSecret, testTOTPNow)
+    assert.NoError(t, err)
+
+    mockStore.On("GetUserById", 1).Return(app.User{Id: 1, TOTPSecret: testTOTPSecret, TOTPEnabled: true}, nil).Once()
+    mockStore.On("RecordTOTPStep", 1, totp.Step(testTOTPNow)).Return(true, nil).Once()
+    mockStore.On("DisableTOTP", 1).Return(nil).Once()
+
+    rr := httptest.NewRecorder()
+    handler.SubmitTwoFactorDisable(rr, twoFactorRequest("/settings/2fa/disable", code), 1)
+
+    assert.Equal(t, http.StatusSeeOther, rr.Code)
+    mockStore.AssertExpectations(t)
+}
+
+func TestAPILoginWithTOTP(t *testing.T) {
+    passwordHash, err := bcrypt.GenerateFromPassword([]byte("password123"), bcrypt.MinCost)
+    if err != nil {
+        t.Fatalf("Failed to generate password hash: %v", err)
+    }
+    code, err := totp.Code(testTOTPSecret, testTOTPNow)
+    assert.NoError(t, err)
+
+    user := app.User{Id: 1, PasswordHash: passwordHash, EmailVerified: true, TOTPSecret: testTOTPSecret, TOTPEnabled: true}
+
+    t.Run("missing code", func(t *testing.T) {
+        mockStore := new(MockSQLiteStore)
+        handler := newTwoFactorTestHandler(mockStore)
+        mockStore.On("GetUserByEmail", "test@example.com").Return(user, nil).Once()
+        mockStore.On("ResetFailedLogins", 1).Return(nil).Once()
+
+        body := `{"email": "test@example.com", "password": "password123"}`
+        rr := httptest.NewRecorder()
+        handler.APILogin(rr, httptest.NewRequest(http.MethodPost, "/api/v1/login", strings.NewReader(body)))
+
+        assert.Equal(t, http.StatusUnauthorized, rr.Code)
+        assert.Contains(t, rr.Body.String(), "two-factor code required")
+        mockStore.AssertNotCalled(t, "AddSessionToken", mock.Anything, mock.Anything, mock.Anything)
+    })
+
+    t.Run("valid code", func(t *testing.T) {
+        mockStore := new(MockSQLiteStore)
+        handler := newTwoFactorTestHandler(mockStore)
+        mockStore.On("GetUserByEmail", "test@example.com").Return(user, nil).Once()
+        mockStore.On("ResetFailedLogins", 1).Return(nil).Once()
+        mockStore.On("RecordTOTPStep", 1, totp.Step(testTOTPNow)).Return(true, nil).Once()
+        mockStore.On("AddSessionToken", 1, mock.Anything, mock.Anything).Return("session-token", time.Now().Add(time.Hour), nil).Once()
+
+        body := `{"email": "test@example.com", "password": "password123", "code": "` + code + `"}`
+        rr := httptest.NewRecorder()
+        handler.APILogin(rr, httptest.NewRequest(http.MethodPost, "/api/v1/login", strings.NewReader(body)))
+
+        assert.Equal(t, http.StatusOK, rr.Code)
+        mockStore.AssertExpectations(t)
+    })
+}
@@ -0,0 +1,363 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+
+	"penumbra/app"
+	"penumbra/db"
+	"penumbra/validate"
+)
+
+// The handlers in this file serve the JSON API under `/api/v1/`. Unlike the HTML handlers, they never redirect: failures are reported with a status code and a JSON body of the form `{"error": "..."}`, and clients authenticate with an `Authorization: Bearer` header, carrying either a personal API token or a session token, rather than a cookie.
+
+type apiError struct {
+    Error  string            `json:"error"`
+    Fields validate.Errors `json:"fields,omitempty"` // Problems with individual fields, when the body failed validation.
+}
+
+type apiLoginRequest struct {
+    Email    string `json:"email"`
+    Password string `json:"password"`
+    Code     string `json:"code,omitempty"` // A TOTP or recovery code, required if the user has two-factor authentication on.
+}
+
+type apiLoginResponse struct {
+    Token     string    `json:"token"`
+    ExpiresAt time.Time `json:"expiresAt"`
+}
+
+// apiTaskPatch holds the fields of a task that a PATCH request may change. Fields left out of the request body stay nil and are left as they are.
+type apiTaskPatch struct {
+    Title       *string    `json:"title"`
+    Description *string    `json:"description"`
+    Done        *int       `json:"done"`
+    Due         *time.Time `json:"due"`
+    Recurrence  *string    `json:"recurrence"` // An empty string stops the task repeating.
+    ProjectId   *int       `json:"projectId"`  // Zero takes the task out of its project.
+    Priority    *app.Priority `json:"priority"`
+    AllDay      *bool      `json:"allDay"` // True makes the task due by the end of its due date, in the user's time zone.
+}
+
+// endOfDueDay returns the last moment, in UTC, of the day the task is due on in its owner's time zone, which is when a task due by the end of a day falls due.
+func endOfDueDay(task app.Task) time.Time {
+    return app.EndOfDay(task.Due.In(app.Location(task.TimeZone))).UTC()
+}
+
+func writeJSON(w http.ResponseWriter, status int, v any) {
+    w.Header().Set("Content-Type", "application/json")
+    w.WriteHeader(status)
+    if err := json.NewEncoder(w).Encode(v); err != nil {
+        log.Println("Error encoding JSON: ", err)
+    }
+}
+
+func writeJSONError(w http.ResponseWriter, status int, message string) {
+    writeJSON(w, status, apiError{Error: message})
+}
+
+func writeJSONValidationError(w http.ResponseWriter, errs validate.Errors) {
+    writeJSON(w, http.StatusBadRequest, apiError{Error: "invalid task: " + errs.Error(), Fields: errs})
+}
+
+// apiTaskError is the JSON counterpart of taskError.
+func apiTaskError(w http.ResponseWriter, err error) {
+    if errors.Is(err, db.ErrTaskNotFound) {
+        writeJSONError(w, http.StatusNotFound, "task not found")
+        return
+    }
+    if errors.Is(err, db.ErrProjectNotFound) {
+        writeJSONError(w, http.StatusBadRequest, "project not found")
+        return
+    }
+    log.Println("Error accessing task: ", err)
+    writeJSONError(w, http.StatusInternalServerError, "internal server error")
+}
+
+// bearerToken returns the token from an `Authorization: Bearer <token>` header, or the empty string if there isn't one.
+func bearerToken(r *http.Request) string {
+    scheme, token, found := strings.Cut(r.Header.Get("Authorization"), " ")
+    if !found || !strings.EqualFold(scheme, "Bearer") {
+        return ""
+    }
+    return strings.TrimSpace(token)
+}
+
+func decodeJSONBody(w http.ResponseWriter, r *http.Request, v any) bool {
+    decoder := json.NewDecoder(http.MaxBytesReader(w, r.Body, 1<<20))
+    decoder.DisallowUnknownFields()
+    if err := decoder.Decode(v); err != nil {
+        writeJSONError(w, http.StatusBadRequest, "invalid request body: "+err.Error())
+        return false
+    }
+    return true
+}
+
+func (h *RealHandler) HandleAPIProtected(w http.ResponseWriter, r *http.Request, handler func(http.ResponseWriter, *http.Request, int)) {
+    token := bearerToken(r)
+    if token == "" {
+        w.Header().Set("WWW-Authenticate", `Bearer realm="penumbra"`)
+        writeJSONError(w, http.StatusUnauthorized, "missing bearer token")
+        return
+    }
+
+    userId, err := h.authenticateBearer(r, token)
+    if errors.Is(err, errReadOnlyToken) {
+        w.Header().Set("WWW-Authenticate", `Bearer realm="penumbra", error="insufficient_scope"`)
+        writeJSONError(w, http.StatusForbidden, "API token is read-only")
+        return
+    }
+    if err != nil {
+        log.Println("Error authenticating request: ", err)
+        w.Header().Set("WWW-Authenticate", `Bearer realm="penumbra", error="invalid_token"`)
+        writeJSONError(w, http.StatusUnauthorized, "invalid or expired token")
+        return
+    }
+
+    handler(w, r, userId)
+}
+
+func (h *RealHandler) HandleAPIProtectedWithTaskId(w http.ResponseWriter, r *http.Request, handler func(http.ResponseWriter, *http.Request, int, uuid.UUID), idString string) {
+    h.HandleAPIProtected(w, r, func(w http.ResponseWriter, r *http.Request, userId int) {
+        taskId, err := uuid.Parse(idString)
+        if err != nil {
+            writeJSONError(w, http.StatusNotFound, "task not found")
+            return
+        }
+
+        handler(w, r, userId, taskId)
+    })
+}
+
+// APILogin exchanges an email and password for a bearer token.
+func (h *RealHandler) APILogin(w http.ResponseWriter, r *http.Request) {
+    var body apiLoginRequest
+    if !decodeJSONBody(w, r, &body) {
+        return
+    }
+
+    if ok, wait := h.allowAttempt(r, "login", body.Email); !ok {
+        tooManyRequestsJSON(w, wait)
+        return
+    }
+
+    user, err := h.store.GetUserByEmail(body.Email)
+    if err != nil {
+        log.Println("Error getting user: ", err)
+        writeJSONError(w, http.StatusUnauthorized, "invalid email or password")
+        return
+    }
+
+    ok, wait, err := h.checkPassword(user, body.Password)
+    if err != nil {
+        log.Println("Error checking password: ", err)
+        writeJSONError(w, http.StatusInternalServerError, "internal server error")
+        return
+    }
+    if wait > 0 {
+        tooManyRequestsJSON(w, wait)
+        return
+    }
+    if !ok {
+        writeJSONError(w, http.StatusUnauthorized, "invalid email or password")
+        return
+    }
+
+    if !user.EmailVerified {
+        writeJSONError(w, http.StatusForbidden, "email address not verified")
+        return
+    }
+
+    if user.TOTPEnabled {
+        if body.Code == "" {
+            writeJSONError(w, http.StatusUnauthorized, "two-factor code required")
+            return
+        }
+        ok, err := h.checkSecondFactor(user, body.Code)
+        if err != nil {
+            log.Println("Error checking two-factor code: ", err)
+            writeJSONError(w, http.StatusInternalServerError, "internal server error")
+            return
+        }
+        if !ok {
+            writeJSONError(w, http.StatusUnauthorized, "invalid two-factor code")
+            return
+        }
+    }
+
+    token, expiresAt, err := h.store.AddSessionToken(user.Id, r.UserAgent(), clientIP(r))
+    if err != nil {
+        log.Println("Error adding session: ", err)
+        writeJSONError(w, http.StatusInternalServerError, "internal server error")
+        return
+    }
+
+    writeJSON(w, http.StatusOK, apiLoginResponse{Token: token, ExpiresAt: expiresAt})
+}
+
+// APIListTasks lists the user's tasks, only those with every `tag` in the query string if any are given, in the order given by `sort` and `order`.
+func (h *RealHandler) APIListTasks(w http.ResponseWriter, r *http.Request, userId int) {
+    form, order, ok := parseSortForm(r.URL.Query())
+    if !ok {
+        writeJSONError(w, http.StatusBadRequest, "invalid sort")
+        return
+    }
+    tasks, err := h.store.QueryTasks(userId, db.TaskQuery{Tags: form.Tags, Order: order})
+    if err != nil {
+        log.Println("Error getting tasks: ", err)
+        writeJSONError(w, http.StatusInternalServerError, "internal server error")
+        return
+    }
+
+    writeJSON(w, http.StatusOK, tasks)
+}
+
+func (h *RealHandler) APICreateTask(w http.ResponseWriter, r *http.Request, userId int) {
+    var body app.Task
+    if !decodeJSONBody(w, r, &body) {
+        return
+    }
+
+    task := app.Task{
+        Id:          uuid.New(),
+        UserId:      userId,
+        Title:       strings.TrimSpace(body.Title),
+        Description: body.Description,
+        Done:        body.Done,
+        Due:         body.Due.UTC(),
+        Recurrence:  normalizeRecurrence(body.Recurrence),
+        ProjectId:   body.ProjectId,
+        Priority:    body.Priority,
+        CreatedAt:   time.Now().UTC(),
+        AllDay:      body.AllDay,
+    }
+    if task.AllDay {
+        user, err := h.store.GetUserById(userId)
+        if err != nil {
+            log.Println("Error getting user: ", err)
+            writeJSONError(w, http.StatusInternalServerError, "internal server error")
+            return
+        }
+        task.TimeZone = user.TimeZone
+        task.Due = endOfDueDay(task)
+    }
+
+    if errs := validate.Task(task, time.Now()); len(errs) > 0 {
+        writeJSONValidationError(w, errs)
+        return
+    }
+
+    if err := h.store.CreateTask(task); err != nil {
+        apiTaskError(w, err)
+        return
+    }
+
+    task.SetStatus()
+    w.Header().Set("Location", "/api/v1/tasks/"+task.Id.String())
+    writeJSON(w, http.StatusCreated, task)
+}
+
+func (h *RealHandler) APIGetTask(w http.ResponseWriter, r *http.Request, userId int, id uuid.UUID) {
+    task, err := h.store.GetTaskById(userId, id)
+    if err != nil {
+        apiTaskError(w, err)
+        return
+    }
+
+    writeJSON(w, http.StatusOK, task)
+}
+
+func (h *RealHandler) APIPatchTask(w http.ResponseWriter, r *http.Request, userId int, id uuid.UUID) {
+    var patch apiTaskPatch
+    if !decodeJSONBody(w, r, &patch) {
+        return
+    }
+
+    task, err := h.store.GetTaskById(userId, id)
+    if err != nil {
+        apiTaskError(w, err)
+        return
+    }
+
+    if patch.Title != nil {
+        task.Title = strings.TrimSpace(*patch.Title)
+    }
+    if patch.Description != nil {
+        task.Description = *patch.Description
+    }
+    if patch.Done != nil {
+        task.Done = *patch.Done
+    }
+    if patch.Due != nil {
+        task.Due = patch.Due.UTC()
+    }
+    if patch.Recurrence != nil {
+        task.Recurrence = normalizeRecurrence(*patch.Recurrence)
+    }
+    if patch.ProjectId != nil {
+        task.ProjectId = *patch.ProjectId
+    }
+    if patch.Priority != nil {
+        task.Priority = *patch.Priority
+    }
+    if patch.AllDay != nil {
+        task.AllDay = *patch.AllDay
+    }
+    if task.AllDay && (patch.AllDay != nil || patch.Due != nil) {
+        task.Due = endOfDueDay(task)
+    }
+
+    if errs := validate.Task(task, time.Now()); len(errs) > 0 {
+        writeJSONValidationError(w, errs)
+        return
+    }
+
+    if err := h.store.UpdateTask(task); err != nil {
+        apiTaskError(w, err)
+        return
+    }
+
+    // Fetch the task again, since becoming done moves a recurring task's rule to its next occurrence.
+    task, err = h.store.GetTaskById(userId, id)
+    if err != nil {
+        apiTaskError(w, err)
+        return
+    }
+
+    writeJSON(w, http.StatusOK, task)
+}
+
+func (h *RealHandler) APIDeleteTask(w http.ResponseWriter, r *http.Request, userId int, id uuid.UUID) {
+    if err := h.store.DeleteTask(userId, id); err != nil {
+        apiTaskError(w, err)
+        return
+    }
+
+    w.WriteHeader(http.StatusNoContent)
+}
+
+// APISetTaskDone marks a task as done on POST and as not done on DELETE. When a recurring task is done, a `Link` header points to its next occurrence.
+func (h *RealHandler) APISetTaskDone(w http.ResponseWriter, r *http.Request, userId int, id uuid.UUID) {
+    next, err := h.store.SetTaskDone(userId, id, r.Method == http.MethodPost)
+    if err != nil {
+        apiTaskError(w, err)
+        return
+    }
+    if next.Id != uuid.Nil {
+        w.Header().Set("Link", "</api/v1/tasks/"+next.Id.String()+`>; rel="next"`)
+    }
+
+    task, err := h.store.GetTaskById(userId, id)
+    if err != nil {
+        apiTaskError(w, err)
+        return
+    }
+
+    writeJSON(w, http.StatusOK, task)
+}
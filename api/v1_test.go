@@ -0,0 +1,378 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"golang.org/x/crypto/bcrypt"
+
+	"penumbra/app"
+	"penumbra/db"
+	"penumbra/validate"
+)
+
+func TestHandleAPIProtectedMissingToken(t *testing.T) {
+    mockStore := new(MockSQLiteStore)
+    handler := &RealHandler{store: mockStore}
+
+    req := httptest.NewRequest(http.MethodGet, "/api/v1/tasks", nil)
+    rr := httptest.NewRecorder()
+
+    called := false
+    handler.HandleAPIProtected(rr, req, func(w http.ResponseWriter, r *http.Request, userId int) {
+        called = true
+    })
+
+    assert.False(t, called)
+    assert.Equal(t, http.StatusUnauthorized, rr.Code)
+    assert.Equal(t, "application/json", rr.Header().Get("Content-Type"))
+    assert.Contains(t, rr.Header().Get("WWW-Authenticate"), "Bearer")
+    assert.Empty(t, rr.Header().Get("Location"))
+}
+
+func TestHandleAPIProtectedIgnoresCookie(t *testing.T) {
+    mockStore := new(MockSQLiteStore)
+    handler := &RealHandler{store: mockStore}
+
+    req := httptest.NewRequest(http.MethodGet, "/api/v1/tasks", nil)
+    req.AddCookie(&http.Cookie{Name: "session_token", Value: "session-token"})
+    rr := httptest.NewRecorder()
+
+    handler.HandleAPIProtected(rr, req, func(w http.ResponseWriter, r *http.Request, userId int) {
+        t.Fatal("handler should not be called")
+    })
+
+    assert.Equal(t, http.StatusUnauthorized, rr.Code)
+    mockStore.AssertNotCalled(t, "GetUserIdFromSessionToken", mock.Anything)
+}
+
+func TestHandleAPIProtectedValidToken(t *testing.T) {
+    mockStore := new(MockSQLiteStore)
+    handler := &RealHandler{store: mockStore}
+
+    mockStore.On("GetUserIdFromSessionToken", "session-token").Return(4, nil).Once()
+
+    req := httptest.NewRequest(http.MethodGet, "/api/v1/tasks", nil)
+    req.Header.Set("Authorization", "Bearer session-token")
+    rr := httptest.NewRecorder()
+
+    var gotUserId int
+    handler.HandleAPIProtected(rr, req, func(w http.ResponseWriter, r *http.Request, userId int) {
+        gotUserId = userId
+    })
+
+    assert.Equal(t, 4, gotUserId)
+    mockStore.AssertExpectations(t)
+}
+
+func TestAPILogin(t *testing.T) {
+    passwordHash, err := bcrypt.GenerateFromPassword([]byte("password123"), bcrypt.MinCost)
+    if err != nil {
+        t.Fatalf("Failed to generate password hash: %v", err)
+    }
+
+    mockStore := new(MockSQLiteStore)
+    handler := &RealHandler{store: mockStore}
+
+    expiresAt := time.Now().Add(time.Hour).UTC()
+    mockStore.On("GetUserByEmail", "test@example.com").Return(app.User{Id: 1, PasswordHash: passwordHash, EmailVerified: true}, nil).Once()
+    mockStore.On("ResetFailedLogins", 1).Return(nil).Once()
+    mockStore.On("AddSessionToken", 1, mock.Anything, mock.Anything).Return("session-token", expiresAt, nil).Once()
+
+    body := `{"email": "test@example.com", "password": "password123"}`
+    req := httptest.NewRequest(http.MethodPost, "/api/v1/login", strings.NewReader(body))
+    rr := httptest.NewRecorder()
+
+    handler.APILogin(rr, req)
+
+    assert.Equal(t, http.StatusOK, rr.Code)
+
+    var res apiLoginResponse
+    assert.NoError(t, json.NewDecoder(rr.Body).Decode(&res))
+    assert.Equal(t, "session-token", res.Token)
+    assert.True(t, expiresAt.Equal(res.ExpiresAt))
+    mockStore.AssertExpectations(t)
+}
+
+func TestAPILoginWrongPassword(t *testing.T) {
+    passwordHash, err := bcrypt.GenerateFromPassword([]byte("password123"), bcrypt.MinCost)
+    if err != nil {
+        t.Fatalf("Failed to generate password hash: %v", err)
+    }
+
+    mockStore := new(MockSQLiteStore)
+    handler := &RealHandler{store: mockStore}
+
+    mockStore.On("GetUserByEmail", "test@example.com").Return(app.User{Id: 1, PasswordHash: passwordHash}, nil).Once()
+    mockStore.On("RecordFailedLogin", 1, maxFailedLogins, lockoutDuration).Return(time.Time{}, nil).Once()
+
+    body := `{"email": "test@example.com", "password": "wrong"}`
+    req := httptest.NewRequest(http.MethodPost, "/api/v1/login", strings.NewReader(body))
+    rr := httptest.NewRecorder()
+
+    handler.APILogin(rr, req)
+
+    assert.Equal(t, http.StatusUnauthorized, rr.Code)
+    mockStore.AssertNotCalled(t, "AddSessionToken", mock.Anything, mock.Anything, mock.Anything)
+}
+
+func TestAPIListTasks(t *testing.T) {
+    mockStore := new(MockSQLiteStore)
+    handler := &RealHandler{store: mockStore}
+
+    tasks := []app.Task{
+        {Id: uuid.New(), UserId: 1, Title: "First", Status: "pending", Due: time.Now().Add(time.Hour).UTC()},
+        {Id: uuid.New(), UserId: 1, Title: "Second", Status: "done", Done: 1, Due: time.Now().UTC()},
+    }
+    mockStore.On("QueryTasks", 1, db.TaskQuery{Order: []db.TaskOrder{{By: db.SortDue}}}).Return(tasks, nil).Once()
+
+    req := httptest.NewRequest(http.MethodGet, "/api/v1/tasks", nil)
+    rr := httptest.NewRecorder()
+
+    handler.APIListTasks(rr, req, 1)
+
+    assert.Equal(t, http.StatusOK, rr.Code)
+    assert.Equal(t, "application/json", rr.Header().Get("Content-Type"))
+
+    var got []app.Task
+    assert.NoError(t, json.NewDecoder(rr.Body).Decode(&got))
+    if assert.Len(t, got, 2) {
+        assert.Equal(t, tasks[0].Id, got[0].Id)
+        assert.Equal(t, "done", got[1].Status)
+    }
+}
+
+func TestAPIListTasksSorted(t *testing.T) {
+    mockStore := new(MockSQLiteStore)
+    handler := &RealHandler{store: mockStore}
+
+    mockStore.On("QueryTasks", 1, db.TaskQuery{Order: []db.TaskOrder{{By: db.SortPriority, Desc: false}}}).Return([]app.Task{}, nil).Once()
+
+    rr := httptest.NewRecorder()
+    handler.APIListTasks(rr, httptest.NewRequest(http.MethodGet, "/api/v1/tasks?sort=priority&order=asc", nil), 1)
+    assert.Equal(t, http.StatusOK, rr.Code)
+
+    rr = httptest.NewRecorder()
+    handler.APIListTasks(rr, httptest.NewRequest(http.MethodGet, "/api/v1/tasks?sort=colour", nil), 1)
+    assert.Equal(t, http.StatusBadRequest, rr.Code)
+
+    mockStore.AssertExpectations(t)
+}
+
+func TestAPICreateTaskWithPriority(t *testing.T) {
+    mockStore := new(MockSQLiteStore)
+    handler := &RealHandler{store: mockStore}
+
+    mockStore.On("CreateTask", mock.MatchedBy(func(task app.Task) bool {
+        return task.Priority == app.PriorityHigh
+    })).Return(nil).Once()
+
+    body := `{"title": "Write report", "due": "2030-01-02T15:04:05Z", "priority": "high"}`
+    rr := httptest.NewRecorder()
+    handler.APICreateTask(rr, httptest.NewRequest(http.MethodPost, "/api/v1/tasks", strings.NewReader(body)), 1)
+
+    assert.Equal(t, http.StatusCreated, rr.Code)
+    assert.Contains(t, rr.Body.String(), `"priority":"high"`)
+    mockStore.AssertExpectations(t)
+
+    body = `{"title": "Write report", "due": "2030-01-02T15:04:05Z", "priority": "critical"}`
+    rr = httptest.NewRecorder()
+    handler.APICreateTask(rr, httptest.NewRequest(http.MethodPost, "/api/v1/tasks", strings.NewReader(body)), 1)
+    assert.Equal(t, http.StatusBadRequest, rr.Code)
+}
+
+func TestAPICreateTask(t *testing.T) {
+    mockStore := new(MockSQLiteStore)
+    handler := &RealHandler{store: mockStore}
+
+    mockStore.On("CreateTask", mock.MatchedBy(func(task app.Task) bool {
+        return task.UserId == 1 && task.Title == "Write report" && task.Id != uuid.Nil
+    })).Return(nil).Once()
+
+    body := `{"title": "Write report", "description": "Quarterly", "due": "2030-01-02T15:04:05Z"}`
+    req := httptest.NewRequest(http.MethodPost, "/api/v1/tasks", strings.NewReader(body))
+    rr := httptest.NewRecorder()
+
+    handler.APICreateTask(rr, req, 1)
+
+    assert.Equal(t, http.StatusCreated, rr.Code)
+
+    var got app.Task
+    assert.NoError(t, json.NewDecoder(rr.Body).Decode(&got))
+    assert.Equal(t, "/api/v1/tasks/"+got.Id.String(), rr.Header().Get("Location"))
+    assert.Equal(t, "pending", got.Status)
+    mockStore.AssertExpectations(t)
+}
+
+func TestAPICreateTaskInvalid(t *testing.T) {
+    cases := map[string]string{
+        "malformed":     `{"title": `,
+        "missing title": `{"due": "2030-01-02T15:04:05Z"}`,
+        "missing due":   `{"title": "Write report"}`,
+        "unknown field": `{"title": "Write report", "due": "2030-01-02T15:04:05Z", "colour": "red"}`,
+        "bad done":      `{"title": "Write report", "due": "2030-01-02T15:04:05Z", "done": 7}`,
+    }
+
+    for name, body := range cases {
+        t.Run(name, func(t *testing.T) {
+            mockStore := new(MockSQLiteStore)
+            handler := &RealHandler{store: mockStore}
+
+            req := httptest.NewRequest(http.MethodPost, "/api/v1/tasks", strings.NewReader(body))
+            rr := httptest.NewRecorder()
+
+            handler.APICreateTask(rr, req, 1)
+
+            assert.Equal(t, http.StatusBadRequest, rr.Code)
+
+            var res apiError
+            assert.NoError(t, json.NewDecoder(rr.Body).Decode(&res))
+            assert.NotEmpty(t, res.Error)
+            mockStore.AssertNotCalled(t, "CreateTask", mock.Anything)
+        })
+    }
+}
+
+func TestAPICreateTaskReportsFields(t *testing.T) {
+    mockStore := new(MockSQLiteStore)
+    handler := &RealHandler{store: mockStore}
+
+    body := `{"title": "` + strings.Repeat("x", validate.MaxTitleLength+1) + `", "due": "1900-01-02T15:04:05Z"}`
+    req := httptest.NewRequest(http.MethodPost, "/api/v1/tasks", strings.NewReader(body))
+    rr := httptest.NewRecorder()
+
+    handler.APICreateTask(rr, req, 1)
+
+    assert.Equal(t, http.StatusBadRequest, rr.Code)
+
+    var res apiError
+    assert.NoError(t, json.NewDecoder(rr.Body).Decode(&res))
+    assert.Contains(t, res.Fields, "title")
+    assert.Contains(t, res.Fields, "due")
+    mockStore.AssertNotCalled(t, "CreateTask", mock.Anything)
+}
+
+func TestAPIGetTaskNotFound(t *testing.T) {
+    mockStore := new(MockSQLiteStore)
+    handler := &RealHandler{store: mockStore}
+
+    id := uuid.New()
+    mockStore.On("GetTaskById", 2, id).Return(app.Task{}, db.ErrTaskNotFound).Once()
+
+    req := httptest.NewRequest(http.MethodGet, "/api/v1/tasks/"+id.String(), nil)
+    rr := httptest.NewRecorder()
+
+    handler.APIGetTask(rr, req, 2, id)
+
+    assert.Equal(t, http.StatusNotFound, rr.Code)
+
+    var res apiError
+    assert.NoError(t, json.NewDecoder(rr.Body).Decode(&res))
+    assert.Equal(t, "task not found", res.Error)
+}
+
+func TestAPIPatchTask(t *testing.T) {
+    mockStore := new(MockSQLiteStore)
+    handler := &RealHandler{store: mockStore}
+
+    id := uuid.New()
+    due := time.Now().Add(time.Hour).UTC()
+    existing := app.Task{Id: id, UserId: 1, Title: "Old", Description: "Keep me", Due: due}
+    mockStore.On("GetTaskById", 1, id).Return(existing, nil).Once()
+    mockStore.On("UpdateTask", mock.MatchedBy(func(task app.Task) bool {
+        return task.Title == "New" && task.Description == "Keep me" && task.Done == 1 && task.Due.Equal(due)
+    })).Return(nil).Once()
+    updated := app.Task{Id: id, UserId: 1, Title: "New", Description: "Keep me", Done: 1, Status: "done", Due: due}
+    mockStore.On("GetTaskById", 1, id).Return(updated, nil).Once()
+
+    body := `{"title": "New", "done": 1}`
+    req := httptest.NewRequest(http.MethodPatch, "/api/v1/tasks/"+id.String(), strings.NewReader(body))
+    rr := httptest.NewRecorder()
+
+    handler.APIPatchTask(rr, req, 1, id)
+
+    assert.Equal(t, http.StatusOK, rr.Code)
+
+    var got app.Task
+    assert.NoError(t, json.NewDecoder(rr.Body).Decode(&got))
+    assert.Equal(t, "done", got.Status)
+    mockStore.AssertExpectations(t)
+}
+
+func TestAPIPatchTaskRecurrence(t *testing.T) {
+    mockStore := new(MockSQLiteStore)
+    handler := &RealHandler{store: mockStore}
+
+    id := uuid.New()
+    existing := app.Task{Id: id, UserId: 1, Title: "Bins", Due: time.Now().Add(time.Hour).UTC()}
+    mockStore.On("GetTaskById", 1, id).Return(existing, nil)
+    mockStore.On("UpdateTask", mock.MatchedBy(func(task app.Task) bool {
+        return task.Recurrence == "FREQ=WEEKLY;BYDAY=MO,TH"
+    })).Return(nil).Once()
+
+    req := httptest.NewRequest(http.MethodPatch, "/api/v1/tasks/"+id.String(), strings.NewReader(`{"recurrence": "rrule:freq=weekly;byday=mo,th"}`))
+    rr := httptest.NewRecorder()
+    handler.APIPatchTask(rr, req, 1, id)
+    assert.Equal(t, http.StatusOK, rr.Code)
+
+    req = httptest.NewRequest(http.MethodPatch, "/api/v1/tasks/"+id.String(), strings.NewReader(`{"recurrence": "FREQ=FORTNIGHTLY"}`))
+    rr = httptest.NewRecorder()
+    handler.APIPatchTask(rr, req, 1, id)
+    assert.Equal(t, http.StatusBadRequest, rr.Code)
+
+    var res apiError
+    assert.NoError(t, json.NewDecoder(rr.Body).Decode(&res))
+    assert.Contains(t, res.Fields, "recurrence")
+    mockStore.AssertExpectations(t)
+}
+
+func TestAPIDeleteTask(t *testing.T) {
+    mockStore := new(MockSQLiteStore)
+    handler := &RealHandler{store: mockStore}
+
+    id := uuid.New()
+    mockStore.On("DeleteTask", 1, id).Return(nil).Once()
+
+    req := httptest.NewRequest(http.MethodDelete, "/api/v1/tasks/"+id.String(), nil)
+    rr := httptest.NewRecorder()
+
+    handler.APIDeleteTask(rr, req, 1, id)
+
+    assert.Equal(t, http.StatusNoContent, rr.Code)
+    assert.Empty(t, rr.Body.String())
+    mockStore.AssertExpectations(t)
+}
+
+func TestAPISetTaskDone(t *testing.T) {
+    for method, done := range map[string]int{http.MethodPost: 1, http.MethodDelete: 0} {
+        t.Run(method, func(t *testing.T) {
+            mockStore := new(MockSQLiteStore)
+            handler := &RealHandler{store: mockStore}
+
+            id := uuid.New()
+            updated := app.Task{Id: id, UserId: 1, Title: "Task", Done: done, Due: time.Now().Add(time.Hour)}
+            updated.SetStatus()
+            mockStore.On("SetTaskDone", 1, id, done == 1).Return(app.Task{}, nil).Once()
+            mockStore.On("GetTaskById", 1, id).Return(updated, nil).Once()
+
+            req := httptest.NewRequest(method, "/api/v1/tasks/"+id.String()+"/done", nil)
+            rr := httptest.NewRecorder()
+
+            handler.APISetTaskDone(rr, req, 1, id)
+
+            assert.Equal(t, http.StatusOK, rr.Code)
+
+            var got app.Task
+            assert.NoError(t, json.NewDecoder(rr.Body).Decode(&got))
+            assert.Equal(t, done, got.Done)
+            mockStore.AssertExpectations(t)
+        })
+    }
+}
@@ -0,0 +1,143 @@
+package api
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"penumbra/app"
+	"penumbra/db"
+	"penumbra/mail"
+)
+
+// Email verification links carry a token signed with the handler's signing key, so nothing needs storing until the link is opened. The token names the user, the address being confirmed, and when it expires. Accounts can't log in until their address is confirmed.
+
+const emailVerificationLifetime = 24 * time.Hour
+
+var errInvalidVerificationToken = errors.New("invalid or expired verification token")
+
+type VerifyEmailPage struct {
+    Email      string
+    Sent       bool // A confirmation link has just been sent, or would have been if the account exists.
+    Unverified bool // The user tried to log in before confirming their address.
+    Verified   bool
+    Invalid    bool
+}
+
+func signVerification(key []byte, payload string) string {
+    mac := hmac.New(sha256.New, key)
+    mac.Write([]byte("verify-email:" + payload))
+    return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// emailVerificationToken returns a token confirming that `email` belongs to the user, valid until `expiresAt`.
+func emailVerificationToken(key []byte, userId int, email string, expiresAt time.Time) string {
+    payload := fmt.Sprintf("%d:%d:%s", userId, expiresAt.Unix(), email)
+    return base64.RawURLEncoding.EncodeToString([]byte(payload)) + "." + signVerification(key, payload)
+}
+
+// parseEmailVerificationToken checks the token's signature and expiry and returns the user id and email it confirms.
+func parseEmailVerificationToken(key []byte, token string, now time.Time) (int, string, error) {
+    encoded, signature, ok := strings.Cut(token, ".")
+    if !ok {
+        return 0, "", errInvalidVerificationToken
+    }
+
+    raw, err := base64.RawURLEncoding.DecodeString(encoded)
+    if err != nil {
+        return 0, "", errInvalidVerificationToken
+    }
+    payload := string(raw)
+
+    if !hmac.Equal([]byte(signature), []byte(signVerification(key, payload))) {
+        return 0, "", errInvalidVerificationToken
+    }
+
+    parts := strings.SplitN(payload, ":", 3)
+    if len(parts) != 3 {
+        return 0, "", errInvalidVerificationToken
+    }
+    userId, err := strconv.Atoi(parts[0])
+    if err != nil {
+        return 0, "", errInvalidVerificationToken
+    }
+    expires, err := strconv.ParseInt(parts[1], 10, 64)
+    if err != nil || now.Unix() >= expires {
+        return 0, "", errInvalidVerificationToken
+    }
+
+    return userId, parts[2], nil
+}
+
+func (h *RealHandler) sendEmailVerification(user app.User) error {
+    token := emailVerificationToken(h.signingKey, user.Id, user.Email, h.clock().Add(emailVerificationLifetime))
+    link := strings.TrimRight(h.baseURL, "/") + "/verify?token=" + url.QueryEscape(token)
+
+    return h.mailer.Send(mail.Message{
+        To:      user.Email,
+        Subject: "Confirm your Penumbra email address",
+        Body: "Welcome to Penumbra! Open this link within a day to confirm your email address and start using your account:\n\n" +
+            link + "\n\n" +
+            "If you didn't sign up, you can ignore this email.\n",
+    })
+}
+
+func (h *RealHandler) HandleVerifyEmail(w http.ResponseWriter, r *http.Request) {
+    noReferrer(w)
+
+    userId, email, err := parseEmailVerificationToken(h.signingKey, r.URL.Query().Get("token"), h.clock())
+    if err != nil {
+        w.WriteHeader(http.StatusBadRequest)
+        h.RenderPage(w, r, "verify", VerifyEmailPage{Invalid: true})
+        return
+    }
+
+    err = h.store.MarkEmailVerified(userId, email)
+    if errors.Is(err, db.ErrUserNotFound) {
+        w.WriteHeader(http.StatusBadRequest)
+        h.RenderPage(w, r, "verify", VerifyEmailPage{Invalid: true})
+        return
+    }
+    if err != nil {
+        log.Println("Error verifying email: ", err)
+        http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+        return
+    }
+
+    h.RenderPage(w, r, "verify", VerifyEmailPage{Email: email, Verified: true})
+}
+
+// SubmitResendVerification sends another confirmation link. Like the password reset form, it responds the same way whether or not the address belongs to an unverified account.
+func (h *RealHandler) SubmitResendVerification(w http.ResponseWriter, r *http.Request) {
+    if err := r.ParseForm(); err != nil {
+        http.Error(w, "Bad Request", http.StatusBadRequest)
+        return
+    }
+
+    email := strings.TrimSpace(r.FormValue("email"))
+    if ok, wait := h.allowAttempt(r, "verify", email); !ok {
+        tooManyRequests(w, wait)
+        return
+    }
+
+    if email != "" {
+        user, err := h.store.GetUserByEmail(email)
+        if err != nil {
+            log.Println("Verification resend requested for unknown email: ", err)
+        } else if !user.EmailVerified {
+            if err := h.sendEmailVerification(user); err != nil {
+                log.Println("Error sending verification email: ", err)
+            }
+        }
+    }
+
+    h.RenderPage(w, r, "verify", VerifyEmailPage{Email: email, Sent: true})
+}
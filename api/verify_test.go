@@ -0,0 +1,197 @@
+package api
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"golang.org/x/crypto/bcrypt"
+
+	"penumbra/app"
+	"penumbra/db"
+	"penumbra/mail"
+)
+
+var testSigningKey = []byte("test-signing-key")
+
+func newVerifyTestHandler(store db.Store, mailer mail.Mailer) *RealHandler {
+    h := newTestHandler(store)
+    h.mailer, h.baseURL, h.signingKey = mailer, "https://penumbra.example.com", testSigningKey
+    h.now = func() time.Time { return testTOTPNow }
+    return h
+}
+
+// verificationLink pulls the confirmation link out of an email.
+func verificationLink(t *testing.T, msg mail.Message) *url.URL {
+    t.Helper()
+
+    start := strings.Index(msg.Body, "https://")
+    if start < 0 {
+        t.Fatalf("no link in email body %q", msg.Body)
+    }
+    end := strings.IndexByte(msg.Body[start:], '\n')
+    link, err := url.Parse(msg.Body[start : start+end])
+    if err != nil {
+        t.Fatalf("invalid link: %v", err)
+    }
+    return link
+}
+
+func TestEmailVerificationToken(t *testing.T) {
+    expiresAt := testTOTPNow.Add(time.Hour)
+    token := emailVerificationToken(testSigningKey, 7, "bob@example.com", expiresAt)
+
+    userId, email, err := parseEmailVerificationToken(testSigningKey, token, testTOTPNow)
+    assert.NoError(t, err)
+    assert.Equal(t, 7, userId)
+    assert.Equal(t, "bob@example.com", email)
+
+    _, _, err = parseEmailVerificationToken(testSigningKey, token, expiresAt)
+    assert.Error(t, err, "an expired token should be refused")
+
+    _, _, err = parseEmailVerificationToken([]byte("other-key"), token, testTOTPNow)
+    assert.Error(t, err, "a token signed with another key should be refused")
+
+    forged := emailVerificationToken([]byte("other-key"), 8, "bob@example.com", expiresAt)
+    payload, _, _ := strings.Cut(forged, ".")
+    _, signature, _ := strings.Cut(token, ".")
+    _, _, err = parseEmailVerificationToken(testSigningKey, payload+"."+signature, testTOTPNow)
+    assert.Error(t, err, "a changed payload should be refused")
+
+    for _, bad := range []string{"", "no-dot", "!!!.abc", token + "x"} {
+        _, _, err = parseEmailVerificationToken(testSigningKey, bad, testTOTPNow)
+        assert.Error(t, err, "token %q", bad)
+    }
+}
+
+func TestSubmitRegisterSendsVerification(t *testing.T) {
+    mockStore := new(MockSQLiteStore)
+    mailer := &mail.MemoryMailer{}
+    handler := newVerifyTestHandler(mockStore, mailer)
+
+    mockStore.On("CreateUser", mock.MatchedBy(func(user app.User) bool {
+        return user.Email == "dana@example.com" && !user.EmailVerified
+    })).Return(nil).Once()
+    mockStore.On("GetUserByEmail", "dana@example.com").Return(app.User{Id: 4, Email: "dana@example.com"}, nil).Once()
+    mockStore.On("MarkEmailVerified", 4, "dana@example.com").Return(nil).Once()
+
+    form := url.Values{"name": {"Dana"}, "phone": {"+15555550123"}, "email": {"dana@example.com"}, "password": {"password123"}}
+    rr := httptest.NewRecorder()
+    handler.SubmitRegister(rr, formRequest("/register", form))
+
+    assert.Equal(t, http.StatusOK, rr.Code)
+    assert.Contains(t, rr.Body.String(), "verify|")
+    assert.Contains(t, rr.Body.String(), "Sent:true")
+
+    sent := mailer.Messages()
+    if !assert.Len(t, sent, 1) {
+        return
+    }
+    assert.Equal(t, "dana@example.com", sent[0].To)
+    link := verificationLink(t, sent[0])
+    assert.Equal(t, "penumbra.example.com", link.Host)
+    assert.Equal(t, "/verify", link.Path)
+
+    rr = httptest.NewRecorder()
+    handler.HandleVerifyEmail(rr, httptest.NewRequest(http.MethodGet, link.RequestURI(), nil))
+
+    assert.Equal(t, http.StatusOK, rr.Code)
+    assert.Contains(t, rr.Body.String(), "Verified:true")
+    mockStore.AssertExpectations(t)
+}
+
+func TestHandleVerifyEmailInvalid(t *testing.T) {
+    mockStore := new(MockSQLiteStore)
+    handler := newVerifyTestHandler(mockStore, nil)
+
+    expired := emailVerificationToken(testSigningKey, 4, "dana@example.com", testTOTPNow.Add(-time.Second))
+    rr := httptest.NewRecorder()
+    handler.HandleVerifyEmail(rr, httptest.NewRequest(http.MethodGet, "/verify?token="+url.QueryEscape(expired), nil))
+
+    assert.Equal(t, http.StatusBadRequest, rr.Code)
+    assert.Contains(t, rr.Body.String(), "Invalid:true")
+    mockStore.AssertNotCalled(t, "MarkEmailVerified", mock.Anything, mock.Anything)
+}
+
+func TestHandleVerifyEmailChangedAddress(t *testing.T) {
+    mockStore := new(MockSQLiteStore)
+    handler := newVerifyTestHandler(mockStore, nil)
+
+    token := emailVerificationToken(testSigningKey, 4, "old@example.com", testTOTPNow.Add(time.Hour))
+    mockStore.On("MarkEmailVerified", 4, "old@example.com").Return(db.ErrUserNotFound).Once()
+
+    rr := httptest.NewRecorder()
+    handler.HandleVerifyEmail(rr, httptest.NewRequest(http.MethodGet, "/verify?token="+url.QueryEscape(token), nil))
+
+    assert.Equal(t, http.StatusBadRequest, rr.Code)
+    assert.Contains(t, rr.Body.String(), "Invalid:true")
+}
+
+func TestSubmitLoginUnverified(t *testing.T) {
+    passwordHash, err := bcrypt.GenerateFromPassword([]byte("password123"), bcrypt.MinCost)
+    if err != nil {
+        t.Fatalf("Failed to generate password hash: %v", err)
+    }
+
+    mockStore := new(MockSQLiteStore)
+    handler := newVerifyTestHandler(mockStore, nil)
+
+    mockStore.On("GetUserByEmail", "dana@example.com").Return(app.User{Id: 4, Email: "dana@example.com", PasswordHash: passwordHash}, nil).Once()
+    mockStore.On("ResetFailedLogins", 4).Return(nil).Once()
+
+    rr := httptest.NewRecorder()
+    handler.SubmitLogin(rr, loginRequest("dana@example.com", "password123"))
+
+    assert.Equal(t, http.StatusForbidden, rr.Code)
+    assert.Contains(t, rr.Body.String(), "Unverified:true")
+    assert.Nil(t, findCookie(rr, "session_token"))
+    mockStore.AssertNotCalled(t, "AddSessionToken", mock.Anything, mock.Anything, mock.Anything)
+}
+
+func TestAPILoginUnverified(t *testing.T) {
+    passwordHash, err := bcrypt.GenerateFromPassword([]byte("password123"), bcrypt.MinCost)
+    if err != nil {
+        t.Fatalf("Failed to generate password hash: %v", err)
+    }
+
+    mockStore := new(MockSQLiteStore)
+    handler := newVerifyTestHandler(mockStore, nil)
+
+    mockStore.On("GetUserByEmail", "dana@example.com").Return(app.User{Id: 4, PasswordHash: passwordHash}, nil).Once()
+    mockStore.On("ResetFailedLogins", 4).Return(nil).Once()
+
+    body := `{"email": "dana@example.com", "password": "password123"}`
+    rr := httptest.NewRecorder()
+    handler.APILogin(rr, httptest.NewRequest(http.MethodPost, "/api/v1/login", strings.NewReader(body)))
+
+    assert.Equal(t, http.StatusForbidden, rr.Code)
+    assert.Contains(t, rr.Body.String(), "email address not verified")
+}
+
+func TestSubmitResendVerification(t *testing.T) {
+    mockStore := new(MockSQLiteStore)
+    mailer := &mail.MemoryMailer{}
+    handler := newVerifyTestHandler(mockStore, mailer)
+
+    mockStore.On("GetUserByEmail", "dana@example.com").Return(app.User{Id: 4, Email: "dana@example.com"}, nil).Once()
+    mockStore.On("GetUserByEmail", "verified@example.com").Return(app.User{Id: 5, Email: "verified@example.com", EmailVerified: true}, nil).Once()
+    mockStore.On("GetUserByEmail", "nobody@example.com").Return(app.User{}, assert.AnError).Once()
+
+    for _, email := range []string{"dana@example.com", "verified@example.com", "nobody@example.com"} {
+        rr := httptest.NewRecorder()
+        handler.SubmitResendVerification(rr, formRequest("/verify/resend", url.Values{"email": {email}}))
+
+        assert.Equal(t, http.StatusOK, rr.Code)
+        assert.Contains(t, rr.Body.String(), "Sent:true", "the response shouldn't depend on the account")
+    }
+
+    sent := mailer.Messages()
+    if assert.Len(t, sent, 1) {
+        assert.Equal(t, "dana@example.com", sent[0].To)
+    }
+}
@@ -1,6 +1,8 @@
 package app
 
 import (
+	"fmt"
+	"sync"
 	"time"
 
 	"github.com/google/uuid"
@@ -12,6 +14,12 @@ type User struct {
     Phone string
     Email string
     PasswordHash []byte
+    EmailVerified bool    // False until the user opens the confirmation link emailed when they registered.
+    LockedUntil time.Time // Zero unless too many failed logins have locked the account.
+    TOTPSecret string     // Set once the user starts enrolling in two-factor authentication.
+    TOTPEnabled bool      // True once the user has confirmed a code, after which logins need a second step.
+    TOTPLastStep int64    // The last TOTP step a code was accepted for, so codes can't be replayed.
+    TimeZone string       // An IANA time zone such as `Europe/London`, which the user's due dates are entered, shown, and judged in.
 }
 
 type Task struct {
@@ -21,17 +29,162 @@ type Task struct {
     Description string    `json:"description,omitempty"`
     Status      string    `json:"status"`
     Done        int      `json:"done"`
-    Due         time.Time `json:"due"`
+    Due         time.Time `json:"due"` // Always in UTC.
+    AllDay      bool      `json:"allDay,omitempty"` // True if the task is due by the end of a day rather than at a time, in which case Due is the last moment of that day in the owner's time zone.
+    TimeZone    string    `json:"-"` // The owner's time zone, which the task's status is judged in.
+    CompletedAt time.Time `json:"completedAt,omitzero"` // Zero unless the task is done.
+    Recurrence  string    `json:"recurrence,omitempty"`  // An RRULE such as `FREQ=WEEKLY;BYDAY=MO`, or empty for a one-off task. Only the latest occurrence of a series carries it.
+    ChecklistTotal int    `json:"checklistTotal,omitempty"` // How many checklist items the task has.
+    ChecklistDone  int    `json:"checklistDone,omitempty"`  // How many of them are ticked.
+    Tags        []Tag     `json:"tags,omitempty"` // In order of name.
+    ProjectId   int       `json:"projectId,omitempty"` // Zero if the task isn't in a project.
+    Priority    Priority  `json:"priority,omitempty"`
+    CreatedAt   time.Time `json:"createdAt,omitzero"` // Zero for tasks created before it was recorded.
 }
 
+// Priority says how urgent a task is. It's stored as a number, so that tasks can be sorted by it, and shown and sent as its name.
+type Priority int
+
+const (
+    PriorityNone Priority = iota
+    PriorityLow
+    PriorityMedium
+    PriorityHigh
+    PriorityUrgent
+)
+
+// Priorities lists every priority, least urgent first.
+var Priorities = []Priority{PriorityNone, PriorityLow, PriorityMedium, PriorityHigh, PriorityUrgent}
+
+var priorityNames = []string{"none", "low", "medium", "high", "urgent"}
+
+func (p Priority) String() string {
+    if p < PriorityNone || p > PriorityUrgent {
+        return fmt.Sprintf("Priority(%d)", int(p))
+    }
+    return priorityNames[p]
+}
+
+// ParsePriority reads a priority by name, such as `high`. The empty string is PriorityNone.
+func ParsePriority(s string) (Priority, error) {
+    if s == "" {
+        return PriorityNone, nil
+    }
+    for i, name := range priorityNames {
+        if s == name {
+            return Priority(i), nil
+        }
+    }
+    return PriorityNone, fmt.Errorf("unknown priority %q", s)
+}
+
+func (p Priority) MarshalText() ([]byte, error) {
+    return []byte(p.String()), nil
+}
+
+func (p *Priority) UnmarshalText(text []byte) error {
+    parsed, err := ParsePriority(string(text))
+    if err != nil {
+        return err
+    }
+    *p = parsed
+    return nil
+}
+
+// Project is a named list of a user's tasks. A task belongs to at most one project. Names are unique per user, ignoring case.
+type Project struct {
+    Id     int    `json:"id"`
+    UserId int    `json:"-"`
+    Name   string `json:"name"`
+}
+
+// Tag is a user's label for grouping tasks. Names are unique per user, ignoring case.
+type Tag struct {
+    Id     int    `json:"id"`
+    UserId int    `json:"-"`
+    Name   string `json:"name"`
+    Colour string `json:"colour"` // A hex colour such as `#1e90ff`.
+}
+
+// ChecklistItem is one step of a task's checklist.
+type ChecklistItem struct {
+    Id       int       `json:"id"`
+    TaskId   uuid.UUID `json:"taskId"`
+    Position int       `json:"position"` // Counting from 0.
+    Text     string    `json:"text"`
+    Done     bool      `json:"done"`
+}
+
+// Scopes an APIToken can be granted.
+const (
+    ScopeRead      = "read"
+    ScopeReadWrite = "read-write"
+)
+
+// APIToken is a named, revocable credential that a user creates for scripts and integrations. The token itself is only shown once, when it's created; only its hash is stored.
+type APIToken struct {
+    Id         int
+    UserId     int
+    Name       string
+    Scope      string
+    CreatedAt  time.Time
+    LastUsedAt time.Time // Zero if the token has never been used.
+    ExpiresAt  time.Time // Zero if the token never expires.
+}
+
+// CalendarFeed is a user's secret iCalendar subscription URL, which calendar apps poll for the user's open tasks. Like an APIToken, the token in the URL is only shown when it's created.
+type CalendarFeed struct {
+    UserId       int
+    CreatedAt    time.Time
+    ChangedAt    time.Time // When the user's tasks last changed, or the feed was created if later.
+    LastPolledAt time.Time // Zero if the feed has never been fetched.
+}
+
+// SetStatus works out whether the task is done, overdue, or pending, in the owner's time zone. A task due on a day, without a time, is only overdue once that day is over where the owner is.
 func (t *Task) SetStatus() {
     if t.Done == 1 {
         t.Status = "done"
+        return
+    }
+
+    now := time.Now()
+    overdue := t.Due.Before(now)
+    if t.AllDay {
+        loc := Location(t.TimeZone)
+        overdue = EndOfDay(t.Due.In(loc)).Before(now)
+    }
+    if overdue {
+        t.Status = "overdue"
     } else {
-        if t.Due.Before(time.Now()) {
-            t.Status = "overdue"
-        } else {
-            t.Status = "pending"
-        }
+        t.Status = "pending"
+    }
+}
+
+var (
+    locationsMu sync.Mutex
+    locations   = map[string]*time.Location{}
+)
+
+// Location returns the IANA time zone with the given name, or UTC if the name is empty or unknown. Zones are loaded once and kept.
+func Location(name string) *time.Location {
+    if name == "" || name == "UTC" {
+        return time.UTC
     }
+
+    locationsMu.Lock()
+    defer locationsMu.Unlock()
+    if loc, ok := locations[name]; ok {
+        return loc
+    }
+    loc, err := time.LoadLocation(name)
+    if err != nil {
+        loc = time.UTC
+    }
+    locations[name] = loc
+    return loc
+}
+
+// EndOfDay returns the last moment of t's day, in t's location.
+func EndOfDay(t time.Time) time.Time {
+    return time.Date(t.Year(), t.Month(), t.Day(), 23, 59, 59, 999999999, t.Location())
 }
@@ -0,0 +1,325 @@
+// Package backup reads and writes a user's tasks as CSV or JSON, so that they can keep a copy and restore it. JSON backups are lists of tasks as the JSON API has them; CSV ones have a column for each of the same fields, apart from the user's id, with a header naming them.
+package backup
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+
+	"penumbra/app"
+	"penumbra/validate"
+)
+
+// Format is how a backup is written.
+type Format string
+
+const (
+    FormatCSV  Format = "csv"
+    FormatJSON Format = "json"
+)
+
+// ParseFormat reads a format by name, such as `csv`.
+func ParseFormat(s string) (Format, bool) {
+    switch f := Format(strings.ToLower(s)); f {
+    case FormatCSV, FormatJSON:
+        return f, true
+    }
+    return "", false
+}
+
+// DetectFormat tells which format a backup is in from how it starts: JSON with a list or object, and CSV otherwise.
+func DetectFormat(data []byte) Format {
+    data = bytes.TrimLeft(bytes.TrimPrefix(data, []byte("\ufeff")), " \t\r\n")
+    if len(data) > 0 && (data[0] == '[' || data[0] == '{') {
+        return FormatJSON
+    }
+    return FormatCSV
+}
+
+// ErrNotBackup is returned by Decode for input that isn't a backup in the format.
+var ErrNotBackup = errors.New("not a backup")
+
+// Columns are those of a CSV backup, in order.
+var Columns = []string{"id", "title", "description", "status", "done", "due", "allDay", "completedAt", "recurrence", "checklistTotal", "checklistDone", "tags", "projectId", "priority", "createdAt"}
+
+// Encode writes the tasks as a backup. Times are in UTC, in RFC 3339 format; in CSV, a task's tags are their names, separated by commas, and fields the JSON leaves out are empty.
+func Encode(w io.Writer, format Format, tasks []app.Task) error {
+    if format == FormatJSON {
+        e := json.NewEncoder(w)
+        e.SetIndent("", "  ")
+        return e.Encode(tasks)
+    }
+
+    c := csv.NewWriter(w)
+    c.Write(Columns)
+    for _, t := range tasks {
+        tags := make([]string, len(t.Tags))
+        for i, tag := range t.Tags {
+            tags[i] = tag.Name
+        }
+        priority := ""
+        if t.Priority != app.PriorityNone {
+            priority = t.Priority.String()
+        }
+        c.Write([]string{
+            t.Id.String(),
+            t.Title,
+            t.Description,
+            t.Status,
+            strconv.Itoa(t.Done),
+            t.Due.UTC().Format(time.RFC3339),
+            strconv.FormatBool(t.AllDay),
+            formatTime(t.CompletedAt),
+            t.Recurrence,
+            formatCount(t.ChecklistTotal),
+            formatCount(t.ChecklistDone),
+            strings.Join(tags, ","),
+            formatCount(t.ProjectId),
+            priority,
+            formatTime(t.CreatedAt),
+        })
+    }
+    c.Flush()
+    return c.Error()
+}
+
+func formatTime(t time.Time) string {
+    if t.IsZero() {
+        return ""
+    }
+    return t.UTC().Format(time.RFC3339)
+}
+
+func formatCount(n int) string {
+    if n == 0 {
+        return ""
+    }
+    return strconv.Itoa(n)
+}
+
+// Row is a task read from a backup, and what was wrong with it, by field, if it couldn't be read.
+type Row struct {
+    Line   int // The line of a CSV backup the row starts on, or the position of a task in a JSON one, from 1.
+    Task   app.Task
+    Errors validate.Errors
+}
+
+// Decode reads the tasks of a backup. Only the fields a user can set are read: a task's status and checklist counts aren't, nor is the user it belonged to. Tags are read by name, and in JSON with their colour; their ids aren't, since they belong to the user who made the backup. Tasks without an id get a new one. An all-day task is due at the end of the day it's due on in loc, and a CSV row due on a date without a time is an all-day task due that day. Rows that can't be read are returned with what was wrong with them; input that isn't a backup at all is an error.
+func Decode(r io.Reader, format Format, loc *time.Location) ([]Row, error) {
+    var rows []Row
+    var err error
+    if format == FormatJSON {
+        rows, err = decodeJSON(r)
+    } else {
+        rows, err = decodeCSV(r, loc)
+    }
+    if err != nil {
+        return nil, err
+    }
+
+    for i := range rows {
+        t := &rows[i].Task
+        if t.Id == uuid.Nil {
+            t.Id = uuid.New()
+        }
+        if t.AllDay && !t.Due.IsZero() {
+            t.Due = app.EndOfDay(t.Due.In(loc)).UTC()
+        }
+    }
+    return rows, nil
+}
+
+// fieldErrors are what's said about a field that can't be read.
+var fieldErrors = map[string]string{
+    "id":          "Use a task id, or leave it empty for a new task.",
+    "title":       "Use text for the title.",
+    "description": "Use text for the description.",
+    "done":        "Use 0 or 1.",
+    "due":         "Use an RFC 3339 time, such as 2030-01-07T09:00:00Z.",
+    "allDay":      "Use true or false.",
+    "completedAt": "Use an RFC 3339 time, such as 2030-01-07T09:00:00Z, or leave it empty.",
+    "recurrence":  "Use an RRULE, such as FREQ=WEEKLY;BYDAY=MO.",
+    "tags":        "Use a list of tags, each with a name.",
+    "projectId":   "Use a project id, or leave it empty.",
+    "priority":    "Use low, medium, high, urgent, or leave it empty.",
+    "createdAt":   "Use an RFC 3339 time, such as 2030-01-07T09:00:00Z, or leave it empty.",
+}
+
+func decodeJSON(r io.Reader) ([]Row, error) {
+    var tasks []json.RawMessage
+    if err := json.NewDecoder(r).Decode(&tasks); err != nil {
+        return nil, fmt.Errorf("%w: expected a JSON list of tasks (%v)", ErrNotBackup, err)
+    }
+
+    rows := make([]Row, len(tasks))
+    for i, raw := range tasks {
+        row := Row{Line: i + 1, Errors: validate.Errors{}}
+        var fields map[string]json.RawMessage
+        if err := json.Unmarshal(raw, &fields); err != nil {
+            row.Errors.Add("task", "Use an object with a task's fields.")
+            rows[i] = row
+            continue
+        }
+
+        t := &row.Task
+        var id string
+        for name, v := range map[string]any{
+            "id": &id, "title": &t.Title, "description": &t.Description, "done": &t.Done, "due": &t.Due, "allDay": &t.AllDay,
+            "completedAt": &t.CompletedAt, "recurrence": &t.Recurrence, "tags": &t.Tags, "projectId": &t.ProjectId,
+            "priority": &t.Priority, "createdAt": &t.CreatedAt,
+        } {
+            value, ok := fields[name]
+            if !ok || string(value) == "null" {
+                continue
+            }
+            if err := json.Unmarshal(value, v); err != nil {
+                row.Errors.Add(name, fieldErrors[name])
+            }
+        }
+        if id != "" {
+            parsed, err := uuid.Parse(id)
+            if err != nil {
+                row.Errors.Add("id", fieldErrors["id"])
+            }
+            t.Id = parsed
+        }
+        for j, tag := range t.Tags {
+            t.Tags[j] = app.Tag{Name: strings.TrimSpace(tag.Name), Colour: tag.Colour}
+        }
+        if t.ProjectId < 0 {
+            row.Errors.Add("projectId", fieldErrors["projectId"])
+        }
+        t.Due = t.Due.UTC()
+        t.CompletedAt = t.CompletedAt.UTC()
+        t.CreatedAt = t.CreatedAt.UTC()
+        rows[i] = row
+    }
+    return rows, nil
+}
+
+func decodeCSV(r io.Reader, loc *time.Location) ([]Row, error) {
+    body, err := io.ReadAll(r)
+    if err != nil {
+        return nil, err
+    }
+    // Spreadsheets often save CSV with a byte order mark.
+    c := csv.NewReader(bytes.NewReader(bytes.TrimPrefix(body, []byte("\ufeff"))))
+    c.FieldsPerRecord = -1
+
+    header, err := c.Read()
+    if err != nil {
+        return nil, fmt.Errorf("%w: expected a CSV header (%v)", ErrNotBackup, err)
+    }
+    columns := map[string]int{}
+    for i, name := range header {
+        for _, column := range Columns {
+            if strings.EqualFold(strings.TrimSpace(name), column) {
+                columns[column] = i
+            }
+        }
+    }
+    for _, required := range []string{"title", "due"} {
+        if _, ok := columns[required]; !ok {
+            return nil, fmt.Errorf("%w: the header has no %s column", ErrNotBackup, required)
+        }
+    }
+
+    var rows []Row
+    for {
+        record, err := c.Read()
+        if err == io.EOF {
+            break
+        }
+        if err != nil {
+            return nil, fmt.Errorf("%w: %v", ErrNotBackup, err)
+        }
+        line, _ := c.FieldPos(0)
+        rows = append(rows, csvRow(line, record, columns, loc))
+    }
+    return rows, nil
+}
+
+func csvRow(line int, record []string, columns map[string]int, loc *time.Location) Row {
+    row := Row{Line: line, Errors: validate.Errors{}}
+    field := func(name string) string {
+        if i, ok := columns[name]; ok && i < len(record) {
+            return strings.TrimSpace(record[i])
+        }
+        return ""
+    }
+    parseTime := func(name string) time.Time {
+        value := field(name)
+        if value == "" {
+            return time.Time{}
+        }
+        t, err := time.Parse(time.RFC3339, value)
+        if err != nil {
+            row.Errors.Add(name, fieldErrors[name])
+        }
+        return t.UTC()
+    }
+
+    t := &row.Task
+    if id := field("id"); id != "" {
+        parsed, err := uuid.Parse(id)
+        if err != nil {
+            row.Errors.Add("id", fieldErrors["id"])
+        }
+        t.Id = parsed
+    }
+    t.Title = field("title")
+    if i, ok := columns["description"]; ok && i < len(record) {
+        t.Description = record[i]
+    }
+
+    switch field("done") {
+    case "", "0", "false":
+    case "1", "true":
+        t.Done = 1
+    default:
+        row.Errors.Add("done", fieldErrors["done"])
+    }
+
+    if value := field("allDay"); value != "" {
+        allDay, err := strconv.ParseBool(value)
+        if err != nil {
+            row.Errors.Add("allDay", fieldErrors["allDay"])
+        }
+        t.AllDay = allDay
+    }
+    if day, err := time.ParseInLocation(time.DateOnly, field("due"), loc); err == nil {
+        t.Due, t.AllDay = day, true
+    } else {
+        t.Due = parseTime("due")
+    }
+
+    t.CompletedAt = parseTime("completedAt")
+    t.CreatedAt = parseTime("createdAt")
+    t.Recurrence = field("recurrence")
+    for _, name := range strings.Split(field("tags"), ",") {
+        if name = strings.TrimSpace(name); name != "" {
+            t.Tags = append(t.Tags, app.Tag{Name: name})
+        }
+    }
+    if value := field("projectId"); value != "" {
+        id, err := strconv.Atoi(value)
+        if err != nil || id < 0 {
+            row.Errors.Add("projectId", fieldErrors["projectId"])
+        }
+        t.ProjectId = id
+    }
+    priority, err := app.ParsePriority(strings.ToLower(field("priority")))
+    if err != nil {
+        row.Errors.Add("priority", fieldErrors["priority"])
+    }
+    t.Priority = priority
+    return row
+}
@@ -0,0 +1,175 @@
+package backup
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"penumbra/app"
+	"penumbra/validate"
+)
+
+func TestRoundTrip(t *testing.T) {
+    london, _ := time.LoadLocation("Europe/London")
+    tasks := []app.Task{
+        {
+            Id:          uuid.New(),
+            Title:       `Write "report", then send it`,
+            Description: "Sections:\n1. Costs\n2. Next steps",
+            Status:      "pending",
+            Due:         time.Date(2030, 1, 7, 14, 30, 0, 0, time.UTC),
+            Priority:    app.PriorityHigh,
+            Recurrence:  "FREQ=WEEKLY;BYDAY=MO",
+            Tags:        []app.Tag{{Name: "home"}, {Name: "money"}},
+            ProjectId:   7,
+            CreatedAt:   time.Date(2029, 12, 1, 9, 0, 0, 0, time.UTC),
+        },
+        {
+            Id:          uuid.New(),
+            Title:       "Water plants",
+            Status:      "done",
+            Done:        1,
+            Due:         app.EndOfDay(time.Date(2030, 1, 8, 0, 0, 0, 0, london)).UTC(),
+            AllDay:      true,
+            CompletedAt: time.Date(2030, 1, 8, 7, 15, 0, 0, time.UTC),
+        },
+    }
+
+    for _, format := range []Format{FormatCSV, FormatJSON} {
+        var b bytes.Buffer
+        require.NoError(t, Encode(&b, format, tasks), format)
+
+        rows, err := Decode(&b, format, london)
+        require.NoError(t, err, format)
+        require.Len(t, rows, 2, format)
+        for i, row := range rows {
+            assert.Empty(t, row.Errors, format)
+            want := tasks[i]
+            want.Status = ""
+            // Times lose what's finer than a second in CSV, and all-day tasks are due at the end of their day.
+            assert.Equal(t, want.Due.Truncate(time.Second), row.Task.Due.Truncate(time.Second), format)
+            row.Task.Due, want.Due = time.Time{}, time.Time{}
+            assert.Equal(t, want, row.Task, format)
+        }
+    }
+}
+
+func TestEncodeCSV(t *testing.T) {
+    id := uuid.MustParse("123e4567-e89b-12d3-a456-426614174000")
+    var b bytes.Buffer
+    err := Encode(&b, FormatCSV, []app.Task{{
+        Id:             id,
+        Title:          "Pay rent, on time",
+        Status:         "overdue",
+        Due:            time.Date(2030, 1, 7, 12, 0, 0, 0, time.UTC),
+        ChecklistTotal: 2,
+        ChecklistDone:  1,
+        Tags:           []app.Tag{{Name: "home"}, {Name: "money"}},
+        ProjectId:      7,
+    }})
+    require.NoError(t, err)
+
+    assert.Equal(t, "id,title,description,status,done,due,allDay,completedAt,recurrence,checklistTotal,checklistDone,tags,projectId,priority,createdAt\n"+
+        id.String()+`,"Pay rent, on time",,overdue,0,2030-01-07T12:00:00Z,false,,,2,1,"home,money",7,,`+"\n", b.String())
+}
+
+func TestDecodeCSV(t *testing.T) {
+    newYork, _ := time.LoadLocation("America/New_York")
+    csv := "\ufeffTitle,Due,Priority,Done,Notes,Tags,ProjectId\n" +
+        "Pay rent,2030-01-07,High,1,ignored,\"home, ,money\",7\n" +
+        "\"Call\nmum\",2030-01-08T17:00:00Z,,,\n" +
+        "Someday,,low,maybe,,,seven\n" +
+        "Later,next week,urgent,0\n"
+
+    rows, err := Decode(strings.NewReader(csv), FormatCSV, newYork)
+    require.NoError(t, err)
+    require.Len(t, rows, 4)
+
+    assert.Equal(t, 2, rows[0].Line)
+    assert.Equal(t, "Pay rent", rows[0].Task.Title)
+    assert.True(t, rows[0].Task.AllDay)
+    assert.Equal(t, app.EndOfDay(time.Date(2030, 1, 7, 0, 0, 0, 0, newYork)).UTC(), rows[0].Task.Due)
+    assert.Equal(t, app.PriorityHigh, rows[0].Task.Priority)
+    assert.Equal(t, 1, rows[0].Task.Done)
+    assert.Equal(t, []app.Tag{{Name: "home"}, {Name: "money"}}, rows[0].Task.Tags)
+    assert.Equal(t, 7, rows[0].Task.ProjectId)
+    assert.NotEqual(t, uuid.Nil, rows[0].Task.Id)
+    assert.Empty(t, rows[0].Errors)
+
+    assert.Equal(t, 3, rows[1].Line)
+    assert.Equal(t, "Call\nmum", rows[1].Task.Title)
+    assert.Equal(t, time.Date(2030, 1, 8, 17, 0, 0, 0, time.UTC), rows[1].Task.Due)
+    assert.False(t, rows[1].Task.AllDay)
+
+    // A missing due date is for validation to catch; one that can't be read is reported here.
+    assert.Equal(t, 5, rows[2].Line)
+    assert.Equal(t, validate.Errors{"done": "Use 0 or 1.", "projectId": fieldErrors["projectId"]}, rows[2].Errors)
+    assert.Equal(t, validate.Errors{"due": fieldErrors["due"]}, rows[3].Errors)
+}
+
+func TestDecodeJSON(t *testing.T) {
+    id := uuid.New()
+    json := `[
+        {"id": "` + id.String() + `", "userId": 9, "title": "Pay rent", "status": "overdue", "due": "2030-01-07T12:00:00+01:00", "priority": "urgent", "projectId": 3, "tags": [{"id": 1, "name": " home ", "colour": "#1e90ff"}]},
+        {"title": "Call mum", "due": "soon", "done": "yes", "priority": 2, "tags": "home"},
+        "not a task"
+    ]`
+
+    rows, err := Decode(strings.NewReader(json), FormatJSON, time.UTC)
+    require.NoError(t, err)
+    require.Len(t, rows, 3)
+
+    assert.Equal(t, app.Task{
+        Id:        id,
+        Title:     "Pay rent",
+        Due:       time.Date(2030, 1, 7, 11, 0, 0, 0, time.UTC),
+        Priority:  app.PriorityUrgent,
+        Tags:      []app.Tag{{Name: "home", Colour: "#1e90ff"}},
+        ProjectId: 3,
+    }, rows[0].Task)
+    assert.Empty(t, rows[0].Errors)
+
+    assert.Equal(t, 2, rows[1].Line)
+    assert.Equal(t, validate.Errors{"due": fieldErrors["due"], "done": fieldErrors["done"], "priority": fieldErrors["priority"], "tags": fieldErrors["tags"]}, rows[1].Errors)
+    assert.NotEqual(t, uuid.Nil, rows[1].Task.Id)
+
+    assert.Contains(t, rows[2].Errors, "task")
+}
+
+func TestDecodeRefusesWhatIsntABackup(t *testing.T) {
+    cases := map[string]struct {
+        format Format
+        input  string
+    }{
+        "empty CSV":       {FormatCSV, ""},
+        "CSV without due": {FormatCSV, "title,notes\nPay rent,\n"},
+        "calendar as CSV": {FormatCSV, "BEGIN:VCALENDAR\r\nEND:VCALENDAR\r\n"},
+        "broken CSV":      {FormatCSV, "title,due\n\"Pay rent,2030-01-07\n"},
+        "JSON object":     {FormatJSON, `{"title": "Pay rent"}`},
+        "CSV as JSON":     {FormatJSON, "title,due\n"},
+    }
+    for name, c := range cases {
+        _, err := Decode(strings.NewReader(c.input), c.format, time.UTC)
+        assert.ErrorIs(t, err, ErrNotBackup, name)
+    }
+}
+
+func TestParseFormat(t *testing.T) {
+    f, ok := ParseFormat("JSON")
+    assert.True(t, ok)
+    assert.Equal(t, FormatJSON, f)
+    _, ok = ParseFormat("xml")
+    assert.False(t, ok)
+}
+
+func TestDetectFormat(t *testing.T) {
+    assert.Equal(t, FormatJSON, DetectFormat([]byte("\n  [{\"title\": \"Pay rent\"}]")))
+    assert.Equal(t, FormatJSON, DetectFormat([]byte(`{"title": "Pay rent"}`)))
+    assert.Equal(t, FormatCSV, DetectFormat([]byte("\ufeffid,title,due\n")))
+    assert.Equal(t, FormatCSV, DetectFormat(nil))
+}
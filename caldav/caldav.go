@@ -0,0 +1,462 @@
+// Package caldav reads the WebDAV (RFC 4918) and CalDAV (RFC 4791) requests that calendar apps make to sync a calendar, and writes the responses they expect. It knows the XML, not tasks: what resources there are, and what their properties hold, is up to the server.
+package caldav
+
+import (
+	"bytes"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// Namespaces of the elements that clients and servers exchange.
+const (
+    NamespaceDAV            = "DAV:"
+    NamespaceCalDAV         = "urn:ietf:params:xml:ns:caldav"
+    NamespaceCalendarServer = "http://calendarserver.org/ns/"
+)
+
+// Properties that clients ask for.
+var (
+    ResourceType                  = xml.Name{Space: NamespaceDAV, Local: "resourcetype"}
+    DisplayName                   = xml.Name{Space: NamespaceDAV, Local: "displayname"}
+    GetETag                       = xml.Name{Space: NamespaceDAV, Local: "getetag"}
+    GetContentType                = xml.Name{Space: NamespaceDAV, Local: "getcontenttype"}
+    CurrentUserPrincipal          = xml.Name{Space: NamespaceDAV, Local: "current-user-principal"}
+    PrincipalURL                  = xml.Name{Space: NamespaceDAV, Local: "principal-URL"}
+    SupportedReportSet            = xml.Name{Space: NamespaceDAV, Local: "supported-report-set"}
+    CalendarHomeSet               = xml.Name{Space: NamespaceCalDAV, Local: "calendar-home-set"}
+    SupportedCalendarComponentSet = xml.Name{Space: NamespaceCalDAV, Local: "supported-calendar-component-set"}
+    CalendarData                  = xml.Name{Space: NamespaceCalDAV, Local: "calendar-data"}
+    GetCTag                       = xml.Name{Space: NamespaceCalendarServer, Local: "getctag"} // Changes whenever anything in the collection does.
+)
+
+// Resource types, reports, and the preconditions whose failure WriteError reports.
+var (
+    Collection = xml.Name{Space: NamespaceDAV, Local: "collection"}
+    Principal  = xml.Name{Space: NamespaceDAV, Local: "principal"}
+    Calendar   = xml.Name{Space: NamespaceCalDAV, Local: "calendar"}
+
+    CalendarQuery    = xml.Name{Space: NamespaceCalDAV, Local: "calendar-query"}
+    CalendarMultiget = xml.Name{Space: NamespaceCalDAV, Local: "calendar-multiget"}
+
+    SupportedReport              = xml.Name{Space: NamespaceDAV, Local: "supported-report"}
+    SupportedCalendarComponent   = xml.Name{Space: NamespaceCalDAV, Local: "supported-calendar-component"}
+    ValidCalendarData            = xml.Name{Space: NamespaceCalDAV, Local: "valid-calendar-data"}
+    ValidCalendarObjectResource  = xml.Name{Space: NamespaceCalDAV, Local: "valid-calendar-object-resource"}
+    NoUIDConflict                = xml.Name{Space: NamespaceCalDAV, Local: "no-uid-conflict"}
+)
+
+// prefixes are those the namespaces are written with.
+var prefixes = map[string]string{
+    NamespaceDAV:            "d",
+    NamespaceCalDAV:         "c",
+    NamespaceCalendarServer: "cs",
+}
+
+// ErrUnsupportedReport is returned by ParseReport for reports other than calendar-query and calendar-multiget.
+var ErrUnsupportedReport = errors.New("unsupported report")
+
+// element is an XML element of a request, with everything in it.
+type element struct {
+    XMLName  xml.Name
+    Attrs    []xml.Attr `xml:",any,attr"`
+    Children []element  `xml:",any"`
+    Text     string     `xml:",chardata"`
+}
+
+func (e element) child(name xml.Name) (element, bool) {
+    for _, c := range e.Children {
+        if c.XMLName == name {
+            return c, true
+        }
+    }
+    return element{}, false
+}
+
+func (e element) attr(name string) string {
+    for _, a := range e.Attrs {
+        if a.Name.Local == name {
+            return a.Value
+        }
+    }
+    return ""
+}
+
+// parse reads a request body. An empty body is reported as a nil element.
+func parse(r io.Reader) (*element, error) {
+    body, err := io.ReadAll(r)
+    if err != nil {
+        return nil, err
+    }
+    if len(bytes.TrimSpace(body)) == 0 {
+        return nil, nil
+    }
+    var root element
+    if err := xml.Unmarshal(body, &root); err != nil {
+        return nil, fmt.Errorf("invalid XML: %w", err)
+    }
+    return &root, nil
+}
+
+// PropRequest is the properties a PROPFIND or REPORT asks for: all of them, or those named.
+type PropRequest struct {
+    All   bool
+    Names []xml.Name
+}
+
+// propRequest reads the prop, allprop, or propname element inside a request. Propname is answered as allprop, which names the same properties.
+func propRequest(e element) PropRequest {
+    var req PropRequest
+    for _, c := range e.Children {
+        switch c.XMLName {
+        case xml.Name{Space: NamespaceDAV, Local: "allprop"}, xml.Name{Space: NamespaceDAV, Local: "propname"}:
+            req.All = true
+        case xml.Name{Space: NamespaceDAV, Local: "prop"}:
+            for _, p := range c.Children {
+                req.Names = append(req.Names, p.XMLName)
+            }
+        }
+    }
+    return req
+}
+
+// ParsePropfind reads the body of a PROPFIND. One with no body asks for all properties.
+func ParsePropfind(r io.Reader) (PropRequest, error) {
+    root, err := parse(r)
+    if err != nil {
+        return PropRequest{}, err
+    }
+    if root == nil {
+        return PropRequest{All: true}, nil
+    }
+    if root.XMLName != (xml.Name{Space: NamespaceDAV, Local: "propfind"}) {
+        return PropRequest{}, fmt.Errorf("expected propfind, got %s", root.XMLName.Local)
+    }
+    return propRequest(*root), nil
+}
+
+// Report is what a calendar-query or calendar-multiget REPORT asks for.
+type Report struct {
+    Props  PropRequest
+    Hrefs  []string    // The resources a calendar-multiget asks for.
+    Filter *CompFilter // The filter of a calendar-query, on the VCALENDAR; nil for a calendar-multiget.
+}
+
+// ParseReport reads the body of a REPORT.
+func ParseReport(r io.Reader) (Report, error) {
+    root, err := parse(r)
+    if err != nil {
+        return Report{}, err
+    }
+    if root == nil {
+        return Report{}, errors.New("empty report")
+    }
+
+    report := Report{Props: propRequest(*root)}
+    switch root.XMLName {
+    case CalendarMultiget:
+        for _, c := range root.Children {
+            if c.XMLName == (xml.Name{Space: NamespaceDAV, Local: "href"}) {
+                report.Hrefs = append(report.Hrefs, strings.TrimSpace(c.Text))
+            }
+        }
+    case CalendarQuery:
+        filter, ok := root.child(xml.Name{Space: NamespaceCalDAV, Local: "filter"})
+        if !ok {
+            return Report{}, errors.New("calendar-query has no filter")
+        }
+        comp, ok := filter.child(xml.Name{Space: NamespaceCalDAV, Local: "comp-filter"})
+        if !ok {
+            return Report{}, errors.New("filter has no comp-filter")
+        }
+        f, err := compFilter(comp)
+        if err != nil {
+            return Report{}, err
+        }
+        report.Filter = &f
+    default:
+        return Report{}, ErrUnsupportedReport
+    }
+    return report, nil
+}
+
+// CompFilter matches components, per section 9.7.1: those with its name, overlapping its time range, with properties matching its property filters, and with components matching its component filters. One that's not defined matches when there's no component with its name.
+type CompFilter struct {
+    Name         string
+    IsNotDefined bool
+    TimeRange    *TimeRange
+    Props        []PropFilter
+    Comps        []CompFilter
+}
+
+// PropFilter matches a property, per section 9.7.2: if it's defined, and its value contains the text of its text match, if any, or doesn't with a negated one. One that's not defined matches when the property isn't.
+type PropFilter struct {
+    Name         string
+    IsNotDefined bool
+    TextMatch    *TextMatch
+}
+
+// TextMatch is matched ignoring ASCII case, the default collation.
+type TextMatch struct {
+    Text   string
+    Negate bool
+}
+
+// TimeRange is a span of time, in UTC. A zero Start or End leaves that side open.
+type TimeRange struct {
+    Start, End time.Time
+}
+
+func compFilter(e element) (CompFilter, error) {
+    f := CompFilter{Name: strings.ToUpper(e.attr("name"))}
+    if f.Name == "" {
+        return CompFilter{}, errors.New("comp-filter has no name")
+    }
+    for _, c := range e.Children {
+        if c.XMLName.Space != NamespaceCalDAV {
+            continue
+        }
+        switch c.XMLName.Local {
+        case "is-not-defined":
+            f.IsNotDefined = true
+        case "time-range":
+            tr, err := timeRange(c)
+            if err != nil {
+                return CompFilter{}, err
+            }
+            f.TimeRange = &tr
+        case "prop-filter":
+            p, err := propFilter(c)
+            if err != nil {
+                return CompFilter{}, err
+            }
+            f.Props = append(f.Props, p)
+        case "comp-filter":
+            sub, err := compFilter(c)
+            if err != nil {
+                return CompFilter{}, err
+            }
+            f.Comps = append(f.Comps, sub)
+        }
+    }
+    return f, nil
+}
+
+// propFilter reads a prop-filter. Its time ranges and parameter filters aren't supported, so they're ignored, matching more than they should rather than less.
+func propFilter(e element) (PropFilter, error) {
+    f := PropFilter{Name: strings.ToUpper(e.attr("name"))}
+    if f.Name == "" {
+        return PropFilter{}, errors.New("prop-filter has no name")
+    }
+    for _, c := range e.Children {
+        if c.XMLName.Space != NamespaceCalDAV {
+            continue
+        }
+        switch c.XMLName.Local {
+        case "is-not-defined":
+            f.IsNotDefined = true
+        case "text-match":
+            f.TextMatch = &TextMatch{Text: c.Text, Negate: c.attr("negate-condition") == "yes"}
+        }
+    }
+    return f, nil
+}
+
+func timeRange(e element) (TimeRange, error) {
+    var tr TimeRange
+    for _, bound := range []struct {
+        attr string
+        t    *time.Time
+    }{{"start", &tr.Start}, {"end", &tr.End}} {
+        value := e.attr(bound.attr)
+        if value == "" {
+            continue
+        }
+        t, err := time.Parse("20060102T150405Z", value)
+        if err != nil {
+            return TimeRange{}, fmt.Errorf("time-range %s %q isn't a UTC time", bound.attr, value)
+        }
+        *bound.t = t
+    }
+    return tr, nil
+}
+
+// Component is a calendar component to match a filter against.
+type Component struct {
+    Name       string
+    Properties map[string]string // Values by name, for the properties it has.
+    Components []Component
+    Overlaps   func(TimeRange) bool // Whether the component overlaps a time range; nil if it never does.
+}
+
+// Match reports whether the component matches the filter.
+func (f CompFilter) Match(c Component) bool {
+    if !strings.EqualFold(f.Name, c.Name) {
+        return false
+    }
+    if f.TimeRange != nil && (c.Overlaps == nil || !c.Overlaps(*f.TimeRange)) {
+        return false
+    }
+    for _, p := range f.Props {
+        value, defined := c.Properties[p.Name]
+        switch {
+        case p.IsNotDefined:
+            if defined {
+                return false
+            }
+        case !defined:
+            return false
+        case p.TextMatch != nil:
+            contains := strings.Contains(strings.ToLower(value), strings.ToLower(p.TextMatch.Text))
+            if contains == p.TextMatch.Negate {
+                return false
+            }
+        }
+    }
+    for _, sub := range f.Comps {
+        if !sub.matchAny(c.Components) {
+            return false
+        }
+    }
+    return true
+}
+
+// matchAny reports whether any of the components matches the filter, or, for one that's not defined, whether none has its name.
+func (f CompFilter) matchAny(components []Component) bool {
+    for _, c := range components {
+        if !strings.EqualFold(f.Name, c.Name) {
+            continue
+        }
+        if f.IsNotDefined {
+            return false
+        }
+        if f.Match(c) {
+            return true
+        }
+    }
+    return f.IsNotDefined
+}
+
+// Property is a property of a resource: its name, and its value as XML, written with the prefixes `d`, `c`, and `cs` for the DAV, CalDAV, and CalendarServer namespaces.
+type Property struct {
+    Name  xml.Name
+    Value string
+}
+
+// TextProperty is a property holding text.
+func TextProperty(name xml.Name, text string) Property {
+    return Property{name, escape(text)}
+}
+
+// HrefProperty is a property holding the URL of another resource.
+func HrefProperty(name xml.Name, href string) Property {
+    return Property{name, "<d:href>" + escape(href) + "</d:href>"}
+}
+
+// ResourceTypeProperty is a resourcetype property, with the types given.
+func ResourceTypeProperty(types ...xml.Name) Property {
+    var b strings.Builder
+    for _, t := range types {
+        b.WriteString(emptyElement(t))
+    }
+    return Property{ResourceType, b.String()}
+}
+
+// ComponentSetProperty is a supported-calendar-component-set property, with the components given.
+func ComponentSetProperty(components ...string) Property {
+    var b strings.Builder
+    for _, c := range components {
+        b.WriteString(`<c:comp name="` + escape(c) + `"/>`)
+    }
+    return Property{SupportedCalendarComponentSet, b.String()}
+}
+
+// ReportSetProperty is a supported-report-set property, with the reports given.
+func ReportSetProperty(reports ...xml.Name) Property {
+    var b strings.Builder
+    for _, r := range reports {
+        b.WriteString("<d:supported-report><d:report>" + emptyElement(r) + "</d:report></d:supported-report>")
+    }
+    return Property{SupportedReportSet, b.String()}
+}
+
+// Response is what a multistatus says about one resource: the properties asked for that it has, and those it doesn't. A response with a Status, such as 404 for a resource that doesn't exist, has no properties.
+type Response struct {
+    Href     string
+    Found    []Property
+    NotFound []xml.Name
+    Status   int
+}
+
+// WriteMultistatus writes the responses as a `207 Multi-Status`.
+func WriteMultistatus(w http.ResponseWriter, responses []Response) {
+    var b strings.Builder
+    b.WriteString(xml.Header)
+    b.WriteString(`<d:multistatus` + namespaceDeclarations() + `>`)
+    for _, r := range responses {
+        b.WriteString("<d:response><d:href>" + escape(r.Href) + "</d:href>")
+        if r.Status != 0 {
+            b.WriteString(status(r.Status))
+        } else {
+            if len(r.Found) > 0 || len(r.NotFound) == 0 {
+                b.WriteString("<d:propstat><d:prop>")
+                for _, p := range r.Found {
+                    open, close := tags(p.Name)
+                    b.WriteString(open + p.Value + close)
+                }
+                b.WriteString("</d:prop>" + status(http.StatusOK) + "</d:propstat>")
+            }
+            if len(r.NotFound) > 0 {
+                b.WriteString("<d:propstat><d:prop>")
+                for _, name := range r.NotFound {
+                    b.WriteString(emptyElement(name))
+                }
+                b.WriteString("</d:prop>" + status(http.StatusNotFound) + "</d:propstat>")
+            }
+        }
+        b.WriteString("</d:response>")
+    }
+    b.WriteString("</d:multistatus>")
+
+    w.Header().Set("Content-Type", "application/xml; charset=utf-8")
+    w.WriteHeader(http.StatusMultiStatus)
+    io.WriteString(w, b.String())
+}
+
+// WriteError responds with the status, and an error body naming the precondition that failed, per section 16 of RFC 4918.
+func WriteError(w http.ResponseWriter, code int, condition xml.Name) {
+    w.Header().Set("Content-Type", "application/xml; charset=utf-8")
+    w.WriteHeader(code)
+    io.WriteString(w, xml.Header+`<d:error`+namespaceDeclarations()+`>`+emptyElement(condition)+`</d:error>`)
+}
+
+func namespaceDeclarations() string {
+    return ` xmlns:d="` + NamespaceDAV + `" xmlns:c="` + NamespaceCalDAV + `" xmlns:cs="` + NamespaceCalendarServer + `"`
+}
+
+func status(code int) string {
+    return fmt.Sprintf("<d:status>HTTP/1.1 %d %s</d:status>", code, http.StatusText(code))
+}
+
+// tags are the opening and closing tags of an element, declaring its namespace if it has no prefix here.
+func tags(name xml.Name) (string, string) {
+    if prefix, ok := prefixes[name.Space]; ok {
+        return "<" + prefix + ":" + name.Local + ">", "</" + prefix + ":" + name.Local + ">"
+    }
+    return `<x:` + name.Local + ` xmlns:x="` + escape(name.Space) + `">`, "</x:" + name.Local + ">"
+}
+
+func emptyElement(name xml.Name) string {
+    open, _ := tags(name)
+    return strings.TrimSuffix(open, ">") + "/>"
+}
+
+func escape(s string) string {
+    var b strings.Builder
+    xml.EscapeText(&b, []byte(s))
+    return b.String()
+}
@@ -0,0 +1,154 @@
+package caldav
+
+import (
+	"encoding/xml"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParsePropfind(t *testing.T) {
+    req, err := ParsePropfind(strings.NewReader(`<?xml version="1.0"?>
+<propfind xmlns="DAV:" xmlns:C="urn:ietf:params:xml:ns:caldav" xmlns:CS="http://calendarserver.org/ns/">
+  <prop><resourcetype/><C:calendar-home-set/><CS:getctag/></prop>
+</propfind>`))
+    require.NoError(t, err)
+    assert.Equal(t, PropRequest{Names: []xml.Name{ResourceType, CalendarHomeSet, GetCTag}}, req)
+
+    for _, body := range []string{"", `<propfind xmlns="DAV:"><allprop/></propfind>`, `<propfind xmlns="DAV:"><propname/></propfind>`} {
+        req, err = ParsePropfind(strings.NewReader(body))
+        require.NoError(t, err, body)
+        assert.True(t, req.All, body)
+    }
+
+    for _, body := range []string{"<propfind", `<report xmlns="DAV:"/>`} {
+        _, err = ParsePropfind(strings.NewReader(body))
+        assert.Error(t, err, body)
+    }
+}
+
+func TestParseReport(t *testing.T) {
+    multiget, err := ParseReport(strings.NewReader(`<C:calendar-multiget xmlns:D="DAV:" xmlns:C="urn:ietf:params:xml:ns:caldav">
+  <D:prop><D:getetag/><C:calendar-data/></D:prop>
+  <D:href>/caldav/calendars/tasks/a.ics</D:href>
+  <D:href> /caldav/calendars/tasks/b.ics </D:href>
+</C:calendar-multiget>`))
+    require.NoError(t, err)
+    assert.Equal(t, Report{
+        Props: PropRequest{Names: []xml.Name{GetETag, CalendarData}},
+        Hrefs: []string{"/caldav/calendars/tasks/a.ics", "/caldav/calendars/tasks/b.ics"},
+    }, multiget)
+
+    query, err := ParseReport(strings.NewReader(`<C:calendar-query xmlns:D="DAV:" xmlns:C="urn:ietf:params:xml:ns:caldav">
+  <D:prop><D:getetag/></D:prop>
+  <C:filter>
+    <C:comp-filter name="VCALENDAR">
+      <C:comp-filter name="vtodo">
+        <C:time-range start="20300101T000000Z"/>
+        <C:prop-filter name="COMPLETED"><C:is-not-defined/></C:prop-filter>
+        <C:prop-filter name="SUMMARY"><C:text-match negate-condition="yes">rent</C:text-match></C:prop-filter>
+      </C:comp-filter>
+    </C:comp-filter>
+  </C:filter>
+</C:calendar-query>`))
+    require.NoError(t, err)
+    assert.Equal(t, &CompFilter{Name: "VCALENDAR", Comps: []CompFilter{{
+        Name:      "VTODO",
+        TimeRange: &TimeRange{Start: time.Date(2030, 1, 1, 0, 0, 0, 0, time.UTC)},
+        Props: []PropFilter{
+            {Name: "COMPLETED", IsNotDefined: true},
+            {Name: "SUMMARY", TextMatch: &TextMatch{Text: "rent", Negate: true}},
+        },
+    }}}, query.Filter)
+
+    _, err = ParseReport(strings.NewReader(`<D:sync-collection xmlns:D="DAV:"/>`))
+    assert.ErrorIs(t, err, ErrUnsupportedReport)
+
+    for _, body := range []string{
+        "",
+        `<C:calendar-query xmlns:C="urn:ietf:params:xml:ns:caldav"/>`,
+        `<C:calendar-query xmlns:C="urn:ietf:params:xml:ns:caldav"><C:filter><C:comp-filter/></C:filter></C:calendar-query>`,
+        `<C:calendar-query xmlns:C="urn:ietf:params:xml:ns:caldav"><C:filter><C:comp-filter name="VCALENDAR"><C:time-range start="tomorrow"/></C:comp-filter></C:filter></C:calendar-query>`,
+    } {
+        _, err = ParseReport(strings.NewReader(body))
+        assert.Error(t, err, body)
+        assert.NotErrorIs(t, err, ErrUnsupportedReport, body)
+    }
+}
+
+func TestCompFilterMatch(t *testing.T) {
+    due := time.Date(2030, 1, 7, 12, 0, 0, 0, time.UTC)
+    todo := Component{
+        Name:       "VTODO",
+        Properties: map[string]string{"SUMMARY": "Pay Rent", "DUE": "20300107T120000Z"},
+        Overlaps: func(tr TimeRange) bool {
+            return (tr.Start.IsZero() || tr.Start.Before(due)) && (tr.End.IsZero() || !tr.End.Before(due))
+        },
+    }
+    calendar := Component{Name: "VCALENDAR", Components: []Component{todo}}
+
+    cases := []struct {
+        name   string
+        filter CompFilter
+        want   bool
+    }{
+        {"any calendar", CompFilter{Name: "VCALENDAR"}, true},
+        {"any to-do", CompFilter{Name: "VCALENDAR", Comps: []CompFilter{{Name: "VTODO"}}}, true},
+        {"events", CompFilter{Name: "VCALENDAR", Comps: []CompFilter{{Name: "VEVENT"}}}, false},
+        {"no events", CompFilter{Name: "VCALENDAR", Comps: []CompFilter{{Name: "VEVENT", IsNotDefined: true}}}, true},
+        {"no to-dos", CompFilter{Name: "VCALENDAR", Comps: []CompFilter{{Name: "VTODO", IsNotDefined: true}}}, false},
+        {"due in range", CompFilter{Name: "VCALENDAR", Comps: []CompFilter{{Name: "VTODO", TimeRange: &TimeRange{Start: due.AddDate(0, 0, -1), End: due.AddDate(0, 0, 1)}}}}, true},
+        {"due before range", CompFilter{Name: "VCALENDAR", Comps: []CompFilter{{Name: "VTODO", TimeRange: &TimeRange{Start: due}}}}, false},
+        {"open", CompFilter{Name: "VCALENDAR", Comps: []CompFilter{{Name: "VTODO", Props: []PropFilter{{Name: "COMPLETED", IsNotDefined: true}}}}}, true},
+        {"completed", CompFilter{Name: "VCALENDAR", Comps: []CompFilter{{Name: "VTODO", Props: []PropFilter{{Name: "COMPLETED"}}}}}, false},
+        {"summary has text", CompFilter{Name: "VCALENDAR", Comps: []CompFilter{{Name: "VTODO", Props: []PropFilter{{Name: "SUMMARY", TextMatch: &TextMatch{Text: "rent"}}}}}}, true},
+        {"summary lacks text", CompFilter{Name: "VCALENDAR", Comps: []CompFilter{{Name: "VTODO", Props: []PropFilter{{Name: "SUMMARY", TextMatch: &TextMatch{Text: "rent", Negate: true}}}}}}, false},
+    }
+    for _, c := range cases {
+        assert.Equal(t, c.want, c.filter.Match(calendar), c.name)
+    }
+
+    noDue := Component{Name: "VTODO"}
+    assert.False(t, CompFilter{Name: "VTODO", TimeRange: &TimeRange{}}.Match(noDue))
+}
+
+func TestWriteMultistatus(t *testing.T) {
+    rr := httptest.NewRecorder()
+    WriteMultistatus(rr, []Response{
+        {
+            Href: "/caldav/calendars/tasks/",
+            Found: []Property{
+                ResourceTypeProperty(Collection, Calendar),
+                TextProperty(DisplayName, "Tasks & chores"),
+                ComponentSetProperty("VTODO"),
+            },
+            NotFound: []xml.Name{{Space: "http://apple.com/ns/ical/", Local: "calendar-color"}},
+        },
+        {Href: "/caldav/calendars/tasks/gone.ics", Status: http.StatusNotFound},
+    })
+
+    assert.Equal(t, http.StatusMultiStatus, rr.Code)
+    assert.Equal(t, "application/xml; charset=utf-8", rr.Header().Get("Content-Type"))
+    body := rr.Body.String()
+    assert.Contains(t, body, `<d:response><d:href>/caldav/calendars/tasks/</d:href><d:propstat><d:prop><d:resourcetype><d:collection/><c:calendar/></d:resourcetype><d:displayname>Tasks &amp; chores</d:displayname><c:supported-calendar-component-set><c:comp name="VTODO"/></c:supported-calendar-component-set></d:prop><d:status>HTTP/1.1 200 OK</d:status></d:propstat>`)
+    assert.Contains(t, body, `<d:propstat><d:prop><x:calendar-color xmlns:x="http://apple.com/ns/ical/"/></d:prop><d:status>HTTP/1.1 404 Not Found</d:status></d:propstat>`)
+    assert.Contains(t, body, `<d:response><d:href>/caldav/calendars/tasks/gone.ics</d:href><d:status>HTTP/1.1 404 Not Found</d:status></d:response>`)
+
+    // What's written is well formed, with the namespaces it uses declared.
+    var parsed element
+    require.NoError(t, xml.Unmarshal(rr.Body.Bytes(), &parsed))
+    assert.Equal(t, xml.Name{Space: NamespaceDAV, Local: "multistatus"}, parsed.XMLName)
+}
+
+func TestWriteError(t *testing.T) {
+    rr := httptest.NewRecorder()
+    WriteError(rr, http.StatusForbidden, NoUIDConflict)
+
+    assert.Equal(t, http.StatusForbidden, rr.Code)
+    assert.Contains(t, rr.Body.String(), `<c:no-uid-conflict/></d:error>`)
+}
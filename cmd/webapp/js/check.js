@@ -1,31 +1,54 @@
+const csrfToken = document
+  .querySelector('meta[name="csrf-token"]')
+  .getAttribute("content");
+
 document.querySelectorAll(".row-checkbox").forEach(function (checkbox) {
   checkbox.addEventListener("change", function () {
-    if (!this.checked) {
-      this.checked = true;
-    }
-
     const id = this.getAttribute("data-id");
+    const open = Number(this.getAttribute("data-open"));
+
+    if (
+      this.checked &&
+      open > 0 &&
+      !window.confirm(
+        open === 1
+          ? "This task still has an open checklist item. Mark it done anyway?"
+          : "This task still has " + open + " open checklist items. Mark it done anyway?",
+      )
+    ) {
+      this.checked = false;
+      return;
+    }
 
     fetch("/tasks/done/" + id, {
       method: "POST",
       headers: {
         "Content-Type": "application/json",
+        "X-CSRF-Token": csrfToken,
       },
       body: JSON.stringify({ checked: this.checked }),
     })
       .then((response) => {
         if (!response.ok) {
-          console.error("Request failed.");
-        } else {
-          const statusCell =
-            this.closest("tr").querySelector("td:nth-child(3)");
-          if (statusCell) {
-            statusCell.textContent = "done";
-          }
+          throw new Error("Request failed.");
+        }
+        return response.json();
+      })
+      .then((task) => {
+        if (task.next) {
+          // A recurring task was done; reload to show its next occurrence.
+          window.location.reload();
+          return;
+        }
+        const statusCell =
+          this.closest("tr").querySelector("td:nth-child(3)");
+        if (statusCell) {
+          statusCell.textContent = task.status;
         }
       })
       .catch((error) => {
-        console.error("Network error:", error);
+        console.error(error);
+        this.checked = !this.checked;
       });
   });
 });
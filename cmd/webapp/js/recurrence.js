@@ -0,0 +1,17 @@
+const fieldset = document.getElementById("recurrence");
+const repeat = fieldset.querySelector('select[name="repeat"]');
+const ends = fieldset.querySelector('select[name="ends"]');
+
+function update() {
+  fieldset.querySelectorAll("[data-repeat]").forEach(function (el) {
+    const shown = el.getAttribute("data-repeat").split(" ");
+    el.classList.toggle("hidden", !shown.includes(repeat.value));
+  });
+  fieldset.querySelectorAll("[data-ends]").forEach(function (el) {
+    el.classList.toggle("hidden", el.getAttribute("data-ends") !== ends.value);
+  });
+}
+
+repeat.addEventListener("change", update);
+ends.addEventListener("change", update);
+update();
@@ -0,0 +1,12 @@
+// Offers the browser's own zone when it isn't the one already chosen.
+const detected = Intl.DateTimeFormat().resolvedOptions().timeZone;
+const zoneInput = document.getElementById("timeZone");
+
+if (detected && zoneInput.value !== detected) {
+  const button = document.getElementById("timeZoneDetected");
+  button.textContent = detected;
+  button.addEventListener("click", function () {
+    zoneInput.value = detected;
+  });
+  document.getElementById("timeZoneHint").classList.remove("hidden");
+}
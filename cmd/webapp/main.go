@@ -5,9 +5,12 @@ import (
 	"html/template"
 	"log"
 	"net/http"
+	"os"
+	_ "time/tzdata" // Users' time zones work even where the system has no zone database.
 
 	"penumbra/api"
 	"penumbra/db"
+	"penumbra/mail"
 )
 
 //go:embed templates/*
@@ -20,7 +23,24 @@ func main() {
 		log.Fatalf("NewSQLiteStore failed: %v", err)
 	}
 
-    handler := api.NewHandler(store, templates)
+    var opts []api.Option
+    if baseURL := os.Getenv("PENUMBRA_BASE_URL"); baseURL != "" {
+        opts = append(opts, api.WithBaseURL(baseURL))
+    }
+    if key := os.Getenv("PENUMBRA_SECRET_KEY"); key != "" {
+        opts = append(opts, api.WithSigningKey([]byte(key)))
+    } else {
+        log.Println("PENUMBRA_SECRET_KEY is not set; email verification links will stop working when the server restarts")
+    }
+    if addr := os.Getenv("PENUMBRA_SMTP_ADDR"); addr != "" {
+        mailer, err := mail.NewSMTPMailer(addr, os.Getenv("PENUMBRA_SMTP_FROM"), os.Getenv("PENUMBRA_SMTP_USERNAME"), os.Getenv("PENUMBRA_SMTP_PASSWORD"))
+        if err != nil {
+            log.Fatalf("NewSMTPMailer failed: %v", err)
+        }
+        opts = append(opts, api.WithMailer(mailer))
+    }
+
+    handler := api.NewHandler(store, templates, opts...)
     router := api.NewRouter(handler)
 
     log.Println("Server running on :8080")
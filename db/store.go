@@ -1,33 +1,133 @@
 package db
 
 import (
+	"crypto/rand"
+	"crypto/sha256"
 	"database/sql"
+	"encoding/base64"
+	"encoding/json"
 	"errors"
 	"fmt"
+	"slices"
+	"strconv"
+	"strings"
 	"sync"
 	"testing"
 	"time"
+	"unicode"
 
 	_ "github.com/glebarez/go-sqlite"
 	"github.com/google/uuid"
-	"golang.org/x/crypto/bcrypt"
 
 	"penumbra/app"
+	"penumbra/recur"
 )
 
 type Store interface {
     CreateUser(user app.User) error
-    AddSessionToken(user_id int) (uuid.UUID, time.Time, error)
-    GetUserIdFromSessionToken(sessionToken uuid.UUID) (int, error)
-    GetTaskById(id uuid.UUID) (app.Task, error)
-    SetTaskDone(id uuid.UUID) error
+    AddSessionToken(user_id int, userAgent string, ip string) (string, time.Time, error)
+    GetUserIdFromSessionToken(sessionToken string) (int, error)
+    DeleteSession(sessionToken string) error
+    DeleteAllSessions(user_id int) error
+    CreateAPIToken(user_id int, name string, scope string, expiresAt time.Time) (string, error)
+    GetAPITokens(user_id int) ([]app.APIToken, error)
+    GetAPIToken(token string) (app.APIToken, error)
+    DeleteAPIToken(user_id int, id int) error
+    CreateCalendarFeed(user_id int) (string, error)
+    GetCalendarFeed(user_id int) (app.CalendarFeed, error)
+    GetCalendarFeedByToken(token string) (app.CalendarFeed, error)
+    DeleteCalendarFeed(user_id int) error
+    GetTaskById(user_id int, id uuid.UUID) (app.Task, error)
+    SetTaskDone(user_id int, id uuid.UUID, done bool) (app.Task, error)
     GetUserByEmail(email string) (app.User, error)
+    GetUserById(user_id int) (app.User, error)
+    SetTimeZone(user_id int, timeZone string) error
+    MarkEmailVerified(user_id int, email string) error
+    RecordFailedLogin(user_id int, maxAttempts int, lockFor time.Duration) (time.Time, error)
+    ResetFailedLogins(user_id int) error
+    SetPendingTOTPSecret(user_id int, secret string) error
+    EnableTOTP(user_id int, recoveryCodes []string) error
+    DisableTOTP(user_id int) error
+    RecordTOTPStep(user_id int, step int64) (bool, error)
+    UseRecoveryCode(user_id int, code string) (bool, error)
+    CountRecoveryCodes(user_id int) (int, error)
+    CreateLoginChallenge(user_id int) (string, time.Time, error)
+    GetLoginChallenge(token string) (int, error)
+    DeleteLoginChallenge(token string) error
+    CreatePasswordReset(user_id int) (string, time.Time, error)
+    GetPasswordReset(token string) (int, error)
+    ResetPassword(token string, passwordHash []byte) (int, error)
     GetAllTasks(user_id int) ([]app.Task, error)
+    QueryTasks(user_id int, q TaskQuery) ([]app.Task, error)
+    PageTasks(user_id int, q TaskQuery, page PageQuery) (TaskPage, error)
+    SearchTasks(user_id int, query string) ([]TaskMatch, error)
     CreateTask(task app.Task) error
     UpdateTask(task app.Task) error
-    DeleteTask(id uuid.UUID) error
+    DeleteTask(user_id int, id uuid.UUID) error
+    ImportTasks(user_id int, tasks []app.Task) (ImportResult, error)
+    RestoreTasks(user_id int, tasks []app.Task) (ImportResult, error)
+    PreviewImport(user_id int, tasks []app.Task) ([]bool, error)
+    GetCalDAVObjects(user_id int) ([]CalDAVObject, error)
+    GetCalDAVObject(user_id int, name string) (CalDAVObject, error)
+    PutCalDAVObject(user_id int, o CalDAVObject) (CalDAVObject, bool, error)
+    GetChecklist(user_id int, task_id uuid.UUID) ([]app.ChecklistItem, error)
+    AddChecklistItem(user_id int, task_id uuid.UUID, text string) (app.ChecklistItem, error)
+    SetChecklistItemDone(user_id int, task_id uuid.UUID, item_id int, done bool) error
+    MoveChecklistItem(user_id int, task_id uuid.UUID, item_id int, position int) error
+    DeleteChecklistItem(user_id int, task_id uuid.UUID, item_id int) error
+    GetTags(user_id int) ([]app.Tag, error)
+    CreateTag(tag app.Tag) (app.Tag, error)
+    DeleteTag(user_id int, tag_id int) error
+    GetProjects(user_id int) ([]app.Project, error)
+    GetProject(user_id int, project_id int) (app.Project, error)
+    CreateProject(project app.Project) (app.Project, error)
+    RenameProject(user_id int, project_id int, name string) error
+    DeleteProject(user_id int, project_id int) error
+    MoveTask(user_id int, task_id uuid.UUID, project_id int) error
 }
 
+// ErrAPITokenNotFound is returned when an API token doesn't exist, has expired, or belongs to another user.
+var ErrAPITokenNotFound = errors.New("API token not found")
+
+// ErrCalendarFeedNotFound is returned when the user has no calendar feed, or no feed has the token.
+var ErrCalendarFeedNotFound = errors.New("calendar feed not found")
+
+// APITokenPrefix starts every API token, which lets a bearer token be told apart from a session token before looking it up.
+const APITokenPrefix = "pnb_"
+
+// ErrLoginChallengeNotFound is returned when a login challenge doesn't exist or has expired.
+var ErrLoginChallengeNotFound = errors.New("login challenge not found")
+
+// How long a user has to enter their two-factor code after entering their password.
+const loginChallengeLifetime = 5 * time.Minute
+
+// ErrChecklistItemNotFound is returned when a checklist item doesn't exist or belongs to another task.
+var ErrChecklistItemNotFound = errors.New("checklist item not found")
+
+// ErrTagNotFound is returned when a tag doesn't exist or belongs to another user.
+var ErrTagNotFound = errors.New("tag not found")
+
+// ErrTagExists is returned when a user already has a tag with the same name, ignoring case.
+var ErrTagExists = errors.New("tag already exists")
+
+// ErrProjectNotFound is returned when a project doesn't exist or belongs to another user.
+var ErrProjectNotFound = errors.New("project not found")
+
+// ErrProjectExists is returned when a user already has a project with the same name, ignoring case.
+var ErrProjectExists = errors.New("project already exists")
+
+// ErrPasswordResetNotFound is returned when a password reset token doesn't exist, has expired, or has already been used.
+var ErrPasswordResetNotFound = errors.New("password reset not found")
+
+// How long a password reset link works for.
+const passwordResetLifetime = time.Hour
+
+// ErrUserNotFound is returned when no user matches.
+var ErrUserNotFound = errors.New("user not found")
+
+// ErrTaskNotFound is returned by task methods when no task with the given id belongs to the given user. It's deliberately the same whether the task doesn't exist or belongs to someone else, so as not to leak the existence of other users' tasks.
+var ErrTaskNotFound = errors.New("task not found")
+
 type SQLiteStore struct {
     db *sql.DB
     path string
@@ -58,7 +158,7 @@ func NewSQLiteStore(path string) (*SQLiteStore, error) {
 }
 
 func checkAllTablesExist(db *sql.DB) error {
-    tables := []string{"tasks", "users"}
+    tables := []string{"tasks", "users", "sessions", "api_tokens", "recovery_codes", "login_challenges", "password_resets", "checklist_items", "tags", "task_tags", "projects", "tasks_fts", "calendar_feeds", "caldav_objects"}
     for _, table := range tables {
         if err := checkTableExists(db, table); err != nil {
             return err
@@ -84,9 +184,9 @@ func (s *SQLiteStore) CreateUser(user app.User) error {
     defer s.mu.Unlock()
 
     _, err := s.db.Exec(`
-    INSERT INTO users (name, password_hash, email, phone, session_token_hash, session_expires_at)
-    VALUES (?, ?, ?, ?, '', ?)`,
-    user.Name, user.PasswordHash, user.Email, user.Phone, time.Unix(0, 0))
+    INSERT INTO users (name, password_hash, email, phone)
+    VALUES (?, ?, ?, ?)`,
+    user.Name, user.PasswordHash, user.Email, user.Phone)
 
     return err
 }
@@ -95,180 +195,2256 @@ func (s *SQLiteStore) GetUserByEmail(email string) (app.User, error) {
     s.mu.RLock()
     defer s.mu.RUnlock()
 
+    return scanUser(s.db.QueryRow(`SELECT `+userColumns+` FROM users WHERE email = ?`, email))
+}
+
+func (s *SQLiteStore) GetUserById(user_id int) (app.User, error) {
+    s.mu.RLock()
+    defer s.mu.RUnlock()
+
+    return scanUser(s.db.QueryRow(`SELECT `+userColumns+` FROM users WHERE id = ?`, user_id))
+}
+
+// MarkEmailVerified records that the user has confirmed their email address. The address is checked too, so that a link sent to an old address can't verify a new one.
+func (s *SQLiteStore) MarkEmailVerified(user_id int, email string) error {
+    s.mu.Lock()
+    defer s.mu.Unlock()
+
+    result, err := s.db.Exec(`UPDATE users SET email_verified = 1 WHERE id = ? AND email = ?`, user_id, email)
+    if err != nil {
+        return err
+    }
+    n, err := result.RowsAffected()
+    if err != nil {
+        return err
+    }
+    if n == 0 {
+        return ErrUserNotFound
+    }
+
+    return nil
+}
+
+const userColumns = `id, name, password_hash, email, phone, email_verified, locked_until, totp_secret, totp_enabled, totp_last_step, time_zone`
+
+func scanUser(row interface{ Scan(...any) error }) (app.User, error) {
     var user app.User
-    err := s.db.QueryRow(`SELECT id, name, password_hash, email, phone FROM users WHERE email = ?`, email).
-        Scan(&user.Id, &user.Name, &user.PasswordHash, &user.Email, &user.Phone)
+    var lockedUntil sql.NullTime
+    var totpSecret sql.NullString
+    err := row.Scan(&user.Id, &user.Name, &user.PasswordHash, &user.Email, &user.Phone, &user.EmailVerified, &lockedUntil, &totpSecret, &user.TOTPEnabled, &user.TOTPLastStep, &user.TimeZone)
+    user.LockedUntil = lockedUntil.Time
+    user.TOTPSecret = totpSecret.String
 
     return user, err
 }
 
-func (s *SQLiteStore) AddSessionToken(user_id int) (uuid.UUID, time.Time, error) {
+// SetTimeZone changes the user's time zone. Tasks due on a day, rather than at a time, stay due on the same day in the new zone.
+func (s *SQLiteStore) SetTimeZone(user_id int, timeZone string) error {
     s.mu.Lock()
     defer s.mu.Unlock()
 
-    sessionToken := uuid.New()
-    expiresAt := time.Now().Add(24 * time.Hour)
+    tx, err := s.db.Begin()
+    if err != nil {
+        return err
+    }
+    defer tx.Rollback()
 
-    sessionTokenHash, err := bcrypt.GenerateFromPassword(sessionToken[:], 10)
+    var old string
+    err = tx.QueryRow(`SELECT time_zone FROM users WHERE id = ?`, user_id).Scan(&old)
+    if err == sql.ErrNoRows {
+        return ErrUserNotFound
+    }
     if err != nil {
-        return uuid.Nil, time.Time{}, err
+        return err
     }
 
-	_, err = s.db.Exec(`
-        UPDATE users SET session_token_hash = ?, session_expires_at = ? WHERE id = ?
-    `, sessionTokenHash, expiresAt, user_id)
+    rows, err := tx.Query(`SELECT id, due FROM tasks WHERE user_id = ? AND all_day = 1`, user_id)
     if err != nil {
-        return uuid.Nil, time.Time{}, err
+        return err
+    }
+    dues := map[uuid.UUID]time.Time{}
+    for rows.Next() {
+        var id uuid.UUID
+        var due time.Time
+        if err := rows.Scan(&id, &due); err != nil {
+            rows.Close()
+            return err
+        }
+        dues[id] = due
+    }
+    if err := rows.Err(); err != nil {
+        return err
     }
+    rows.Close()
 
-    return sessionToken, expiresAt, err
+    from, to := app.Location(old), app.Location(timeZone)
+    for id, due := range dues {
+        day := due.In(from)
+        moved := app.EndOfDay(time.Date(day.Year(), day.Month(), day.Day(), 0, 0, 0, 0, to))
+        if _, err := tx.Exec(`UPDATE tasks SET due = ? WHERE id = ?`, moved.UTC(), id); err != nil {
+            return err
+        }
+    }
+
+    if _, err := tx.Exec(`UPDATE users SET time_zone = ? WHERE id = ?`, timeZone, user_id); err != nil {
+        return err
+    }
+    return tx.Commit()
 }
 
-func (s *SQLiteStore) GetUserIdFromSessionToken(sessionToken uuid.UUID) (int, error) {
-    s.mu.RLock()
-    defer s.mu.RUnlock()
+// RecordFailedLogin counts a failed login attempt against the user. Once `maxAttempts` have failed in a row, the account is locked for `lockFor` and the count starts again. It returns the time the lock expires, or the zero time if the account isn't locked.
+func (s *SQLiteStore) RecordFailedLogin(user_id int, maxAttempts int, lockFor time.Duration) (time.Time, error) {
+    s.mu.Lock()
+    defer s.mu.Unlock()
 
-    if sessionToken == uuid.Nil {
-        return 0, errors.New("session token is empty")
+    var failedLogins int
+    err := s.db.QueryRow(`UPDATE users SET failed_logins = failed_logins + 1 WHERE id = ? RETURNING failed_logins`, user_id).
+        Scan(&failedLogins)
+    if err != nil {
+        return time.Time{}, err
+    }
+
+    if failedLogins < maxAttempts {
+        return time.Time{}, nil
     }
 
-    rows, err := s.db.Query(`SELECT id, session_token_hash, session_expires_at FROM users`)
+    lockedUntil := time.Now().Add(lockFor)
+    _, err = s.db.Exec(`UPDATE users SET failed_logins = 0, locked_until = ? WHERE id = ?`, lockedUntil, user_id)
     if err != nil {
-        return 0, err
+        return time.Time{}, err
     }
-    defer rows.Close()
 
-    for rows.Next() {
-        var userId int
-        var hash []byte
-        var expiresAt time.Time
+    return lockedUntil, nil
+}
 
-        if err := rows.Scan(&userId, &hash, &expiresAt); err != nil {
-            return 0, err
-        }
+// ResetFailedLogins clears the count of failed login attempts and any lock, after a successful login.
+func (s *SQLiteStore) ResetFailedLogins(user_id int) error {
+    s.mu.Lock()
+    defer s.mu.Unlock()
 
-        if len(hash) == 0 {
-            continue // Skip users with no session token.
-        }
+    _, err := s.db.Exec(`UPDATE users SET failed_logins = 0, locked_until = NULL WHERE id = ?`, user_id)
 
-        if err := bcrypt.CompareHashAndPassword(hash, sessionToken[:]); err == nil {
-            if time.Now().After(expiresAt) {
-                return 0, errors.New("session token has expired")
-            }
-            return userId, nil
+    return err
+}
+
+// SetPendingTOTPSecret stores a new secret for the user to enrol with. Two-factor authentication stays off until EnableTOTP is called, once the user has shown they can generate codes from the secret.
+func (s *SQLiteStore) SetPendingTOTPSecret(user_id int, secret string) error {
+    s.mu.Lock()
+    defer s.mu.Unlock()
+
+    _, err := s.db.Exec(`UPDATE users SET totp_secret = ?, totp_enabled = 0, totp_last_step = 0 WHERE id = ? AND totp_enabled = 0`, secret, user_id)
+
+    return err
+}
+
+// EnableTOTP turns on two-factor authentication with the pending secret and replaces the user's recovery codes with the given ones, which should already be normalized. Only hashes of the codes are stored.
+func (s *SQLiteStore) EnableTOTP(user_id int, recoveryCodes []string) error {
+    s.mu.Lock()
+    defer s.mu.Unlock()
+
+    tx, err := s.db.Begin()
+    if err != nil {
+        return err
+    }
+    defer tx.Rollback()
+
+    result, err := tx.Exec(`UPDATE users SET totp_enabled = 1 WHERE id = ? AND totp_secret IS NOT NULL`, user_id)
+    if err != nil {
+        return err
+    }
+    if n, err := result.RowsAffected(); err != nil {
+        return err
+    } else if n == 0 {
+        return errors.New("no pending TOTP secret")
+    }
+
+    if _, err := tx.Exec(`DELETE FROM recovery_codes WHERE user_id = ?`, user_id); err != nil {
+        return err
+    }
+    for _, code := range recoveryCodes {
+        if _, err := tx.Exec(`INSERT INTO recovery_codes (user_id, code_hash) VALUES (?, ?)`, user_id, HashToken(code)); err != nil {
+            return err
         }
     }
 
-    return 0, errors.New("session token not found")
+    return tx.Commit()
+}
+
+// DisableTOTP turns off two-factor authentication, forgetting the secret and any recovery codes.
+func (s *SQLiteStore) DisableTOTP(user_id int) error {
+    s.mu.Lock()
+    defer s.mu.Unlock()
+
+    tx, err := s.db.Begin()
+    if err != nil {
+        return err
+    }
+    defer tx.Rollback()
+
+    if _, err := tx.Exec(`UPDATE users SET totp_secret = NULL, totp_enabled = 0, totp_last_step = 0 WHERE id = ?`, user_id); err != nil {
+        return err
+    }
+    if _, err := tx.Exec(`DELETE FROM recovery_codes WHERE user_id = ?`, user_id); err != nil {
+        return err
+    }
+
+    return tx.Commit()
+}
+
+// RecordTOTPStep records that a code for `step` has been accepted. It reports false if a code for that step or a later one was already accepted, in which case the code is a replay and should be refused.
+func (s *SQLiteStore) RecordTOTPStep(user_id int, step int64) (bool, error) {
+    s.mu.Lock()
+    defer s.mu.Unlock()
+
+    result, err := s.db.Exec(`UPDATE users SET totp_last_step = ? WHERE id = ? AND totp_last_step < ?`, step, user_id, step)
+    if err != nil {
+        return false, err
+    }
+    n, err := result.RowsAffected()
+
+    return n == 1, err
+}
+
+// UseRecoveryCode marks one of the user's recovery codes as used. It reports false if the code doesn't match an unused one.
+func (s *SQLiteStore) UseRecoveryCode(user_id int, code string) (bool, error) {
+    s.mu.Lock()
+    defer s.mu.Unlock()
+
+    result, err := s.db.Exec(`UPDATE recovery_codes SET used_at = ? WHERE user_id = ? AND code_hash = ? AND used_at IS NULL`, time.Now(), user_id, HashToken(code))
+    if err != nil {
+        return false, err
+    }
+    n, err := result.RowsAffected()
+
+    return n == 1, err
 }
 
-func (s *SQLiteStore) GetTaskById(id uuid.UUID) (app.Task, error) {
+// CountRecoveryCodes returns how many of the user's recovery codes are still unused.
+func (s *SQLiteStore) CountRecoveryCodes(user_id int) (int, error) {
     s.mu.RLock()
     defer s.mu.RUnlock()
 
-    var t app.Task
-    err := s.db.QueryRow(`SELECT id, title, description, done, due FROM tasks WHERE id = ?`, id).
-        Scan(&t.Id, &t.Title, &t.Description, &t.Done, &t.Due)
-    t.SetStatus()
+    var count int
+    err := s.db.QueryRow(`SELECT COUNT(*) FROM recovery_codes WHERE user_id = ? AND used_at IS NULL`, user_id).Scan(&count)
 
-    return t, err
+    return count, err
 }
 
-func (s *SQLiteStore) GetAllTasks(user_id int) ([]app.Task, error) {
+// CreateLoginChallenge records that the user has entered the right password but still has to enter a two-factor code. The returned token identifies the challenge; like session tokens, only its hash is stored.
+func (s *SQLiteStore) CreateLoginChallenge(user_id int) (string, time.Time, error) {
+    s.mu.Lock()
+    defer s.mu.Unlock()
+
+    token, err := GenerateToken()
+    if err != nil {
+        return "", time.Time{}, err
+    }
+
+    now := time.Now()
+    expiresAt := now.Add(loginChallengeLifetime)
+
+    if _, err := s.db.Exec(`DELETE FROM login_challenges WHERE expires_at <= ?`, now); err != nil {
+        return "", time.Time{}, err
+    }
+
+    _, err = s.db.Exec(`INSERT INTO login_challenges (user_id, token_hash, expires_at) VALUES (?, ?, ?)`, user_id, HashToken(token), expiresAt)
+    if err != nil {
+        return "", time.Time{}, err
+    }
+
+    return token, expiresAt, nil
+}
+
+// GetLoginChallenge returns the id of the user an unexpired login challenge belongs to.
+func (s *SQLiteStore) GetLoginChallenge(token string) (int, error) {
     s.mu.RLock()
     defer s.mu.RUnlock()
 
-    rows, err := s.db.Query(`SELECT id, title, description, done, due FROM tasks WHERE user_id = ?`, user_id)
+    var userId int
+    err := s.db.QueryRow(`SELECT user_id FROM login_challenges WHERE token_hash = ? AND expires_at > ?`, HashToken(token), time.Now()).
+        Scan(&userId)
+    if err == sql.ErrNoRows {
+        return 0, ErrLoginChallengeNotFound
+    }
+
+    return userId, err
+}
+
+func (s *SQLiteStore) DeleteLoginChallenge(token string) error {
+    s.mu.Lock()
+    defer s.mu.Unlock()
+
+    _, err := s.db.Exec(`DELETE FROM login_challenges WHERE token_hash = ?`, HashToken(token))
+
+    return err
+}
+
+// CreatePasswordReset creates a single-use token the user can reset their password with. Only its hash is stored.
+func (s *SQLiteStore) CreatePasswordReset(user_id int) (string, time.Time, error) {
+    s.mu.Lock()
+    defer s.mu.Unlock()
+
+    token, err := GenerateToken()
     if err != nil {
-        return nil, err
+        return "", time.Time{}, err
     }
-    tasks := []app.Task{}
-    for rows.Next() {
-        var t app.Task
-        err := rows.Scan(&t.Id, &t.Title, &t.Description, &t.Done, &t.Due)
-        if err != nil {
-            return nil, err
-        }
-        t.SetStatus()
-        tasks = append(tasks, t)
+
+    now := time.Now()
+    expiresAt := now.Add(passwordResetLifetime)
+
+    _, err = s.db.Exec(`DELETE FROM password_resets WHERE user_id = ? AND (expires_at <= ? OR used_at IS NOT NULL)`, user_id, now)
+    if err != nil {
+        return "", time.Time{}, err
     }
 
-    return tasks, nil
+    _, err = s.db.Exec(`
+        INSERT INTO password_resets (user_id, token_hash, created_at, expires_at)
+        VALUES (?, ?, ?, ?)
+    `, user_id, HashToken(token), now, expiresAt)
+    if err != nil {
+        return "", time.Time{}, err
+    }
+
+    return token, expiresAt, nil
 }
 
-func (s *SQLiteStore) DeleteTask(id uuid.UUID) error {
+// GetPasswordReset returns the id of the user an unused, unexpired password reset token belongs to.
+func (s *SQLiteStore) GetPasswordReset(token string) (int, error) {
+    s.mu.RLock()
+    defer s.mu.RUnlock()
+
+    var userId int
+    err := s.db.QueryRow(`SELECT user_id FROM password_resets WHERE token_hash = ? AND expires_at > ? AND used_at IS NULL`, HashToken(token), time.Now()).
+        Scan(&userId)
+    if err == sql.ErrNoRows {
+        return 0, ErrPasswordResetNotFound
+    }
+
+    return userId, err
+}
+
+// ResetPassword uses up a password reset token and sets the user's new password. In the same transaction it revokes all of the user's sessions and other reset tokens and lifts any lockout, so whoever knew the old password is logged out. It returns the user's id.
+func (s *SQLiteStore) ResetPassword(token string, passwordHash []byte) (int, error) {
     s.mu.Lock()
     defer s.mu.Unlock()
 
-    _, err := s.db.Exec(`DELETE FROM tasks WHERE id = ?`, id)
-    
-    return err
+    tx, err := s.db.Begin()
+    if err != nil {
+        return 0, err
+    }
+    defer tx.Rollback()
+
+    now := time.Now()
+    var userId int
+    err = tx.QueryRow(`
+        UPDATE password_resets SET used_at = ?
+        WHERE token_hash = ? AND expires_at > ? AND used_at IS NULL
+        RETURNING user_id
+    `, now, HashToken(token), now).Scan(&userId)
+    if err == sql.ErrNoRows {
+        return 0, ErrPasswordResetNotFound
+    }
+    if err != nil {
+        return 0, err
+    }
+
+    if _, err := tx.Exec(`UPDATE users SET password_hash = ?, failed_logins = 0, locked_until = NULL WHERE id = ?`, passwordHash, userId); err != nil {
+        return 0, err
+    }
+    if _, err := tx.Exec(`DELETE FROM sessions WHERE user_id = ?`, userId); err != nil {
+        return 0, err
+    }
+    if _, err := tx.Exec(`DELETE FROM password_resets WHERE user_id = ? AND used_at IS NULL`, userId); err != nil {
+        return 0, err
+    }
+
+    return userId, tx.Commit()
 }
 
-func (s *SQLiteStore) CreateTask(t app.Task) error {
+// GenerateToken returns a random, URL-safe token carrying 256 bits of entropy.
+func GenerateToken() (string, error) {
+    b := make([]byte, 32)
+    if _, err := rand.Read(b); err != nil {
+        return "", err
+    }
+    return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// HashToken returns the SHA-256 hash under which a token generated by GenerateToken is stored. A fast, unsalted hash is enough here, unlike for passwords, because the token is random and far too long to brute-force; and it lets the hash itself be used as an indexed lookup key.
+func HashToken(token string) []byte {
+    sum := sha256.Sum256([]byte(token))
+    return sum[:]
+}
+
+// AddSessionToken starts a new session for the user, leaving any sessions they have on other devices intact. Expired sessions belonging to the user are cleared out at the same time.
+func (s *SQLiteStore) AddSessionToken(user_id int, userAgent string, ip string) (string, time.Time, error) {
     s.mu.Lock()
     defer s.mu.Unlock()
 
-    _, err := s.db.Exec(`
-        INSERT INTO tasks (id, user_id, title, description, done, due)
-        VALUES (?, ?, ?, ?, ?, ?)
-    `, t.Id, t.UserId, t.Title, t.Description, t.Done, t.Due)
+    sessionToken, err := GenerateToken()
+    if err != nil {
+        return "", time.Time{}, err
+    }
 
-    return err
+    now := time.Now()
+    expiresAt := now.Add(24 * time.Hour)
+
+    _, err = s.db.Exec(`DELETE FROM sessions WHERE user_id = ? AND expires_at <= ?`, user_id, now)
+    if err != nil {
+        return "", time.Time{}, err
+    }
+
+    _, err = s.db.Exec(`
+        INSERT INTO sessions (user_id, token_hash, created_at, last_seen_at, expires_at, user_agent, ip)
+        VALUES (?, ?, ?, ?, ?, ?, ?)
+    `, user_id, HashToken(sessionToken), now, now, expiresAt, userAgent, ip)
+    if err != nil {
+        return "", time.Time{}, err
+    }
+
+    return sessionToken, expiresAt, err
 }
 
-func (s *SQLiteStore) UpdateTask(t app.Task) error {
+// findSession returns the id and user id of the unexpired session matching the token. The lookup goes through the unique index on `token_hash`, so its cost doesn't depend on how many sessions or users there are.
+func (s *SQLiteStore) findSession(sessionToken string) (int64, int, error) {
+    if sessionToken == "" {
+        return 0, 0, errors.New("session token is empty")
+    }
+
+    var sessionId int64
+    var userId int
+    err := s.db.QueryRow(`SELECT id, user_id FROM sessions WHERE token_hash = ? AND expires_at > ?`, HashToken(sessionToken), time.Now()).
+        Scan(&sessionId, &userId)
+    if err == sql.ErrNoRows {
+        return 0, 0, errors.New("session token not found")
+    }
+    if err != nil {
+        return 0, 0, err
+    }
+
+    return sessionId, userId, nil
+}
+
+func (s *SQLiteStore) GetUserIdFromSessionToken(sessionToken string) (int, error) {
     s.mu.Lock()
     defer s.mu.Unlock()
 
-    _, err := s.db.Exec(`
-        UPDATE tasks
-        SET title = ?, description = ?, done = ?, due = ?
-        WHERE id = ?
-    `, t.Title, t.Description, t.Done, t.Due, t.Id)
+    sessionId, userId, err := s.findSession(sessionToken)
+    if err != nil {
+        return 0, err
+    }
+
+    _, err = s.db.Exec(`UPDATE sessions SET last_seen_at = ? WHERE id = ?`, time.Now(), sessionId)
+    if err != nil {
+        return 0, err
+    }
+
+    return userId, nil
+}
+
+// DeleteSession revokes the session matching the token, leaving the user's other sessions intact.
+func (s *SQLiteStore) DeleteSession(sessionToken string) error {
+    s.mu.Lock()
+    defer s.mu.Unlock()
+
+    sessionId, _, err := s.findSession(sessionToken)
+    if err != nil {
+        return err
+    }
+
+    _, err = s.db.Exec(`DELETE FROM sessions WHERE id = ?`, sessionId)
 
     return err
 }
 
-func (s *SQLiteStore) SetTaskDone(id uuid.UUID) error {
+// DeleteAllSessions revokes every session belonging to the user, on every device.
+func (s *SQLiteStore) DeleteAllSessions(user_id int) error {
     s.mu.Lock()
     defer s.mu.Unlock()
 
-    _, err := s.db.Exec(`UPDATE tasks SET done = 1 WHERE id = ?`, id)
+    _, err := s.db.Exec(`DELETE FROM sessions WHERE user_id = ?`, user_id)
 
     return err
 }
 
-func TestSetTaskDone(t *testing.T) {
-    db, err := sql.Open("sqlite3", ":memory:")
+// CreateAPIToken creates a token for the user and returns it. A zero `expiresAt` means the token never expires.
+func (s *SQLiteStore) CreateAPIToken(user_id int, name string, scope string, expiresAt time.Time) (string, error) {
+    s.mu.Lock()
+    defer s.mu.Unlock()
+
+    if scope != app.ScopeRead && scope != app.ScopeReadWrite {
+        return "", fmt.Errorf("invalid scope %q", scope)
+    }
+
+    token, err := GenerateToken()
     if err != nil {
-        t.Fatalf("failed to open db: %v", err)
+        return "", err
     }
-    defer db.Close()
+    token = APITokenPrefix + token
 
-    store := &SQLiteStore{db: db}
+    var expires sql.NullTime
+    if !expiresAt.IsZero() {
+        expires = sql.NullTime{Time: expiresAt, Valid: true}
+    }
 
-    _, err = db.Exec(`
-        CREATE TABLE tasks (
-            id TEXT PRIMARY KEY,
-            title TEXT,
-            description TEXT,
-            done INTEGER,
-            due TEXT
-        )`)
+    _, err = s.db.Exec(`
+        INSERT INTO api_tokens (user_id, name, token_hash, scope, created_at, expires_at)
+        VALUES (?, ?, ?, ?, ?, ?)
+    `, user_id, name, HashToken(token), scope, time.Now(), expires)
     if err != nil {
-        t.Fatalf("failed to create table: %v", err)
+        return "", err
     }
 
-    taskID := uuid.New()
-    _, err = db.Exec(`
-        INSERT INTO tasks (id, title, description, done, due)
-        VALUES (?, ?, ?, ?, ?)`, taskID, "Test Task", "Test Description", 0, "2025-05-13")
+    return token, nil
+}
+
+// GetAPITokens returns all of the user's tokens, including expired ones, newest first.
+func (s *SQLiteStore) GetAPITokens(user_id int) ([]app.APIToken, error) {
+    s.mu.RLock()
+    defer s.mu.RUnlock()
+
+    rows, err := s.db.Query(`
+        SELECT id, user_id, name, scope, created_at, last_used_at, expires_at
+        FROM api_tokens WHERE user_id = ? ORDER BY created_at DESC, id DESC
+    `, user_id)
+    if err != nil {
+        return nil, err
+    }
+    defer rows.Close()
+
+    tokens := []app.APIToken{}
+    for rows.Next() {
+        t, err := scanAPIToken(rows)
+        if err != nil {
+            return nil, err
+        }
+        tokens = append(tokens, t)
+    }
+
+    return tokens, rows.Err()
+}
+
+// GetAPIToken looks up an unexpired token and records that it has been used.
+func (s *SQLiteStore) GetAPIToken(token string) (app.APIToken, error) {
+    s.mu.Lock()
+    defer s.mu.Unlock()
+
+    now := time.Now()
+    row := s.db.QueryRow(`
+        SELECT id, user_id, name, scope, created_at, last_used_at, expires_at
+        FROM api_tokens WHERE token_hash = ? AND (expires_at IS NULL OR expires_at > ?)
+    `, HashToken(token), now)
+    t, err := scanAPIToken(row)
+    if err == sql.ErrNoRows {
+        return app.APIToken{}, ErrAPITokenNotFound
+    }
+    if err != nil {
+        return app.APIToken{}, err
+    }
+
+    _, err = s.db.Exec(`UPDATE api_tokens SET last_used_at = ? WHERE id = ?`, now, t.Id)
+    if err != nil {
+        return app.APIToken{}, err
+    }
+    t.LastUsedAt = now
+
+    return t, nil
+}
+
+// DeleteAPIToken revokes one of the user's tokens.
+func (s *SQLiteStore) DeleteAPIToken(user_id int, id int) error {
+    s.mu.Lock()
+    defer s.mu.Unlock()
+
+    result, err := s.db.Exec(`DELETE FROM api_tokens WHERE id = ? AND user_id = ?`, id, user_id)
+    if err != nil {
+        return err
+    }
+
+    n, err := result.RowsAffected()
+    if err != nil {
+        return err
+    }
+    if n == 0 {
+        return ErrAPITokenNotFound
+    }
+    return nil
+}
+
+// scanAPIToken scans a row of `id, user_id, name, scope, created_at, last_used_at, expires_at`.
+func scanAPIToken(row interface{ Scan(...any) error }) (app.APIToken, error) {
+    var t app.APIToken
+    var lastUsedAt, expiresAt sql.NullTime
+    err := row.Scan(&t.Id, &t.UserId, &t.Name, &t.Scope, &t.CreatedAt, &lastUsedAt, &expiresAt)
+    t.LastUsedAt = lastUsedAt.Time
+    t.ExpiresAt = expiresAt.Time
+    return t, err
+}
+
+// CreateCalendarFeed gives the user a calendar feed and returns its token, replacing the token of any feed they already had.
+func (s *SQLiteStore) CreateCalendarFeed(user_id int) (string, error) {
+    s.mu.Lock()
+    defer s.mu.Unlock()
+
+    token, err := GenerateToken()
+    if err != nil {
+        return "", err
+    }
+
+    now := time.Now()
+    _, err = s.db.Exec(`
+        INSERT INTO calendar_feeds (user_id, token_hash, created_at, changed_at) VALUES (?, ?, ?, ?)
+        ON CONFLICT (user_id) DO UPDATE SET
+            token_hash = excluded.token_hash, created_at = excluded.created_at, changed_at = excluded.changed_at, last_polled_at = NULL
+    `, user_id, HashToken(token), now, now)
+    if err != nil {
+        return "", err
+    }
+
+    return token, nil
+}
+
+// GetCalendarFeed returns the user's calendar feed.
+func (s *SQLiteStore) GetCalendarFeed(user_id int) (app.CalendarFeed, error) {
+    s.mu.RLock()
+    defer s.mu.RUnlock()
+
+    f, err := scanCalendarFeed(s.db.QueryRow(`
+        SELECT user_id, created_at, changed_at, last_polled_at FROM calendar_feeds WHERE user_id = ?
+    `, user_id))
+    if err == sql.ErrNoRows {
+        return app.CalendarFeed{}, ErrCalendarFeedNotFound
+    }
+    return f, err
+}
+
+// GetCalendarFeedByToken looks up the feed with the token and records that it has been polled.
+func (s *SQLiteStore) GetCalendarFeedByToken(token string) (app.CalendarFeed, error) {
+    s.mu.Lock()
+    defer s.mu.Unlock()
+
+    f, err := scanCalendarFeed(s.db.QueryRow(`
+        SELECT user_id, created_at, changed_at, last_polled_at FROM calendar_feeds WHERE token_hash = ?
+    `, HashToken(token)))
+    if err == sql.ErrNoRows {
+        return app.CalendarFeed{}, ErrCalendarFeedNotFound
+    }
+    if err != nil {
+        return app.CalendarFeed{}, err
+    }
+
+    now := time.Now()
+    if _, err := s.db.Exec(`UPDATE calendar_feeds SET last_polled_at = ? WHERE user_id = ?`, now, f.UserId); err != nil {
+        return app.CalendarFeed{}, err
+    }
+    f.LastPolledAt = now
+
+    return f, nil
+}
+
+// DeleteCalendarFeed revokes the user's calendar feed.
+func (s *SQLiteStore) DeleteCalendarFeed(user_id int) error {
+    s.mu.Lock()
+    defer s.mu.Unlock()
+
+    result, err := s.db.Exec(`DELETE FROM calendar_feeds WHERE user_id = ?`, user_id)
+    if err != nil {
+        return err
+    }
+
+    n, err := result.RowsAffected()
+    if err != nil {
+        return err
+    }
+    if n == 0 {
+        return ErrCalendarFeedNotFound
+    }
+    return nil
+}
+
+// scanCalendarFeed scans a row of `user_id, created_at, changed_at, last_polled_at`.
+func scanCalendarFeed(row interface{ Scan(...any) error }) (app.CalendarFeed, error) {
+    var f app.CalendarFeed
+    var lastPolledAt sql.NullTime
+    err := row.Scan(&f.UserId, &f.CreatedAt, &f.ChangedAt, &lastPolledAt)
+    f.LastPolledAt = lastPolledAt.Time
+    return f, err
+}
+
+func (s *SQLiteStore) GetTaskById(user_id int, id uuid.UUID) (app.Task, error) {
+    s.mu.RLock()
+    defer s.mu.RUnlock()
+
+    t, err := scanTask(s.db.QueryRow(`SELECT `+taskColumns+` FROM tasks WHERE id = ? AND user_id = ?`, id, user_id))
+    if err == sql.ErrNoRows {
+        return app.Task{}, ErrTaskNotFound
+    }
+    if err != nil {
+        return app.Task{}, err
+    }
+    t.SetStatus()
+
+    tasks := []app.Task{t}
+    err = attachTags(s.db, user_id, tasks)
+
+    return tasks[0], err
+}
+
+const taskColumns = `id, user_id, title, description, done, due, all_day, completed_at, recurrence, project_id, priority, created_at,
+    (SELECT time_zone FROM users WHERE users.id = tasks.user_id),
+    (SELECT COUNT(*) FROM checklist_items WHERE task_id = tasks.id),
+    (SELECT COUNT(*) FROM checklist_items WHERE task_id = tasks.id AND done = 1)`
+
+func scanTask(row interface{ Scan(...any) error }) (app.Task, error) {
+    var t app.Task
+    var completedAt sql.NullTime
+    var recurrence sql.NullString
+    var projectId sql.NullInt64
+    var createdAt sql.NullTime
+    var timeZone sql.NullString
+    err := row.Scan(&t.Id, &t.UserId, &t.Title, &t.Description, &t.Done, &t.Due, &t.AllDay, &completedAt, &recurrence, &projectId, &t.Priority, &createdAt, &timeZone, &t.ChecklistTotal, &t.ChecklistDone)
+    t.CompletedAt = completedAt.Time
+    t.Recurrence = recurrence.String
+    t.ProjectId = int(projectId.Int64)
+    t.CreatedAt = createdAt.Time
+    t.TimeZone = timeZone.String
+    t.Due = t.Due.UTC()
+
+    return t, err
+}
+
+func (s *SQLiteStore) GetAllTasks(user_id int) ([]app.Task, error) {
+    return s.QueryTasks(user_id, TaskQuery{})
+}
+
+// TaskQuery narrows down the tasks QueryTasks and PageTasks return, and says in what order. The zero value matches all of a user's tasks, soonest due first.
+type TaskQuery struct {
+    Tags      []string    // Only tasks with every one of these tags, by name, ignoring case.
+    ProjectId int         // Only tasks in this project, unless it's zero.
+    Status    string      // Only tasks with this status, `pending`, `overdue`, or `done`, unless it's empty.
+    DueFrom   time.Time   // Only tasks due at or after this, unless it's zero.
+    DueTo     time.Time   // Only tasks due at or before this, unless it's zero.
+    Text      string      // Only tasks whose title or description matches this, searched as by SearchTasks, unless it's empty.
+    Order     []TaskOrder // Ties, and the zero value, are broken by due date, then by when the task was created.
+}
+
+// TaskSort is a field that tasks can be sorted by.
+type TaskSort string
+
+const (
+    SortDue      TaskSort = "due"
+    SortPriority TaskSort = "priority"
+    SortStatus   TaskSort = "status"
+    SortTitle    TaskSort = "title"
+    SortCreated  TaskSort = "created"
+)
+
+// TaskOrder is one key of a TaskQuery's order.
+type TaskOrder struct {
+    By   TaskSort
+    Desc bool
+}
+
+// taskSortExprs maps each TaskSort to what it orders by in SQL. Statuses sort as overdue, then pending, then done. Creation times are always stored in UTC, so they sort as text, to the nanosecond, and tasks from before they were recorded sort as the oldest.
+var taskSortExprs = map[TaskSort]string{
+    SortDue:      `julianday(due)`,
+    SortPriority: `priority`,
+    SortStatus:   `CASE WHEN done = 1 THEN 2 WHEN julianday(due) < julianday('now') THEN 0 ELSE 1 END`,
+    SortTitle:    `title COLLATE NOCASE`,
+    SortCreated:  `COALESCE(created_at, '')`,
+}
+
+// ValidTaskSort reports whether tasks can be sorted by the field.
+func ValidTaskSort(by TaskSort) bool {
+    _, ok := taskSortExprs[by]
+    return ok
+}
+
+// ErrInvalidTaskSort is returned by QueryTasks when asked to sort by an unknown field.
+var ErrInvalidTaskSort = errors.New("invalid task sort")
+
+// ErrInvalidTaskFilter is returned by QueryTasks and PageTasks when asked for tasks with an unknown status.
+var ErrInvalidTaskFilter = errors.New("invalid task filter")
+
+// taskStatusRanks maps each status to its value of the status sort expression, which is how tasks are filtered by it.
+var taskStatusRanks = map[string]int{
+    "overdue": 0,
+    "pending": 1,
+    "done":    2,
+}
+
+// sortKey is one expression tasks are ordered by, from taskSortExprs or a tiebreaker.
+type sortKey struct {
+    expr string
+    desc bool
+}
+
+// sortKeys lists the expressions for the query's order, followed by the tiebreakers, due date and then rowid, so that no two tasks are ever level. Only the expressions in taskSortExprs make it into the SQL.
+func sortKeys(order []TaskOrder) ([]sortKey, error) {
+    var keys []sortKey
+    for _, o := range order {
+        expr, ok := taskSortExprs[o.By]
+        if !ok {
+            return nil, ErrInvalidTaskSort
+        }
+        keys = append(keys, sortKey{expr, o.Desc})
+    }
+    return append(keys, sortKey{taskSortExprs[SortDue], false}, sortKey{`rowid`, false}), nil
+}
+
+// orderBy builds the ORDER BY clause for the keys, backwards if reverse is set.
+func orderBy(keys []sortKey, reverse bool) string {
+    var terms []string
+    for _, k := range keys {
+        if k.desc != reverse {
+            terms = append(terms, k.expr+` DESC`)
+        } else {
+            terms = append(terms, k.expr)
+        }
+    }
+    return ` ORDER BY ` + strings.Join(terms, `, `)
+}
+
+// taskFilter builds the WHERE clause that picks out the user's tasks matching the query.
+func taskFilter(user_id int, q TaskQuery) (string, []any, error) {
+    where := ` WHERE user_id = ?`
+    args := []any{user_id}
+    if q.ProjectId != 0 {
+        where += ` AND project_id = ?`
+        args = append(args, q.ProjectId)
+    }
+    for _, tag := range q.Tags {
+        where += `
+            AND EXISTS (
+                SELECT 1 FROM task_tags JOIN tags ON tags.id = task_tags.tag_id
+                WHERE task_tags.task_id = tasks.id AND tags.user_id = ? AND tags.name = ?
+            )`
+        args = append(args, user_id, tag)
+    }
+    if q.Status != "" {
+        rank, ok := taskStatusRanks[q.Status]
+        if !ok {
+            return "", nil, ErrInvalidTaskFilter
+        }
+        where += ` AND ` + taskSortExprs[SortStatus] + ` = ?`
+        args = append(args, rank)
+    }
+    // julianday keeps times to the millisecond, dropping the rest, so the last instant of a day still falls before the next.
+    if !q.DueFrom.IsZero() {
+        where += ` AND julianday(due) >= julianday(?)`
+        args = append(args, q.DueFrom.UTC())
+    }
+    if !q.DueTo.IsZero() {
+        where += ` AND julianday(due) <= julianday(?)`
+        args = append(args, q.DueTo.UTC())
+    }
+    if text := strings.TrimSpace(q.Text); text != "" {
+        match := ftsQuery(text)
+        if match == "" {
+            // Nothing in the text can match, so neither can any task.
+            where += ` AND 0`
+        } else {
+            where += ` AND id IN (SELECT task_id FROM tasks_fts WHERE tasks_fts MATCH ?)`
+            args = append(args, match)
+        }
+    }
+    return where, args, nil
+}
+
+// QueryTasks returns the user's tasks that match the query, with their tags.
+func (s *SQLiteStore) QueryTasks(user_id int, q TaskQuery) ([]app.Task, error) {
+    s.mu.RLock()
+    defer s.mu.RUnlock()
+
+    where, args, err := taskFilter(user_id, q)
+    if err != nil {
+        return nil, err
+    }
+    keys, err := sortKeys(q.Order)
+    if err != nil {
+        return nil, err
+    }
+
+    rows, err := s.db.Query(`SELECT `+taskColumns+` FROM tasks`+where+orderBy(keys, false), args...)
+    if err != nil {
+        return nil, err
+    }
+    defer rows.Close()
+
+    tasks := []app.Task{}
+    for rows.Next() {
+        t, err := scanTask(rows)
+        if err != nil {
+            return nil, err
+        }
+        t.SetStatus()
+        tasks = append(tasks, t)
+    }
+    if err := rows.Err(); err != nil {
+        return nil, err
+    }
+    rows.Close()
+
+    if err := attachTags(s.db, user_id, tasks); err != nil {
+        return nil, err
+    }
+    return tasks, nil
+}
+
+// PageQuery asks for one page of a task query: the first, or the one after or before the page a cursor came from.
+type PageQuery struct {
+    Size   int
+    After  string // TaskPage.Next of the page before, to get the page after it.
+    Before string // TaskPage.Prev of the page after, to get the page before it.
+}
+
+// TaskPage is one page of the tasks matching a query, with cursors for the pages either side of it, each empty if there's no such page.
+type TaskPage struct {
+    Tasks []app.Task
+    Next  string
+    Prev  string
+}
+
+// ErrInvalidCursor is returned by PageTasks for a cursor it didn't make, or that was made for a differently sorted query.
+var ErrInvalidCursor = errors.New("invalid page cursor")
+
+// PageTasks returns a page of the user's tasks that match the query, with their tags. Pages are found by the sort keys of the task at their edge rather than an offset, so tasks added or removed before a page don't shift it.
+func (s *SQLiteStore) PageTasks(user_id int, q TaskQuery, page PageQuery) (TaskPage, error) {
+    if page.Size <= 0 || (page.After != "" && page.Before != "") {
+        return TaskPage{}, ErrInvalidCursor
+    }
+    where, args, err := taskFilter(user_id, q)
+    if err != nil {
+        return TaskPage{}, err
+    }
+    keys, err := sortKeys(q.Order)
+    if err != nil {
+        return TaskPage{}, err
+    }
+
+    // Going back, the page before the cursor is read in reverse order, then turned around.
+    cursor, reverse := page.After, false
+    if page.Before != "" {
+        cursor, reverse = page.Before, true
+    }
+    if cursor != "" {
+        values, err := decodeCursor(cursor, len(keys))
+        if err != nil {
+            return TaskPage{}, err
+        }
+        seek, seekArgs := keysetFilter(keys, values, reverse)
+        where += ` AND ` + seek
+        args = append(args, seekArgs...)
+    }
+
+    var exprs []string
+    for _, k := range keys {
+        exprs = append(exprs, k.expr)
+    }
+    s.mu.RLock()
+    defer s.mu.RUnlock()
+
+    // One more than a page is read to find out whether there's another page beyond it.
+    rows, err := s.db.Query(`SELECT `+taskColumns+`, `+strings.Join(exprs, `, `)+` FROM tasks`+where+orderBy(keys, reverse)+` LIMIT ?`, append(args, page.Size+1)...)
+    if err != nil {
+        return TaskPage{}, err
+    }
+    defer rows.Close()
+
+    tasks := []app.Task{}
+    var cursors [][]any
+    for rows.Next() {
+        values := make([]any, len(keys))
+        dest := make([]any, len(keys))
+        for i := range values {
+            dest[i] = &values[i]
+        }
+        t, err := scanTask(scanWith{rows, dest})
+        if err != nil {
+            return TaskPage{}, err
+        }
+        t.SetStatus()
+        tasks = append(tasks, t)
+        cursors = append(cursors, values)
+    }
+    if err := rows.Err(); err != nil {
+        return TaskPage{}, err
+    }
+    rows.Close()
+
+    more := len(tasks) > page.Size
+    if more {
+        tasks, cursors = tasks[:page.Size], cursors[:page.Size]
+    }
+    if reverse {
+        slices.Reverse(tasks)
+        slices.Reverse(cursors)
+    }
+
+    result := TaskPage{Tasks: tasks}
+    if len(tasks) > 0 {
+        if (more && !reverse) || page.Before != "" {
+            result.Next = encodeCursor(cursors[len(cursors)-1])
+        }
+        if (more && reverse) || page.After != "" {
+            result.Prev = encodeCursor(cursors[0])
+        }
+    }
+
+    if err := attachTags(s.db, user_id, result.Tasks); err != nil {
+        return TaskPage{}, err
+    }
+    return result, nil
+}
+
+// keysetFilter builds the condition for the tasks that come after the ones with the given sort key values, or before them if reverse is set.
+func keysetFilter(keys []sortKey, values []any, reverse bool) (string, []any) {
+    var terms []string
+    var args []any
+    for i, k := range keys {
+        var term []string
+        for j := range i {
+            term = append(term, keys[j].expr+` = ?`)
+            args = append(args, values[j])
+        }
+        if k.desc != reverse {
+            term = append(term, k.expr+` < ?`)
+        } else {
+            term = append(term, k.expr+` > ?`)
+        }
+        args = append(args, values[i])
+        terms = append(terms, `(`+strings.Join(term, ` AND `)+`)`)
+    }
+    return `(` + strings.Join(terms, ` OR `) + `)`, args
+}
+
+// encodeCursor and decodeCursor turn the sort key values of a task into a string that can go in a URL, and back. They're JSON underneath, which keeps the numbers and text SQLite compares them as.
+func encodeCursor(values []any) string {
+    b, _ := json.Marshal(values)
+    return base64.RawURLEncoding.EncodeToString(b)
+}
+
+func decodeCursor(cursor string, keys int) ([]any, error) {
+    b, err := base64.RawURLEncoding.DecodeString(cursor)
+    if err != nil {
+        return nil, ErrInvalidCursor
+    }
+    var values []any
+    if err := json.Unmarshal(b, &values); err != nil || len(values) != keys {
+        return nil, ErrInvalidCursor
+    }
+    for _, v := range values {
+        switch v.(type) {
+        case float64, string:
+        default:
+            return nil, ErrInvalidCursor
+        }
+    }
+    return values, nil
+}
+
+// attachTags fills in the tags of the user's tasks, using either the database or a transaction.
+func attachTags(db interface{ Query(string, ...any) (*sql.Rows, error) }, user_id int, tasks []app.Task) error {
+    if len(tasks) == 0 {
+        return nil
+    }
+
+    query := `
+        SELECT task_tags.task_id, tags.id, tags.name, tags.colour
+        FROM task_tags JOIN tags ON tags.id = task_tags.tag_id
+        WHERE tags.user_id = ?`
+    args := []any{user_id}
+    if len(tasks) == 1 {
+        query += ` AND task_tags.task_id = ?`
+        args = append(args, tasks[0].Id)
+    }
+    query += ` ORDER BY tags.name`
+
+    rows, err := db.Query(query, args...)
+    if err != nil {
+        return err
+    }
+    defer rows.Close()
+
+    index := make(map[uuid.UUID]int, len(tasks))
+    for i, t := range tasks {
+        index[t.Id] = i
+    }
+    for rows.Next() {
+        var taskId uuid.UUID
+        tag := app.Tag{UserId: user_id}
+        if err := rows.Scan(&taskId, &tag.Id, &tag.Name, &tag.Colour); err != nil {
+            return err
+        }
+        if i, ok := index[taskId]; ok {
+            tasks[i].Tags = append(tasks[i].Tags, tag)
+        }
+    }
+    return rows.Err()
+}
+
+// TaskMatch is a task found by SearchTasks, with its title and an excerpt of its description in which each matching word is wrapped in MatchStart and MatchEnd.
+type TaskMatch struct {
+    Task    app.Task
+    Title   string
+    Snippet string
+}
+
+// MatchStart and MatchEnd mark the matching words in a TaskMatch. They're control characters, so they can't be mistaken for markup.
+const (
+    MatchStart = "\x02"
+    MatchEnd   = "\x03"
+)
+
+// searchLimit is the most tasks SearchTasks returns.
+const searchLimit = 50
+
+// SearchTasks finds the user's tasks whose title or description matches the query, best match first. Words in the query must all appear, a word ending in `*` matches any word it starts, and words in double quotes must appear together as a phrase. Anything else that FTS5 would treat as syntax is searched for literally.
+func (s *SQLiteStore) SearchTasks(user_id int, query string) ([]TaskMatch, error) {
+    match := ftsQuery(query)
+    if match == "" {
+        return []TaskMatch{}, nil
+    }
+
+    s.mu.RLock()
+    defer s.mu.RUnlock()
+
+    rows, err := s.db.Query(`
+        SELECT `+taskColumns+`, matches.match_title, matches.match_snippet
+        FROM tasks JOIN (
+            SELECT task_id,
+                highlight(tasks_fts, 0, ?, ?) AS match_title,
+                snippet(tasks_fts, 1, ?, ?, '…', 16) AS match_snippet,
+                rank AS match_rank
+            FROM tasks_fts WHERE tasks_fts MATCH ?
+        ) AS matches ON matches.task_id = tasks.id
+        WHERE tasks.user_id = ?
+        ORDER BY matches.match_rank
+        LIMIT ?`,
+        MatchStart, MatchEnd, MatchStart, MatchEnd, match, user_id, searchLimit)
+    if err != nil {
+        return nil, err
+    }
+    defer rows.Close()
+
+    matches := []TaskMatch{}
+    tasks := []app.Task{}
+    for rows.Next() {
+        var title, snippet sql.NullString
+        t, err := scanTask(scanWith{rows, []any{&title, &snippet}})
+        if err != nil {
+            return nil, err
+        }
+        t.SetStatus()
+        tasks = append(tasks, t)
+        matches = append(matches, TaskMatch{Title: title.String, Snippet: snippet.String})
+    }
+    if err := rows.Err(); err != nil {
+        return nil, err
+    }
+    rows.Close()
+
+    if err := attachTags(s.db, user_id, tasks); err != nil {
+        return nil, err
+    }
+    for i := range matches {
+        matches[i].Task = tasks[i]
+    }
+    return matches, nil
+}
+
+// scanWith scans a row's columns beyond those its reader knows about into extra.
+type scanWith struct {
+    row   interface{ Scan(...any) error }
+    extra []any
+}
+
+func (s scanWith) Scan(dest ...any) error {
+    return s.row.Scan(append(dest, s.extra...)...)
+}
+
+// ftsQuery turns a search typed by a user into an FTS5 query: every word and phrase quoted, so that nothing in it is taken as FTS5 syntax, and all of them required. A trailing `*` on a word or phrase is kept as a prefix match. Words with no letters or digits are dropped, since FTS5 wouldn't index them. It returns "" if nothing is left to search for.
+func ftsQuery(search string) string {
+    var terms []string
+    add := func(term string, prefix bool) {
+        if !strings.ContainsFunc(term, func(r rune) bool { return unicode.IsLetter(r) || unicode.IsNumber(r) }) {
+            return
+        }
+        term = `"` + strings.ReplaceAll(term, `"`, `""`) + `"`
+        if prefix {
+            term += "*"
+        }
+        terms = append(terms, term)
+    }
+
+    rest := strings.TrimSpace(search)
+    for rest != "" {
+        var term string
+        if phrase, ok := strings.CutPrefix(rest, `"`); ok {
+            term, rest, _ = strings.Cut(phrase, `"`)
+        } else if i := strings.IndexFunc(rest, unicode.IsSpace); i >= 0 {
+            term, rest = rest[:i], rest[i:]
+        } else {
+            term, rest = rest, ""
+        }
+        prefix := strings.HasPrefix(rest, "*")
+        if prefix {
+            rest = rest[1:]
+        }
+        if trimmed, ok := strings.CutSuffix(term, "*"); ok {
+            term, prefix = trimmed, true
+        }
+        add(term, prefix)
+        rest = strings.TrimSpace(rest)
+    }
+    return strings.Join(terms, " ")
+}
+
+// ImportResult counts the tasks an import created and those it updated.
+type ImportResult struct {
+    Created int
+    Updated int
+}
+
+// ImportTasks saves tasks read from elsewhere for the user, all or none of them. A task with the id of one of the user's tasks updates it, keeping its project, tags, and checklist; any other is created. Ids that belong to another user's task are replaced with one made from the id and the user, so that the same import always lands on the same tasks. Done tasks don't spawn their next occurrence, since the import says where the series is.
+func (s *SQLiteStore) ImportTasks(user_id int, tasks []app.Task) (ImportResult, error) {
+    return s.importTasks(user_id, tasks, false)
+}
+
+// RestoreTasks is ImportTasks for tasks from a backup, which also restores each task's tags and project in place of those it had. Tags are matched by name, and those the user doesn't have are created; a project is kept only if it's still the user's.
+func (s *SQLiteStore) RestoreTasks(user_id int, tasks []app.Task) (ImportResult, error) {
+    return s.importTasks(user_id, tasks, true)
+}
+
+func (s *SQLiteStore) importTasks(user_id int, tasks []app.Task, restore bool) (ImportResult, error) {
+    s.mu.Lock()
+    defer s.mu.Unlock()
+
+    tx, err := s.db.Begin()
+    if err != nil {
+        return ImportResult{}, err
+    }
+    defer tx.Rollback()
+
+    var result ImportResult
+    now := time.Now()
+    for _, t := range tasks {
+        t.UserId = user_id
+        completedAt := importedCompletedAt(t, now)
+
+        id, exists, err := importedTaskId(tx, user_id, t.Id)
+        if err != nil {
+            return ImportResult{}, err
+        }
+        t.Id = id
+        if restore {
+            if err := checkProjectOwner(tx, user_id, t.ProjectId); errors.Is(err, ErrProjectNotFound) {
+                t.ProjectId = 0
+            } else if err != nil {
+                return ImportResult{}, err
+            }
+        }
+
+        if exists {
+            if err := updateImportedTask(tx, t, completedAt); err != nil {
+                return ImportResult{}, err
+            }
+            result.Updated++
+        } else {
+            if _, err := insertTask(tx, t, completedAt); err != nil {
+                return ImportResult{}, err
+            }
+            result.Created++
+        }
+
+        if restore {
+            if err := restoreTask(tx, t); err != nil {
+                return ImportResult{}, err
+            }
+        }
+    }
+
+    return result, tx.Commit()
+}
+
+// PreviewImport reports what ImportTasks would do with the tasks, without saving anything: for each task, whether it would update one of the user's tasks rather than create one.
+func (s *SQLiteStore) PreviewImport(user_id int, tasks []app.Task) ([]bool, error) {
+    s.mu.RLock()
+    defer s.mu.RUnlock()
+
+    updates := make([]bool, len(tasks))
+    seen := map[uuid.UUID]bool{}
+    for i, t := range tasks {
+        id, exists, err := importedTaskId(s.db, user_id, t.Id)
+        if err != nil {
+            return nil, err
+        }
+        // A task listed twice is created by the first and updated by the second.
+        updates[i] = exists || seen[id]
+        seen[id] = true
+    }
+    return updates, nil
+}
+
+// importedTaskId is the id ImportTasks saves a task with the id as, and whether one of the user's tasks already has it.
+func importedTaskId(db interface{ QueryRow(string, ...any) *sql.Row }, user_id int, id uuid.UUID) (uuid.UUID, bool, error) {
+    var owner int
+    err := db.QueryRow(`SELECT user_id FROM tasks WHERE id = ?`, id).Scan(&owner)
+    if err == nil && owner != user_id {
+        id = uuid.NewSHA1(id, []byte(strconv.Itoa(user_id)))
+        err = db.QueryRow(`SELECT user_id FROM tasks WHERE id = ?`, id).Scan(&owner)
+    }
+
+    switch {
+    case err == sql.ErrNoRows:
+        return id, false, nil
+    case err != nil:
+        return uuid.Nil, false, err
+    }
+    return id, true, nil
+}
+
+// importedCompletedAt is when a task read from elsewhere was completed: when it says, or now if it's done without saying when.
+func importedCompletedAt(t app.Task, now time.Time) any {
+    if t.Done != 1 {
+        return nil
+    }
+    if t.CompletedAt.IsZero() {
+        return now
+    }
+    return t.CompletedAt.UTC()
+}
+
+// updateImportedTask overwrites one of the user's tasks with what was read from elsewhere, keeping its project, tags, and checklist.
+func updateImportedTask(tx *sql.Tx, t app.Task, completedAt any) error {
+    _, err := tx.Exec(`
+        UPDATE tasks
+        SET title = ?, description = ?, done = ?, due = ?, all_day = ?, recurrence = ?, priority = ?, completed_at = ?
+        WHERE id = ? AND user_id = ?
+    `, t.Title, t.Description, t.Done, t.Due.UTC(), t.AllDay, nullIfEmpty(t.Recurrence), t.Priority, completedAt, t.Id, t.UserId)
+    return err
+}
+
+// restoreTask gives a task restored from a backup the project and tags it lists, in place of those it had. Its tags are the user's of the names listed, and any the user doesn't have are created with the colour given.
+func restoreTask(tx *sql.Tx, t app.Task) error {
+    if _, err := tx.Exec(`UPDATE tasks SET project_id = ? WHERE id = ? AND user_id = ?`, nullIfZero(t.ProjectId), t.Id, t.UserId); err != nil {
+        return err
+    }
+    if _, err := tx.Exec(`DELETE FROM task_tags WHERE task_id = ?`, t.Id); err != nil {
+        return err
+    }
+    for _, tag := range t.Tags {
+        if _, err := tx.Exec(`INSERT OR IGNORE INTO tags (user_id, name, colour) VALUES (?, ?, ?)`, t.UserId, tag.Name, tag.Colour); err != nil {
+            return err
+        }
+        _, err := tx.Exec(`
+            INSERT OR IGNORE INTO task_tags (task_id, tag_id)
+            SELECT ?, id FROM tags WHERE user_id = ? AND name = ?
+        `, t.Id, t.UserId, tag.Name)
+        if err != nil {
+            return err
+        }
+    }
+    return nil
+}
+
+// CalDAVObject is a task as CalDAV clients see it: a resource in the user's calendar collection, holding a to-do with a UID. Tasks made elsewhere are named `{id}.ics`, with their id as UID; those that clients make keep the names and UIDs they were given.
+type CalDAVObject struct {
+    Name string
+    UID  string
+    Task app.Task
+}
+
+// ErrUIDConflict is returned by PutCalDAVObject for a to-do whose UID is another task's, or isn't the UID its task already has.
+var ErrUIDConflict = errors.New("UID belongs to another task")
+
+const calDAVColumns = taskColumns + `,
+    (SELECT name FROM caldav_objects WHERE task_id = tasks.id),
+    (SELECT uid FROM caldav_objects WHERE task_id = tasks.id)`
+
+// scanCalDAVObject scans a row of calDAVColumns.
+func scanCalDAVObject(row interface{ Scan(...any) error }) (CalDAVObject, error) {
+    var name, uid sql.NullString
+    t, err := scanTask(scanWith{row, []any{&name, &uid}})
+    if err != nil {
+        return CalDAVObject{}, err
+    }
+    t.SetStatus()
+
+    o := CalDAVObject{Name: t.Id.String() + ".ics", UID: t.Id.String(), Task: t}
+    if name.Valid {
+        o.Name, o.UID = name.String, uid.String
+    }
+    return o, nil
+}
+
+// GetCalDAVObjects returns all of the user's tasks, as CalDAV clients see them, without their tags.
+func (s *SQLiteStore) GetCalDAVObjects(user_id int) ([]CalDAVObject, error) {
+    s.mu.RLock()
+    defer s.mu.RUnlock()
+
+    rows, err := s.db.Query(`SELECT `+calDAVColumns+` FROM tasks WHERE user_id = ? ORDER BY created_at, id`, user_id)
+    if err != nil {
+        return nil, err
+    }
+    defer rows.Close()
+
+    objects := []CalDAVObject{}
+    for rows.Next() {
+        o, err := scanCalDAVObject(rows)
+        if err != nil {
+            return nil, err
+        }
+        objects = append(objects, o)
+    }
+    return objects, rows.Err()
+}
+
+// GetCalDAVObject returns the user's task with the CalDAV name.
+func (s *SQLiteStore) GetCalDAVObject(user_id int, name string) (CalDAVObject, error) {
+    s.mu.RLock()
+    defer s.mu.RUnlock()
+
+    return findCalDAVObject(s.db, user_id, name)
+}
+
+// findCalDAVObject looks up the task with the name, which is either one a client gave it, or its id.
+func findCalDAVObject(db interface{ QueryRow(string, ...any) *sql.Row }, user_id int, name string) (CalDAVObject, error) {
+    o, err := scanCalDAVObject(db.QueryRow(`
+        SELECT `+calDAVColumns+` FROM tasks
+        WHERE user_id = ? AND id = (SELECT task_id FROM caldav_objects WHERE user_id = ? AND name = ?)
+    `, user_id, user_id, name))
+    if err != sql.ErrNoRows {
+        return o, err
+    }
+
+    id, ok := canonicalId(strings.TrimSuffix(name, ".ics"))
+    if !ok || !strings.HasSuffix(name, ".ics") {
+        return CalDAVObject{}, ErrTaskNotFound
+    }
+    o, err = scanCalDAVObject(db.QueryRow(`
+        SELECT `+calDAVColumns+` FROM tasks
+        WHERE user_id = ? AND id = ? AND NOT EXISTS (SELECT 1 FROM caldav_objects WHERE task_id = tasks.id)
+    `, user_id, id))
+    if err == sql.ErrNoRows {
+        return CalDAVObject{}, ErrTaskNotFound
+    }
+    return o, err
+}
+
+// canonicalId parses s as a task id written the way the id's String method writes it, and no other.
+func canonicalId(s string) (uuid.UUID, bool) {
+    id, err := uuid.Parse(s)
+    return id, err == nil && id.String() == s
+}
+
+// PutCalDAVObject saves a to-do a CalDAV client sent, and returns the object saved and whether it was new. An object with a name the user's calendar already has updates that task, as ImportTasks does, and must keep its UID; if that completes a repeating task, its next occurrence is created. A new object must have a UID no other task has; its task gets the id of o.Task if that's free, and a fresh one otherwise.
+func (s *SQLiteStore) PutCalDAVObject(user_id int, o CalDAVObject) (CalDAVObject, bool, error) {
+    s.mu.Lock()
+    defer s.mu.Unlock()
+
+    tx, err := s.db.Begin()
+    if err != nil {
+        return CalDAVObject{}, false, err
+    }
+    defer tx.Rollback()
+
+    t := o.Task
+    t.UserId = user_id
+    completedAt := importedCompletedAt(t, time.Now())
+
+    existing, err := findCalDAVObject(tx, user_id, o.Name)
+    created := errors.Is(err, ErrTaskNotFound)
+    switch {
+    case created:
+        var uses int
+        err := tx.QueryRow(`SELECT COUNT(*) FROM caldav_objects WHERE user_id = ? AND uid = ?`, user_id, o.UID).Scan(&uses)
+        if err != nil {
+            return CalDAVObject{}, false, err
+        }
+        if id, ok := canonicalId(o.UID); ok && uses == 0 {
+            err := tx.QueryRow(`
+                SELECT COUNT(*) FROM tasks
+                WHERE user_id = ? AND id = ? AND NOT EXISTS (SELECT 1 FROM caldav_objects WHERE task_id = tasks.id)
+            `, user_id, id).Scan(&uses)
+            if err != nil {
+                return CalDAVObject{}, false, err
+            }
+        }
+        if uses > 0 {
+            return CalDAVObject{}, false, ErrUIDConflict
+        }
+
+        var taken int
+        if err := tx.QueryRow(`SELECT COUNT(*) FROM tasks WHERE id = ?`, t.Id).Scan(&taken); err != nil {
+            return CalDAVObject{}, false, err
+        }
+        if taken > 0 || t.Id == uuid.Nil {
+            t.Id = uuid.New()
+        }
+        if _, err := insertTask(tx, t, completedAt); err != nil {
+            return CalDAVObject{}, false, err
+        }
+        if o.Name != t.Id.String()+".ics" || o.UID != t.Id.String() {
+            _, err := tx.Exec(`INSERT INTO caldav_objects (task_id, user_id, name, uid) VALUES (?, ?, ?, ?)`, t.Id, user_id, o.Name, o.UID)
+            if err != nil {
+                return CalDAVObject{}, false, err
+            }
+        }
+    case err != nil:
+        return CalDAVObject{}, false, err
+    default:
+        if existing.UID != o.UID {
+            return CalDAVObject{}, false, ErrUIDConflict
+        }
+        t.Id = existing.Task.Id
+        if err := updateImportedTask(tx, t, completedAt); err != nil {
+            return CalDAVObject{}, false, err
+        }
+        // Clients that tick off a repeating to-do leave what comes next to the server, as the web UI does.
+        if t.Done == 1 && existing.Task.Done == 0 && t.Recurrence != "" {
+            if _, err := spawnNextOccurrence(tx, t); err != nil {
+                return CalDAVObject{}, false, err
+            }
+        }
+    }
+
+    saved, err := findCalDAVObject(tx, user_id, o.Name)
+    if err != nil {
+        return CalDAVObject{}, false, err
+    }
+    return saved, created, tx.Commit()
+}
+
+func (s *SQLiteStore) DeleteTask(user_id int, id uuid.UUID) error {
+    s.mu.Lock()
+    defer s.mu.Unlock()
+
+    tx, err := s.db.Begin()
+    if err != nil {
+        return err
+    }
+    defer tx.Rollback()
+
+    result, err := tx.Exec(`DELETE FROM tasks WHERE id = ? AND user_id = ?`, id, user_id)
+    if err != nil {
+        return err
+    }
+    if err := checkTaskAffected(result); err != nil {
+        return err
+    }
+
+    // Foreign keys aren't enforced, so the checklist and tags have to be deleted by hand.
+    if _, err := tx.Exec(`DELETE FROM checklist_items WHERE task_id = ?`, id); err != nil {
+        return err
+    }
+    if _, err := tx.Exec(`DELETE FROM task_tags WHERE task_id = ?`, id); err != nil {
+        return err
+    }
+
+    return tx.Commit()
+}
+
+// CreateTask adds a task with the tags it lists by id and in its project, which must be the user's own.
+func (s *SQLiteStore) CreateTask(t app.Task) error {
+    s.mu.Lock()
+    defer s.mu.Unlock()
+
+    var completedAt any
+    if t.Done == 1 {
+        completedAt = time.Now()
+    }
+
+    tx, err := s.db.Begin()
+    if err != nil {
+        return err
+    }
+    defer tx.Rollback()
+
+    if err := checkProjectOwner(tx, t.UserId, t.ProjectId); err != nil {
+        return err
+    }
+    if _, err := insertTask(tx, t, completedAt); err != nil {
+        return err
+    }
+    if err := setTaskTags(tx, t); err != nil {
+        return err
+    }
+
+    return tx.Commit()
+}
+
+// insertTask adds a task using either the database or a transaction.
+func insertTask(db interface{ Exec(string, ...any) (sql.Result, error) }, t app.Task, completedAt any) (sql.Result, error) {
+    createdAt := t.CreatedAt.UTC()
+    if createdAt.IsZero() {
+        createdAt = time.Now().UTC()
+    }
+    return db.Exec(`
+        INSERT INTO tasks (id, user_id, title, description, done, due, all_day, completed_at, recurrence, project_id, priority, created_at)
+        VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+    `, t.Id, t.UserId, t.Title, t.Description, t.Done, t.Due.UTC(), t.AllDay, completedAt, nullIfEmpty(t.Recurrence), nullIfZero(t.ProjectId), t.Priority, createdAt)
+}
+
+func nullIfEmpty(s string) sql.NullString {
+    return sql.NullString{String: s, Valid: s != ""}
+}
+
+func nullIfZero(n int) sql.NullInt64 {
+    return sql.NullInt64{Int64: int64(n), Valid: n != 0}
+}
+
+// UpdateTask saves every field of the task but its checklist. It sets the completion time when the task becomes done and clears it when it stops being done. It replaces the task's tags with those it lists by id, checking that they and its project are the user's. Like SetTaskDone, it creates the next occurrence when a recurring task becomes done.
+func (s *SQLiteStore) UpdateTask(t app.Task) error {
+    s.mu.Lock()
+    defer s.mu.Unlock()
+
+    tx, err := s.db.Begin()
+    if err != nil {
+        return err
+    }
+    defer tx.Rollback()
+
+    var wasDone int
+    err = tx.QueryRow(`SELECT done FROM tasks WHERE id = ? AND user_id = ?`, t.Id, t.UserId).Scan(&wasDone)
+    if err == sql.ErrNoRows {
+        return ErrTaskNotFound
+    }
+    if err != nil {
+        return err
+    }
+    if err := checkProjectOwner(tx, t.UserId, t.ProjectId); err != nil {
+        return err
+    }
+
+    _, err = tx.Exec(`
+        UPDATE tasks
+        SET title = ?, description = ?, done = ?, due = ?, all_day = ?, recurrence = ?, project_id = ?, priority = ?,
+            completed_at = CASE WHEN ? = 1 THEN COALESCE(completed_at, ?) ELSE NULL END
+        WHERE id = ? AND user_id = ?
+    `, t.Title, t.Description, t.Done, t.Due.UTC(), t.AllDay, nullIfEmpty(t.Recurrence), nullIfZero(t.ProjectId), t.Priority, t.Done, time.Now(), t.Id, t.UserId)
+    if err != nil {
+        return err
+    }
+
+    if _, err := tx.Exec(`DELETE FROM task_tags WHERE task_id = ?`, t.Id); err != nil {
+        return err
+    }
+    if err := setTaskTags(tx, t); err != nil {
+        return err
+    }
+
+    if t.Done == 1 && wasDone == 0 && t.Recurrence != "" {
+        if _, err := spawnNextOccurrence(tx, t); err != nil {
+            return err
+        }
+    }
+
+    return tx.Commit()
+}
+
+// SetTaskDone marks a task as done or not done, recording when it was completed. Marking a done task as done again keeps the original completion time. When a recurring task becomes done, its next occurrence is created and returned; otherwise the returned task is the zero value.
+func (s *SQLiteStore) SetTaskDone(user_id int, id uuid.UUID, done bool) (app.Task, error) {
+    s.mu.Lock()
+    defer s.mu.Unlock()
+
+    tx, err := s.db.Begin()
+    if err != nil {
+        return app.Task{}, err
+    }
+    defer tx.Rollback()
+
+    t, err := scanTask(tx.QueryRow(`SELECT `+taskColumns+` FROM tasks WHERE id = ? AND user_id = ?`, id, user_id))
+    if err == sql.ErrNoRows {
+        return app.Task{}, ErrTaskNotFound
+    }
+    if err != nil {
+        return app.Task{}, err
+    }
+
+    _, err = tx.Exec(`
+        UPDATE tasks
+        SET done = ?, completed_at = CASE WHEN ? THEN COALESCE(completed_at, ?) ELSE NULL END
+        WHERE id = ? AND user_id = ?
+    `, done, done, time.Now(), id, user_id)
+    if err != nil {
+        return app.Task{}, err
+    }
+
+    var next app.Task
+    if done && t.Done == 0 && t.Recurrence != "" {
+        next, err = spawnNextOccurrence(tx, t)
+        if err != nil {
+            return app.Task{}, err
+        }
+    }
+
+    return next, tx.Commit()
+}
+
+// spawnNextOccurrence creates the occurrence of a recurring task that follows t, which has just been done, and moves the recurrence rule over to it, so that a series only ever continues from its latest occurrence. It returns the zero value if the series has ended.
+func spawnNextOccurrence(tx *sql.Tx, t app.Task) (app.Task, error) {
+    rule, err := recur.Parse(t.Recurrence)
+    if err != nil {
+        return app.Task{}, err
+    }
+
+    if _, err := tx.Exec(`UPDATE tasks SET recurrence = NULL WHERE id = ?`, t.Id); err != nil {
+        return app.Task{}, err
+    }
+
+    // Occurrences follow on in the owner's time zone, so that they keep their weekday and time of day there whatever daylight saving time does.
+    var timeZone sql.NullString
+    err = tx.QueryRow(`SELECT time_zone FROM users WHERE id = ?`, t.UserId).Scan(&timeZone)
+    if err != nil && err != sql.ErrNoRows {
+        return app.Task{}, err
+    }
+    due, ok := rule.Next(t.Due.In(app.Location(timeZone.String)))
+    if !ok {
+        return app.Task{}, nil
+    }
+
+    next := app.Task{
+        Id:          uuid.New(),
+        UserId:      t.UserId,
+        Title:       t.Title,
+        Description: t.Description,
+        Due:         due.UTC(),
+        AllDay:      t.AllDay,
+        TimeZone:    timeZone.String,
+        Recurrence:  rule.Advance().String(),
+        ProjectId:   t.ProjectId,
+        Priority:    t.Priority,
+        CreatedAt:   time.Now().UTC(),
+    }
+    if _, err := insertTask(tx, next, nil); err != nil {
+        return app.Task{}, err
+    }
+
+    // The checklist starts again from scratch for each occurrence.
+    result, err := tx.Exec(`
+        INSERT INTO checklist_items (task_id, position, text, done)
+        SELECT ?, position, text, 0 FROM checklist_items WHERE task_id = ?
+    `, next.Id, t.Id)
+    if err != nil {
+        return app.Task{}, err
+    }
+    n, err := result.RowsAffected()
+    if err != nil {
+        return app.Task{}, err
+    }
+    next.ChecklistTotal = int(n)
+
+    if _, err := tx.Exec(`INSERT INTO task_tags (task_id, tag_id) SELECT ?, tag_id FROM task_tags WHERE task_id = ?`, next.Id, t.Id); err != nil {
+        return app.Task{}, err
+    }
+    tasks := []app.Task{next}
+    if err := attachTags(tx, t.UserId, tasks); err != nil {
+        return app.Task{}, err
+    }
+    next = tasks[0]
+    next.SetStatus()
+
+    return next, nil
+}
+
+// setTaskTags links a new or untagged task to the tags it lists, checking that each is the user's.
+func setTaskTags(tx *sql.Tx, t app.Task) error {
+    for _, tag := range t.Tags {
+        result, err := tx.Exec(`
+            INSERT OR IGNORE INTO task_tags (task_id, tag_id)
+            SELECT ?, id FROM tags WHERE id = ? AND user_id = ?
+        `, t.Id, tag.Id, t.UserId)
+        if err != nil {
+            return err
+        }
+        n, err := result.RowsAffected()
+        if err != nil {
+            return err
+        }
+        if n == 0 {
+            var exists bool
+            err := tx.QueryRow(`SELECT EXISTS (SELECT 1 FROM tags WHERE id = ? AND user_id = ?)`, tag.Id, t.UserId).Scan(&exists)
+            if err != nil {
+                return err
+            }
+            if !exists {
+                return ErrTagNotFound
+            }
+        }
+    }
+    return nil
+}
+
+// GetTags returns the user's tags in order of name.
+func (s *SQLiteStore) GetTags(user_id int) ([]app.Tag, error) {
+    s.mu.RLock()
+    defer s.mu.RUnlock()
+
+    rows, err := s.db.Query(`SELECT id, user_id, name, colour FROM tags WHERE user_id = ? ORDER BY name`, user_id)
+    if err != nil {
+        return nil, err
+    }
+    defer rows.Close()
+
+    tags := []app.Tag{}
+    for rows.Next() {
+        var tag app.Tag
+        if err := rows.Scan(&tag.Id, &tag.UserId, &tag.Name, &tag.Colour); err != nil {
+            return nil, err
+        }
+        tags = append(tags, tag)
+    }
+    return tags, rows.Err()
+}
+
+// CreateTag adds a tag for tag.UserId and returns it with its id. It returns ErrTagExists if the user has a tag of the same name.
+func (s *SQLiteStore) CreateTag(tag app.Tag) (app.Tag, error) {
+    s.mu.Lock()
+    defer s.mu.Unlock()
+
+    tx, err := s.db.Begin()
+    if err != nil {
+        return app.Tag{}, err
+    }
+    defer tx.Rollback()
+
+    var exists bool
+    err = tx.QueryRow(`SELECT EXISTS (SELECT 1 FROM tags WHERE user_id = ? AND name = ?)`, tag.UserId, tag.Name).Scan(&exists)
+    if err != nil {
+        return app.Tag{}, err
+    }
+    if exists {
+        return app.Tag{}, ErrTagExists
+    }
+
+    err = tx.QueryRow(`INSERT INTO tags (user_id, name, colour) VALUES (?, ?, ?) RETURNING id`, tag.UserId, tag.Name, tag.Colour).Scan(&tag.Id)
+    if err != nil {
+        return app.Tag{}, err
+    }
+
+    return tag, tx.Commit()
+}
+
+// DeleteTag removes a tag from the user's tags and from every task that had it.
+func (s *SQLiteStore) DeleteTag(user_id int, tag_id int) error {
+    s.mu.Lock()
+    defer s.mu.Unlock()
+
+    tx, err := s.db.Begin()
+    if err != nil {
+        return err
+    }
+    defer tx.Rollback()
+
+    result, err := tx.Exec(`DELETE FROM tags WHERE id = ? AND user_id = ?`, tag_id, user_id)
+    if err != nil {
+        return err
+    }
+    n, err := result.RowsAffected()
+    if err != nil {
+        return err
+    }
+    if n == 0 {
+        return ErrTagNotFound
+    }
+
+    if _, err := tx.Exec(`DELETE FROM task_tags WHERE tag_id = ?`, tag_id); err != nil {
+        return err
+    }
+
+    return tx.Commit()
+}
+
+// GetChecklist returns a task's checklist in order.
+func (s *SQLiteStore) GetChecklist(user_id int, task_id uuid.UUID) ([]app.ChecklistItem, error) {
+    s.mu.RLock()
+    defer s.mu.RUnlock()
+
+    if err := checkTaskOwner(s.db, user_id, task_id); err != nil {
+        return nil, err
+    }
+
+    rows, err := s.db.Query(`
+        SELECT id, task_id, position, text, done FROM checklist_items
+        WHERE task_id = ? ORDER BY position, id
+    `, task_id)
+    if err != nil {
+        return nil, err
+    }
+    defer rows.Close()
+
+    items := []app.ChecklistItem{}
+    for rows.Next() {
+        var item app.ChecklistItem
+        if err := rows.Scan(&item.Id, &item.TaskId, &item.Position, &item.Text, &item.Done); err != nil {
+            return nil, err
+        }
+        items = append(items, item)
+    }
+
+    return items, rows.Err()
+}
+
+// AddChecklistItem adds an item to the end of a task's checklist.
+func (s *SQLiteStore) AddChecklistItem(user_id int, task_id uuid.UUID, text string) (app.ChecklistItem, error) {
+    s.mu.Lock()
+    defer s.mu.Unlock()
+
+    tx, err := s.db.Begin()
+    if err != nil {
+        return app.ChecklistItem{}, err
+    }
+    defer tx.Rollback()
+
+    if err := checkTaskOwner(tx, user_id, task_id); err != nil {
+        return app.ChecklistItem{}, err
+    }
+
+    item := app.ChecklistItem{TaskId: task_id, Text: text}
+    err = tx.QueryRow(`
+        INSERT INTO checklist_items (task_id, position, text)
+        VALUES (?, (SELECT COUNT(*) FROM checklist_items WHERE task_id = ?), ?)
+        RETURNING id, position
+    `, task_id, task_id, text).Scan(&item.Id, &item.Position)
+    if err != nil {
+        return app.ChecklistItem{}, err
+    }
+
+    return item, tx.Commit()
+}
+
+// SetChecklistItemDone ticks or unticks a checklist item.
+func (s *SQLiteStore) SetChecklistItemDone(user_id int, task_id uuid.UUID, item_id int, done bool) error {
+    s.mu.Lock()
+    defer s.mu.Unlock()
+
+    if err := checkTaskOwner(s.db, user_id, task_id); err != nil {
+        return err
+    }
+
+    result, err := s.db.Exec(`UPDATE checklist_items SET done = ? WHERE id = ? AND task_id = ?`, done, item_id, task_id)
+    if err != nil {
+        return err
+    }
+
+    return checkChecklistItemAffected(result)
+}
+
+// MoveChecklistItem moves an item to a new position in its checklist, counting from 0, shifting the items in between. Positions past the end move the item to the end.
+func (s *SQLiteStore) MoveChecklistItem(user_id int, task_id uuid.UUID, item_id int, position int) error {
+    s.mu.Lock()
+    defer s.mu.Unlock()
+
+    tx, err := s.db.Begin()
+    if err != nil {
+        return err
+    }
+    defer tx.Rollback()
+
+    if err := checkTaskOwner(tx, user_id, task_id); err != nil {
+        return err
+    }
+
+    var from, count int
+    err = tx.QueryRow(`
+        SELECT position, (SELECT COUNT(*) FROM checklist_items WHERE task_id = ?)
+        FROM checklist_items WHERE id = ? AND task_id = ?
+    `, task_id, item_id, task_id).Scan(&from, &count)
+    if err == sql.ErrNoRows {
+        return ErrChecklistItemNotFound
+    }
+    if err != nil {
+        return err
+    }
+
+    to := min(max(position, 0), count-1)
+    switch {
+    case to > from:
+        _, err = tx.Exec(`UPDATE checklist_items SET position = position - 1 WHERE task_id = ? AND position > ? AND position <= ?`, task_id, from, to)
+    case to < from:
+        _, err = tx.Exec(`UPDATE checklist_items SET position = position + 1 WHERE task_id = ? AND position >= ? AND position < ?`, task_id, to, from)
+    default:
+        return nil
+    }
+    if err != nil {
+        return err
+    }
+
+    if _, err := tx.Exec(`UPDATE checklist_items SET position = ? WHERE id = ?`, to, item_id); err != nil {
+        return err
+    }
+
+    return tx.Commit()
+}
+
+// DeleteChecklistItem removes an item from a checklist, closing the gap it leaves.
+func (s *SQLiteStore) DeleteChecklistItem(user_id int, task_id uuid.UUID, item_id int) error {
+    s.mu.Lock()
+    defer s.mu.Unlock()
+
+    tx, err := s.db.Begin()
+    if err != nil {
+        return err
+    }
+    defer tx.Rollback()
+
+    if err := checkTaskOwner(tx, user_id, task_id); err != nil {
+        return err
+    }
+
+    var position int
+    err = tx.QueryRow(`DELETE FROM checklist_items WHERE id = ? AND task_id = ? RETURNING position`, item_id, task_id).Scan(&position)
+    if err == sql.ErrNoRows {
+        return ErrChecklistItemNotFound
+    }
+    if err != nil {
+        return err
+    }
+
+    if _, err := tx.Exec(`UPDATE checklist_items SET position = position - 1 WHERE task_id = ? AND position > ?`, task_id, position); err != nil {
+        return err
+    }
+
+    return tx.Commit()
+}
+
+// GetProjects returns the user's projects in order of name.
+func (s *SQLiteStore) GetProjects(user_id int) ([]app.Project, error) {
+    s.mu.RLock()
+    defer s.mu.RUnlock()
+
+    rows, err := s.db.Query(`SELECT id, user_id, name FROM projects WHERE user_id = ? ORDER BY name`, user_id)
+    if err != nil {
+        return nil, err
+    }
+    defer rows.Close()
+
+    projects := []app.Project{}
+    for rows.Next() {
+        var p app.Project
+        if err := rows.Scan(&p.Id, &p.UserId, &p.Name); err != nil {
+            return nil, err
+        }
+        projects = append(projects, p)
+    }
+    return projects, rows.Err()
+}
+
+func (s *SQLiteStore) GetProject(user_id int, project_id int) (app.Project, error) {
+    s.mu.RLock()
+    defer s.mu.RUnlock()
+
+    var p app.Project
+    err := s.db.QueryRow(`SELECT id, user_id, name FROM projects WHERE id = ? AND user_id = ?`, project_id, user_id).Scan(&p.Id, &p.UserId, &p.Name)
+    if err == sql.ErrNoRows {
+        return app.Project{}, ErrProjectNotFound
+    }
+    return p, err
+}
+
+// CreateProject adds a project for project.UserId and returns it with its id. It returns ErrProjectExists if the user has a project of the same name.
+func (s *SQLiteStore) CreateProject(project app.Project) (app.Project, error) {
+    s.mu.Lock()
+    defer s.mu.Unlock()
+
+    tx, err := s.db.Begin()
+    if err != nil {
+        return app.Project{}, err
+    }
+    defer tx.Rollback()
+
+    if err := checkProjectNameFree(tx, project.UserId, 0, project.Name); err != nil {
+        return app.Project{}, err
+    }
+
+    err = tx.QueryRow(`INSERT INTO projects (user_id, name) VALUES (?, ?) RETURNING id`, project.UserId, project.Name).Scan(&project.Id)
+    if err != nil {
+        return app.Project{}, err
+    }
+
+    return project, tx.Commit()
+}
+
+// RenameProject changes the name of a project. It returns ErrProjectExists if the user has another project of that name.
+func (s *SQLiteStore) RenameProject(user_id int, project_id int, name string) error {
+    s.mu.Lock()
+    defer s.mu.Unlock()
+
+    tx, err := s.db.Begin()
+    if err != nil {
+        return err
+    }
+    defer tx.Rollback()
+
+    if err := checkProjectOwner(tx, user_id, project_id); err != nil {
+        return err
+    }
+    if err := checkProjectNameFree(tx, user_id, project_id, name); err != nil {
+        return err
+    }
+
+    if _, err := tx.Exec(`UPDATE projects SET name = ? WHERE id = ? AND user_id = ?`, name, project_id, user_id); err != nil {
+        return err
+    }
+
+    return tx.Commit()
+}
+
+// DeleteProject deletes a project. Its tasks are kept, in no project.
+func (s *SQLiteStore) DeleteProject(user_id int, project_id int) error {
+    s.mu.Lock()
+    defer s.mu.Unlock()
+
+    tx, err := s.db.Begin()
+    if err != nil {
+        return err
+    }
+    defer tx.Rollback()
+
+    result, err := tx.Exec(`DELETE FROM projects WHERE id = ? AND user_id = ?`, project_id, user_id)
+    if err != nil {
+        return err
+    }
+    n, err := result.RowsAffected()
+    if err != nil {
+        return err
+    }
+    if n == 0 {
+        return ErrProjectNotFound
+    }
+
+    // Foreign keys aren't enforced, so the tasks have to be taken out of the project by hand.
+    if _, err := tx.Exec(`UPDATE tasks SET project_id = NULL WHERE project_id = ? AND user_id = ?`, project_id, user_id); err != nil {
+        return err
+    }
+
+    return tx.Commit()
+}
+
+// MoveTask puts a task in a project, or in no project if project_id is zero.
+func (s *SQLiteStore) MoveTask(user_id int, task_id uuid.UUID, project_id int) error {
+    s.mu.Lock()
+    defer s.mu.Unlock()
+
+    tx, err := s.db.Begin()
+    if err != nil {
+        return err
+    }
+    defer tx.Rollback()
+
+    if err := checkProjectOwner(tx, user_id, project_id); err != nil {
+        return err
+    }
+
+    result, err := tx.Exec(`UPDATE tasks SET project_id = ? WHERE id = ? AND user_id = ?`, nullIfZero(project_id), task_id, user_id)
+    if err != nil {
+        return err
+    }
+    if err := checkTaskAffected(result); err != nil {
+        return err
+    }
+
+    return tx.Commit()
+}
+
+// checkProjectOwner returns ErrProjectNotFound unless the project is the user's. Zero, meaning no project, is always allowed.
+func checkProjectOwner(db interface{ QueryRow(string, ...any) *sql.Row }, user_id int, project_id int) error {
+    if project_id == 0 {
+        return nil
+    }
+
+    var exists bool
+    err := db.QueryRow(`SELECT EXISTS (SELECT 1 FROM projects WHERE id = ? AND user_id = ?)`, project_id, user_id).Scan(&exists)
+    if err != nil {
+        return err
+    }
+    if !exists {
+        return ErrProjectNotFound
+    }
+    return nil
+}
+
+// checkProjectNameFree returns ErrProjectExists if the user has a project other than project_id with the name, ignoring case.
+func checkProjectNameFree(db interface{ QueryRow(string, ...any) *sql.Row }, user_id int, project_id int, name string) error {
+    var exists bool
+    err := db.QueryRow(`SELECT EXISTS (SELECT 1 FROM projects WHERE user_id = ? AND name = ? AND id != ?)`, user_id, name, project_id).Scan(&exists)
+    if err != nil {
+        return err
+    }
+    if exists {
+        return ErrProjectExists
+    }
+    return nil
+}
+
+// checkTaskOwner returns ErrTaskNotFound unless the task exists and belongs to the user.
+func checkTaskOwner(db interface{ QueryRow(string, ...any) *sql.Row }, user_id int, task_id uuid.UUID) error {
+    var one int
+    err := db.QueryRow(`SELECT 1 FROM tasks WHERE id = ? AND user_id = ?`, task_id, user_id).Scan(&one)
+    if err == sql.ErrNoRows {
+        return ErrTaskNotFound
+    }
+    return err
+}
+
+// checkChecklistItemAffected returns ErrChecklistItemNotFound if a statement scoped by item id and task id matched no rows.
+func checkChecklistItemAffected(result sql.Result) error {
+    n, err := result.RowsAffected()
+    if err != nil {
+        return err
+    }
+    if n == 0 {
+        return ErrChecklistItemNotFound
+    }
+    return nil
+}
+
+// checkTaskAffected returns ErrTaskNotFound if a statement scoped by task id and user id matched no rows.
+func checkTaskAffected(result sql.Result) error {
+    n, err := result.RowsAffected()
+    if err != nil {
+        return err
+    }
+    if n == 0 {
+        return ErrTaskNotFound
+    }
+    return nil
+}
+
+func TestSetTaskDone(t *testing.T) {
+    db, err := sql.Open("sqlite3", ":memory:")
+    if err != nil {
+        t.Fatalf("failed to open db: %v", err)
+    }
+    defer db.Close()
+
+    store := &SQLiteStore{db: db}
+
+    _, err = db.Exec(`
+        CREATE TABLE tasks (
+            id TEXT PRIMARY KEY,
+            user_id INTEGER,
+            title TEXT,
+            description TEXT,
+            done INTEGER,
+            due TEXT,
+            completed_at DATETIME,
+            recurrence TEXT
+        )`)
+    if err != nil {
+        t.Fatalf("failed to create table: %v", err)
+    }
+
+    taskID := uuid.New()
+    _, err = db.Exec(`
+        INSERT INTO tasks (id, user_id, title, description, done, due)
+        VALUES (?, ?, ?, ?, ?, ?)`, taskID, 1, "Test Task", "Test Description", 0, "2025-05-13")
     if err != nil {
         t.Fatalf("failed to insert task: %v", err)
     }
 
-    err = store.SetTaskDone(taskID)
+    _, err = store.SetTaskDone(1, taskID, true)
     if err != nil {
         t.Fatalf("failed to set task done: %v", err)
     }
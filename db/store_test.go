@@ -3,12 +3,15 @@ package db
 import (
 	"bytes"
 	"database/sql"
+	"errors"
+	"fmt"
+	"reflect"
+	"strings"
 	"testing"
 	"time"
 
 	_ "github.com/glebarez/go-sqlite"
 	"github.com/google/uuid"
-	"golang.org/x/crypto/bcrypt"
 
 	"penumbra/app"
 )
@@ -25,9 +28,7 @@ func TestCreateUser(t *testing.T) {
         name TEXT,
         password_hash BLOB,
         email TEXT,
-        phone TEXT,
-        session_token_hash TEXT,
-        session_expires_at TIMESTAMP
+        phone TEXT
     )`)
     if err != nil {
         t.Fatalf("failed to create table: %v", err)
@@ -48,12 +49,10 @@ func TestCreateUser(t *testing.T) {
 
     var name, email, phone string
     var passwordHash []byte
-    var sessionToken string
-    var sessionExpires time.Time
     row := db.QueryRow(`
-        SELECT name, password_hash, email, phone, session_token_hash, session_expires_at 
+        SELECT name, password_hash, email, phone
         FROM users WHERE email = ?`, user.Email)
-    err = row.Scan(&name, &passwordHash, &email, &phone, &sessionToken, &sessionExpires)
+    err = row.Scan(&name, &passwordHash, &email, &phone)
     if err != nil {
         t.Errorf("failed to retrieve user: %v", err)
     }
@@ -65,16 +64,78 @@ func TestCreateUser(t *testing.T) {
     if !bytes.Equal(passwordHash, user.PasswordHash) {
         t.Errorf("expected password hash %v, got %v", user.PasswordHash, passwordHash)
     }
-
-    if sessionToken != "" {
-        t.Errorf("expected empty session token hash, got %q", sessionToken)
-    }
-
-    if !sessionExpires.Equal(time.Unix(0, 0)) {
-        t.Errorf("expected session expires at Unix(0, 0), got %v", sessionExpires)
-    }
 }
 
+// usersTableSchema matches the users table once all migrations have run.
+const usersTableSchema = `CREATE TABLE users (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    name TEXT,
+    password_hash BLOB,
+    email TEXT UNIQUE,
+    phone TEXT,
+    email_verified INTEGER NOT NULL DEFAULT 0,
+    failed_logins INTEGER NOT NULL DEFAULT 0,
+    locked_until DATETIME,
+    totp_secret TEXT,
+    totp_enabled INTEGER NOT NULL DEFAULT 0,
+    totp_last_step INTEGER NOT NULL DEFAULT 0,
+    time_zone TEXT NOT NULL DEFAULT 'UTC'
+)`
+
+const tasksTableSchema = `CREATE TABLE tasks (
+    id TEXT PRIMARY KEY,
+    user_id INTEGER,
+    title TEXT,
+    description TEXT,
+    done BOOLEAN,
+    due TIMESTAMP,
+    all_day INTEGER NOT NULL DEFAULT 0,
+    completed_at DATETIME,
+    recurrence TEXT,
+    project_id INTEGER,
+    priority INTEGER NOT NULL DEFAULT 0,
+    created_at DATETIME
+);
+CREATE TABLE projects (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    user_id INTEGER NOT NULL,
+    name TEXT NOT NULL COLLATE NOCASE,
+    UNIQUE (user_id, name)
+);
+CREATE TABLE checklist_items (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    task_id BLOB NOT NULL,
+    position INTEGER NOT NULL,
+    text TEXT NOT NULL,
+    done INTEGER NOT NULL DEFAULT 0
+);
+CREATE TABLE tags (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    user_id INTEGER NOT NULL,
+    name TEXT NOT NULL COLLATE NOCASE,
+    colour TEXT NOT NULL,
+    UNIQUE (user_id, name)
+);
+CREATE TABLE task_tags (
+    task_id BLOB NOT NULL,
+    tag_id INTEGER NOT NULL,
+    PRIMARY KEY (task_id, tag_id)
+);
+CREATE TABLE users (
+    id INTEGER PRIMARY KEY,
+    time_zone TEXT NOT NULL DEFAULT 'UTC'
+);
+CREATE VIRTUAL TABLE tasks_fts USING fts5(title, description, task_id UNINDEXED, tokenize = 'unicode61 remove_diacritics 2');
+CREATE TRIGGER tasks_fts_insert AFTER INSERT ON tasks BEGIN
+    INSERT INTO tasks_fts (title, description, task_id) VALUES (new.title, new.description, new.id);
+END;
+CREATE TRIGGER tasks_fts_update AFTER UPDATE OF title, description ON tasks BEGIN
+    UPDATE tasks_fts SET title = new.title, description = new.description WHERE task_id = old.id;
+END;
+CREATE TRIGGER tasks_fts_delete AFTER DELETE ON tasks BEGIN
+    DELETE FROM tasks_fts WHERE task_id = old.id;
+END`
+
 func TestGetUserByEmail(t *testing.T) {
     db, err := sql.Open("sqlite", ":memory:")
     if err != nil {
@@ -82,15 +143,7 @@ func TestGetUserByEmail(t *testing.T) {
     }
     defer db.Close()
 
-    _, err = db.Exec(`CREATE TABLE users (
-        id INTEGER PRIMARY KEY AUTOINCREMENT,
-        name TEXT,
-        password_hash BLOB,
-        email TEXT UNIQUE,
-        phone TEXT,
-        session_token_hash TEXT,
-        session_expires_at TIMESTAMP
-    )`)
+    _, err = db.Exec(usersTableSchema)
     if err != nil {
         t.Fatalf("failed to create table: %v", err)
     }
@@ -124,26 +177,106 @@ func TestGetUserByEmail(t *testing.T) {
     if user.Id == 0 {
         t.Errorf("expected non-zero user ID")
     }
+
+    if !user.LockedUntil.IsZero() {
+        t.Errorf("expected new user not to be locked, got %v", user.LockedUntil)
+    }
 }
 
-func TestAddSessionToken(t *testing.T) {
+func TestRecordFailedLogin(t *testing.T) {
     db, err := sql.Open("sqlite", ":memory:")
     if err != nil {
         t.Fatalf("failed to open db: %v", err)
     }
     defer db.Close()
 
-    _, err = db.Exec(`CREATE TABLE users (
+    _, err = db.Exec(usersTableSchema)
+    if err != nil {
+        t.Fatalf("failed to create table: %v", err)
+    }
+
+    store := &SQLiteStore{db: db}
+    if err := store.CreateUser(app.User{Name: "Carol", Email: "carol@example.com"}); err != nil {
+        t.Fatalf("CreateUser failed: %v", err)
+    }
+    user, err := store.GetUserByEmail("carol@example.com")
+    if err != nil {
+        t.Fatalf("GetUserByEmail returned error: %v", err)
+    }
+
+    for i := 1; i < 3; i++ {
+        lockedUntil, err := store.RecordFailedLogin(user.Id, 3, time.Minute)
+        if err != nil {
+            t.Fatalf("RecordFailedLogin returned error: %v", err)
+        }
+        if !lockedUntil.IsZero() {
+            t.Fatalf("expected no lock after %d failures, got %v", i, lockedUntil)
+        }
+    }
+
+    lockedUntil, err := store.RecordFailedLogin(user.Id, 3, time.Minute)
+    if err != nil {
+        t.Fatalf("RecordFailedLogin returned error: %v", err)
+    }
+    if !lockedUntil.After(time.Now()) {
+        t.Fatalf("expected a lock in the future after 3 failures, got %v", lockedUntil)
+    }
+
+    user, err = store.GetUserByEmail("carol@example.com")
+    if err != nil {
+        t.Fatalf("GetUserByEmail returned error: %v", err)
+    }
+    if !user.LockedUntil.After(time.Now()) {
+        t.Errorf("expected stored lock in the future, got %v", user.LockedUntil)
+    }
+
+    if err := store.ResetFailedLogins(user.Id); err != nil {
+        t.Fatalf("ResetFailedLogins returned error: %v", err)
+    }
+    user, err = store.GetUserByEmail("carol@example.com")
+    if err != nil {
+        t.Fatalf("GetUserByEmail returned error: %v", err)
+    }
+    if !user.LockedUntil.IsZero() {
+        t.Errorf("expected lock to be cleared, got %v", user.LockedUntil)
+    }
+
+    var failedLogins int
+    if err := db.QueryRow(`SELECT failed_logins FROM users WHERE id = ?`, user.Id).Scan(&failedLogins); err != nil {
+        t.Fatalf("failed to read failed_logins: %v", err)
+    }
+    if failedLogins != 0 {
+        t.Errorf("expected failed_logins to be reset, got %d", failedLogins)
+    }
+}
+
+// newSessionTestStore returns a store with a single user, whose id is returned too.
+func newSessionTestStore(t *testing.T) (*SQLiteStore, *sql.DB, int) {
+    t.Helper()
+
+    db, err := sql.Open("sqlite", ":memory:")
+    if err != nil {
+        t.Fatalf("failed to open db: %v", err)
+    }
+    t.Cleanup(func() { db.Close() })
+
+    _, err = db.Exec(usersTableSchema)
+    if err != nil {
+        t.Fatalf("failed to create users table: %v", err)
+    }
+
+    _, err = db.Exec(`CREATE TABLE sessions (
         id INTEGER PRIMARY KEY AUTOINCREMENT,
-        name TEXT,
-        password_hash BLOB,
-        email TEXT,
-        phone TEXT,
-        session_token_hash BLOB,
-        session_expires_at TIMESTAMP
+        user_id INTEGER NOT NULL,
+        token_hash BLOB NOT NULL,
+        created_at DATETIME NOT NULL,
+        last_seen_at DATETIME NOT NULL,
+        expires_at DATETIME NOT NULL,
+        user_agent TEXT NOT NULL DEFAULT '',
+        ip TEXT NOT NULL DEFAULT ''
     )`)
     if err != nil {
-        t.Fatalf("failed to create table: %v", err)
+        t.Fatalf("failed to create sessions table: %v", err)
     }
 
     store := &SQLiteStore{db: db}
@@ -166,27 +299,152 @@ func TestAddSessionToken(t *testing.T) {
         t.Fatalf("failed to get user ID: %v", err)
     }
 
-    token, expiresAt, err := store.AddSessionToken(userID)
+    return store, db, userID
+}
+
+func TestAddSessionToken(t *testing.T) {
+    store, db, userID := newSessionTestStore(t)
+
+    token, expiresAt, err := store.AddSessionToken(userID, "Firefox", "192.0.2.1")
     if err != nil {
         t.Fatalf("AddSessionToken failed: %v", err)
     }
 
     var hashFromDB []byte
-    var expiresFromDB time.Time
+    var expiresFromDB, createdAt, lastSeenAt time.Time
+    var userAgent, ip string
     err = db.QueryRow(`
-        SELECT session_token_hash, session_expires_at FROM users WHERE id = ?`, userID).
-        Scan(&hashFromDB, &expiresFromDB)
+        SELECT token_hash, created_at, last_seen_at, expires_at, user_agent, ip FROM sessions WHERE user_id = ?`, userID).
+        Scan(&hashFromDB, &createdAt, &lastSeenAt, &expiresFromDB, &userAgent, &ip)
     if err != nil {
         t.Fatalf("failed to fetch session info: %v", err)
     }
 
-    if err := bcrypt.CompareHashAndPassword(hashFromDB, token[:]); err != nil {
-        t.Errorf("session token hash doesn't match: %v", err)
+    if !bytes.Equal(hashFromDB, HashToken(token)) {
+        t.Errorf("session token hash doesn't match")
+    }
+
+    if bytes.Contains(hashFromDB, []byte(token)) {
+        t.Errorf("expected raw session token not to be stored")
     }
 
     if !expiresAt.Equal(expiresFromDB) {
         t.Errorf("expiresAt mismatch: expected %v, got %v", expiresAt, expiresFromDB)
     }
+
+    if createdAt.IsZero() || !createdAt.Equal(lastSeenAt) {
+        t.Errorf("expected created_at and last_seen_at to be set to the same time, got %v and %v", createdAt, lastSeenAt)
+    }
+
+    if userAgent != "Firefox" || ip != "192.0.2.1" {
+        t.Errorf("expected user agent and ip to be recorded, got %q and %q", userAgent, ip)
+    }
+}
+
+func TestConcurrentSessions(t *testing.T) {
+    store, _, userID := newSessionTestStore(t)
+
+    laptop, _, err := store.AddSessionToken(userID, "laptop", "192.0.2.1")
+    if err != nil {
+        t.Fatalf("AddSessionToken failed: %v", err)
+    }
+
+    phone, _, err := store.AddSessionToken(userID, "phone", "192.0.2.2")
+    if err != nil {
+        t.Fatalf("AddSessionToken failed: %v", err)
+    }
+
+    for _, token := range []string{laptop, phone} {
+        id, err := store.GetUserIdFromSessionToken(token)
+        if err != nil {
+            t.Fatalf("expected both sessions to be valid, got %v", err)
+        }
+        if id != userID {
+            t.Errorf("expected user id %d, got %d", userID, id)
+        }
+    }
+}
+
+func TestDeleteSession(t *testing.T) {
+    store, _, userID := newSessionTestStore(t)
+
+    laptop, _, err := store.AddSessionToken(userID, "laptop", "192.0.2.1")
+    if err != nil {
+        t.Fatalf("AddSessionToken failed: %v", err)
+    }
+
+    phone, _, err := store.AddSessionToken(userID, "phone", "192.0.2.2")
+    if err != nil {
+        t.Fatalf("AddSessionToken failed: %v", err)
+    }
+
+    if err := store.DeleteSession(laptop); err != nil {
+        t.Fatalf("DeleteSession failed: %v", err)
+    }
+
+    if _, err := store.GetUserIdFromSessionToken(laptop); err == nil {
+        t.Errorf("expected revoked session to be rejected")
+    }
+
+    if _, err := store.GetUserIdFromSessionToken(phone); err != nil {
+        t.Errorf("expected other session to remain valid, got %v", err)
+    }
+}
+
+func TestDeleteAllSessions(t *testing.T) {
+    store, db, userID := newSessionTestStore(t)
+
+    laptop, _, err := store.AddSessionToken(userID, "laptop", "192.0.2.1")
+    if err != nil {
+        t.Fatalf("AddSessionToken failed: %v", err)
+    }
+
+    phone, _, err := store.AddSessionToken(userID, "phone", "192.0.2.2")
+    if err != nil {
+        t.Fatalf("AddSessionToken failed: %v", err)
+    }
+
+    _, err = db.Exec(`INSERT INTO users (name, password_hash, email, phone) VALUES ('Dana', 'hash', 'dana@example.com', '1')`)
+    if err != nil {
+        t.Fatalf("failed to insert second user: %v", err)
+    }
+
+    other, _, err := store.AddSessionToken(userID+1, "desktop", "192.0.2.3")
+    if err != nil {
+        t.Fatalf("AddSessionToken failed: %v", err)
+    }
+
+    if err := store.DeleteAllSessions(userID); err != nil {
+        t.Fatalf("DeleteAllSessions failed: %v", err)
+    }
+
+    for _, token := range []string{laptop, phone} {
+        if _, err := store.GetUserIdFromSessionToken(token); err == nil {
+            t.Errorf("expected session to be revoked")
+        }
+    }
+
+    if _, err := store.GetUserIdFromSessionToken(other); err != nil {
+        t.Errorf("expected other user's session to remain valid, got %v", err)
+    }
+}
+
+func TestGetUserIdFromSessionTokenExpired(t *testing.T) {
+    store, db, userID := newSessionTestStore(t)
+
+    token, _, err := store.AddSessionToken(userID, "laptop", "192.0.2.1")
+    if err != nil {
+        t.Fatalf("AddSessionToken failed: %v", err)
+    }
+
+    _, err = db.Exec(`UPDATE sessions SET expires_at = ?`, time.Now().Add(-time.Minute))
+    if err != nil {
+        t.Fatalf("failed to expire session: %v", err)
+    }
+
+    if _, err := store.GetUserIdFromSessionToken(token); err == nil {
+        t.Errorf("expected expired session to be rejected")
+    }
 }
 
 func TestGetTaskById(t *testing.T) {
@@ -196,13 +454,7 @@ func TestGetTaskById(t *testing.T) {
     }
     defer db.Close()
 
-    _, err = db.Exec(`CREATE TABLE tasks (
-        id TEXT PRIMARY KEY,
-        title TEXT,
-        description TEXT,
-        done BOOLEAN,
-        due TIMESTAMP
-    )`)
+    _, err = db.Exec(tasksTableSchema)
     if err != nil {
         t.Fatalf("failed to create tasks table: %v", err)
     }
@@ -213,14 +465,14 @@ func TestGetTaskById(t *testing.T) {
     due := time.Now().Add(48 * time.Hour)
 
     _, err = db.Exec(`
-        INSERT INTO tasks (id, title, description, done, due)
-        VALUES (?, ?, ?, ?, ?)`,
-        id.String(), "Test Task", "Do the thing", false, due)
+        INSERT INTO tasks (id, user_id, title, description, done, due)
+        VALUES (?, ?, ?, ?, ?, ?)`,
+        id.String(), 1, "Test Task", "Do the thing", false, due)
     if err != nil {
         t.Fatalf("failed to insert test task: %v", err)
     }
 
-    task, err := store.GetTaskById(id)
+    task, err := store.GetTaskById(1, id)
     if err != nil {
         t.Fatalf("GetTaskById failed: %v", err)
     }
@@ -252,7 +504,35 @@ func TestGetAllTasks(t *testing.T) {
             title TEXT NOT NULL,
             description TEXT,
             done INTEGER NULL,
-            due DATETIME NOT NULL
+            due DATETIME NOT NULL,
+            all_day INTEGER NOT NULL DEFAULT 0,
+            completed_at DATETIME,
+            recurrence TEXT,
+            project_id INTEGER,
+            priority INTEGER NOT NULL DEFAULT 0,
+            created_at DATETIME
+        );
+        CREATE TABLE checklist_items (
+            id INTEGER PRIMARY KEY AUTOINCREMENT,
+            task_id BLOB NOT NULL,
+            position INTEGER NOT NULL,
+            text TEXT NOT NULL,
+            done INTEGER NOT NULL DEFAULT 0
+        );
+        CREATE TABLE tags (
+            id INTEGER PRIMARY KEY AUTOINCREMENT,
+            user_id INTEGER NOT NULL,
+            name TEXT NOT NULL COLLATE NOCASE,
+            colour TEXT NOT NULL
+        );
+        CREATE TABLE task_tags (
+            task_id BLOB NOT NULL,
+            tag_id INTEGER NOT NULL,
+            PRIMARY KEY (task_id, tag_id)
+        );
+        CREATE TABLE users (
+            id INTEGER PRIMARY KEY,
+            time_zone TEXT NOT NULL DEFAULT 'UTC'
         )`)
     if err != nil {
         t.Fatalf("failed to create table: %v", err)
@@ -296,14 +576,7 @@ func TestCreateTask(t *testing.T) {
     }
     defer db.Close()
 
-    _, err = db.Exec(`CREATE TABLE tasks (
-        id TEXT PRIMARY KEY,
-        user_id INTEGER,
-        title TEXT,
-        description TEXT,
-        done BOOLEAN,
-        due TIMESTAMP
-    )`)
+    _, err = db.Exec(tasksTableSchema)
     if err != nil {
         t.Fatalf("failed to create tasks table: %v", err)
     }
@@ -339,7 +612,7 @@ func TestCreateTask(t *testing.T) {
         t.Errorf("expected due %v, got %v", task.Due, dbTask.Due)
     }
 
-    if dbTask != task {
+    if !reflect.DeepEqual(dbTask, task) {
         t.Errorf("expected task %+v, got %+v", task, dbTask)
     }
 }
@@ -351,14 +624,7 @@ func TestDeleteTask(t *testing.T) {
     }
     defer db.Close()
 
-    _, err = db.Exec(`CREATE TABLE tasks (
-        id TEXT PRIMARY KEY,
-        user_id INTEGER,
-        title TEXT,
-        description TEXT,
-        done BOOLEAN,
-        due TIMESTAMP
-    )`)
+    _, err = db.Exec(tasksTableSchema)
     if err != nil {
         t.Fatalf("failed to create tasks table: %v", err)
     }
@@ -382,7 +648,7 @@ func TestDeleteTask(t *testing.T) {
         t.Fatalf("CreateTask failed: %v", err)
     }
 
-    err = store.DeleteTask(id)
+    err = store.DeleteTask(1, id)
     if err != nil {
         t.Fatalf("DeleteTask failed: %v", err)
     }
@@ -397,3 +663,1920 @@ func TestDeleteTask(t *testing.T) {
         t.Errorf("expected task to be deleted, but found %d task(s)", count)
     }
 }
+
+// newOwnershipTestStore returns a store holding one task belonging to user 1.
+func newOwnershipTestStore(t *testing.T) (*SQLiteStore, uuid.UUID) {
+    t.Helper()
+
+    db, err := sql.Open("sqlite", ":memory:")
+    if err != nil {
+        t.Fatalf("failed to open db: %v", err)
+    }
+    t.Cleanup(func() { db.Close() })
+
+    _, err = db.Exec(tasksTableSchema)
+    if err != nil {
+        t.Fatalf("failed to create tasks table: %v", err)
+    }
+
+    store := &SQLiteStore{db: db}
+
+    task := app.Task{
+        Id:          uuid.New(),
+        UserId:      1,
+        Title:       "Alice's task",
+        Description: "Private",
+        Due:         time.Now().Add(48 * time.Hour).UTC(),
+    }
+    if err := store.CreateTask(task); err != nil {
+        t.Fatalf("CreateTask failed: %v", err)
+    }
+
+    return store, task.Id
+}
+
+func TestGetTaskByIdOtherUser(t *testing.T) {
+    store, id := newOwnershipTestStore(t)
+
+    _, err := store.GetTaskById(2, id)
+    if !errors.Is(err, ErrTaskNotFound) {
+        t.Fatalf("expected ErrTaskNotFound, got %v", err)
+    }
+
+    _, err = store.GetTaskById(1, uuid.New())
+    if !errors.Is(err, ErrTaskNotFound) {
+        t.Fatalf("expected ErrTaskNotFound for missing task, got %v", err)
+    }
+}
+
+func TestUpdateTaskOtherUser(t *testing.T) {
+    store, id := newOwnershipTestStore(t)
+
+    err := store.UpdateTask(app.Task{Id: id, UserId: 2, Title: "Hijacked", Due: time.Now()})
+    if !errors.Is(err, ErrTaskNotFound) {
+        t.Fatalf("expected ErrTaskNotFound, got %v", err)
+    }
+
+    task, err := store.GetTaskById(1, id)
+    if err != nil {
+        t.Fatalf("GetTaskById failed: %v", err)
+    }
+    if task.Title != "Alice's task" {
+        t.Errorf("expected title to be unchanged, got %q", task.Title)
+    }
+}
+
+func TestSetTaskDoneOtherUser(t *testing.T) {
+    store, id := newOwnershipTestStore(t)
+
+    _, err := store.SetTaskDone(2, id, true)
+    if !errors.Is(err, ErrTaskNotFound) {
+        t.Fatalf("expected ErrTaskNotFound, got %v", err)
+    }
+
+    task, err := store.GetTaskById(1, id)
+    if err != nil {
+        t.Fatalf("GetTaskById failed: %v", err)
+    }
+    if task.Done != 0 {
+        t.Errorf("expected task to still be pending, got done = %d", task.Done)
+    }
+
+    if _, err := store.SetTaskDone(1, id, true); err != nil {
+        t.Fatalf("SetTaskDone failed for owner: %v", err)
+    }
+}
+
+func TestSetTaskDoneRecordsCompletion(t *testing.T) {
+    store, id := newOwnershipTestStore(t)
+
+    if _, err := store.SetTaskDone(1, id, true); err != nil {
+        t.Fatalf("SetTaskDone failed: %v", err)
+    }
+    task, err := store.GetTaskById(1, id)
+    if err != nil {
+        t.Fatalf("GetTaskById failed: %v", err)
+    }
+    if task.Done != 1 || task.Status != "done" {
+        t.Fatalf("expected task to be done, got done = %d, status = %q", task.Done, task.Status)
+    }
+    if task.CompletedAt.IsZero() {
+        t.Fatal("expected completed_at to be set")
+    }
+    completedAt := task.CompletedAt
+
+    if _, err := store.SetTaskDone(1, id, true); err != nil {
+        t.Fatalf("SetTaskDone failed: %v", err)
+    }
+    task, _ = store.GetTaskById(1, id)
+    if !task.CompletedAt.Equal(completedAt) {
+        t.Errorf("expected marking a done task done again to keep %v, got %v", completedAt, task.CompletedAt)
+    }
+
+    if _, err := store.SetTaskDone(1, id, false); err != nil {
+        t.Fatalf("SetTaskDone failed: %v", err)
+    }
+    task, _ = store.GetTaskById(1, id)
+    if task.Done != 0 || task.Status != "pending" {
+        t.Errorf("expected task to be pending again, got done = %d, status = %q", task.Done, task.Status)
+    }
+    if !task.CompletedAt.IsZero() {
+        t.Errorf("expected completed_at to be cleared, got %v", task.CompletedAt)
+    }
+}
+
+func TestUpdateTaskRecordsCompletion(t *testing.T) {
+    store, id := newOwnershipTestStore(t)
+
+    task, err := store.GetTaskById(1, id)
+    if err != nil {
+        t.Fatalf("GetTaskById failed: %v", err)
+    }
+
+    task.Done = 1
+    if err := store.UpdateTask(task); err != nil {
+        t.Fatalf("UpdateTask failed: %v", err)
+    }
+    task, _ = store.GetTaskById(1, id)
+    if task.CompletedAt.IsZero() {
+        t.Fatal("expected completed_at to be set")
+    }
+
+    task.Done = 0
+    if err := store.UpdateTask(task); err != nil {
+        t.Fatalf("UpdateTask failed: %v", err)
+    }
+    task, _ = store.GetTaskById(1, id)
+    if task.Done != 0 || !task.CompletedAt.IsZero() {
+        t.Errorf("expected task to be undone with no completion time, got done = %d, completed_at = %v", task.Done, task.CompletedAt)
+    }
+}
+
+func TestSetTaskDoneSpawnsNextOccurrence(t *testing.T) {
+    store, _ := newOwnershipTestStore(t)
+
+    due := time.Date(2030, time.January, 7, 23, 59, 59, 0, time.UTC)
+    task := app.Task{Id: uuid.New(), UserId: 1, Title: "Bins", Due: due, Recurrence: "FREQ=WEEKLY;BYDAY=MO,TH;COUNT=2"}
+    if err := store.CreateTask(task); err != nil {
+        t.Fatalf("CreateTask failed: %v", err)
+    }
+
+    next, err := store.SetTaskDone(1, task.Id, true)
+    if err != nil {
+        t.Fatalf("SetTaskDone failed: %v", err)
+    }
+    if next.Id == uuid.Nil || next.Id == task.Id {
+        t.Fatalf("expected a new occurrence, got %+v", next)
+    }
+    if want := due.AddDate(0, 0, 3); !next.Due.Equal(want) {
+        t.Errorf("expected next occurrence on %v, got %v", want, next.Due)
+    }
+    if next.Title != "Bins" || next.Recurrence != "FREQ=WEEKLY;BYDAY=MO,TH;COUNT=1" {
+        t.Errorf("unexpected next occurrence %+v", next)
+    }
+
+    done, err := store.GetTaskById(1, task.Id)
+    if err != nil {
+        t.Fatalf("GetTaskById failed: %v", err)
+    }
+    if done.Recurrence != "" {
+        t.Errorf("expected the recurrence to move to the next occurrence, got %q", done.Recurrence)
+    }
+
+    // Unchecking and rechecking the old occurrence mustn't spawn another.
+    if _, err := store.SetTaskDone(1, task.Id, false); err != nil {
+        t.Fatalf("SetTaskDone failed: %v", err)
+    }
+    again, err := store.SetTaskDone(1, task.Id, true)
+    if err != nil {
+        t.Fatalf("SetTaskDone failed: %v", err)
+    }
+    if again.Id != uuid.Nil {
+        t.Errorf("expected no new occurrence, got %+v", again)
+    }
+
+    // The last counted occurrence ends the series.
+    last, err := store.SetTaskDone(1, next.Id, true)
+    if err != nil {
+        t.Fatalf("SetTaskDone failed: %v", err)
+    }
+    if last.Id != uuid.Nil {
+        t.Errorf("expected the series to end, got %+v", last)
+    }
+
+    tasks, err := store.GetAllTasks(1)
+    if err != nil {
+        t.Fatalf("GetAllTasks failed: %v", err)
+    }
+    if len(tasks) != 3 {
+        t.Errorf("expected the original task and two occurrences, got %d tasks", len(tasks))
+    }
+}
+
+func TestUpdateTaskSpawnsNextOccurrence(t *testing.T) {
+    store, _ := newOwnershipTestStore(t)
+
+    due := time.Date(2030, time.January, 31, 23, 59, 59, 0, time.UTC)
+    task := app.Task{Id: uuid.New(), UserId: 1, Title: "Rent", Due: due, Recurrence: "FREQ=MONTHLY"}
+    if err := store.CreateTask(task); err != nil {
+        t.Fatalf("CreateTask failed: %v", err)
+    }
+
+    task.Done = 1
+    if err := store.UpdateTask(task); err != nil {
+        t.Fatalf("UpdateTask failed: %v", err)
+    }
+
+    tasks, err := store.GetAllTasks(1)
+    if err != nil {
+        t.Fatalf("GetAllTasks failed: %v", err)
+    }
+    var found bool
+    for _, other := range tasks {
+        if other.Title == "Rent" && other.Id != task.Id {
+            found = true
+            if want := time.Date(2030, time.March, 31, 23, 59, 59, 0, time.UTC); !other.Due.Equal(want) {
+                t.Errorf("expected next occurrence on %v, got %v", want, other.Due)
+            }
+            if other.Recurrence != "FREQ=MONTHLY" || other.Done != 0 {
+                t.Errorf("unexpected next occurrence %+v", other)
+            }
+        }
+    }
+    if !found {
+        t.Error("expected UpdateTask to create the next occurrence")
+    }
+}
+
+func TestDeleteTaskOtherUser(t *testing.T) {
+    store, id := newOwnershipTestStore(t)
+
+    err := store.DeleteTask(2, id)
+    if !errors.Is(err, ErrTaskNotFound) {
+        t.Fatalf("expected ErrTaskNotFound, got %v", err)
+    }
+
+    if _, err := store.GetTaskById(1, id); err != nil {
+        t.Fatalf("expected task to survive, got %v", err)
+    }
+}
+
+func TestGenerateToken(t *testing.T) {
+    a, err := GenerateToken()
+    if err != nil {
+        t.Fatalf("GenerateToken failed: %v", err)
+    }
+
+    b, err := GenerateToken()
+    if err != nil {
+        t.Fatalf("GenerateToken failed: %v", err)
+    }
+
+    if len(a) < 43 {
+        t.Errorf("expected at least 43 characters (256 bits), got %d", len(a))
+    }
+
+    if a == b {
+        t.Errorf("expected distinct tokens")
+    }
+}
+
+// BenchmarkGetUserIdFromSessionToken shows that looking up a session costs the same however many users are logged in.
+func BenchmarkGetUserIdFromSessionToken(b *testing.B) {
+    for _, users := range []int{10, 100, 1000, 10000} {
+        b.Run(fmt.Sprintf("users=%d", users), func(b *testing.B) {
+            db, err := sql.Open("sqlite", ":memory:")
+            if err != nil {
+                b.Fatalf("failed to open db: %v", err)
+            }
+            defer db.Close()
+            db.SetMaxOpenConns(1) // Each connection to `:memory:` would otherwise get its own empty database.
+
+            _, err = db.Exec(`CREATE TABLE sessions (
+                id INTEGER PRIMARY KEY AUTOINCREMENT,
+                user_id INTEGER NOT NULL,
+                token_hash BLOB NOT NULL,
+                created_at DATETIME NOT NULL,
+                last_seen_at DATETIME NOT NULL,
+                expires_at DATETIME NOT NULL,
+                user_agent TEXT NOT NULL DEFAULT '',
+                ip TEXT NOT NULL DEFAULT ''
+            );
+            CREATE UNIQUE INDEX sessions_token_hash ON sessions (token_hash)`)
+            if err != nil {
+                b.Fatalf("failed to create sessions table: %v", err)
+            }
+
+            store := &SQLiteStore{db: db}
+
+            var token string
+            for i := 1; i <= users; i++ {
+                token, _, err = store.AddSessionToken(i, "bench", "192.0.2.1")
+                if err != nil {
+                    b.Fatalf("AddSessionToken failed: %v", err)
+                }
+            }
+
+            b.ResetTimer()
+            for i := 0; i < b.N; i++ {
+                if _, err := store.GetUserIdFromSessionToken(token); err != nil {
+                    b.Fatalf("GetUserIdFromSessionToken failed: %v", err)
+                }
+            }
+        })
+    }
+}
+
+func newAPITokenTestStore(t *testing.T) (*SQLiteStore, *sql.DB) {
+    t.Helper()
+
+    db, err := sql.Open("sqlite", ":memory:")
+    if err != nil {
+        t.Fatalf("failed to open db: %v", err)
+    }
+    t.Cleanup(func() { db.Close() })
+
+    _, err = db.Exec(`CREATE TABLE api_tokens (
+        id INTEGER PRIMARY KEY AUTOINCREMENT,
+        user_id INTEGER NOT NULL,
+        name TEXT NOT NULL,
+        token_hash BLOB NOT NULL UNIQUE,
+        scope TEXT NOT NULL CHECK (scope IN ('read', 'read-write')),
+        created_at DATETIME NOT NULL,
+        last_used_at DATETIME,
+        expires_at DATETIME
+    )`)
+    if err != nil {
+        t.Fatalf("failed to create api_tokens table: %v", err)
+    }
+
+    return &SQLiteStore{db: db}, db
+}
+
+func TestCreateAPIToken(t *testing.T) {
+    store, db := newAPITokenTestStore(t)
+
+    token, err := store.CreateAPIToken(1, "Backup script", app.ScopeRead, time.Time{})
+    if err != nil {
+        t.Fatalf("CreateAPIToken failed: %v", err)
+    }
+
+    if len(token) <= len(APITokenPrefix) || token[:len(APITokenPrefix)] != APITokenPrefix {
+        t.Errorf("expected token to start with %q, got %q", APITokenPrefix, token)
+    }
+
+    var hash []byte
+    if err := db.QueryRow(`SELECT token_hash FROM api_tokens`).Scan(&hash); err != nil {
+        t.Fatalf("failed to fetch token hash: %v", err)
+    }
+    if !bytes.Equal(hash, HashToken(token)) {
+        t.Errorf("expected only the hash of the token to be stored")
+    }
+
+    got, err := store.GetAPIToken(token)
+    if err != nil {
+        t.Fatalf("GetAPIToken failed: %v", err)
+    }
+    if got.UserId != 1 || got.Name != "Backup script" || got.Scope != app.ScopeRead {
+        t.Errorf("unexpected token: %+v", got)
+    }
+    if !got.ExpiresAt.IsZero() {
+        t.Errorf("expected token never to expire, got %v", got.ExpiresAt)
+    }
+
+    tokens, err := store.GetAPITokens(1)
+    if err != nil {
+        t.Fatalf("GetAPITokens failed: %v", err)
+    }
+    if len(tokens) != 1 || tokens[0].LastUsedAt.IsZero() {
+        t.Errorf("expected one token with its last use recorded, got %+v", tokens)
+    }
+}
+
+func TestCreateAPITokenInvalidScope(t *testing.T) {
+    store, _ := newAPITokenTestStore(t)
+
+    if _, err := store.CreateAPIToken(1, "Admin", "admin", time.Time{}); err == nil {
+        t.Errorf("expected invalid scope to be rejected")
+    }
+}
+
+func TestGetAPITokenExpired(t *testing.T) {
+    store, _ := newAPITokenTestStore(t)
+
+    token, err := store.CreateAPIToken(1, "Old", app.ScopeReadWrite, time.Now().Add(-time.Minute))
+    if err != nil {
+        t.Fatalf("CreateAPIToken failed: %v", err)
+    }
+
+    if _, err := store.GetAPIToken(token); !errors.Is(err, ErrAPITokenNotFound) {
+        t.Errorf("expected ErrAPITokenNotFound, got %v", err)
+    }
+}
+
+func TestDeleteAPIToken(t *testing.T) {
+    store, _ := newAPITokenTestStore(t)
+
+    token, err := store.CreateAPIToken(1, "CI", app.ScopeReadWrite, time.Time{})
+    if err != nil {
+        t.Fatalf("CreateAPIToken failed: %v", err)
+    }
+
+    tokens, err := store.GetAPITokens(1)
+    if err != nil || len(tokens) != 1 {
+        t.Fatalf("expected one token, got %v, %v", tokens, err)
+    }
+
+    if err := store.DeleteAPIToken(2, tokens[0].Id); !errors.Is(err, ErrAPITokenNotFound) {
+        t.Errorf("expected another user's revocation to fail with ErrAPITokenNotFound, got %v", err)
+    }
+
+    if err := store.DeleteAPIToken(1, tokens[0].Id); err != nil {
+        t.Fatalf("DeleteAPIToken failed: %v", err)
+    }
+
+    if _, err := store.GetAPIToken(token); !errors.Is(err, ErrAPITokenNotFound) {
+        t.Errorf("expected revoked token to be rejected, got %v", err)
+    }
+}
+
+// newCalendarFeedTestStore returns a store with the tables and triggers calendar feeds need.
+func newCalendarFeedTestStore(t *testing.T) (*SQLiteStore, *sql.DB) {
+    t.Helper()
+
+    db, err := sql.Open("sqlite", ":memory:")
+    if err != nil {
+        t.Fatalf("failed to open db: %v", err)
+    }
+    t.Cleanup(func() { db.Close() })
+
+    _, err = db.Exec(tasksTableSchema + `;
+    CREATE TABLE calendar_feeds (
+        user_id INTEGER PRIMARY KEY,
+        token_hash BLOB NOT NULL UNIQUE,
+        created_at DATETIME NOT NULL,
+        changed_at DATETIME NOT NULL,
+        last_polled_at DATETIME
+    );
+    CREATE TRIGGER calendar_feeds_task_insert AFTER INSERT ON tasks BEGIN
+        UPDATE calendar_feeds SET changed_at = strftime('%Y-%m-%d %H:%M:%f+00:00', 'now') WHERE user_id = new.user_id;
+    END;
+    CREATE TRIGGER calendar_feeds_task_update AFTER UPDATE ON tasks BEGIN
+        UPDATE calendar_feeds SET changed_at = strftime('%Y-%m-%d %H:%M:%f+00:00', 'now') WHERE user_id = new.user_id;
+    END;
+    CREATE TRIGGER calendar_feeds_task_delete AFTER DELETE ON tasks BEGIN
+        UPDATE calendar_feeds SET changed_at = strftime('%Y-%m-%d %H:%M:%f+00:00', 'now') WHERE user_id = old.user_id;
+    END;
+    CREATE TRIGGER calendar_feeds_time_zone_update AFTER UPDATE OF time_zone ON users BEGIN
+        UPDATE calendar_feeds SET changed_at = strftime('%Y-%m-%d %H:%M:%f+00:00', 'now') WHERE user_id = new.id;
+    END`)
+    if err != nil {
+        t.Fatalf("failed to create tables: %v", err)
+    }
+    if _, err := db.Exec(`INSERT INTO users (id) VALUES (1), (2)`); err != nil {
+        t.Fatalf("failed to insert users: %v", err)
+    }
+
+    return &SQLiteStore{db: db}, db
+}
+
+func TestCalendarFeed(t *testing.T) {
+    store, db := newCalendarFeedTestStore(t)
+
+    if _, err := store.GetCalendarFeed(1); !errors.Is(err, ErrCalendarFeedNotFound) {
+        t.Errorf("expected ErrCalendarFeedNotFound before a feed is created, got %v", err)
+    }
+
+    token, err := store.CreateCalendarFeed(1)
+    if err != nil {
+        t.Fatalf("CreateCalendarFeed failed: %v", err)
+    }
+    var hash []byte
+    if err := db.QueryRow(`SELECT token_hash FROM calendar_feeds`).Scan(&hash); err != nil {
+        t.Fatalf("failed to fetch token hash: %v", err)
+    }
+    if !bytes.Equal(hash, HashToken(token)) {
+        t.Errorf("expected only the hash of the token to be stored")
+    }
+
+    feed, err := store.GetCalendarFeedByToken(token)
+    if err != nil {
+        t.Fatalf("GetCalendarFeedByToken failed: %v", err)
+    }
+    if feed.UserId != 1 || feed.ChangedAt.IsZero() || feed.LastPolledAt.IsZero() {
+        t.Errorf("unexpected feed: %+v", feed)
+    }
+    if feed, err := store.GetCalendarFeed(1); err != nil || feed.LastPolledAt.IsZero() {
+        t.Errorf("expected the poll to be recorded, got %+v, %v", feed, err)
+    }
+    if _, err := store.GetCalendarFeedByToken("wrong"); !errors.Is(err, ErrCalendarFeedNotFound) {
+        t.Errorf("expected an unknown token to be rejected, got %v", err)
+    }
+
+    // A new token replaces the old one.
+    newToken, err := store.CreateCalendarFeed(1)
+    if err != nil {
+        t.Fatalf("CreateCalendarFeed failed: %v", err)
+    }
+    if _, err := store.GetCalendarFeedByToken(token); !errors.Is(err, ErrCalendarFeedNotFound) {
+        t.Errorf("expected the replaced token to be rejected, got %v", err)
+    }
+
+    if err := store.DeleteCalendarFeed(2); !errors.Is(err, ErrCalendarFeedNotFound) {
+        t.Errorf("expected revoking a missing feed to fail with ErrCalendarFeedNotFound, got %v", err)
+    }
+    if err := store.DeleteCalendarFeed(1); err != nil {
+        t.Fatalf("DeleteCalendarFeed failed: %v", err)
+    }
+    if _, err := store.GetCalendarFeedByToken(newToken); !errors.Is(err, ErrCalendarFeedNotFound) {
+        t.Errorf("expected the revoked token to be rejected, got %v", err)
+    }
+}
+
+func TestCalendarFeedChangedAt(t *testing.T) {
+    store, db := newCalendarFeedTestStore(t)
+
+    if _, err := store.CreateCalendarFeed(1); err != nil {
+        t.Fatalf("CreateCalendarFeed failed: %v", err)
+    }
+    long := time.Date(2000, 1, 1, 0, 0, 0, 0, time.UTC)
+    reset := func() {
+        t.Helper()
+        if _, err := db.Exec(`UPDATE calendar_feeds SET changed_at = ?`, long); err != nil {
+            t.Fatalf("failed to reset changed_at: %v", err)
+        }
+    }
+    changed := func() bool {
+        t.Helper()
+        feed, err := store.GetCalendarFeed(1)
+        if err != nil {
+            t.Fatalf("GetCalendarFeed failed: %v", err)
+        }
+        return feed.ChangedAt.After(long)
+    }
+
+    task := app.Task{Id: uuid.New(), UserId: 1, Title: "Pay rent", Due: time.Now().Add(time.Hour), CreatedAt: time.Now()}
+    other := app.Task{Id: uuid.New(), UserId: 2, Title: "Someone else's", Due: time.Now().Add(time.Hour), CreatedAt: time.Now()}
+
+    reset()
+    if err := store.CreateTask(other); err != nil {
+        t.Fatalf("CreateTask failed: %v", err)
+    }
+    if changed() {
+        t.Errorf("expected another user's new task to leave the feed unchanged")
+    }
+
+    steps := map[string]func() error{
+        "create": func() error { return store.CreateTask(task) },
+        "update": func() error { task.Title = "Pay the rent"; return store.UpdateTask(task) },
+        "time zone": func() error { return store.SetTimeZone(1, "Europe/London") },
+        "delete": func() error { return store.DeleteTask(1, task.Id) },
+    }
+    for _, name := range []string{"create", "update", "time zone", "delete"} {
+        reset()
+        if err := steps[name](); err != nil {
+            t.Fatalf("%s failed: %v", name, err)
+        }
+        if !changed() {
+            t.Errorf("expected %s to change the feed", name)
+        }
+    }
+}
+
+// newTOTPTestStore returns a store with a single user and the tables two-factor authentication needs.
+func newTOTPTestStore(t *testing.T) (*SQLiteStore, int) {
+    db, err := sql.Open("sqlite", ":memory:")
+    if err != nil {
+        t.Fatalf("failed to open db: %v", err)
+    }
+    t.Cleanup(func() { db.Close() })
+
+    for _, schema := range []string{
+        usersTableSchema,
+        `CREATE TABLE recovery_codes (
+            id INTEGER PRIMARY KEY AUTOINCREMENT,
+            user_id INTEGER NOT NULL,
+            code_hash BLOB NOT NULL,
+            used_at DATETIME,
+            UNIQUE (user_id, code_hash)
+        )`,
+        `CREATE TABLE login_challenges (
+            id INTEGER PRIMARY KEY AUTOINCREMENT,
+            user_id INTEGER NOT NULL,
+            token_hash BLOB NOT NULL UNIQUE,
+            expires_at DATETIME NOT NULL
+        )`,
+    } {
+        if _, err := db.Exec(schema); err != nil {
+            t.Fatalf("failed to create table: %v", err)
+        }
+    }
+
+    store := &SQLiteStore{db: db}
+    if err := store.CreateUser(app.User{Name: "Erin", Email: "erin@example.com"}); err != nil {
+        t.Fatalf("CreateUser failed: %v", err)
+    }
+    user, err := store.GetUserByEmail("erin@example.com")
+    if err != nil {
+        t.Fatalf("GetUserByEmail returned error: %v", err)
+    }
+
+    return store, user.Id
+}
+
+func TestEnableAndDisableTOTP(t *testing.T) {
+    store, userId := newTOTPTestStore(t)
+
+    if err := store.EnableTOTP(userId, []string{"abcdefghjk"}); err == nil {
+        t.Fatal("expected EnableTOTP to fail without a pending secret")
+    }
+
+    if err := store.SetPendingTOTPSecret(userId, "JBSWY3DPEHPK3PXP"); err != nil {
+        t.Fatalf("SetPendingTOTPSecret returned error: %v", err)
+    }
+    user, err := store.GetUserById(userId)
+    if err != nil {
+        t.Fatalf("GetUserById returned error: %v", err)
+    }
+    if user.TOTPSecret != "JBSWY3DPEHPK3PXP" || user.TOTPEnabled {
+        t.Fatalf("expected pending secret, got secret %q enabled %v", user.TOTPSecret, user.TOTPEnabled)
+    }
+
+    if err := store.EnableTOTP(userId, []string{"abcdefghjk", "mnpqrstuvw"}); err != nil {
+        t.Fatalf("EnableTOTP returned error: %v", err)
+    }
+    user, err = store.GetUserById(userId)
+    if err != nil {
+        t.Fatalf("GetUserById returned error: %v", err)
+    }
+    if !user.TOTPEnabled {
+        t.Fatal("expected TOTP to be enabled")
+    }
+
+    if err := store.SetPendingTOTPSecret(userId, "OTHERSECRET"); err != nil {
+        t.Fatalf("SetPendingTOTPSecret returned error: %v", err)
+    }
+    user, _ = store.GetUserById(userId)
+    if user.TOTPSecret != "JBSWY3DPEHPK3PXP" {
+        t.Error("expected an enabled secret not to be replaced")
+    }
+
+    count, err := store.CountRecoveryCodes(userId)
+    if err != nil || count != 2 {
+        t.Fatalf("expected 2 recovery codes, got %d (%v)", count, err)
+    }
+
+    if err := store.DisableTOTP(userId); err != nil {
+        t.Fatalf("DisableTOTP returned error: %v", err)
+    }
+    user, _ = store.GetUserById(userId)
+    if user.TOTPEnabled || user.TOTPSecret != "" {
+        t.Errorf("expected TOTP to be cleared, got secret %q enabled %v", user.TOTPSecret, user.TOTPEnabled)
+    }
+    count, _ = store.CountRecoveryCodes(userId)
+    if count != 0 {
+        t.Errorf("expected recovery codes to be deleted, got %d", count)
+    }
+}
+
+func TestRecordTOTPStep(t *testing.T) {
+    store, userId := newTOTPTestStore(t)
+
+    for _, tc := range []struct {
+        step int64
+        want bool
+    }{
+        {100, true},
+        {100, false},
+        {99, false},
+        {101, true},
+    } {
+        ok, err := store.RecordTOTPStep(userId, tc.step)
+        if err != nil {
+            t.Fatalf("RecordTOTPStep returned error: %v", err)
+        }
+        if ok != tc.want {
+            t.Errorf("RecordTOTPStep(%d) = %v, want %v", tc.step, ok, tc.want)
+        }
+    }
+}
+
+func TestUseRecoveryCode(t *testing.T) {
+    store, userId := newTOTPTestStore(t)
+
+    if err := store.SetPendingTOTPSecret(userId, "JBSWY3DPEHPK3PXP"); err != nil {
+        t.Fatalf("SetPendingTOTPSecret returned error: %v", err)
+    }
+    if err := store.EnableTOTP(userId, []string{"abcdefghjk"}); err != nil {
+        t.Fatalf("EnableTOTP returned error: %v", err)
+    }
+
+    ok, err := store.UseRecoveryCode(userId, "abcdefghjk")
+    if err != nil || !ok {
+        t.Fatalf("expected recovery code to be accepted, got %v (%v)", ok, err)
+    }
+
+    ok, err = store.UseRecoveryCode(userId, "abcdefghjk")
+    if err != nil || ok {
+        t.Errorf("expected used recovery code to be refused, got %v (%v)", ok, err)
+    }
+
+    ok, _ = store.UseRecoveryCode(userId+1, "abcdefghjk")
+    if ok {
+        t.Error("expected another user's recovery code to be refused")
+    }
+
+    count, _ := store.CountRecoveryCodes(userId)
+    if count != 0 {
+        t.Errorf("expected no unused recovery codes, got %d", count)
+    }
+}
+
+func TestLoginChallenge(t *testing.T) {
+    store, userId := newTOTPTestStore(t)
+
+    token, expiresAt, err := store.CreateLoginChallenge(userId)
+    if err != nil {
+        t.Fatalf("CreateLoginChallenge returned error: %v", err)
+    }
+    if !expiresAt.After(time.Now()) {
+        t.Errorf("expected challenge to expire in the future, got %v", expiresAt)
+    }
+
+    got, err := store.GetLoginChallenge(token)
+    if err != nil || got != userId {
+        t.Fatalf("GetLoginChallenge = %d, %v; want %d", got, err, userId)
+    }
+
+    if err := store.DeleteLoginChallenge(token); err != nil {
+        t.Fatalf("DeleteLoginChallenge returned error: %v", err)
+    }
+    if _, err := store.GetLoginChallenge(token); !errors.Is(err, ErrLoginChallengeNotFound) {
+        t.Errorf("expected ErrLoginChallengeNotFound, got %v", err)
+    }
+
+    expired, _, err := store.CreateLoginChallenge(userId)
+    if err != nil {
+        t.Fatalf("CreateLoginChallenge returned error: %v", err)
+    }
+    if _, err := store.db.Exec(`UPDATE login_challenges SET expires_at = ?`, time.Now().Add(-time.Minute)); err != nil {
+        t.Fatalf("failed to expire challenge: %v", err)
+    }
+    if _, err := store.GetLoginChallenge(expired); !errors.Is(err, ErrLoginChallengeNotFound) {
+        t.Errorf("expected expired challenge to be refused, got %v", err)
+    }
+}
+
+func newPasswordResetTestStore(t *testing.T) (*SQLiteStore, *sql.DB, int) {
+    t.Helper()
+
+    store, db, userId := newSessionTestStore(t)
+    _, err := db.Exec(`CREATE TABLE password_resets (
+        id INTEGER PRIMARY KEY AUTOINCREMENT,
+        user_id INTEGER NOT NULL,
+        token_hash BLOB NOT NULL UNIQUE,
+        created_at DATETIME NOT NULL,
+        expires_at DATETIME NOT NULL,
+        used_at DATETIME
+    )`)
+    if err != nil {
+        t.Fatalf("failed to create password_resets table: %v", err)
+    }
+
+    return store, db, userId
+}
+
+func TestResetPassword(t *testing.T) {
+    store, db, userId := newPasswordResetTestStore(t)
+
+    sessionToken, _, err := store.AddSessionToken(userId, "", "")
+    if err != nil {
+        t.Fatalf("AddSessionToken failed: %v", err)
+    }
+    if _, err := store.RecordFailedLogin(userId, 1, time.Hour); err != nil {
+        t.Fatalf("RecordFailedLogin failed: %v", err)
+    }
+
+    token, expiresAt, err := store.CreatePasswordReset(userId)
+    if err != nil {
+        t.Fatalf("CreatePasswordReset failed: %v", err)
+    }
+    if !expiresAt.After(time.Now()) {
+        t.Errorf("expected reset to expire in the future, got %v", expiresAt)
+    }
+    other, _, err := store.CreatePasswordReset(userId)
+    if err != nil {
+        t.Fatalf("CreatePasswordReset failed: %v", err)
+    }
+
+    got, err := store.GetPasswordReset(token)
+    if err != nil || got != userId {
+        t.Fatalf("GetPasswordReset = %d, %v; want %d", got, err, userId)
+    }
+
+    got, err = store.ResetPassword(token, []byte("new-hash"))
+    if err != nil || got != userId {
+        t.Fatalf("ResetPassword = %d, %v; want %d", got, err, userId)
+    }
+
+    user, err := store.GetUserByEmail("charlie@example.com")
+    if err != nil {
+        t.Fatalf("GetUserByEmail failed: %v", err)
+    }
+    if !bytes.Equal(user.PasswordHash, []byte("new-hash")) {
+        t.Errorf("expected password hash to be updated, got %q", user.PasswordHash)
+    }
+    if !user.LockedUntil.IsZero() {
+        t.Errorf("expected lockout to be lifted, got %v", user.LockedUntil)
+    }
+
+    if _, err := store.GetUserIdFromSessionToken(sessionToken); err == nil {
+        t.Error("expected sessions to be revoked")
+    }
+
+    if _, err := store.ResetPassword(token, []byte("again")); !errors.Is(err, ErrPasswordResetNotFound) {
+        t.Errorf("expected a used token to be refused, got %v", err)
+    }
+    if _, err := store.GetPasswordReset(other); !errors.Is(err, ErrPasswordResetNotFound) {
+        t.Errorf("expected other reset tokens to be revoked, got %v", err)
+    }
+
+    var count int
+    if err := db.QueryRow(`SELECT COUNT(*) FROM password_resets WHERE token_hash = ?`, []byte(token)).Scan(&count); err != nil {
+        t.Fatalf("failed to count reset tokens: %v", err)
+    }
+    if count != 0 {
+        t.Error("expected only the hash of the token to be stored")
+    }
+}
+
+func TestResetPasswordExpired(t *testing.T) {
+    store, db, userId := newPasswordResetTestStore(t)
+
+    token, _, err := store.CreatePasswordReset(userId)
+    if err != nil {
+        t.Fatalf("CreatePasswordReset failed: %v", err)
+    }
+    if _, err := db.Exec(`UPDATE password_resets SET expires_at = ?`, time.Now().Add(-time.Minute)); err != nil {
+        t.Fatalf("failed to expire reset: %v", err)
+    }
+
+    if _, err := store.GetPasswordReset(token); !errors.Is(err, ErrPasswordResetNotFound) {
+        t.Errorf("expected ErrPasswordResetNotFound, got %v", err)
+    }
+    if _, err := store.ResetPassword(token, []byte("new-hash")); !errors.Is(err, ErrPasswordResetNotFound) {
+        t.Errorf("expected ErrPasswordResetNotFound, got %v", err)
+    }
+
+    user, _ := store.GetUserByEmail("charlie@example.com")
+    if !bytes.Equal(user.PasswordHash, []byte("passhash")) {
+        t.Error("expected password to be unchanged")
+    }
+}
+
+func TestMarkEmailVerified(t *testing.T) {
+    store, _, userId := newSessionTestStore(t)
+
+    user, err := store.GetUserById(userId)
+    if err != nil {
+        t.Fatalf("GetUserById failed: %v", err)
+    }
+    if user.EmailVerified {
+        t.Fatal("expected a new user to be unverified")
+    }
+
+    if err := store.MarkEmailVerified(userId, "old@example.com"); !errors.Is(err, ErrUserNotFound) {
+        t.Errorf("expected a different email to be refused, got %v", err)
+    }
+
+    if err := store.MarkEmailVerified(userId, "charlie@example.com"); err != nil {
+        t.Fatalf("MarkEmailVerified failed: %v", err)
+    }
+    user, _ = store.GetUserById(userId)
+    if !user.EmailVerified {
+        t.Error("expected user to be verified")
+    }
+}
+
+func checklistTexts(t *testing.T, store *SQLiteStore, id uuid.UUID) []string {
+    t.Helper()
+    items, err := store.GetChecklist(1, id)
+    if err != nil {
+        t.Fatalf("GetChecklist failed: %v", err)
+    }
+    texts := []string{}
+    for i, item := range items {
+        if item.Position != i {
+            t.Errorf("expected %q at position %d, got %d", item.Text, i, item.Position)
+        }
+        texts = append(texts, item.Text)
+    }
+    return texts
+}
+
+func TestChecklist(t *testing.T) {
+    store, id := newOwnershipTestStore(t)
+
+    var ids []int
+    for _, text := range []string{"Wash", "Dry", "Fold", "Put away"} {
+        item, err := store.AddChecklistItem(1, id, text)
+        if err != nil {
+            t.Fatalf("AddChecklistItem failed: %v", err)
+        }
+        ids = append(ids, item.Id)
+    }
+    if got := checklistTexts(t, store, id); strings.Join(got, ",") != "Wash,Dry,Fold,Put away" {
+        t.Fatalf("unexpected checklist %v", got)
+    }
+
+    if err := store.MoveChecklistItem(1, id, ids[3], 0); err != nil {
+        t.Fatalf("MoveChecklistItem failed: %v", err)
+    }
+    if got := checklistTexts(t, store, id); strings.Join(got, ",") != "Put away,Wash,Dry,Fold" {
+        t.Errorf("unexpected checklist after moving up %v", got)
+    }
+
+    if err := store.MoveChecklistItem(1, id, ids[3], 99); err != nil {
+        t.Fatalf("MoveChecklistItem failed: %v", err)
+    }
+    if got := checklistTexts(t, store, id); strings.Join(got, ",") != "Wash,Dry,Fold,Put away" {
+        t.Errorf("unexpected checklist after moving down %v", got)
+    }
+
+    if err := store.DeleteChecklistItem(1, id, ids[1]); err != nil {
+        t.Fatalf("DeleteChecklistItem failed: %v", err)
+    }
+    if got := checklistTexts(t, store, id); strings.Join(got, ",") != "Wash,Fold,Put away" {
+        t.Errorf("unexpected checklist after deleting %v", got)
+    }
+
+    if err := store.SetChecklistItemDone(1, id, ids[0], true); err != nil {
+        t.Fatalf("SetChecklistItemDone failed: %v", err)
+    }
+    task, err := store.GetTaskById(1, id)
+    if err != nil {
+        t.Fatalf("GetTaskById failed: %v", err)
+    }
+    if task.ChecklistDone != 1 || task.ChecklistTotal != 3 {
+        t.Errorf("expected progress 1/3, got %d/%d", task.ChecklistDone, task.ChecklistTotal)
+    }
+
+    if err := store.DeleteTask(1, id); err != nil {
+        t.Fatalf("DeleteTask failed: %v", err)
+    }
+    var left int
+    if err := store.db.QueryRow(`SELECT COUNT(*) FROM checklist_items`).Scan(&left); err != nil {
+        t.Fatalf("failed to count checklist items: %v", err)
+    }
+    if left != 0 {
+        t.Errorf("expected the checklist to be deleted with the task, %d items left", left)
+    }
+}
+
+func TestChecklistOtherUser(t *testing.T) {
+    store, id := newOwnershipTestStore(t)
+
+    item, err := store.AddChecklistItem(1, id, "Mine")
+    if err != nil {
+        t.Fatalf("AddChecklistItem failed: %v", err)
+    }
+
+    if _, err := store.AddChecklistItem(2, id, "Theirs"); !errors.Is(err, ErrTaskNotFound) {
+        t.Errorf("expected ErrTaskNotFound adding to another user's task, got %v", err)
+    }
+    if _, err := store.GetChecklist(2, id); !errors.Is(err, ErrTaskNotFound) {
+        t.Errorf("expected ErrTaskNotFound reading another user's checklist, got %v", err)
+    }
+    if err := store.SetChecklistItemDone(2, id, item.Id, true); !errors.Is(err, ErrTaskNotFound) {
+        t.Errorf("expected ErrTaskNotFound ticking another user's item, got %v", err)
+    }
+    if err := store.DeleteChecklistItem(2, id, item.Id); !errors.Is(err, ErrTaskNotFound) {
+        t.Errorf("expected ErrTaskNotFound deleting another user's item, got %v", err)
+    }
+
+    // An item can't be reached through a different task, even one the user owns.
+    other := app.Task{Id: uuid.New(), UserId: 1, Title: "Other", Due: time.Now().Add(time.Hour)}
+    if err := store.CreateTask(other); err != nil {
+        t.Fatalf("CreateTask failed: %v", err)
+    }
+    if err := store.SetChecklistItemDone(1, other.Id, item.Id, true); !errors.Is(err, ErrChecklistItemNotFound) {
+        t.Errorf("expected ErrChecklistItemNotFound, got %v", err)
+    }
+    if err := store.MoveChecklistItem(1, other.Id, item.Id, 0); !errors.Is(err, ErrChecklistItemNotFound) {
+        t.Errorf("expected ErrChecklistItemNotFound, got %v", err)
+    }
+}
+
+func TestRecurringTaskCopiesChecklist(t *testing.T) {
+    store, _ := newOwnershipTestStore(t)
+
+    task := app.Task{Id: uuid.New(), UserId: 1, Title: "Laundry", Due: time.Now().Add(time.Hour), Recurrence: "FREQ=WEEKLY"}
+    if err := store.CreateTask(task); err != nil {
+        t.Fatalf("CreateTask failed: %v", err)
+    }
+    for _, text := range []string{"Wash", "Dry"} {
+        item, err := store.AddChecklistItem(1, task.Id, text)
+        if err != nil {
+            t.Fatalf("AddChecklistItem failed: %v", err)
+        }
+        if err := store.SetChecklistItemDone(1, task.Id, item.Id, true); err != nil {
+            t.Fatalf("SetChecklistItemDone failed: %v", err)
+        }
+    }
+
+    next, err := store.SetTaskDone(1, task.Id, true)
+    if err != nil {
+        t.Fatalf("SetTaskDone failed: %v", err)
+    }
+    if got := checklistTexts(t, store, next.Id); strings.Join(got, ",") != "Wash,Dry" {
+        t.Errorf("expected the checklist to be copied, got %v", got)
+    }
+    if next.ChecklistTotal != 2 || next.ChecklistDone != 0 {
+        t.Errorf("expected progress 0/2 on the next occurrence, got %d/%d", next.ChecklistDone, next.ChecklistTotal)
+    }
+}
+
+func tagNames(task app.Task) string {
+    names := make([]string, len(task.Tags))
+    for i, tag := range task.Tags {
+        names[i] = tag.Name
+    }
+    return strings.Join(names, ",")
+}
+
+func createTestTag(t *testing.T, store *SQLiteStore, user_id int, name string) app.Tag {
+    t.Helper()
+
+    tag, err := store.CreateTag(app.Tag{UserId: user_id, Name: name, Colour: "#1e90ff"})
+    if err != nil {
+        t.Fatalf("CreateTag failed: %v", err)
+    }
+    return tag
+}
+
+func TestTags(t *testing.T) {
+    store, _ := newOwnershipTestStore(t)
+
+    work := createTestTag(t, store, 1, "work")
+    home := createTestTag(t, store, 1, "Home")
+    if _, err := store.CreateTag(app.Tag{UserId: 1, Name: "WORK", Colour: "#000000"}); !errors.Is(err, ErrTagExists) {
+        t.Errorf("expected ErrTagExists for a name differing only in case, got %v", err)
+    }
+    createTestTag(t, store, 2, "work") // Another user can have a tag of the same name.
+
+    tags, err := store.GetTags(1)
+    if err != nil {
+        t.Fatalf("GetTags failed: %v", err)
+    }
+    if len(tags) != 2 || tags[0].Name != "Home" || tags[1].Name != "work" {
+        t.Errorf("expected Home and work in order of name, got %+v", tags)
+    }
+
+    both := app.Task{Id: uuid.New(), UserId: 1, Title: "Both", Due: time.Now().Add(time.Hour), Tags: []app.Tag{{Id: work.Id}, {Id: home.Id}}}
+    onlyWork := app.Task{Id: uuid.New(), UserId: 1, Title: "Work", Due: time.Now().Add(time.Hour), Tags: []app.Tag{{Id: work.Id}}}
+    for _, task := range []app.Task{both, onlyWork} {
+        if err := store.CreateTask(task); err != nil {
+            t.Fatalf("CreateTask failed: %v", err)
+        }
+    }
+
+    got, err := store.GetTaskById(1, both.Id)
+    if err != nil {
+        t.Fatalf("GetTaskById failed: %v", err)
+    }
+    if tagNames(got) != "Home,work" || got.Tags[0].Colour != "#1e90ff" {
+        t.Errorf("expected the task to have tags Home and work, got %+v", got.Tags)
+    }
+
+    cases := []struct {
+        tags []string
+        want int
+    }{
+        {nil, 3},
+        {[]string{"work"}, 2},
+        {[]string{"HOME"}, 1},
+        {[]string{"work", "home"}, 1},
+        {[]string{"nothing"}, 0},
+    }
+    for _, tc := range cases {
+        tasks, err := store.QueryTasks(1, TaskQuery{Tags: tc.tags})
+        if err != nil {
+            t.Fatalf("QueryTasks failed: %v", err)
+        }
+        if len(tasks) != tc.want {
+            t.Errorf("expected %d tasks tagged %v, got %d", tc.want, tc.tags, len(tasks))
+        }
+    }
+    if tasks, _ := store.QueryTasks(2, TaskQuery{Tags: []string{"work"}}); len(tasks) != 0 {
+        t.Errorf("expected no tasks for the other user's tag, got %d", len(tasks))
+    }
+
+    got.Tags = []app.Tag{{Id: home.Id}}
+    if err := store.UpdateTask(got); err != nil {
+        t.Fatalf("UpdateTask failed: %v", err)
+    }
+    if got, _ := store.GetTaskById(1, both.Id); tagNames(got) != "Home" {
+        t.Errorf("expected UpdateTask to replace the tags, got %q", tagNames(got))
+    }
+
+    if err := store.DeleteTag(1, work.Id); err != nil {
+        t.Fatalf("DeleteTag failed: %v", err)
+    }
+    if got, _ := store.GetTaskById(1, onlyWork.Id); len(got.Tags) != 0 {
+        t.Errorf("expected the deleted tag to be removed from tasks, got %+v", got.Tags)
+    }
+    if err := store.DeleteTag(1, work.Id); !errors.Is(err, ErrTagNotFound) {
+        t.Errorf("expected ErrTagNotFound, got %v", err)
+    }
+
+    if err := store.DeleteTask(1, both.Id); err != nil {
+        t.Fatalf("DeleteTask failed: %v", err)
+    }
+    var links int
+    store.db.QueryRow(`SELECT COUNT(*) FROM task_tags`).Scan(&links)
+    if links != 0 {
+        t.Errorf("expected no task_tags rows left, got %d", links)
+    }
+}
+
+func TestTagsOtherUser(t *testing.T) {
+    store, taskID := newOwnershipTestStore(t)
+    theirs := createTestTag(t, store, 2, "secret")
+
+    task := app.Task{Id: uuid.New(), UserId: 1, Title: "Mine", Due: time.Now().Add(time.Hour), Tags: []app.Tag{{Id: theirs.Id}}}
+    if err := store.CreateTask(task); !errors.Is(err, ErrTagNotFound) {
+        t.Errorf("expected ErrTagNotFound tagging with another user's tag, got %v", err)
+    }
+    if _, err := store.GetTaskById(1, task.Id); !errors.Is(err, ErrTaskNotFound) {
+        t.Errorf("expected the task not to be created, got %v", err)
+    }
+
+    existing, err := store.GetTaskById(1, taskID)
+    if err != nil {
+        t.Fatalf("GetTaskById failed: %v", err)
+    }
+    existing.Tags = []app.Tag{{Id: theirs.Id}}
+    if err := store.UpdateTask(existing); !errors.Is(err, ErrTagNotFound) {
+        t.Errorf("expected ErrTagNotFound, got %v", err)
+    }
+
+    if err := store.DeleteTag(1, theirs.Id); !errors.Is(err, ErrTagNotFound) {
+        t.Errorf("expected ErrTagNotFound deleting another user's tag, got %v", err)
+    }
+}
+
+func TestRecurringTaskCopiesTags(t *testing.T) {
+    store, _ := newOwnershipTestStore(t)
+    tag := createTestTag(t, store, 1, "chores")
+
+    task := app.Task{Id: uuid.New(), UserId: 1, Title: "Bins", Due: time.Now().Add(time.Hour), Recurrence: "FREQ=WEEKLY", Tags: []app.Tag{{Id: tag.Id}}}
+    if err := store.CreateTask(task); err != nil {
+        t.Fatalf("CreateTask failed: %v", err)
+    }
+
+    next, err := store.SetTaskDone(1, task.Id, true)
+    if err != nil {
+        t.Fatalf("SetTaskDone failed: %v", err)
+    }
+    if tagNames(next) != "chores" {
+        t.Errorf("expected the next occurrence to be returned with its tags, got %+v", next.Tags)
+    }
+    if got, _ := store.GetTaskById(1, next.Id); tagNames(got) != "chores" {
+        t.Errorf("expected the tags to be copied, got %+v", got.Tags)
+    }
+}
+
+func TestProjects(t *testing.T) {
+    store, inbox := newOwnershipTestStore(t)
+
+    work, err := store.CreateProject(app.Project{UserId: 1, Name: "Work"})
+    if err != nil {
+        t.Fatalf("CreateProject failed: %v", err)
+    }
+    home, err := store.CreateProject(app.Project{UserId: 1, Name: "Home"})
+    if err != nil {
+        t.Fatalf("CreateProject failed: %v", err)
+    }
+    if _, err := store.CreateProject(app.Project{UserId: 1, Name: "work"}); !errors.Is(err, ErrProjectExists) {
+        t.Errorf("expected ErrProjectExists for a name differing only in case, got %v", err)
+    }
+    if _, err := store.CreateProject(app.Project{UserId: 2, Name: "Work"}); err != nil {
+        t.Errorf("expected another user to be able to use the same name, got %v", err)
+    }
+
+    projects, err := store.GetProjects(1)
+    if err != nil {
+        t.Fatalf("GetProjects failed: %v", err)
+    }
+    if len(projects) != 2 || projects[0].Name != "Home" || projects[1].Name != "Work" {
+        t.Errorf("expected Home and Work in order of name, got %+v", projects)
+    }
+
+    task := app.Task{Id: uuid.New(), UserId: 1, Title: "Report", Due: time.Now().Add(time.Hour), ProjectId: work.Id}
+    if err := store.CreateTask(task); err != nil {
+        t.Fatalf("CreateTask failed: %v", err)
+    }
+    if got, _ := store.GetTaskById(1, task.Id); got.ProjectId != work.Id {
+        t.Errorf("expected the task to be in project %d, got %d", work.Id, got.ProjectId)
+    }
+
+    inWork, err := store.QueryTasks(1, TaskQuery{ProjectId: work.Id})
+    if err != nil {
+        t.Fatalf("QueryTasks failed: %v", err)
+    }
+    if len(inWork) != 1 || inWork[0].Id != task.Id {
+        t.Errorf("expected only the report in Work, got %+v", inWork)
+    }
+    if all, _ := store.QueryTasks(1, TaskQuery{}); len(all) != 2 {
+        t.Errorf("expected 2 tasks in all projects, got %d", len(all))
+    }
+
+    if err := store.MoveTask(1, task.Id, home.Id); err != nil {
+        t.Fatalf("MoveTask failed: %v", err)
+    }
+    if got, _ := store.GetTaskById(1, task.Id); got.ProjectId != home.Id {
+        t.Errorf("expected the task to move to project %d, got %d", home.Id, got.ProjectId)
+    }
+    if err := store.MoveTask(1, inbox, home.Id); err != nil {
+        t.Fatalf("MoveTask failed: %v", err)
+    }
+    if err := store.MoveTask(1, inbox, 0); err != nil {
+        t.Fatalf("MoveTask failed: %v", err)
+    }
+    if got, _ := store.GetTaskById(1, inbox); got.ProjectId != 0 {
+        t.Errorf("expected the task to be taken out of its project, got %d", got.ProjectId)
+    }
+
+    if err := store.RenameProject(1, home.Id, "House"); err != nil {
+        t.Fatalf("RenameProject failed: %v", err)
+    }
+    if err := store.RenameProject(1, home.Id, "HOUSE"); err != nil {
+        t.Errorf("expected renaming a project to its own name in another case to work, got %v", err)
+    }
+    if err := store.RenameProject(1, home.Id, "work"); !errors.Is(err, ErrProjectExists) {
+        t.Errorf("expected ErrProjectExists, got %v", err)
+    }
+    if got, _ := store.GetProject(1, home.Id); got.Name != "HOUSE" {
+        t.Errorf("expected the project to be renamed, got %q", got.Name)
+    }
+
+    if err := store.DeleteProject(1, home.Id); err != nil {
+        t.Fatalf("DeleteProject failed: %v", err)
+    }
+    if _, err := store.GetProject(1, home.Id); !errors.Is(err, ErrProjectNotFound) {
+        t.Errorf("expected ErrProjectNotFound after deleting, got %v", err)
+    }
+    if got, err := store.GetTaskById(1, task.Id); err != nil || got.ProjectId != 0 {
+        t.Errorf("expected the task to be kept in no project, got %d, %v", got.ProjectId, err)
+    }
+}
+
+func TestProjectsOtherUser(t *testing.T) {
+    store, taskID := newOwnershipTestStore(t)
+
+    theirs, err := store.CreateProject(app.Project{UserId: 2, Name: "Secret"})
+    if err != nil {
+        t.Fatalf("CreateProject failed: %v", err)
+    }
+
+    if _, err := store.GetProject(1, theirs.Id); !errors.Is(err, ErrProjectNotFound) {
+        t.Errorf("expected ErrProjectNotFound, got %v", err)
+    }
+    if err := store.RenameProject(1, theirs.Id, "Mine"); !errors.Is(err, ErrProjectNotFound) {
+        t.Errorf("expected ErrProjectNotFound renaming, got %v", err)
+    }
+    if err := store.DeleteProject(1, theirs.Id); !errors.Is(err, ErrProjectNotFound) {
+        t.Errorf("expected ErrProjectNotFound deleting, got %v", err)
+    }
+    if err := store.MoveTask(1, taskID, theirs.Id); !errors.Is(err, ErrProjectNotFound) {
+        t.Errorf("expected ErrProjectNotFound moving into it, got %v", err)
+    }
+    if err := store.MoveTask(2, taskID, theirs.Id); !errors.Is(err, ErrTaskNotFound) {
+        t.Errorf("expected ErrTaskNotFound moving another user's task, got %v", err)
+    }
+
+    task := app.Task{Id: uuid.New(), UserId: 1, Title: "Sneaky", Due: time.Now().Add(time.Hour), ProjectId: theirs.Id}
+    if err := store.CreateTask(task); !errors.Is(err, ErrProjectNotFound) {
+        t.Errorf("expected ErrProjectNotFound creating a task in it, got %v", err)
+    }
+    existing, _ := store.GetTaskById(1, taskID)
+    existing.ProjectId = theirs.Id
+    if err := store.UpdateTask(existing); !errors.Is(err, ErrProjectNotFound) {
+        t.Errorf("expected ErrProjectNotFound updating a task into it, got %v", err)
+    }
+}
+
+func TestRecurringTaskKeepsProject(t *testing.T) {
+    store, _ := newOwnershipTestStore(t)
+
+    project, err := store.CreateProject(app.Project{UserId: 1, Name: "Chores"})
+    if err != nil {
+        t.Fatalf("CreateProject failed: %v", err)
+    }
+    task := app.Task{Id: uuid.New(), UserId: 1, Title: "Bins", Due: time.Now().Add(time.Hour), Recurrence: "FREQ=WEEKLY", ProjectId: project.Id}
+    if err := store.CreateTask(task); err != nil {
+        t.Fatalf("CreateTask failed: %v", err)
+    }
+
+    next, err := store.SetTaskDone(1, task.Id, true)
+    if err != nil {
+        t.Fatalf("SetTaskDone failed: %v", err)
+    }
+    if got, _ := store.GetTaskById(1, next.Id); got.ProjectId != project.Id {
+        t.Errorf("expected the next occurrence to stay in the project, got %d", got.ProjectId)
+    }
+}
+
+func TestQueryTasksOrder(t *testing.T) {
+    store, _ := newOwnershipTestStore(t)
+
+    now := time.Now()
+    tasks := []app.Task{
+        {Id: uuid.New(), UserId: 3, Title: "banana", Due: now.Add(3 * time.Hour), Priority: app.PriorityLow},
+        {Id: uuid.New(), UserId: 3, Title: "Apple", Due: now.Add(2 * time.Hour), Priority: app.PriorityUrgent, Done: 1},
+        {Id: uuid.New(), UserId: 3, Title: "cherry", Due: now.Add(-time.Hour)},
+        {Id: uuid.New(), UserId: 3, Title: "date", Due: now.Add(time.Hour), Priority: app.PriorityUrgent},
+    }
+    for _, task := range tasks {
+        if err := store.CreateTask(task); err != nil {
+            t.Fatalf("CreateTask failed: %v", err)
+        }
+    }
+
+    cases := []struct {
+        order []TaskOrder
+        want  []string
+    }{
+        {nil, []string{"cherry", "date", "Apple", "banana"}},
+        {[]TaskOrder{{By: SortDue, Desc: true}}, []string{"banana", "Apple", "date", "cherry"}},
+        {[]TaskOrder{{By: SortPriority, Desc: true}}, []string{"date", "Apple", "banana", "cherry"}},
+        {[]TaskOrder{{By: SortTitle}}, []string{"Apple", "banana", "cherry", "date"}},
+        {[]TaskOrder{{By: SortStatus}}, []string{"cherry", "date", "banana", "Apple"}},
+        {[]TaskOrder{{By: SortCreated, Desc: true}}, []string{"date", "cherry", "Apple", "banana"}},
+        {[]TaskOrder{{By: SortStatus}, {By: SortTitle, Desc: true}}, []string{"cherry", "date", "banana", "Apple"}},
+    }
+    for _, tc := range cases {
+        got, err := store.QueryTasks(3, TaskQuery{Order: tc.order})
+        if err != nil {
+            t.Fatalf("QueryTasks(%+v) failed: %v", tc.order, err)
+        }
+        var titles []string
+        for _, task := range got {
+            titles = append(titles, task.Title)
+        }
+        if !reflect.DeepEqual(titles, tc.want) {
+            t.Errorf("QueryTasks(%+v) = %v, want %v", tc.order, titles, tc.want)
+        }
+    }
+
+    if _, err := store.QueryTasks(3, TaskQuery{Order: []TaskOrder{{By: "rowid; DROP TABLE tasks"}}}); !errors.Is(err, ErrInvalidTaskSort) {
+        t.Errorf("expected ErrInvalidTaskSort for an unknown sort, got %v", err)
+    }
+
+    got, err := store.GetTaskById(3, tasks[3].Id)
+    if err != nil {
+        t.Fatalf("GetTaskById failed: %v", err)
+    }
+    if got.Priority != app.PriorityUrgent || got.CreatedAt.IsZero() {
+        t.Errorf("expected an urgent task with a creation time, got %+v", got)
+    }
+
+    got.Priority = app.PriorityMedium
+    if err := store.UpdateTask(got); err != nil {
+        t.Fatalf("UpdateTask failed: %v", err)
+    }
+    if got, _ := store.GetTaskById(3, got.Id); got.Priority != app.PriorityMedium {
+        t.Errorf("expected the priority to be updated to medium, got %v", got.Priority)
+    }
+}
+
+func TestSetTimeZone(t *testing.T) {
+    store, _ := newOwnershipTestStore(t)
+    if _, err := store.db.Exec(`INSERT INTO users (id) VALUES (3)`); err != nil {
+        t.Fatalf("failed to add user: %v", err)
+    }
+
+    london, _ := time.LoadLocation("Europe/London")
+    tokyo, _ := time.LoadLocation("Asia/Tokyo")
+    allDay := app.Task{Id: uuid.New(), UserId: 3, Title: "Bins", AllDay: true, Due: app.EndOfDay(time.Date(2030, 7, 1, 0, 0, 0, 0, time.UTC))}
+    timed := app.Task{Id: uuid.New(), UserId: 3, Title: "Call", Due: time.Date(2030, 7, 1, 9, 30, 0, 0, time.UTC)}
+    for _, task := range []app.Task{allDay, timed} {
+        if err := store.CreateTask(task); err != nil {
+            t.Fatalf("CreateTask failed: %v", err)
+        }
+    }
+
+    if err := store.SetTimeZone(3, "Europe/London"); err != nil {
+        t.Fatalf("SetTimeZone failed: %v", err)
+    }
+    got, err := store.GetTaskById(3, allDay.Id)
+    if err != nil {
+        t.Fatalf("GetTaskById failed: %v", err)
+    }
+    if want := app.EndOfDay(time.Date(2030, 7, 1, 0, 0, 0, 0, london)); !got.Due.Equal(want) || got.TimeZone != "Europe/London" {
+        t.Errorf("expected the all-day task to be due at %v in Europe/London, got %v in %q", want, got.Due, got.TimeZone)
+    }
+
+    if err := store.SetTimeZone(3, "Asia/Tokyo"); err != nil {
+        t.Fatalf("SetTimeZone failed: %v", err)
+    }
+    got, _ = store.GetTaskById(3, allDay.Id)
+    if want := app.EndOfDay(time.Date(2030, 7, 1, 0, 0, 0, 0, tokyo)); !got.Due.Equal(want) {
+        t.Errorf("expected the all-day task to stay due on July 1st, at %v, got %v", want, got.Due)
+    }
+    if got, _ := store.GetTaskById(3, timed.Id); !got.Due.Equal(timed.Due) {
+        t.Errorf("expected the timed task to keep its due time %v, got %v", timed.Due, got.Due)
+    }
+
+    if err := store.SetTimeZone(9, "Asia/Tokyo"); !errors.Is(err, ErrUserNotFound) {
+        t.Errorf("expected ErrUserNotFound for an unknown user, got %v", err)
+    }
+}
+
+func TestRecurringTaskFollowsTimeZone(t *testing.T) {
+    store, _ := newOwnershipTestStore(t)
+    if _, err := store.db.Exec(`INSERT INTO users (id, time_zone) VALUES (3, 'America/New_York')`); err != nil {
+        t.Fatalf("failed to add user: %v", err)
+    }
+
+    newYork, _ := time.LoadLocation("America/New_York")
+    // Daylight saving time ends in New York on Sunday the 3rd of November 2030.
+    task := app.Task{
+        Id:         uuid.New(),
+        UserId:     3,
+        Title:      "Standup",
+        Due:        time.Date(2030, 11, 1, 9, 0, 0, 0, newYork),
+        Recurrence: "FREQ=WEEKLY;BYDAY=MO,FR",
+    }
+    if err := store.CreateTask(task); err != nil {
+        t.Fatalf("CreateTask failed: %v", err)
+    }
+
+    next, err := store.SetTaskDone(3, task.Id, true)
+    if err != nil {
+        t.Fatalf("SetTaskDone failed: %v", err)
+    }
+    if want := time.Date(2030, 11, 4, 9, 0, 0, 0, newYork); !next.Due.Equal(want) {
+        t.Errorf("expected the next standup on Monday at 9am in New York, %v, got %v", want.UTC(), next.Due)
+    }
+}
+
+func TestFTSQuery(t *testing.T) {
+    cases := map[string]string{
+        "":                     "",
+        "  milk  eggs ":        `"milk" "eggs"`,
+        "rep*":                 `"rep"*`,
+        `"buy milk" today`:     `"buy milk" "today"`,
+        `"buy mi"*`:            `"buy mi"*`,
+        `"unclosed phrase`:     `"unclosed phrase"`,
+        `title:x OR NOT (y) -`: `"title:x" "OR" "NOT" "(y)"`,
+        `say"hi`:               `"say""hi"`,
+        "* ** \"\"":            "",
+    }
+    for search, want := range cases {
+        if got := ftsQuery(search); got != want {
+            t.Errorf("ftsQuery(%q) = %q, want %q", search, got, want)
+        }
+    }
+}
+
+func TestSearchTasks(t *testing.T) {
+    store, _ := newOwnershipTestStore(t)
+
+    due := time.Now().Add(time.Hour)
+    report := app.Task{Id: uuid.New(), UserId: 3, Title: "Write quarterly report", Description: "Send the report to the finance team by Friday", Due: due}
+    tasks := []app.Task{
+        report,
+        {Id: uuid.New(), UserId: 3, Title: "Buy milk", Description: "Semi-skimmed, from the café", Due: due},
+        {Id: uuid.New(), UserId: 3, Title: "Book dentist", Description: "Report the broken filling", Due: due},
+        {Id: uuid.New(), UserId: 4, Title: "Someone else's report", Due: due},
+    }
+    for _, task := range tasks {
+        if err := store.CreateTask(task); err != nil {
+            t.Fatalf("CreateTask failed: %v", err)
+        }
+    }
+
+    expect := func(query string, want ...string) {
+        t.Helper()
+        matches, err := store.SearchTasks(3, query)
+        if err != nil {
+            t.Fatalf("SearchTasks(%q) failed: %v", query, err)
+        }
+        var titles []string
+        for _, match := range matches {
+            titles = append(titles, match.Task.Title)
+        }
+        if !reflect.DeepEqual(titles, want) {
+            t.Errorf("SearchTasks(%q) = %v, want %v", query, titles, want)
+        }
+    }
+
+    expect("report", "Write quarterly report", "Book dentist")
+    expect("rep* friday", "Write quarterly report")
+    expect(`"finance team"`, "Write quarterly report")
+    expect(`"team finance"`)
+    expect("cafe", "Buy milk")
+    expect("OR")
+    expect("")
+
+    matches, err := store.SearchTasks(3, "quarterly")
+    if err != nil || len(matches) != 1 {
+        t.Fatalf("SearchTasks(quarterly) = %v, %v", matches, err)
+    }
+    if want := "Write " + MatchStart + "quarterly" + MatchEnd + " report"; matches[0].Title != want {
+        t.Errorf("Title = %q, want %q", matches[0].Title, want)
+    }
+    if want := "Send the report to the finance team by Friday"; matches[0].Snippet != want {
+        t.Errorf("Snippet = %q, want %q", matches[0].Snippet, want)
+    }
+
+    report.Title = "Write annual review"
+    if err := store.UpdateTask(report); err != nil {
+        t.Fatalf("UpdateTask failed: %v", err)
+    }
+    expect("quarterly")
+    expect("annual", "Write annual review")
+
+    if err := store.DeleteTask(3, report.Id); err != nil {
+        t.Fatalf("DeleteTask failed: %v", err)
+    }
+    expect("annual")
+}
+
+func TestPageTasks(t *testing.T) {
+    store, _ := newOwnershipTestStore(t)
+
+    now := time.Now()
+    for i := range 8 {
+        task := app.Task{
+            Id:       uuid.New(),
+            UserId:   3,
+            Title:    fmt.Sprintf("task %d", i),
+            Due:      now.Add(time.Duration(i%3) * time.Hour),
+            Priority: app.Priority(i % 4),
+        }
+        if err := store.CreateTask(task); err != nil {
+            t.Fatalf("CreateTask failed: %v", err)
+        }
+    }
+
+    titles := func(tasks []app.Task) []string {
+        var titles []string
+        for _, task := range tasks {
+            titles = append(titles, task.Title)
+        }
+        return titles
+    }
+
+    orders := [][]TaskOrder{
+        nil,
+        {{By: SortPriority, Desc: true}},
+        {{By: SortTitle, Desc: true}},
+        {{By: SortStatus}, {By: SortCreated, Desc: true}},
+    }
+    for _, order := range orders {
+        q := TaskQuery{Order: order}
+        all, err := store.QueryTasks(3, q)
+        if err != nil {
+            t.Fatalf("QueryTasks failed: %v", err)
+        }
+        want := titles(all)
+
+        // Forwards, page by page, to the end.
+        var got []string
+        var pages []TaskPage
+        page := PageQuery{Size: 3}
+        for {
+            p, err := store.PageTasks(3, q, page)
+            if err != nil {
+                t.Fatalf("PageTasks(%+v, %+v) failed: %v", order, page, err)
+            }
+            got = append(got, titles(p.Tasks)...)
+            pages = append(pages, p)
+            if p.Next == "" {
+                break
+            }
+            page = PageQuery{Size: 3, After: p.Next}
+        }
+        if !reflect.DeepEqual(got, want) {
+            t.Errorf("paging forwards by %+v = %v, want %v", order, got, want)
+        }
+        if len(pages) != 3 || pages[0].Prev != "" || pages[2].Prev == "" {
+            t.Errorf("paging forwards by %+v gave %d pages, first Prev %q", order, len(pages), pages[0].Prev)
+        }
+
+        // And back again from the last page.
+        for i := len(pages) - 1; i > 0; i-- {
+            p, err := store.PageTasks(3, q, PageQuery{Size: 3, Before: pages[i].Prev})
+            if err != nil {
+                t.Fatalf("PageTasks before failed: %v", err)
+            }
+            if !reflect.DeepEqual(titles(p.Tasks), titles(pages[i-1].Tasks)) {
+                t.Errorf("paging back by %+v = %v, want %v", order, titles(p.Tasks), titles(pages[i-1].Tasks))
+            }
+            if p.Next == "" || (i == 1) != (p.Prev == "") {
+                t.Errorf("paging back by %+v to page %d: Next %q, Prev %q", order, i-1, p.Next, p.Prev)
+            }
+        }
+    }
+
+    for _, cursor := range []string{"nope!", encodeCursor([]any{1.0}), encodeCursor([]any{1.0, []any{}})} {
+        if _, err := store.PageTasks(3, TaskQuery{}, PageQuery{Size: 3, After: cursor}); err != ErrInvalidCursor {
+            t.Errorf("PageTasks(After: %q) err = %v, want ErrInvalidCursor", cursor, err)
+        }
+    }
+    if _, err := store.PageTasks(3, TaskQuery{}, PageQuery{Size: 3, After: "a", Before: "b"}); err != ErrInvalidCursor {
+        t.Errorf("PageTasks with both cursors err = %v, want ErrInvalidCursor", err)
+    }
+}
+
+func TestQueryTasksFilters(t *testing.T) {
+    store, _ := newOwnershipTestStore(t)
+
+    day := time.Date(2030, 1, 7, 12, 0, 0, 0, time.UTC)
+    tasks := []app.Task{
+        {Id: uuid.New(), UserId: 3, Title: "Pay rent", Due: day},
+        {Id: uuid.New(), UserId: 3, Title: "Pay gas bill", Due: day.AddDate(0, 0, 2), Done: 1},
+        {Id: uuid.New(), UserId: 3, Title: "Renew passport", Due: time.Now().Add(-time.Hour)},
+        {Id: uuid.New(), UserId: 4, Title: "Pay someone else's rent", Due: day},
+    }
+    for _, task := range tasks {
+        if err := store.CreateTask(task); err != nil {
+            t.Fatalf("CreateTask failed: %v", err)
+        }
+    }
+
+    cases := []struct {
+        q    TaskQuery
+        want []string
+    }{
+        {TaskQuery{Status: "overdue"}, []string{"Renew passport"}},
+        {TaskQuery{Status: "pending"}, []string{"Pay rent"}},
+        {TaskQuery{Status: "done"}, []string{"Pay gas bill"}},
+        {TaskQuery{DueFrom: day}, []string{"Pay rent", "Pay gas bill"}},
+        {TaskQuery{DueFrom: day.Add(time.Millisecond)}, []string{"Pay gas bill"}},
+        {TaskQuery{DueFrom: day.AddDate(0, 0, -1), DueTo: day.AddDate(0, 0, 1)}, []string{"Pay rent"}},
+        {TaskQuery{Text: "pay"}, []string{"Pay rent", "Pay gas bill"}},
+        {TaskQuery{Text: "ren*", DueTo: day}, []string{"Renew passport", "Pay rent"}},
+        {TaskQuery{Text: "***"}, nil},
+    }
+    for _, tc := range cases {
+        got, err := store.QueryTasks(3, tc.q)
+        if err != nil {
+            t.Fatalf("QueryTasks(%+v) failed: %v", tc.q, err)
+        }
+        var titles []string
+        for _, task := range got {
+            titles = append(titles, task.Title)
+        }
+        if !reflect.DeepEqual(titles, tc.want) {
+            t.Errorf("QueryTasks(%+v) = %v, want %v", tc.q, titles, tc.want)
+        }
+    }
+
+    if _, err := store.QueryTasks(3, TaskQuery{Status: "someday"}); err != ErrInvalidTaskFilter {
+        t.Errorf("QueryTasks(Status: someday) err = %v, want ErrInvalidTaskFilter", err)
+    }
+}
+
+func TestImportTasks(t *testing.T) {
+    store, _ := newOwnershipTestStore(t)
+
+    due := time.Date(2030, 1, 7, 12, 0, 0, 0, time.UTC)
+    mine := app.Task{Id: uuid.New(), UserId: 3, Title: "Old title", Due: due}
+    theirs := app.Task{Id: uuid.New(), UserId: 4, Title: "Not yours", Due: due}
+    for _, task := range []app.Task{mine, theirs} {
+        if err := store.CreateTask(task); err != nil {
+            t.Fatalf("CreateTask failed: %v", err)
+        }
+    }
+
+    completed := time.Date(2030, 1, 6, 8, 0, 0, 0, time.UTC)
+    imported := []app.Task{
+        {Id: mine.Id, Title: "New title", Due: due.AddDate(0, 0, 1), Done: 1, CompletedAt: completed, Priority: app.PriorityHigh},
+        {Id: theirs.Id, Title: "Copied", Due: due},
+        {Id: uuid.New(), Title: "Brand new", Due: due, AllDay: true},
+    }
+
+    // A preview says what would happen, and saves nothing.
+    updates, err := store.PreviewImport(3, append(imported, imported[2]))
+    if err != nil {
+        t.Fatalf("PreviewImport failed: %v", err)
+    }
+    if want := []bool{true, false, false, true}; !reflect.DeepEqual(updates, want) {
+        t.Errorf("PreviewImport = %v, want %v", updates, want)
+    }
+    if tasks, _ := store.GetAllTasks(3); len(tasks) != 1 {
+        t.Errorf("after a preview, user has %d tasks, want 1", len(tasks))
+    }
+
+    for round := range 2 {
+        result, err := store.ImportTasks(3, imported)
+        if err != nil {
+            t.Fatalf("ImportTasks failed: %v", err)
+        }
+        want := ImportResult{Created: 2, Updated: 1}
+        if round == 1 {
+            want = ImportResult{Updated: 3}
+        }
+        if result != want {
+            t.Errorf("ImportTasks round %d = %+v, want %+v", round, result, want)
+        }
+    }
+
+    got, err := store.GetTaskById(3, mine.Id)
+    if err != nil {
+        t.Fatalf("GetTaskById failed: %v", err)
+    }
+    if got.Title != "New title" || got.Done != 1 || !got.CompletedAt.Equal(completed) || got.Priority != app.PriorityHigh {
+        t.Errorf("updated task = %+v", got)
+    }
+
+    other, err := store.GetTaskById(4, theirs.Id)
+    if err != nil || other.Title != "Not yours" {
+        t.Errorf("other user's task = %+v, %v", other, err)
+    }
+    tasks, err := store.GetAllTasks(3)
+    if err != nil {
+        t.Fatalf("GetAllTasks failed: %v", err)
+    }
+    if len(tasks) != 3 {
+        t.Errorf("user has %d tasks, want 3", len(tasks))
+    }
+}
+
+func TestRestoreTasks(t *testing.T) {
+    store, _ := newOwnershipTestStore(t)
+
+    home, err := store.CreateProject(app.Project{UserId: 3, Name: "Home"})
+    if err != nil {
+        t.Fatalf("CreateProject failed: %v", err)
+    }
+    theirs, err := store.CreateProject(app.Project{UserId: 4, Name: "Theirs"})
+    if err != nil {
+        t.Fatalf("CreateProject failed: %v", err)
+    }
+    old := createTestTag(t, store, 3, "old")
+    createTestTag(t, store, 3, "Work")
+    createTestTag(t, store, 4, "Errands")
+
+    due := time.Date(2030, 1, 7, 12, 0, 0, 0, time.UTC)
+    mine := app.Task{Id: uuid.New(), UserId: 3, Title: "Pay rent", Due: due, ProjectId: home.Id, Tags: []app.Tag{old}}
+    if err := store.CreateTask(mine); err != nil {
+        t.Fatalf("CreateTask failed: %v", err)
+    }
+
+    errands := app.Tag{Name: "Errands", Colour: "#ff0000"}
+    restored := []app.Task{
+        {Id: mine.Id, Title: "Pay rent", Due: due, Tags: []app.Tag{{Name: "work"}, errands}},
+        {Id: uuid.New(), Title: "Water plants", Due: due, ProjectId: home.Id, Tags: []app.Tag{errands}},
+        {Id: uuid.New(), Title: "Not my project", Due: due, ProjectId: theirs.Id},
+    }
+    result, err := store.RestoreTasks(3, restored)
+    if err != nil {
+        t.Fatalf("RestoreTasks failed: %v", err)
+    }
+    if want := (ImportResult{Created: 2, Updated: 1}); result != want {
+        t.Errorf("RestoreTasks = %+v, want %+v", result, want)
+    }
+
+    for _, tc := range []struct {
+        id        uuid.UUID
+        projectId int
+        tags      string
+    }{
+        {mine.Id, 0, "Errands,Work"},
+        {restored[1].Id, home.Id, "Errands"},
+        {restored[2].Id, 0, ""},
+    } {
+        got, err := store.GetTaskById(3, tc.id)
+        if err != nil {
+            t.Fatalf("GetTaskById failed: %v", err)
+        }
+        if got.ProjectId != tc.projectId || tagNames(got) != tc.tags {
+            t.Errorf("restored %q has project %d and tags %q, want %d and %q", got.Title, got.ProjectId, tagNames(got), tc.projectId, tc.tags)
+        }
+    }
+
+    tags, err := store.GetTags(3)
+    if err != nil {
+        t.Fatalf("GetTags failed: %v", err)
+    }
+    if len(tags) != 3 || tags[0].Name != "Errands" || tags[0].Colour != "#ff0000" {
+        t.Errorf("user's tags = %+v, want Errands created alongside old and Work", tags)
+    }
+
+    // An import from elsewhere leaves them be.
+    if _, err := store.ImportTasks(3, []app.Task{{Id: restored[1].Id, Title: "Water the plants", Due: due}}); err != nil {
+        t.Fatalf("ImportTasks failed: %v", err)
+    }
+    got, err := store.GetTaskById(3, restored[1].Id)
+    if err != nil || got.ProjectId != home.Id || tagNames(got) != "Errands" {
+        t.Errorf("imported task = %+v, %v, want its project and tags kept", got, err)
+    }
+}
+
+func TestCalDAVObjects(t *testing.T) {
+    store, aliceTask := newOwnershipTestStore(t)
+    _, err := store.db.Exec(`
+        CREATE TABLE caldav_objects (
+            task_id TEXT PRIMARY KEY,
+            user_id INTEGER NOT NULL,
+            name TEXT NOT NULL,
+            uid TEXT NOT NULL,
+            UNIQUE (user_id, name),
+            UNIQUE (user_id, uid)
+        );
+        CREATE TRIGGER caldav_objects_task_delete AFTER DELETE ON tasks BEGIN
+            DELETE FROM caldav_objects WHERE task_id = old.id;
+        END`)
+    if err != nil {
+        t.Fatalf("failed to create caldav_objects table: %v", err)
+    }
+
+    // Tasks made elsewhere are named by their id.
+    objects, err := store.GetCalDAVObjects(1)
+    if err != nil {
+        t.Fatalf("GetCalDAVObjects failed: %v", err)
+    }
+    if len(objects) != 1 || objects[0].Name != aliceTask.String()+".ics" || objects[0].UID != aliceTask.String() {
+        t.Fatalf("objects = %+v", objects)
+    }
+
+    due := time.Date(2030, 1, 7, 12, 0, 0, 0, time.UTC)
+    put := func(user_id int, name, uid, title string, id uuid.UUID) (CalDAVObject, bool, error) {
+        return store.PutCalDAVObject(user_id, CalDAVObject{Name: name, UID: uid, Task: app.Task{Id: id, Title: title, Due: due}})
+    }
+
+    // A client's own names and UIDs are kept.
+    made, created, err := put(1, "shopping.ics", "shopping@phone", "Buy milk", uuid.New())
+    if err != nil || !created {
+        t.Fatalf("PutCalDAVObject = %v, %v", created, err)
+    }
+    if made.Name != "shopping.ics" || made.UID != "shopping@phone" || made.Task.Title != "Buy milk" {
+        t.Errorf("new object = %+v", made)
+    }
+    got, err := store.GetCalDAVObject(1, "shopping.ics")
+    if err != nil || got.Task.Id != made.Task.Id {
+        t.Errorf("GetCalDAVObject = %+v, %v", got, err)
+    }
+
+    updated, created, err := put(1, "shopping.ics", "shopping@phone", "Buy oat milk", uuid.New())
+    if err != nil || created || updated.Task.Id != made.Task.Id || updated.Task.Title != "Buy oat milk" {
+        t.Errorf("update = %+v, %v, %v", updated, created, err)
+    }
+    updated, created, err = put(1, aliceTask.String()+".ics", aliceTask.String(), "Renamed", uuid.Nil)
+    if err != nil || created || updated.Task.Id != aliceTask || updated.Task.Title != "Renamed" {
+        t.Errorf("update of a task made elsewhere = %+v, %v, %v", updated, created, err)
+    }
+
+    for name, uid := range map[string]string{
+        "shopping.ics": "changed@phone",    // A task's UID can't change,
+        "other.ics":    "shopping@phone",   // nor can two tasks share one,
+        "another.ics":  aliceTask.String(), // even if one is named by its id.
+    } {
+        if _, _, err := put(1, name, uid, "Clash", uuid.New()); !errors.Is(err, ErrUIDConflict) {
+            t.Errorf("put %s with UID %s: expected ErrUIDConflict, got %v", name, uid, err)
+        }
+    }
+
+    // Another user's id isn't reused, and their names and UIDs don't clash.
+    theirs, created, err := put(2, "shopping.ics", "shopping@phone", "Someone else's", made.Task.Id)
+    if err != nil || !created || theirs.Task.Id == made.Task.Id {
+        t.Errorf("other user's object = %+v, %v, %v", theirs, created, err)
+    }
+
+    if err := store.DeleteTask(1, made.Task.Id); err != nil {
+        t.Fatalf("DeleteTask failed: %v", err)
+    }
+    if _, err := store.GetCalDAVObject(1, "shopping.ics"); !errors.Is(err, ErrTaskNotFound) {
+        t.Errorf("expected the deleted task's name to be gone, got %v", err)
+    }
+    if _, created, err := put(1, "again.ics", "shopping@phone", "Buy milk", uuid.New()); err != nil || !created {
+        t.Errorf("expected the deleted task's UID to be free, got %v, %v", created, err)
+    }
+
+    // Ticking off a repeating to-do moves the series on, as the web UI does.
+    rent := CalDAVObject{Name: "rent.ics", UID: "rent@phone", Task: app.Task{Title: "Pay rent", Due: due, Recurrence: "FREQ=MONTHLY"}}
+    if _, _, err := store.PutCalDAVObject(1, rent); err != nil {
+        t.Fatalf("PutCalDAVObject failed: %v", err)
+    }
+    rent.Task.Done = 1
+    if _, _, err := store.PutCalDAVObject(1, rent); err != nil {
+        t.Fatalf("PutCalDAVObject failed: %v", err)
+    }
+    objects, err = store.GetCalDAVObjects(1)
+    if err != nil {
+        t.Fatalf("GetCalDAVObjects failed: %v", err)
+    }
+    var next []app.Task
+    for _, o := range objects {
+        if o.Task.Title == "Pay rent" && o.Task.Done == 0 {
+            next = append(next, o.Task)
+        }
+    }
+    if len(next) != 1 || !next[0].Due.Equal(due.AddDate(0, 1, 0)) || next[0].Recurrence != "FREQ=MONTHLY" {
+        t.Errorf("next occurrences = %+v", next)
+    }
+}
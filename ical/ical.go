@@ -0,0 +1,497 @@
+// Package ical reads and writes tasks as iCalendar (RFC 5545) to-dos: a VCALENDAR of VTODO components, one per task, as calendar apps import and export them. Tasks can also be written as events, for calendar apps that only show those.
+package ical
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/google/uuid"
+
+	"penumbra/app"
+)
+
+// ProdId identifies this app as the maker of the calendars it writes.
+const ProdId = "-//Penumbra//Tasks//EN"
+
+// uidNamespace turns UIDs that aren't UUIDs, as other apps make them, into task ids, the same one each time, so that importing a calendar twice updates the tasks it made the first time.
+var uidNamespace = uuid.MustParse("6f1a7c52-3b0e-4d8e-9a51-2f4a0c9d7e13")
+
+const (
+    dateLayout     = "20060102"
+    dateTimeLayout = "20060102T150405"
+    utcLayout      = "20060102T150405Z"
+    maxLineOctets  = 75 // Longer lines are folded, per section 3.1.
+)
+
+// Priorities map to iCalendar's scale, on which 1 is the highest, 9 the lowest, and 0 means none. Reading, 2 to 4 count as high and 6 to 8 as low.
+var priorityValues = map[app.Priority]int{
+    app.PriorityUrgent: 1,
+    app.PriorityHigh:   3,
+    app.PriorityMedium: 5,
+    app.PriorityLow:    9,
+}
+
+// ErrNotCalendar is returned by Decode for input that isn't an iCalendar object.
+var ErrNotCalendar = errors.New("not an iCalendar file")
+
+// Todo is a to-do as a calendar has it: the task it stands for, and its UID as written, which may not be the task's id.
+type Todo struct {
+    UID  string // Empty if the to-do had none.
+    Task app.Task
+}
+
+// Encode writes the tasks as a calendar of to-dos. Each task's UID is its id. Tasks due by the end of a day are due on that date, in the task's time zone; the rest are due at a time, in UTC. now is the calendar's DTSTAMP.
+func Encode(w io.Writer, tasks []app.Task, now time.Time) error {
+    todos := make([]Todo, len(tasks))
+    for i, t := range tasks {
+        todos[i] = Todo{UID: t.Id.String(), Task: t}
+    }
+    return EncodeTodos(w, todos, now)
+}
+
+// EncodeTodos writes the to-dos as Encode does, each with its own UID.
+func EncodeTodos(w io.Writer, todos []Todo, now time.Time) error {
+    e := newEncoder(w)
+    for _, todo := range todos {
+        e.component("VTODO", todoLines(todo, now))
+    }
+    return e.close()
+}
+
+// EncodeEvents writes the tasks as a calendar of events, for calendar apps that don't show to-dos. Each task is an event at the time it's due, or lasting the day it's due on, that doesn't count as busy time. now is the calendar's DTSTAMP.
+func EncodeEvents(w io.Writer, tasks []app.Task, now time.Time) error {
+    e := newEncoder(w)
+    for _, t := range tasks {
+        e.component("VEVENT", eventLines(t, now))
+    }
+    return e.close()
+}
+
+// Properties returns the value of each property that EncodeTodos writes for the to-do, by name, with text unescaped; a property it leaves out has none. A CalDAV server matches queries against them.
+func Properties(todo Todo, now time.Time) map[string]string {
+    props := map[string]string{}
+    for _, l := range todoLines(todo, now) {
+        name, _, _ := strings.Cut(l.name, ";")
+        props[name] = unescapeText(l.value)
+    }
+    return props
+}
+
+// contentLine is a line to write: a property's name, with any parameters, and its value, escaped.
+type contentLine struct {
+    name, value string
+}
+
+func todoLines(todo Todo, now time.Time) []contentLine {
+    t := todo.Task
+    lines := commonLines(todo.UID, t, now)
+    due := dueLine(t)
+    if t.Recurrence != "" {
+        // A rule repeats from DTSTART, which for a task is when it's first due.
+        lines = append(lines, contentLine{"DTSTART" + strings.TrimPrefix(due.name, "DUE"), due.value})
+    }
+    lines = append(lines, due)
+    if t.Recurrence != "" {
+        lines = append(lines, contentLine{"RRULE", t.Recurrence})
+    }
+    if p, ok := priorityValues[t.Priority]; ok {
+        lines = append(lines, contentLine{"PRIORITY", strconv.Itoa(p)})
+    }
+    if t.Done == 1 {
+        lines = append(lines, contentLine{"STATUS", "COMPLETED"})
+        if !t.CompletedAt.IsZero() {
+            lines = append(lines, contentLine{"COMPLETED", t.CompletedAt.UTC().Format(utcLayout)})
+        }
+    } else {
+        lines = append(lines, contentLine{"STATUS", "NEEDS-ACTION"})
+    }
+    return lines
+}
+
+func eventLines(t app.Task, now time.Time) []contentLine {
+    lines := commonLines(t.Id.String(), t, now)
+    if t.AllDay {
+        day := t.Due.In(app.Location(t.TimeZone))
+        lines = append(lines,
+            contentLine{"DTSTART;VALUE=DATE", day.Format(dateLayout)},
+            contentLine{"DTEND;VALUE=DATE", day.AddDate(0, 0, 1).Format(dateLayout)},
+        )
+    } else {
+        lines = append(lines, contentLine{"DTSTART", t.Due.UTC().Format(utcLayout)})
+    }
+    if t.Recurrence != "" {
+        lines = append(lines, contentLine{"RRULE", t.Recurrence})
+    }
+    if p, ok := priorityValues[t.Priority]; ok {
+        lines = append(lines, contentLine{"PRIORITY", strconv.Itoa(p)})
+    }
+    return append(lines, contentLine{"TRANSP", "TRANSPARENT"})
+}
+
+// commonLines are the properties that to-dos and events of a task share.
+func commonLines(uid string, t app.Task, now time.Time) []contentLine {
+    lines := []contentLine{
+        {"UID", escapeText(uid)},
+        {"DTSTAMP", now.UTC().Format(utcLayout)},
+    }
+    if !t.CreatedAt.IsZero() {
+        lines = append(lines, contentLine{"CREATED", t.CreatedAt.UTC().Format(utcLayout)})
+    }
+    lines = append(lines, contentLine{"SUMMARY", escapeText(t.Title)})
+    if t.Description != "" {
+        lines = append(lines, contentLine{"DESCRIPTION", escapeText(t.Description)})
+    }
+    return lines
+}
+
+// dueLine is the DUE property of the task.
+func dueLine(t app.Task) contentLine {
+    if t.AllDay {
+        return contentLine{"DUE;VALUE=DATE", t.Due.In(app.Location(t.TimeZone)).Format(dateLayout)}
+    }
+    return contentLine{"DUE", t.Due.UTC().Format(utcLayout)}
+}
+
+type encoder struct {
+    w   *bufio.Writer
+    err error
+}
+
+// newEncoder starts a calendar.
+func newEncoder(w io.Writer) *encoder {
+    e := &encoder{w: bufio.NewWriter(w)}
+    e.line("BEGIN", "VCALENDAR")
+    e.line("VERSION", "2.0")
+    e.line("PRODID", ProdId)
+    e.line("CALSCALE", "GREGORIAN")
+    return e
+}
+
+// component writes a component with the lines.
+func (e *encoder) component(name string, lines []contentLine) {
+    e.line("BEGIN", name)
+    for _, l := range lines {
+        e.line(l.name, l.value)
+    }
+    e.line("END", name)
+}
+
+// close ends the calendar and flushes it.
+func (e *encoder) close() error {
+    e.line("END", "VCALENDAR")
+    if e.err != nil {
+        return e.err
+    }
+    return e.w.Flush()
+}
+
+// line writes a content line, folded so that no line is longer than 75 octets, without splitting a UTF-8 sequence.
+func (e *encoder) line(name, value string) {
+    if e.err != nil {
+        return
+    }
+    line := name + ":" + value
+    limit := maxLineOctets
+    for len(line) > limit {
+        cut := limit
+        for cut > 1 && !utf8.RuneStart(line[cut]) {
+            cut--
+        }
+        _, e.err = e.w.WriteString(line[:cut] + "\r\n ")
+        line = line[cut:]
+        limit = maxLineOctets - 1 // The leading space counts.
+    }
+    if e.err == nil {
+        _, e.err = e.w.WriteString(line + "\r\n")
+    }
+}
+
+// escapeText escapes a TEXT value, per section 3.3.11.
+func escapeText(s string) string {
+    return strings.NewReplacer(`\`, `\\`, `;`, `\;`, `,`, `\,`, "\r\n", `\n`, "\n", `\n`).Replace(s)
+}
+
+func unescapeText(s string) string {
+    var b strings.Builder
+    for i := 0; i < len(s); i++ {
+        if s[i] != '\\' || i == len(s)-1 {
+            b.WriteByte(s[i])
+            continue
+        }
+        i++
+        switch s[i] {
+        case 'n', 'N':
+            b.WriteByte('\n')
+        default:
+            b.WriteByte(s[i])
+        }
+    }
+    return b.String()
+}
+
+// property is a content line: a name, its parameters, and its value.
+type property struct {
+    name   string
+    params map[string]string
+    value  string
+}
+
+// Decode reads the to-dos of a calendar as tasks. Other components, such as events, and the alarms inside to-dos are skipped. A UID that's a UUID becomes the task's id, and any other UID is turned into one. Times with no zone, and dates, are taken to be in loc; a date means the task is due by the end of that day. Anything Decode can't make sense of is returned as an error, naming its line.
+//
+// Tasks are returned as they were read, without being checked: a to-do with no due date has a zero Due, and one with no summary an empty Title.
+func Decode(r io.Reader, loc *time.Location) ([]app.Task, error) {
+    todos, err := DecodeTodos(r, loc)
+    if err != nil {
+        return nil, err
+    }
+    tasks := make([]app.Task, len(todos))
+    for i, todo := range todos {
+        tasks[i] = todo.Task
+    }
+    return tasks, nil
+}
+
+// DecodeTodos reads the to-dos of a calendar as Decode does, keeping their UIDs as written.
+func DecodeTodos(r io.Reader, loc *time.Location) ([]Todo, error) {
+    lines, err := unfold(r)
+    if err != nil {
+        return nil, err
+    }
+
+    var todos []Todo
+    var stack []string // The components the current line is in, outermost first.
+    var todo *Todo
+    started := false
+    for _, line := range lines {
+        if line.text == "" {
+            continue
+        }
+        prop, err := parseLine(line.text)
+        if !started && (err != nil || prop.name != "BEGIN" || !strings.EqualFold(prop.value, "VCALENDAR")) {
+            return nil, ErrNotCalendar
+        }
+        started = true
+        if err != nil {
+            return nil, fmt.Errorf("line %d: %w", line.number, err)
+        }
+
+        switch prop.name {
+        case "BEGIN":
+            component := strings.ToUpper(prop.value)
+            stack = append(stack, component)
+            if component == "VTODO" && len(stack) == 2 {
+                todo = &Todo{}
+            }
+            continue
+        case "END":
+            component := strings.ToUpper(prop.value)
+            if len(stack) == 0 || stack[len(stack)-1] != component {
+                return nil, fmt.Errorf("line %d: END:%s doesn't close %s", line.number, prop.value, strings.Join(stack, "/"))
+            }
+            stack = stack[:len(stack)-1]
+            if component == "VTODO" && todo != nil && len(stack) == 1 {
+                if todo.Task.Id == uuid.Nil {
+                    // Every to-do should have a UID, but one without can only be new.
+                    todo.Task.Id = uuid.New()
+                }
+                todos = append(todos, *todo)
+                todo = nil
+            }
+            continue
+        }
+
+        // Only the to-do's own properties count, not those of alarms inside it.
+        if todo == nil || len(stack) != 2 {
+            continue
+        }
+        if err := setProperty(todo, prop, loc); err != nil {
+            return nil, fmt.Errorf("line %d: %s: %w", line.number, prop.name, err)
+        }
+    }
+    if !started {
+        return nil, ErrNotCalendar
+    }
+    if len(stack) != 0 {
+        return nil, fmt.Errorf("%s isn't closed", strings.Join(stack, "/"))
+    }
+    return todos, nil
+}
+
+// setProperty copies what a property of a to-do says onto it.
+func setProperty(todo *Todo, prop property, loc *time.Location) error {
+    t := &todo.Task
+    switch prop.name {
+    case "UID":
+        todo.UID = unescapeText(prop.value)
+        t.Id = TaskId(todo.UID)
+    case "SUMMARY":
+        t.Title = unescapeText(prop.value)
+    case "DESCRIPTION":
+        t.Description = unescapeText(prop.value)
+    case "DUE":
+        due, allDay, err := parseTime(prop, loc)
+        if err != nil {
+            return err
+        }
+        t.Due, t.AllDay = due, allDay
+    case "COMPLETED":
+        completed, _, err := parseTime(prop, time.UTC)
+        if err != nil {
+            return err
+        }
+        t.CompletedAt = completed
+        t.Done = 1
+    case "CREATED":
+        created, _, err := parseTime(prop, time.UTC)
+        if err != nil {
+            return err
+        }
+        t.CreatedAt = created
+    case "STATUS":
+        // A cancelled to-do is as finished with as a completed one.
+        switch strings.ToUpper(prop.value) {
+        case "COMPLETED", "CANCELLED":
+            t.Done = 1
+        default:
+            t.Done = 0
+            t.CompletedAt = time.Time{}
+        }
+    case "PRIORITY":
+        p, err := strconv.Atoi(prop.value)
+        if err != nil || p < 0 || p > 9 {
+            return fmt.Errorf("%q isn't a priority from 0 to 9", prop.value)
+        }
+        switch {
+        case p == 0:
+            t.Priority = app.PriorityNone
+        case p == 1:
+            t.Priority = app.PriorityUrgent
+        case p < 5:
+            t.Priority = app.PriorityHigh
+        case p == 5:
+            t.Priority = app.PriorityMedium
+        default:
+            t.Priority = app.PriorityLow
+        }
+    case "RRULE":
+        t.Recurrence = prop.value
+    }
+    return nil
+}
+
+// TaskId is the id of the task made from a to-do with the UID.
+func TaskId(uid string) uuid.UUID {
+    if id, err := uuid.Parse(uid); err == nil {
+        return id
+    }
+    return uuid.NewSHA1(uidNamespace, []byte(uid))
+}
+
+// parseTime reads a DATE or DATE-TIME value, in UTC. Dates, and times with no zone, are in loc, unless their TZID is an IANA zone name; other apps' own zone names are ignored. A date stands for the end of that day, and parseTime reports whether the value was one.
+func parseTime(prop property, loc *time.Location) (time.Time, bool, error) {
+    if tzid := strings.TrimPrefix(prop.params["TZID"], "/"); tzid != "" && tzid != "Local" {
+        if zone, err := time.LoadLocation(tzid); err == nil {
+            loc = zone
+        }
+    }
+
+    value := prop.value
+    switch {
+    case strings.EqualFold(prop.params["VALUE"], "DATE") || len(value) == len(dateLayout):
+        day, err := time.ParseInLocation(dateLayout, value, loc)
+        if err != nil {
+            return time.Time{}, false, fmt.Errorf("%q isn't a date", value)
+        }
+        return app.EndOfDay(day).UTC(), true, nil
+    case strings.HasSuffix(value, "Z"):
+        t, err := time.Parse(utcLayout, value)
+        if err != nil {
+            return time.Time{}, false, fmt.Errorf("%q isn't a time", value)
+        }
+        return t, false, nil
+    default:
+        t, err := time.ParseInLocation(dateTimeLayout, value, loc)
+        if err != nil {
+            return time.Time{}, false, fmt.Errorf("%q isn't a time", value)
+        }
+        return t.UTC(), false, nil
+    }
+}
+
+// numberedLine is an unfolded content line, with the number of the line it started on.
+type numberedLine struct {
+    number int
+    text   string
+}
+
+// unfold reads the content lines, joining lines that start with a space or tab onto the one before, per section 3.1.
+func unfold(r io.Reader) ([]numberedLine, error) {
+    scanner := bufio.NewScanner(r)
+    scanner.Buffer(make([]byte, 0, 64*1024), 1024*1024)
+    var lines []numberedLine
+    for n := 1; scanner.Scan(); n++ {
+        text := strings.TrimSuffix(scanner.Text(), "\r")
+        if n == 1 {
+            text = strings.TrimPrefix(text, "\uFEFF") // A byte order mark.
+        }
+        if (strings.HasPrefix(text, " ") || strings.HasPrefix(text, "\t")) && len(lines) > 0 {
+            lines[len(lines)-1].text += text[1:]
+            continue
+        }
+        lines = append(lines, numberedLine{n, text})
+    }
+    if err := scanner.Err(); err != nil {
+        return nil, err
+    }
+    return lines, nil
+}
+
+// parseLine splits a content line into its name, parameters, and value. Names and parameter names are case-insensitive, so they're upper-cased; parameter values may be quoted, to hold `;`, `:`, or `,`.
+func parseLine(line string) (property, error) {
+    prop := property{params: map[string]string{}}
+
+    end := strings.IndexAny(line, ";:")
+    if end <= 0 {
+        return prop, errors.New("not a content line")
+    }
+    prop.name = strings.ToUpper(line[:end])
+    rest := line[end:]
+
+    for strings.HasPrefix(rest, ";") {
+        rest = rest[1:]
+        eq := strings.IndexByte(rest, '=')
+        if eq <= 0 {
+            return prop, errors.New("parameter has no value")
+        }
+        name := strings.ToUpper(rest[:eq])
+        rest = rest[eq+1:]
+
+        var value string
+        if strings.HasPrefix(rest, `"`) {
+            closing := strings.IndexByte(rest[1:], '"')
+            if closing < 0 {
+                return prop, errors.New("parameter value has no closing quote")
+            }
+            value, rest = rest[1:closing+1], rest[closing+2:]
+        } else {
+            end := strings.IndexAny(rest, ";:")
+            if end < 0 {
+                return prop, errors.New("not a content line")
+            }
+            value, rest = rest[:end], rest[end:]
+        }
+        prop.params[name] = value
+    }
+
+    if !strings.HasPrefix(rest, ":") {
+        return prop, errors.New("not a content line")
+    }
+    prop.value = rest[1:]
+    return prop, nil
+}
@@ -0,0 +1,206 @@
+package ical
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+
+	"penumbra/app"
+)
+
+func TestRoundTrip(t *testing.T) {
+    newYork, _ := time.LoadLocation("America/New_York")
+    tasks := []app.Task{
+        {
+            Id:          uuid.New(),
+            Title:       "Write report; then, send it",
+            Description: "Sections:\n1. Costs\\Benefits\n2. Next steps — " + strings.Repeat("très long ", 20),
+            Due:         time.Date(2030, 1, 7, 14, 30, 0, 0, time.UTC),
+            Priority:    app.PriorityHigh,
+            CreatedAt:   time.Date(2029, 12, 1, 9, 0, 0, 0, time.UTC),
+        },
+        {
+            Id:          uuid.New(),
+            Title:       "Water plants",
+            Due:         app.EndOfDay(time.Date(2030, 1, 8, 0, 0, 0, 0, newYork)).UTC(),
+            AllDay:      true,
+            TimeZone:    "America/New_York",
+            Recurrence:  "FREQ=WEEKLY;BYDAY=TU,FR",
+            Priority:    app.PriorityUrgent,
+        },
+        {
+            Id:          uuid.New(),
+            Title:       "Renew passport",
+            Due:         time.Date(2029, 11, 2, 8, 0, 0, 0, time.UTC),
+            Done:        1,
+            CompletedAt: time.Date(2029, 11, 1, 17, 45, 12, 0, time.UTC),
+            Priority:    app.PriorityLow,
+        },
+    }
+
+    var buf bytes.Buffer
+    if err := Encode(&buf, tasks, time.Date(2030, 1, 1, 0, 0, 0, 0, time.UTC)); err != nil {
+        t.Fatalf("Encode failed: %v", err)
+    }
+    for _, line := range strings.Split(strings.TrimSuffix(buf.String(), "\r\n"), "\r\n") {
+        assert.LessOrEqual(t, len(line), 75, line)
+    }
+    assert.Contains(t, buf.String(), "DUE;VALUE=DATE:20300108\r\n")
+    assert.Contains(t, buf.String(), "DTSTART;VALUE=DATE:20300108\r\n")
+    assert.Contains(t, buf.String(), "SUMMARY:Write report\\; then\\, send it\r\n")
+
+    got, err := Decode(&buf, newYork)
+    if err != nil {
+        t.Fatalf("Decode failed: %v", err)
+    }
+    if assert.Len(t, got, len(tasks)) {
+        for i := range tasks {
+            want := tasks[i]
+            want.TimeZone = ""
+            assert.Equal(t, want, got[i])
+        }
+    }
+}
+
+func TestEncodeEvents(t *testing.T) {
+    newYork, _ := time.LoadLocation("America/New_York")
+    tasks := []app.Task{
+        {Id: uuid.New(), Title: "Call the bank", Due: time.Date(2030, 1, 7, 14, 30, 0, 0, time.UTC)},
+        {
+            Id:         uuid.New(),
+            Title:      "Water plants",
+            Due:        app.EndOfDay(time.Date(2030, 1, 31, 0, 0, 0, 0, newYork)).UTC(),
+            AllDay:     true,
+            TimeZone:   "America/New_York",
+            Recurrence: "FREQ=WEEKLY",
+        },
+    }
+
+    var buf bytes.Buffer
+    if err := EncodeEvents(&buf, tasks, time.Date(2030, 1, 1, 0, 0, 0, 0, time.UTC)); err != nil {
+        t.Fatalf("EncodeEvents failed: %v", err)
+    }
+    calendar := buf.String()
+    assert.Equal(t, 2, strings.Count(calendar, "BEGIN:VEVENT\r\n"))
+    assert.NotContains(t, calendar, "VTODO")
+    assert.Contains(t, calendar, "UID:"+tasks[0].Id.String()+"\r\nDTSTAMP:20300101T000000Z\r\nSUMMARY:Call the bank\r\nDTSTART:20300107T143000Z\r\nTRANSP:TRANSPARENT\r\n")
+    assert.Contains(t, calendar, "DTSTART;VALUE=DATE:20300131\r\nDTEND;VALUE=DATE:20300201\r\nRRULE:FREQ=WEEKLY\r\n")
+
+    // Events aren't to-dos, so there's nothing to import back.
+    got, err := Decode(&buf, time.UTC)
+    assert.NoError(t, err)
+    assert.Empty(t, got)
+}
+
+func TestDecodeFromOtherApps(t *testing.T) {
+    calendar := strings.Join([]string{
+        "BEGIN:VCALENDAR",
+        "VERSION:2.0",
+        "PRODID:-//Example//Reminders//EN",
+        "BEGIN:VTIMEZONE",
+        "TZID:Custom Zone",
+        "END:VTIMEZONE",
+        "BEGIN:VEVENT",
+        "UID:event-1",
+        "SUMMARY:Not a task",
+        "END:VEVENT",
+        "BEGIN:VTODO",
+        "UID:reminder-42@example.com",
+        "SUMMARY:Call the",
+        "  plumber",
+        "DESCRIPTION;LANGUAGE=en:Ask about\\nthe boiler\\, too",
+        "DUE;TZID=\"Europe/London\":20300107T090000",
+        "PRIORITY:4",
+        "BEGIN:VALARM",
+        "ACTION:DISPLAY",
+        "DESCRIPTION:Alarm text",
+        "TRIGGER:-PT15M",
+        "END:VALARM",
+        "END:VTODO",
+        "BEGIN:VTODO",
+        "UID:reminder-43@example.com",
+        "SUMMARY:Floating",
+        "DUE:20300107T090000",
+        "STATUS:CANCELLED",
+        "END:VTODO",
+        "BEGIN:VTODO",
+        "UID:reminder-44@example.com",
+        "SUMMARY:Unknown zone",
+        "DUE;TZID=Custom Zone:20300107T090000",
+        "END:VTODO",
+        "BEGIN:VTODO",
+        "SUMMARY:No due date or UID",
+        "END:VTODO",
+        "END:VCALENDAR",
+        "",
+    }, "\r\n")
+
+    tokyo, _ := time.LoadLocation("Asia/Tokyo")
+    tasks, err := Decode(strings.NewReader(calendar), tokyo)
+    if err != nil {
+        t.Fatalf("Decode failed: %v", err)
+    }
+    if !assert.Len(t, tasks, 4) {
+        return
+    }
+
+    assert.Equal(t, TaskId("reminder-42@example.com"), tasks[0].Id)
+    assert.Equal(t, "Call the plumber", tasks[0].Title)
+    assert.Equal(t, "Ask about\nthe boiler, too", tasks[0].Description)
+    assert.Equal(t, time.Date(2030, 1, 7, 9, 0, 0, 0, time.UTC), tasks[0].Due)
+    assert.Equal(t, app.PriorityHigh, tasks[0].Priority)
+
+    assert.Equal(t, time.Date(2030, 1, 7, 0, 0, 0, 0, time.UTC), tasks[1].Due)
+    assert.Equal(t, 1, tasks[1].Done)
+    assert.Equal(t, time.Date(2030, 1, 7, 0, 0, 0, 0, time.UTC), tasks[2].Due)
+
+    assert.NotEqual(t, uuid.Nil, tasks[3].Id)
+    assert.True(t, tasks[3].Due.IsZero())
+
+    // The same UID always makes the same id.
+    assert.Equal(t, tasks[0].Id, TaskId("reminder-42@example.com"))
+    id := uuid.New()
+    assert.Equal(t, id, TaskId(id.String()))
+}
+
+func TestTodosKeepTheirUIDs(t *testing.T) {
+    task := app.Task{Id: TaskId("milk;1@phone"), Title: "Buy milk", Due: time.Date(2030, 1, 7, 9, 0, 0, 0, time.UTC)}
+
+    var buf bytes.Buffer
+    if err := EncodeTodos(&buf, []Todo{{UID: "milk;1@phone", Task: task}}, time.Date(2030, 1, 1, 0, 0, 0, 0, time.UTC)); err != nil {
+        t.Fatalf("EncodeTodos failed: %v", err)
+    }
+    assert.Contains(t, buf.String(), "UID:milk\\;1@phone\r\n")
+
+    todos, err := DecodeTodos(&buf, time.UTC)
+    if err != nil {
+        t.Fatalf("DecodeTodos failed: %v", err)
+    }
+    assert.Equal(t, []Todo{{UID: "milk;1@phone", Task: task}}, todos)
+
+    props := Properties(todos[0], time.Date(2030, 1, 1, 0, 0, 0, 0, time.UTC))
+    assert.Equal(t, "milk;1@phone", props["UID"])
+    assert.Equal(t, "20300107T090000Z", props["DUE"])
+    assert.Equal(t, "NEEDS-ACTION", props["STATUS"])
+    assert.NotContains(t, props, "COMPLETED")
+}
+
+func TestDecodeRejects(t *testing.T) {
+    cases := map[string]string{
+        "empty":        "",
+        "not ical":     "id,title\n1,Milk\n",
+        "unclosed":     "BEGIN:VCALENDAR\r\nBEGIN:VTODO\r\nSUMMARY:x\r\n",
+        "mismatched":   "BEGIN:VCALENDAR\r\nBEGIN:VTODO\r\nEND:VEVENT\r\nEND:VCALENDAR\r\n",
+        "bad due":      "BEGIN:VCALENDAR\r\nBEGIN:VTODO\r\nDUE:tomorrow\r\nEND:VTODO\r\nEND:VCALENDAR\r\n",
+        "bad priority": "BEGIN:VCALENDAR\r\nBEGIN:VTODO\r\nPRIORITY:10\r\nEND:VTODO\r\nEND:VCALENDAR\r\n",
+        "no colon":     "BEGIN:VCALENDAR\r\nBEGIN:VTODO\r\nSUMMARY\r\nEND:VTODO\r\nEND:VCALENDAR\r\n",
+    }
+    for name, calendar := range cases {
+        _, err := Decode(strings.NewReader(calendar), time.UTC)
+        assert.Error(t, err, name)
+    }
+}
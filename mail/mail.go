@@ -0,0 +1,100 @@
+// Package mail sends the app's emails, such as password reset links. Handlers depend only on the Mailer interface, so they can be given an SMTPMailer in production, a LogMailer for local development, or a MemoryMailer in tests.
+package mail
+
+import (
+	"fmt"
+	"log"
+	"mime"
+	"net"
+	"net/smtp"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Message is a plain-text email.
+type Message struct {
+    To      string
+    Subject string
+    Body    string
+}
+
+type Mailer interface {
+    Send(msg Message) error
+}
+
+// SMTPMailer sends messages through an SMTP server, authenticating with PLAIN auth if a username is given.
+type SMTPMailer struct {
+    addr string // host:port
+    from string
+    auth smtp.Auth
+}
+
+func NewSMTPMailer(addr, from, username, password string) (*SMTPMailer, error) {
+    host, _, err := net.SplitHostPort(addr)
+    if err != nil {
+        return nil, fmt.Errorf("invalid SMTP address %q: %w", addr, err)
+    }
+
+    m := &SMTPMailer{addr: addr, from: from}
+    if username != "" {
+        m.auth = smtp.PlainAuth("", username, password, host)
+    }
+    return m, nil
+}
+
+func (m *SMTPMailer) Send(msg Message) error {
+    return smtp.SendMail(m.addr, m.auth, m.from, []string{msg.To}, format(m.from, msg, time.Now()))
+}
+
+// format renders the message as RFC 5322 text with CRLF line endings, as SMTP expects.
+func format(from string, msg Message, date time.Time) []byte {
+    var b strings.Builder
+    header := func(name, value string) {
+        b.WriteString(name + ": " + value + "\r\n")
+    }
+    header("From", stripNewlines(from))
+    header("To", stripNewlines(msg.To))
+    header("Subject", mime.QEncoding.Encode("utf-8", stripNewlines(msg.Subject)))
+    header("Date", date.Format(time.RFC1123Z))
+    header("MIME-Version", "1.0")
+    header("Content-Type", `text/plain; charset="utf-8"`)
+    b.WriteString("\r\n")
+    b.WriteString(strings.ReplaceAll(strings.ReplaceAll(msg.Body, "\r\n", "\n"), "\n", "\r\n"))
+    return []byte(b.String())
+}
+
+// stripNewlines keeps user-supplied values from injecting extra headers.
+func stripNewlines(s string) string {
+    return strings.NewReplacer("\r", "", "\n", "").Replace(s)
+}
+
+// LogMailer writes messages to the log instead of sending them, for local development.
+type LogMailer struct{}
+
+func (LogMailer) Send(msg Message) error {
+    log.Printf("Email to %s\nSubject: %s\n\n%s", msg.To, msg.Subject, msg.Body)
+    return nil
+}
+
+// MemoryMailer keeps messages in memory so tests can inspect what would have been sent.
+type MemoryMailer struct {
+    mu       sync.Mutex
+    messages []Message
+}
+
+func (m *MemoryMailer) Send(msg Message) error {
+    m.mu.Lock()
+    defer m.mu.Unlock()
+
+    m.messages = append(m.messages, msg)
+    return nil
+}
+
+// Messages returns a copy of the messages sent so far.
+func (m *MemoryMailer) Messages() []Message {
+    m.mu.Lock()
+    defer m.mu.Unlock()
+
+    return append([]Message(nil), m.messages...)
+}
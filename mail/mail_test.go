@@ -0,0 +1,59 @@
+package mail
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFormat(t *testing.T) {
+    date := time.Date(2030, 1, 2, 15, 4, 5, 0, time.UTC)
+    msg := Message{To: "bob@example.com", Subject: "Reset your password", Body: "Line one\nLine two\n"}
+
+    got := string(format("Penumbra <noreply@example.com>", msg, date))
+
+    assert.True(t, strings.HasPrefix(got, "From: Penumbra <noreply@example.com>\r\nTo: bob@example.com\r\n"))
+    assert.Contains(t, got, "Subject: Reset your password\r\n")
+    assert.Contains(t, got, "Date: Wed, 02 Jan 2030 15:04:05 +0000\r\n")
+    assert.True(t, strings.HasSuffix(got, "\r\n\r\nLine one\r\nLine two\r\n"))
+}
+
+func TestFormatStripsHeaderInjection(t *testing.T) {
+    msg := Message{To: "bob@example.com\r\nBcc: eve@example.com", Subject: "Hi\nBcc: eve@example.com"}
+
+    got := string(format("noreply@example.com", msg, time.Now()))
+    headers := got[:strings.Index(got, "\r\n\r\n")]
+
+    for _, line := range strings.Split(headers, "\r\n") {
+        assert.False(t, strings.HasPrefix(line, "Bcc:"), "unexpected header line %q", line)
+    }
+}
+
+func TestFormatEncodesNonASCIISubject(t *testing.T) {
+    got := string(format("noreply@example.com", Message{To: "bob@example.com", Subject: "Réinitialiser"}, time.Now()))
+
+    assert.Contains(t, got, "Subject: =?utf-8?q?R=C3=A9initialiser?=\r\n")
+}
+
+func TestMemoryMailer(t *testing.T) {
+    m := &MemoryMailer{}
+
+    assert.NoError(t, m.Send(Message{To: "a@example.com"}))
+    assert.NoError(t, m.Send(Message{To: "b@example.com"}))
+
+    sent := m.Messages()
+    if assert.Len(t, sent, 2) {
+        assert.Equal(t, "b@example.com", sent[1].To)
+    }
+}
+
+func TestNewSMTPMailerInvalidAddress(t *testing.T) {
+    _, err := NewSMTPMailer("no-port", "noreply@example.com", "", "")
+    assert.Error(t, err)
+
+    m, err := NewSMTPMailer("smtp.example.com:587", "noreply@example.com", "user", "pass")
+    assert.NoError(t, err)
+    assert.NotNil(t, m.auth)
+}
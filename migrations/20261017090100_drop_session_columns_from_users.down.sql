@@ -0,0 +1,2 @@
+ALTER TABLE users ADD COLUMN session_token_hash BLOB NOT NULL DEFAULT '';
+ALTER TABLE users ADD COLUMN session_expires_at DATETIME;
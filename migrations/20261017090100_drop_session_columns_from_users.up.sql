@@ -0,0 +1,2 @@
+ALTER TABLE users DROP COLUMN session_token_hash;
+ALTER TABLE users DROP COLUMN session_expires_at;
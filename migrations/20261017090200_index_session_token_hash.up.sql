@@ -0,0 +1,3 @@
+-- Session tokens are now looked up by their SHA-256 hash rather than bcrypt-compared, so existing bcrypt hashes can never match.
+DELETE FROM sessions;
+CREATE UNIQUE INDEX IF NOT EXISTS sessions_token_hash ON sessions (token_hash);
@@ -0,0 +1,11 @@
+CREATE TABLE IF NOT EXISTS api_tokens (
+  id INTEGER PRIMARY KEY AUTOINCREMENT,
+  user_id INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+  name TEXT NOT NULL,
+  token_hash BLOB NOT NULL UNIQUE,
+  scope TEXT NOT NULL CHECK (scope IN ('read', 'read-write')),
+  created_at DATETIME NOT NULL,
+  last_used_at DATETIME,
+  expires_at DATETIME
+);
+CREATE INDEX IF NOT EXISTS api_tokens_user_id ON api_tokens (user_id);
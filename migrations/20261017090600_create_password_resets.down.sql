@@ -0,0 +1,2 @@
+DROP INDEX IF EXISTS password_resets_user_id;
+DROP TABLE IF EXISTS password_resets;
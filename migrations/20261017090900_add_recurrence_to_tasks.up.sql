@@ -0,0 +1,2 @@
+-- An RRULE-style rule such as FREQ=WEEKLY;BYDAY=MO, or NULL for a one-off task.
+ALTER TABLE tasks ADD COLUMN recurrence TEXT;
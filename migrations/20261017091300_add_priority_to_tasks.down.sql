@@ -0,0 +1,3 @@
+DROP INDEX IF EXISTS tasks_user_id_priority;
+ALTER TABLE tasks DROP COLUMN created_at;
+ALTER TABLE tasks DROP COLUMN priority;
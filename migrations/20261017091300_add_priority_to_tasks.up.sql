@@ -0,0 +1,3 @@
+ALTER TABLE tasks ADD COLUMN priority INTEGER NOT NULL DEFAULT 0;
+ALTER TABLE tasks ADD COLUMN created_at DATETIME;
+CREATE INDEX IF NOT EXISTS tasks_user_id_priority ON tasks(user_id, priority);
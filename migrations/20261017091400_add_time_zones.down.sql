@@ -0,0 +1,2 @@
+ALTER TABLE tasks DROP COLUMN all_day;
+ALTER TABLE users DROP COLUMN time_zone;
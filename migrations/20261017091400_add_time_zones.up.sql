@@ -0,0 +1,4 @@
+ALTER TABLE users ADD COLUMN time_zone TEXT NOT NULL DEFAULT 'UTC';
+ALTER TABLE tasks ADD COLUMN all_day INTEGER NOT NULL DEFAULT 0;
+-- Until due times could be given, tasks created from the web form were due at the end of the day in UTC.
+UPDATE tasks SET all_day = 1 WHERE strftime('%H:%M:%S', due) = '23:59:59';
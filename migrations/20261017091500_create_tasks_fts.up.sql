@@ -0,0 +1,21 @@
+CREATE VIRTUAL TABLE IF NOT EXISTS tasks_fts USING fts5(
+  title,
+  description,
+  task_id UNINDEXED,
+  tokenize = 'unicode61 remove_diacritics 2'
+);
+
+INSERT INTO tasks_fts (title, description, task_id)
+SELECT title, description, id FROM tasks;
+
+CREATE TRIGGER IF NOT EXISTS tasks_fts_insert AFTER INSERT ON tasks BEGIN
+  INSERT INTO tasks_fts (title, description, task_id) VALUES (new.title, new.description, new.id);
+END;
+
+CREATE TRIGGER IF NOT EXISTS tasks_fts_update AFTER UPDATE OF title, description ON tasks BEGIN
+  UPDATE tasks_fts SET title = new.title, description = new.description WHERE task_id = old.id;
+END;
+
+CREATE TRIGGER IF NOT EXISTS tasks_fts_delete AFTER DELETE ON tasks BEGIN
+  DELETE FROM tasks_fts WHERE task_id = old.id;
+END;
@@ -0,0 +1,5 @@
+DROP TRIGGER IF EXISTS calendar_feeds_time_zone_update;
+DROP TRIGGER IF EXISTS calendar_feeds_task_delete;
+DROP TRIGGER IF EXISTS calendar_feeds_task_update;
+DROP TRIGGER IF EXISTS calendar_feeds_task_insert;
+DROP TABLE IF EXISTS calendar_feeds;
@@ -0,0 +1,24 @@
+CREATE TABLE IF NOT EXISTS calendar_feeds (
+  user_id INTEGER PRIMARY KEY REFERENCES users(id) ON DELETE CASCADE,
+  token_hash BLOB NOT NULL UNIQUE,
+  created_at DATETIME NOT NULL,
+  changed_at DATETIME NOT NULL,
+  last_polled_at DATETIME
+);
+
+-- changed_at is when the feed's tasks last changed, which feed clients are told as Last-Modified.
+CREATE TRIGGER IF NOT EXISTS calendar_feeds_task_insert AFTER INSERT ON tasks BEGIN
+  UPDATE calendar_feeds SET changed_at = strftime('%Y-%m-%d %H:%M:%f+00:00', 'now') WHERE user_id = new.user_id;
+END;
+
+CREATE TRIGGER IF NOT EXISTS calendar_feeds_task_update AFTER UPDATE ON tasks BEGIN
+  UPDATE calendar_feeds SET changed_at = strftime('%Y-%m-%d %H:%M:%f+00:00', 'now') WHERE user_id = new.user_id;
+END;
+
+CREATE TRIGGER IF NOT EXISTS calendar_feeds_task_delete AFTER DELETE ON tasks BEGIN
+  UPDATE calendar_feeds SET changed_at = strftime('%Y-%m-%d %H:%M:%f+00:00', 'now') WHERE user_id = old.user_id;
+END;
+
+CREATE TRIGGER IF NOT EXISTS calendar_feeds_time_zone_update AFTER UPDATE OF time_zone ON users BEGIN
+  UPDATE calendar_feeds SET changed_at = strftime('%Y-%m-%d %H:%M:%f+00:00', 'now') WHERE user_id = new.id;
+END;
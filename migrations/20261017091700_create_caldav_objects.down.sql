@@ -0,0 +1,2 @@
+DROP TRIGGER IF EXISTS caldav_objects_task_delete;
+DROP TABLE IF EXISTS caldav_objects;
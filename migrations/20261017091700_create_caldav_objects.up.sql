@@ -0,0 +1,14 @@
+-- The names and UIDs CalDAV clients gave the tasks they made, where those aren't the task's id.
+CREATE TABLE IF NOT EXISTS caldav_objects (
+  task_id TEXT PRIMARY KEY REFERENCES tasks(id) ON DELETE CASCADE,
+  user_id INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+  name TEXT NOT NULL,
+  uid TEXT NOT NULL,
+  UNIQUE (user_id, name),
+  UNIQUE (user_id, uid)
+);
+
+-- Foreign keys aren't enforced, so a task's names go with it here.
+CREATE TRIGGER IF NOT EXISTS caldav_objects_task_delete AFTER DELETE ON tasks BEGIN
+  DELETE FROM caldav_objects WHERE task_id = old.id;
+END;
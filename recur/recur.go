@@ -0,0 +1,325 @@
+// Package recur implements the subset of iCalendar recurrence rules (RFC 5545, section 3.3.10) that tasks can repeat by: daily, weekly on given weekdays, monthly on a day of the month, and yearly, every so many periods, until a date or for a number of occurrences.
+package recur
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+type Frequency string
+
+const (
+    Daily   Frequency = "DAILY"
+    Weekly  Frequency = "WEEKLY"
+    Monthly Frequency = "MONTHLY"
+    Yearly  Frequency = "YEARLY"
+)
+
+// Limits that keep rules sensible and their occurrences quick to find.
+const (
+    MaxInterval = 999
+    MaxCount    = 999
+)
+
+// The two-letter weekday codes RRULE uses, indexed by time.Weekday.
+var dayCodes = [7]string{"SU", "MO", "TU", "WE", "TH", "FR", "SA"}
+
+// Rule describes how a task repeats. The first occurrence is the task's due date; the rule says when the following ones are.
+type Rule struct {
+    Freq       Frequency
+    Interval   int            // Repeat every Interval days, weeks, months, or years; 0 is treated as 1.
+    ByDay      []time.Weekday // Weekly rules only: the days of the week to repeat on. Empty means the weekday of the due date.
+    ByMonthDay int            // Monthly rules only: the day of the month to repeat on. 0 means the day of the due date.
+    Until      time.Time      // Zero unless occurrences stop after this time.
+    Count      int            // Zero unless the series has a fixed number of occurrences left, counting the current one.
+}
+
+// Parse reads a rule in RRULE syntax, such as `FREQ=WEEKLY;INTERVAL=2;BYDAY=MO,TH;COUNT=10`, with or without an `RRULE:` prefix.
+func Parse(s string) (Rule, error) {
+    var r Rule
+    // Every part of the rules supported is case-insensitive.
+    s = strings.TrimPrefix(strings.ToUpper(strings.TrimSpace(s)), "RRULE:")
+    if s == "" {
+        return r, errors.New("recur: empty rule")
+    }
+
+    seen := map[string]bool{}
+    for _, part := range strings.Split(s, ";") {
+        key, value, ok := strings.Cut(part, "=")
+        if !ok || value == "" {
+            return Rule{}, fmt.Errorf("recur: malformed part %q", part)
+        }
+        if seen[key] {
+            return Rule{}, fmt.Errorf("recur: %s given twice", key)
+        }
+        seen[key] = true
+
+        var err error
+        switch key {
+        case "FREQ":
+            r.Freq = Frequency(value)
+        case "INTERVAL":
+            r.Interval, err = strconv.Atoi(value)
+        case "COUNT":
+            r.Count, err = strconv.Atoi(value)
+        case "BYMONTHDAY":
+            r.ByMonthDay, err = strconv.Atoi(value)
+        case "BYDAY":
+            r.ByDay, err = parseDays(value)
+        case "UNTIL":
+            r.Until, err = parseUntil(value)
+        default:
+            return Rule{}, fmt.Errorf("recur: unsupported part %s", key)
+        }
+        if err != nil {
+            return Rule{}, fmt.Errorf("recur: invalid %s: %w", key, err)
+        }
+    }
+
+    if err := r.Validate(); err != nil {
+        return Rule{}, err
+    }
+    return r, nil
+}
+
+func parseDays(value string) ([]time.Weekday, error) {
+    var days []time.Weekday
+    for _, code := range strings.Split(value, ",") {
+        day, ok := ParseDay(code)
+        if !ok {
+            return nil, fmt.Errorf("unknown day %q", code)
+        }
+        days = append(days, day)
+    }
+    return days, nil
+}
+
+// ParseDay converts a two-letter RRULE weekday code, such as `MO`, to a time.Weekday.
+func ParseDay(code string) (time.Weekday, bool) {
+    for i, c := range dayCodes {
+        if c == code {
+            return time.Weekday(i), true
+        }
+    }
+    return 0, false
+}
+
+// DayCode returns the two-letter RRULE code for a weekday.
+func DayCode(day time.Weekday) string {
+    return dayCodes[day]
+}
+
+func parseUntil(value string) (time.Time, error) {
+    if t, err := time.Parse("20060102T150405Z", value); err == nil {
+        return t, nil
+    }
+    t, err := time.Parse("20060102", value)
+    if err != nil {
+        return time.Time{}, err
+    }
+    // A date alone includes the whole of that day.
+    return t.Add(24*time.Hour - time.Nanosecond), nil
+}
+
+// Validate reports the first thing wrong with a rule.
+func (r Rule) Validate() error {
+    switch r.Freq {
+    case Daily, Weekly, Monthly, Yearly:
+    case "":
+        return errors.New("recur: FREQ is required")
+    default:
+        return fmt.Errorf("recur: unsupported FREQ %s", r.Freq)
+    }
+    if r.Interval < 0 || r.Interval > MaxInterval {
+        return fmt.Errorf("recur: INTERVAL must be between 1 and %d", MaxInterval)
+    }
+    if r.Count < 0 || r.Count > MaxCount {
+        return fmt.Errorf("recur: COUNT must be between 1 and %d", MaxCount)
+    }
+    if r.Count > 0 && !r.Until.IsZero() {
+        return errors.New("recur: COUNT and UNTIL can't both be given")
+    }
+    if len(r.ByDay) > 0 && r.Freq != Weekly {
+        return errors.New("recur: BYDAY is only supported for weekly rules")
+    }
+    for _, day := range r.ByDay {
+        if day < time.Sunday || day > time.Saturday {
+            return fmt.Errorf("recur: invalid weekday %d", day)
+        }
+    }
+    if r.ByMonthDay != 0 && r.Freq != Monthly {
+        return errors.New("recur: BYMONTHDAY is only supported for monthly rules")
+    }
+    if r.ByMonthDay < 0 || r.ByMonthDay > 31 {
+        return errors.New("recur: BYMONTHDAY must be between 1 and 31")
+    }
+    return nil
+}
+
+// String formats the rule in RRULE syntax, without the `RRULE:` prefix.
+func (r Rule) String() string {
+    parts := []string{"FREQ=" + string(r.Freq)}
+    if r.Interval > 1 {
+        parts = append(parts, "INTERVAL="+strconv.Itoa(r.Interval))
+    }
+    if len(r.ByDay) > 0 {
+        codes := make([]string, len(r.ByDay))
+        for i, day := range r.ByDay {
+            codes[i] = DayCode(day)
+        }
+        parts = append(parts, "BYDAY="+strings.Join(codes, ","))
+    }
+    if r.ByMonthDay > 0 {
+        parts = append(parts, "BYMONTHDAY="+strconv.Itoa(r.ByMonthDay))
+    }
+    if !r.Until.IsZero() {
+        parts = append(parts, "UNTIL="+r.Until.UTC().Format("20060102T150405Z"))
+    }
+    if r.Count > 0 {
+        parts = append(parts, "COUNT="+strconv.Itoa(r.Count))
+    }
+    return strings.Join(parts, ";")
+}
+
+// Describe summarizes the rule in English, such as "Every 2 weeks on Mon, Thu, 10 times left".
+func (r Rule) Describe() string {
+    units := map[Frequency]string{Daily: "day", Weekly: "week", Monthly: "month", Yearly: "year"}
+    var b strings.Builder
+    if r.interval() == 1 {
+        b.WriteString("Every " + units[r.Freq])
+    } else {
+        fmt.Fprintf(&b, "Every %d %ss", r.interval(), units[r.Freq])
+    }
+
+    if len(r.ByDay) > 0 {
+        names := make([]string, len(r.ByDay))
+        for i, day := range r.ByDay {
+            names[i] = day.String()[:3]
+        }
+        b.WriteString(" on " + strings.Join(names, ", "))
+    }
+    if r.ByMonthDay > 0 {
+        fmt.Fprintf(&b, " on day %d", r.ByMonthDay)
+    }
+    if !r.Until.IsZero() {
+        b.WriteString(" until " + r.Until.Format("Mon Jan 2 2006"))
+    }
+    switch {
+    case r.Count == 1:
+        b.WriteString(", for the last time")
+    case r.Count > 1:
+        fmt.Fprintf(&b, ", %d times left", r.Count)
+    }
+    return b.String()
+}
+
+func (r Rule) interval() int {
+    if r.Interval < 1 {
+        return 1
+    }
+    return r.Interval
+}
+
+// Next returns the occurrence that follows the one at t, keeping its time of day and location. It returns false once the series has ended.
+func (r Rule) Next(t time.Time) (time.Time, bool) {
+    if r.Count == 1 {
+        return time.Time{}, false
+    }
+
+    var next time.Time
+    var ok bool
+    switch r.Freq {
+    case Daily:
+        next, ok = t.AddDate(0, 0, r.interval()), true
+    case Weekly:
+        next, ok = r.nextWeekly(t), true
+    case Monthly:
+        next, ok = r.nextMonthly(t)
+    case Yearly:
+        next, ok = r.nextYearly(t)
+    }
+
+    if !ok || (!r.Until.IsZero() && next.After(r.Until)) {
+        return time.Time{}, false
+    }
+    return next, true
+}
+
+// Advance returns the rule for the rest of the series once an occurrence is done, which only differs from r when occurrences are being counted.
+func (r Rule) Advance() Rule {
+    if r.Count > 1 {
+        r.Count--
+    }
+    return r
+}
+
+// weekIndex numbers the days of the week from Monday, the RFC 5545 default start of the week.
+func weekIndex(day time.Weekday) int {
+    return (int(day) + 6) % 7
+}
+
+func (r Rule) nextWeekly(t time.Time) time.Time {
+    if len(r.ByDay) == 0 {
+        return t.AddDate(0, 0, 7*r.interval())
+    }
+
+    var on [7]bool
+    for _, day := range r.ByDay {
+        on[weekIndex(day)] = true
+    }
+
+    today := weekIndex(t.Weekday())
+    for i := today + 1; i < 7; i++ {
+        if on[i] {
+            return t.AddDate(0, 0, i-today)
+        }
+    }
+
+    // Nothing left this week, so start again at the first chosen day of the next week the rule repeats in.
+    monday := t.AddDate(0, 0, -today+7*r.interval())
+    for i := range on {
+        if on[i] {
+            return monday.AddDate(0, 0, i)
+        }
+    }
+    return monday
+}
+
+func (r Rule) nextMonthly(t time.Time) (time.Time, bool) {
+    day := r.ByMonthDay
+    if day == 0 {
+        day = t.Day()
+    }
+
+    // Months without the day, such as the 31st of April, are skipped, as RFC 5545 requires. Four years of months is enough to find any day that can ever occur.
+    for n := 0; n <= 48; n++ {
+        first := time.Date(t.Year(), t.Month()+time.Month(n*r.interval()), 1, t.Hour(), t.Minute(), t.Second(), t.Nanosecond(), t.Location())
+        if day > daysIn(first) {
+            continue
+        }
+        next := first.AddDate(0, 0, day-1)
+        if next.After(t) {
+            return next, true
+        }
+    }
+    return time.Time{}, false
+}
+
+func (r Rule) nextYearly(t time.Time) (time.Time, bool) {
+    // The 29th of February only comes round in leap years.
+    for n := 1; n <= 8; n++ {
+        year := t.Year() + n*r.interval()
+        first := time.Date(year, t.Month(), 1, t.Hour(), t.Minute(), t.Second(), t.Nanosecond(), t.Location())
+        if t.Day() <= daysIn(first) {
+            return first.AddDate(0, 0, t.Day()-1), true
+        }
+    }
+    return time.Time{}, false
+}
+
+func daysIn(first time.Time) int {
+    return first.AddDate(0, 1, -1).Day()
+}
@@ -0,0 +1,154 @@
+package recur
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func date(year int, month time.Month, day int) time.Time {
+    return time.Date(year, month, day, 23, 59, 59, 0, time.UTC)
+}
+
+func TestParseAndString(t *testing.T) {
+    rules := map[string]string{
+        "FREQ=DAILY":                                     "FREQ=DAILY",
+        "RRULE:FREQ=WEEKLY;INTERVAL=2;BYDAY=MO,TH":       "FREQ=WEEKLY;INTERVAL=2;BYDAY=MO,TH",
+        "freq=monthly;bymonthday=31;count=12":            "FREQ=MONTHLY;BYMONTHDAY=31;COUNT=12",
+        "FREQ=YEARLY;UNTIL=20301231":                     "FREQ=YEARLY;UNTIL=20301231T235959Z",
+        "FREQ=DAILY;INTERVAL=1;UNTIL=20300102T150405Z":   "FREQ=DAILY;UNTIL=20300102T150405Z",
+    }
+
+    for in, want := range rules {
+        r, err := Parse(in)
+        if assert.NoError(t, err, in) {
+            assert.Equal(t, want, r.String(), in)
+        }
+    }
+}
+
+func TestParseInvalid(t *testing.T) {
+    invalid := []string{
+        "",
+        "INTERVAL=2",
+        "FREQ=HOURLY",
+        "FREQ=DAILY;INTERVAL=0x2",
+        "FREQ=DAILY;INTERVAL=-1",
+        "FREQ=DAILY;INTERVAL=1000",
+        "FREQ=DAILY;FREQ=WEEKLY",
+        "FREQ=DAILY;BYDAY=MO",
+        "FREQ=WEEKLY;BYDAY=XX",
+        "FREQ=WEEKLY;BYMONTHDAY=3",
+        "FREQ=MONTHLY;BYMONTHDAY=32",
+        "FREQ=DAILY;COUNT=3;UNTIL=20300101",
+        "FREQ=DAILY;UNTIL=tomorrow",
+        "FREQ=DAILY;WKST=MO",
+        "FREQ=DAILY;",
+    }
+
+    for _, in := range invalid {
+        _, err := Parse(in)
+        assert.Error(t, err, in)
+    }
+}
+
+func TestNext(t *testing.T) {
+    cases := []struct {
+        name string
+        rule Rule
+        from time.Time
+        want []time.Time
+    }{
+        {
+            name: "daily every 3 days",
+            rule: Rule{Freq: Daily, Interval: 3},
+            from: date(2030, time.February, 27),
+            want: []time.Time{date(2030, time.March, 2), date(2030, time.March, 5)},
+        },
+        {
+            name: "weekly on the due date's weekday",
+            rule: Rule{Freq: Weekly},
+            from: date(2030, time.January, 2),
+            want: []time.Time{date(2030, time.January, 9), date(2030, time.January, 16)},
+        },
+        {
+            name: "fortnightly on Monday and Thursday",
+            rule: Rule{Freq: Weekly, Interval: 2, ByDay: []time.Weekday{time.Thursday, time.Monday}},
+            from: date(2030, time.January, 7), // A Monday.
+            want: []time.Time{date(2030, time.January, 10), date(2030, time.January, 21), date(2030, time.January, 24), date(2030, time.February, 4)},
+        },
+        {
+            name: "weekly on Sunday, the end of the week",
+            rule: Rule{Freq: Weekly, ByDay: []time.Weekday{time.Sunday}},
+            from: date(2030, time.January, 7),
+            want: []time.Time{date(2030, time.January, 13), date(2030, time.January, 20)},
+        },
+        {
+            name: "monthly on the 31st skips short months",
+            rule: Rule{Freq: Monthly},
+            from: date(2030, time.January, 31),
+            want: []time.Time{date(2030, time.March, 31), date(2030, time.May, 31), date(2030, time.July, 31)},
+        },
+        {
+            name: "monthly on a different day from the due date",
+            rule: Rule{Freq: Monthly, ByMonthDay: 15},
+            from: date(2030, time.January, 5),
+            want: []time.Time{date(2030, time.January, 15), date(2030, time.February, 15)},
+        },
+        {
+            name: "quarterly",
+            rule: Rule{Freq: Monthly, Interval: 3},
+            from: date(2030, time.November, 30),
+            want: []time.Time{date(2031, time.May, 30), date(2031, time.August, 30)},
+        },
+        {
+            name: "yearly on the 29th of February",
+            rule: Rule{Freq: Yearly},
+            from: date(2028, time.February, 29),
+            want: []time.Time{date(2032, time.February, 29), date(2036, time.February, 29)},
+        },
+        {
+            name: "until",
+            rule: Rule{Freq: Daily, Until: date(2030, time.January, 3)},
+            from: date(2030, time.January, 1),
+            want: []time.Time{date(2030, time.January, 2), date(2030, time.January, 3)},
+        },
+    }
+
+    for _, tc := range cases {
+        t.Run(tc.name, func(t *testing.T) {
+            var got []time.Time
+            for at := tc.from; len(got) < len(tc.want); {
+                next, ok := tc.rule.Next(at)
+                if !ok {
+                    break
+                }
+                got = append(got, next)
+                at = next
+            }
+            assert.Equal(t, tc.want, got)
+        })
+    }
+}
+
+func TestNextEnds(t *testing.T) {
+    until := Rule{Freq: Daily, Until: date(2030, time.January, 3)}
+    _, ok := until.Next(date(2030, time.January, 3))
+    assert.False(t, ok)
+
+    r := Rule{Freq: Weekly, Count: 3}
+    at := date(2030, time.January, 2)
+    var occurrences int
+    for ok := true; ok; occurrences++ {
+        at, ok = r.Next(at)
+        r = r.Advance()
+    }
+    assert.Equal(t, 3, occurrences, "COUNT=3 should give the current occurrence and two more")
+}
+
+func TestDescribe(t *testing.T) {
+    assert.Equal(t, "Every day", Rule{Freq: Daily}.Describe())
+    assert.Equal(t, "Every 2 weeks on Mon, Thu, 5 times left", Rule{Freq: Weekly, Interval: 2, ByDay: []time.Weekday{time.Monday, time.Thursday}, Count: 5}.Describe())
+    assert.Equal(t, "Every month on day 15 until Tue Dec 31 2030", Rule{Freq: Monthly, ByMonthDay: 15, Until: date(2030, time.December, 31)}.Describe())
+}
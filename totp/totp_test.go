@@ -0,0 +1,101 @@
+package totp
+
+import (
+	"encoding/base32"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// The SHA-1 test vectors from RFC 6238 appendix B, truncated to 6 digits.
+var rfcSecret = base32.StdEncoding.WithPadding(base32.NoPadding).EncodeToString([]byte("12345678901234567890"))
+
+func TestCodeRFC6238Vectors(t *testing.T) {
+    vectors := map[int64]string{
+        59:          "287082",
+        1111111109:  "081804",
+        1111111111:  "050471",
+        1234567890:  "005924",
+        2000000000:  "279037",
+        20000000000: "353130",
+    }
+
+    for unix, want := range vectors {
+        got, err := Code(rfcSecret, time.Unix(unix, 0))
+        assert.NoError(t, err)
+        assert.Equal(t, want, got, "at %d", unix)
+    }
+}
+
+func TestValidate(t *testing.T) {
+    now := time.Unix(1111111109, 0)
+    code, err := Code(rfcSecret, now)
+    assert.NoError(t, err)
+
+    step, ok := Validate(rfcSecret, code, now)
+    assert.True(t, ok)
+    assert.Equal(t, Step(now), step)
+
+    _, ok = Validate(rfcSecret, code, now.Add(Period))
+    assert.True(t, ok, "a code from the previous step should still be accepted")
+
+    _, ok = Validate(rfcSecret, code, now.Add(3*Period))
+    assert.False(t, ok, "an old code should be refused")
+
+    _, ok = Validate(rfcSecret, "000000", now)
+    assert.False(t, ok)
+
+    _, ok = Validate(rfcSecret, "12345", now)
+    assert.False(t, ok)
+
+    _, ok = Validate("not base32!", code, now)
+    assert.False(t, ok)
+}
+
+func TestGenerateSecret(t *testing.T) {
+    a, err := GenerateSecret()
+    assert.NoError(t, err)
+    b, err := GenerateSecret()
+    assert.NoError(t, err)
+
+    assert.Len(t, a, 32)
+    assert.NotEqual(t, a, b)
+
+    _, err = Code(a, time.Now())
+    assert.NoError(t, err)
+}
+
+func TestURI(t *testing.T) {
+    uri := URI("Penumbra", "bob@example.com", "JBSWY3DPEHPK3PXP")
+
+    u, err := url.Parse(uri)
+    assert.NoError(t, err)
+    assert.Equal(t, "otpauth", u.Scheme)
+    assert.Equal(t, "totp", u.Host)
+    assert.Equal(t, "/Penumbra:bob@example.com", u.Path)
+    assert.Equal(t, "JBSWY3DPEHPK3PXP", u.Query().Get("secret"))
+    assert.Equal(t, "Penumbra", u.Query().Get("issuer"))
+}
+
+func TestGenerateRecoveryCodes(t *testing.T) {
+    codes, err := GenerateRecoveryCodes(10)
+    assert.NoError(t, err)
+    assert.Len(t, codes, 10)
+
+    seen := map[string]bool{}
+    for _, code := range codes {
+        assert.Len(t, code, 11)
+        assert.Equal(t, byte('-'), code[5])
+        assert.False(t, seen[code])
+        seen[code] = true
+    }
+}
+
+func TestNormalizeRecoveryCode(t *testing.T) {
+    assert.Equal(t, "abcdefghjk", NormalizeRecoveryCode(" ABCDE-fghjk "))
+    assert.Equal(t, "abcdefghjk", NormalizeRecoveryCode("abcde fghjk"))
+    assert.False(t, strings.Contains(NormalizeRecoveryCode("ab-cd"), "-"))
+}
@@ -0,0 +1,239 @@
+// Package validate checks user input before it's stored. Each check returns an Errors map from field name to a message that can be shown next to that field in a form, so handlers can re-render the form with the user's input and the problems together.
+package validate
+
+import (
+	"fmt"
+	"net/mail"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"penumbra/app"
+	"penumbra/recur"
+)
+
+const (
+    MaxNameLength        = 100
+    MaxEmailLength       = 254 // The longest address SMTP allows.
+    MaxPhoneLength       = 16  // A plus sign and up to 15 digits, per E.164.
+    MaxPasswordLength    = 72  // bcrypt ignores anything longer.
+    MaxTitleLength       = 200
+    MaxDescriptionLength = 2000
+    MaxChecklistItemLength = 200
+    MaxChecklistItems      = 100
+    MaxTagNameLength       = 30
+    MaxProjectNameLength   = 100
+    MaxSearchLength        = 200
+
+    // Due dates must fall between the start of MinDueYear and this many years from now.
+    MinDueYear      = 1970
+    MaxDueYearsAway = 100
+)
+
+// Errors maps a field name to a message for the user. A nil or empty Errors means the input is valid.
+type Errors map[string]string
+
+// Add records a problem with the field, keeping the first if there's already one.
+func (e Errors) Add(field, message string) {
+    if _, ok := e[field]; !ok {
+        e[field] = message
+    }
+}
+
+func (e Errors) Error() string {
+    fields := make([]string, 0, len(e))
+    for field := range e {
+        fields = append(fields, field)
+    }
+    sort.Strings(fields)
+
+    parts := make([]string, len(fields))
+    for i, field := range fields {
+        parts[i] = field + ": " + e[field]
+    }
+    return strings.Join(parts, "; ")
+}
+
+var e164 = regexp.MustCompile(`^\+[1-9][0-9]{1,14}$`)
+
+// The `#rrggbb` form a colour input submits.
+var hexColour = regexp.MustCompile(`^#[0-9a-fA-F]{6}$`)
+
+// Email reports whether the address looks deliverable: a bare RFC 5322 address, without a display name, whose domain has at least one dot.
+func Email(email string) bool {
+    if email == "" || len(email) > MaxEmailLength {
+        return false
+    }
+    addr, err := mail.ParseAddress(email)
+    if err != nil || addr.Address != email {
+        return false
+    }
+    at := strings.LastIndexByte(email, '@')
+    domain := email[at+1:]
+    return strings.Contains(domain, ".") && !strings.HasPrefix(domain, ".") && !strings.HasSuffix(domain, ".")
+}
+
+// NormalizePhone strips the spaces, dots, hyphens, and parentheses people type in phone numbers.
+func NormalizePhone(phone string) string {
+    return strings.Map(func(r rune) rune {
+        switch r {
+        case ' ', '.', '-', '(', ')':
+            return -1
+        }
+        return r
+    }, phone)
+}
+
+// Phone reports whether a normalized phone number is in E.164 form, such as `+15555550123`.
+func Phone(phone string) bool {
+    return e164.MatchString(phone)
+}
+
+// Password returns a message if the password isn't acceptable, or the empty string if it is.
+func Password(password string) string {
+    if password == "" {
+        return "Enter a password."
+    }
+    if len(password) > MaxPasswordLength {
+        return fmt.Sprintf("Use at most %d bytes for the password.", MaxPasswordLength)
+    }
+    return ""
+}
+
+// ChecklistItem returns a message if the text of a checklist item isn't acceptable, or the empty string if it is.
+func ChecklistItem(text string) string {
+    switch text = strings.TrimSpace(text); {
+    case text == "":
+        return "Enter the checklist item."
+    case utf8.RuneCountInString(text) > MaxChecklistItemLength:
+        return fmt.Sprintf("Use at most %d characters for a checklist item.", MaxChecklistItemLength)
+    }
+    return ""
+}
+
+// Tag checks the name and colour of a new tag. Names can't contain commas, so that lists of them read unambiguously.
+func Tag(tag app.Tag) Errors {
+    errs := Errors{}
+
+    switch name := strings.TrimSpace(tag.Name); {
+    case name == "":
+        errs.Add("name", "Enter a name for the tag.")
+    case utf8.RuneCountInString(name) > MaxTagNameLength:
+        errs.Add("name", fmt.Sprintf("Use at most %d characters for the name.", MaxTagNameLength))
+    case strings.Contains(name, ","):
+        errs.Add("name", "Leave commas out of the name.")
+    }
+
+    if !hexColour.MatchString(tag.Colour) {
+        errs.Add("colour", "Choose a colour.")
+    }
+
+    return errs
+}
+
+// TimeZone checks that the name is an IANA time zone, such as `America/New_York`.
+func TimeZone(name string) Errors {
+    errs := Errors{}
+
+    if name == "" || name == "Local" {
+        errs.Add("timeZone", "Choose a time zone.")
+    } else if _, err := time.LoadLocation(name); err != nil {
+        errs.Add("timeZone", "Choose a time zone from the list, such as Europe/London.")
+    }
+
+    return errs
+}
+
+// Search checks the length of a search query. An empty query is fine: it just finds nothing.
+func Search(query string) Errors {
+    errs := Errors{}
+
+    if utf8.RuneCountInString(query) > MaxSearchLength {
+        errs.Add("q", fmt.Sprintf("Use at most %d characters for a search.", MaxSearchLength))
+    }
+
+    return errs
+}
+
+// Project checks the name of a project.
+func Project(p app.Project) Errors {
+    errs := Errors{}
+
+    switch name := strings.TrimSpace(p.Name); {
+    case name == "":
+        errs.Add("name", "Enter a name for the project.")
+    case utf8.RuneCountInString(name) > MaxProjectNameLength:
+        errs.Add("name", fmt.Sprintf("Use at most %d characters for the name.", MaxProjectNameLength))
+    }
+
+    return errs
+}
+
+// User checks the fields of a new account. The password is checked separately, with Password, since only its hash is kept in app.User.
+func User(u app.User) Errors {
+    errs := Errors{}
+
+    switch name := strings.TrimSpace(u.Name); {
+    case name == "":
+        errs.Add("name", "Enter your name.")
+    case utf8.RuneCountInString(name) > MaxNameLength:
+        errs.Add("name", fmt.Sprintf("Use at most %d characters for your name.", MaxNameLength))
+    }
+
+    switch {
+    case u.Email == "":
+        errs.Add("email", "Enter your email address.")
+    case !Email(u.Email):
+        errs.Add("email", "Enter a valid email address, like jane@example.com.")
+    }
+
+    switch {
+    case u.Phone == "":
+        errs.Add("phone", "Enter your phone number.")
+    case len(u.Phone) > MaxPhoneLength || !Phone(u.Phone):
+        errs.Add("phone", "Enter your phone number with its country code, like +15555550123.")
+    }
+
+    return errs
+}
+
+// Task checks a task's fields. `now` anchors the range of due dates allowed.
+func Task(t app.Task, now time.Time) Errors {
+    errs := Errors{}
+
+    switch title := strings.TrimSpace(t.Title); {
+    case title == "":
+        errs.Add("title", "Enter a title.")
+    case utf8.RuneCountInString(title) > MaxTitleLength:
+        errs.Add("title", fmt.Sprintf("Use at most %d characters for the title.", MaxTitleLength))
+    }
+
+    if utf8.RuneCountInString(t.Description) > MaxDescriptionLength {
+        errs.Add("description", fmt.Sprintf("Use at most %d characters for the description.", MaxDescriptionLength))
+    }
+
+    switch {
+    case t.Due.IsZero():
+        errs.Add("due", "Choose a due date.")
+    case t.Due.Year() < MinDueYear || t.Due.After(now.AddDate(MaxDueYearsAway, 0, 0)):
+        errs.Add("due", fmt.Sprintf("Choose a due date from %d to at most %d years from now.", MinDueYear, MaxDueYearsAway))
+    }
+
+    if t.Done != 0 && t.Done != 1 {
+        errs.Add("done", "Done must be 0 or 1.")
+    }
+
+    if t.Priority < app.PriorityNone || t.Priority > app.PriorityUrgent {
+        errs.Add("priority", "Choose a priority from the list.")
+    }
+
+    if t.Recurrence != "" {
+        if _, err := recur.Parse(t.Recurrence); err != nil {
+            errs.Add("recurrence", "Choose a valid repeat schedule ("+strings.TrimPrefix(err.Error(), "recur: ")+").")
+        }
+    }
+
+    return errs
+}
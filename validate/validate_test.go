@@ -0,0 +1,159 @@
+package validate
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"penumbra/app"
+)
+
+func TestEmail(t *testing.T) {
+    valid := []string{
+        "jane@example.com",
+        "jane.doe+tasks@mail.example.co.uk",
+        "o'brien@example.ie",
+    }
+    invalid := []string{
+        "",
+        "jane",
+        "jane@",
+        "@example.com",
+        "jane@localhost",
+        "jane@example.",
+        "Jane <jane@example.com>",
+        "jane@@example.com",
+        "jane doe@example.com",
+        strings.Repeat("a", 250) + "@example.com",
+    }
+
+    for _, email := range valid {
+        assert.True(t, Email(email), email)
+    }
+    for _, email := range invalid {
+        assert.False(t, Email(email), email)
+    }
+}
+
+func TestPhone(t *testing.T) {
+    assert.Equal(t, "+15555550123", NormalizePhone("+1 (555) 555-0123"))
+    assert.Equal(t, "+441632960961", NormalizePhone("+44 1632.960.961"))
+
+    assert.True(t, Phone("+15555550123"))
+    assert.True(t, Phone("+441632960961"))
+    assert.False(t, Phone("15555550123"), "the country code's plus sign is required")
+    assert.False(t, Phone("+05555550123"))
+    assert.False(t, Phone("+1555555012345678"))
+    assert.False(t, Phone("+1555abc0123"))
+}
+
+func TestPassword(t *testing.T) {
+    assert.NotEmpty(t, Password(""))
+    assert.NotEmpty(t, Password(strings.Repeat("a", 73)))
+    assert.Empty(t, Password(strings.Repeat("a", 72)))
+}
+
+func TestUser(t *testing.T) {
+    good := app.User{Name: "Jane Doe", Email: "jane@example.com", Phone: "+15555550123"}
+    assert.Empty(t, User(good))
+
+    errs := User(app.User{Name: "  ", Email: "jane", Phone: "555"})
+    assert.Len(t, errs, 3)
+    assert.Contains(t, errs, "name")
+    assert.Contains(t, errs, "email")
+    assert.Contains(t, errs, "phone")
+
+    long := good
+    long.Name = strings.Repeat("é", MaxNameLength+1)
+    assert.Contains(t, User(long), "name")
+}
+
+func TestTask(t *testing.T) {
+    now := time.Date(2030, 1, 2, 15, 4, 5, 0, time.UTC)
+    good := app.Task{Title: "Write report", Due: now.Add(24 * time.Hour)}
+    assert.Empty(t, Task(good, now))
+
+    past := good
+    past.Due = now.AddDate(-5, 0, 0)
+    assert.Empty(t, Task(past, now), "overdue tasks are still valid")
+
+    weekly := good
+    weekly.Recurrence = "FREQ=WEEKLY;BYDAY=MO"
+    assert.Empty(t, Task(weekly, now))
+
+    cases := map[string]app.Task{
+        "title":       {Title: " ", Due: good.Due},
+        "description": {Title: "T", Description: strings.Repeat("x", MaxDescriptionLength+1), Due: good.Due},
+        "due":         {Title: "T"},
+        "done":        {Title: "T", Due: good.Due, Done: 7},
+        "recurrence":  {Title: "T", Due: good.Due, Recurrence: "FREQ=HOURLY"},
+        "priority":    {Title: "T", Due: good.Due, Priority: app.PriorityUrgent + 1},
+    }
+    for field, task := range cases {
+        assert.Contains(t, Task(task, now), field)
+    }
+
+    outOfRange := fmt.Sprintf("Choose a due date from %d to at most %d years from now.", MinDueYear, MaxDueYearsAway)
+    tooLate := good
+    tooLate.Due = now.AddDate(MaxDueYearsAway+1, 0, 0)
+    assert.Equal(t, outOfRange, Task(tooLate, now)["due"])
+
+    tooEarly := good
+    tooEarly.Due = time.Date(1969, 12, 31, 0, 0, 0, 0, time.UTC)
+    assert.Equal(t, outOfRange, Task(tooEarly, now)["due"])
+}
+
+func TestChecklistItem(t *testing.T) {
+    assert.Empty(t, ChecklistItem("Buy milk"))
+    assert.NotEmpty(t, ChecklistItem("  "))
+    assert.NotEmpty(t, ChecklistItem(strings.Repeat("x", MaxChecklistItemLength+1)))
+}
+
+func TestTag(t *testing.T) {
+    assert.Empty(t, Tag(app.Tag{Name: "work", Colour: "#1E90ff"}))
+
+    errs := Tag(app.Tag{Name: " ", Colour: "blue"})
+    assert.Contains(t, errs, "name")
+    assert.Contains(t, errs, "colour")
+
+    assert.Contains(t, Tag(app.Tag{Name: strings.Repeat("x", MaxTagNameLength+1), Colour: "#000000"}), "name")
+    assert.Contains(t, Tag(app.Tag{Name: "a,b", Colour: "#000000"}), "name")
+}
+
+func TestProject(t *testing.T) {
+    assert.Empty(t, Project(app.Project{Name: "Work"}))
+    assert.Contains(t, Project(app.Project{Name: "  "}), "name")
+    assert.Contains(t, Project(app.Project{Name: strings.Repeat("x", MaxProjectNameLength+1)}), "name")
+}
+
+func TestErrors(t *testing.T) {
+    var none Errors
+    assert.Empty(t, none.Error())
+
+    errs := Errors{}
+    errs.Add("title", "Enter a title.")
+    errs.Add("title", "ignored")
+    errs.Add("due", "Choose a due date.")
+
+    assert.Equal(t, "Enter a title.", errs["title"])
+    assert.EqualError(t, errs, "due: Choose a due date.; title: Enter a title.")
+}
+
+func TestTimeZone(t *testing.T) {
+    assert.Empty(t, TimeZone("UTC"))
+    assert.Empty(t, TimeZone("America/New_York"))
+    assert.Contains(t, TimeZone(""), "timeZone")
+    assert.Contains(t, TimeZone("Local"), "timeZone")
+    assert.Contains(t, TimeZone("Mars/Olympus_Mons"), "timeZone")
+    assert.Contains(t, TimeZone("../../etc/passwd"), "timeZone")
+}
+
+func TestSearch(t *testing.T) {
+    assert.Empty(t, Search(""))
+    assert.Empty(t, Search(`"buy milk" rep*`))
+    assert.Empty(t, Search(strings.Repeat("é", MaxSearchLength)))
+    assert.Contains(t, Search(strings.Repeat("a", MaxSearchLength+1)), "q")
+}